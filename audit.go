@@ -0,0 +1,154 @@
+/*
+Copyright 2021 IBM All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Range of valid CGPA values
+const (
+	minCGPA = 0.0
+	maxCGPA = 10.0
+)
+
+// auditViolation is one business rule broken by a student record
+type auditViolation struct {
+	ID      string `json:"id"`
+	Rule    string `json:"rule"`
+	Message string `json:"message"`
+}
+
+// auditReport is returned by the audit endpoint
+type auditReport struct {
+	Scanned    int              `json:"scanned"`
+	Passed     bool             `json:"passed"`
+	Violations []auditViolation `json:"violations"`
+}
+
+// ledgerRecord is a student as the chaincode stores it, with its department kept as the branch
+type ledgerRecord struct {
+	Student
+	Branch string `json:"branch"`
+}
+
+// ledgerPage is a page of students as the chaincode stores them
+type ledgerPage struct {
+	Students []ledgerRecord `json:"students"`
+	Bookmark string         `json:"bookmark"`
+}
+
+// fetchLedgerPage reads the page of students after bookmark as the chaincode stores them
+func fetchLedgerPage(ctx context.Context, ledger ledgerContract, bookmark string) (ledgerPage, error) {
+	var page ledgerPage
+
+	result, err := ledger.EvaluateTransaction(ctx, "GetStudentsPage", strconv.Itoa(exportPageSize), bookmark)
+	if err != nil {
+		return page, err
+	}
+	if err := json.Unmarshal(result, &page); err != nil {
+		return page, fmt.Errorf("failed to parse student data: %w", err)
+	}
+	return page, nil
+}
+
+// validateLedger scans every student, a page at a time, and reports the records that break
+// the business rules. Records written before input validation existed may break any of them.
+func validateLedger(c *gin.Context) {
+	requestLogger(c).Info("Auditing ledger")
+
+	report := auditReport{Violations: []auditViolation{}}
+
+	// Students seen so far in each branch, by lower-cased name, to find duplicates
+	namesByBranch := make(map[string]map[string]string)
+
+	bookmark := ""
+	for {
+		page, err := fetchLedgerPage(c.Request.Context(), requestLedger(c), bookmark)
+		if err != nil {
+			writeTransactionError(c, "get students", err)
+			return
+		}
+
+		for _, record := range page.Students {
+			report.Scanned++
+			report.Violations = append(report.Violations, auditStudent(record.Student)...)
+
+			if violation, ok := checkDuplicateName(namesByBranch, record); ok {
+				report.Violations = append(report.Violations, violation)
+			}
+		}
+
+		if page.Bookmark == "" {
+			break
+		}
+		bookmark = page.Bookmark
+	}
+
+	sort.SliceStable(report.Violations, func(i, j int) bool { return report.Violations[i].ID < report.Violations[j].ID })
+	report.Passed = len(report.Violations) == 0
+
+	requestLogger(c).Info("Ledger audit finished", "scanned", report.Scanned, "violations", len(report.Violations))
+	c.JSON(http.StatusOK, report)
+}
+
+// auditStudent checks the rules that apply to a single student record
+func auditStudent(student Student) []auditViolation {
+	var violations []auditViolation
+
+	if student.ID == "" {
+		violations = append(violations, auditViolation{ID: student.ID, Rule: "missing_field", Message: "id is missing"})
+	}
+	if student.Name == "" {
+		violations = append(violations, auditViolation{ID: student.ID, Rule: "missing_field", Message: "name is missing"})
+	}
+
+	if student.CGPA != "" {
+		cgpa, err := strconv.ParseFloat(student.CGPA, 64)
+		switch {
+		case err != nil:
+			violations = append(violations, auditViolation{ID: student.ID, Rule: "cgpa_invalid", Message: fmt.Sprintf("cgpa %q is not a number", student.CGPA)})
+		case cgpa < minCGPA || cgpa > maxCGPA:
+			violations = append(violations, auditViolation{ID: student.ID, Rule: "cgpa_out_of_range", Message: fmt.Sprintf("cgpa %s is outside %.1f to %.1f", student.CGPA, minCGPA, maxCGPA)})
+		}
+	}
+
+	return violations
+}
+
+// checkDuplicateName records the student's name in its branch, reporting a violation if
+// another student in the same branch already has it. Names are compared ignoring case.
+func checkDuplicateName(namesByBranch map[string]map[string]string, record ledgerRecord) (auditViolation, bool) {
+	name := strings.ToLower(strings.TrimSpace(record.Name))
+	if name == "" || record.Branch == "" {
+		return auditViolation{}, false
+	}
+
+	names, ok := namesByBranch[record.Branch]
+	if !ok {
+		names = make(map[string]string)
+		namesByBranch[record.Branch] = names
+	}
+
+	if first, ok := names[name]; ok {
+		return auditViolation{
+			ID:      record.ID,
+			Rule:    "duplicate_name",
+			Message: fmt.Sprintf("name %q is also used by student %s in branch %s", record.Name, first, record.Branch),
+		}, true
+	}
+	names[name] = record.ID
+	return auditViolation{}, false
+}
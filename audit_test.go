@@ -0,0 +1,87 @@
+/*
+Copyright 2021 IBM All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"reflect"
+	"testing"
+)
+
+func TestValidateLedgerReportsSeededViolations(t *testing.T) {
+	// Two pages of students as the chaincode stores them, some written before validation existed
+	ledger := pagedLedger(map[string]string{
+		"": `{"students":[
+			{"id":"S1","name":"Alice","branch":"CSE","cgpa":"9.1"},
+			{"id":"S2","name":"Bob","branch":"CSE","cgpa":"11.5"},
+			{"id":"S3","name":"Carol","branch":"ECE","cgpa":"nine"},
+			{"id":"S4","name":"","branch":"ECE"}
+		],"bookmark":"S5"}`,
+		"S5": `{"students":[
+			{"id":"S5","name":" alice ","branch":"CSE","cgpa":"8.0"},
+			{"id":"S6","name":"Alice","branch":"MECH","cgpa":"-1"},
+			{"id":"","name":"Dave","branch":"ECE"},
+			{"id":"S8","name":"Erin","branch":"ECE","cgpa":"10"}
+		],"bookmark":""}`,
+	})
+	router := newTestRouter(t, ledger, func(config *Config) { config.AdminToken = "secret" })
+
+	response := serveRequest(router, http.MethodPost, "/api/audit/validate", "", "X-Admin-Token", "secret")
+	if response.Code != http.StatusOK {
+		t.Fatalf("status = %d, body %s", response.Code, response.Body)
+	}
+	var report auditReport
+	if err := json.Unmarshal(response.Body.Bytes(), &report); err != nil {
+		t.Fatalf("parsing report %s: %v", response.Body, err)
+	}
+
+	want := []auditViolation{
+		{ID: "", Rule: "missing_field", Message: "id is missing"},
+		{ID: "S2", Rule: "cgpa_out_of_range", Message: "cgpa 11.5 is outside 0.0 to 10.0"},
+		{ID: "S3", Rule: "cgpa_invalid", Message: `cgpa "nine" is not a number`},
+		{ID: "S4", Rule: "missing_field", Message: "name is missing"},
+		{ID: "S5", Rule: "duplicate_name", Message: `name " alice " is also used by student S1 in branch CSE`},
+		{ID: "S6", Rule: "cgpa_out_of_range", Message: "cgpa -1 is outside 0.0 to 10.0"},
+	}
+	if report.Scanned != 8 || report.Passed {
+		t.Errorf("scanned = %d, passed = %t, want 8 scanned and a failed audit", report.Scanned, report.Passed)
+	}
+	if !reflect.DeepEqual(report.Violations, want) {
+		t.Errorf("violations = %+v, want %+v", report.Violations, want)
+	}
+	if calls := ledger.submitted(); len(calls) != 0 {
+		t.Errorf("submitted %+v, want a read-only audit", calls)
+	}
+}
+
+func TestValidateLedgerPasses(t *testing.T) {
+	ledger := pagedLedger(map[string]string{
+		"": `{"students":[{"id":"S1","name":"Alice","branch":"CSE","cgpa":"9.1"},{"id":"S2","name":"Alice","branch":"ECE"}],"bookmark":""}`,
+	})
+	router := newTestRouter(t, ledger, func(config *Config) { config.AdminToken = "secret" })
+
+	response := serveRequest(router, http.MethodPost, "/api/audit/validate", "", "X-Admin-Token", "secret")
+	if got, want := response.Body.String(), `{"scanned":2,"passed":true,"violations":[]}`; got != want {
+		t.Errorf("body = %s, want %s", got, want)
+	}
+}
+
+func TestValidateLedgerFailures(t *testing.T) {
+	ledger := &fakeLedger{respond: func(string, []string) ([]byte, error) {
+		return nil, errors.New("failed to evaluate transaction: peer crashed")
+	}}
+	router := newTestRouter(t, ledger, func(config *Config) { config.AdminToken = "secret" })
+
+	if response := serveRequest(router, http.MethodPost, "/api/audit/validate", ""); response.Code != http.StatusForbidden {
+		t.Errorf("status without the admin token = %d, want 403", response.Code)
+	}
+	if response := serveRequest(router, http.MethodPost, "/api/audit/validate", "", "X-Admin-Token", "secret"); response.Code != http.StatusInternalServerError {
+		t.Errorf("status when the ledger fails = %d, want 500, body %s", response.Code, response.Body)
+	}
+}
@@ -0,0 +1,250 @@
+/*
+Copyright 2021 IBM All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// defaultAuditPageSize and maxAuditPageSize bound the records returned by one audit query
+const (
+	defaultAuditPageSize = 100
+	maxAuditPageSize     = 1000
+)
+
+// auditRecord is the audit trail's record of one write request
+type auditRecord struct {
+	Time      time.Time `json:"time"`
+	RequestID string    `json:"requestId"`
+	// User is the authenticated user, if any, and ClientIP the address the request came from
+	User     string `json:"user,omitempty"`
+	ClientIP string `json:"clientIp"`
+	Org      string `json:"org,omitempty"`
+	Channel  string `json:"channel,omitempty"`
+	Method   string `json:"method"`
+	Path     string `json:"path"`
+	Endpoint string `json:"endpoint"`
+	// PayloadHash is the salted hash of the request body, so a payload can be matched without being stored
+	PayloadHash   string  `json:"payloadHash,omitempty"`
+	Status        int     `json:"status"`
+	TransactionID string  `json:"transactionId,omitempty"`
+	CommitStatus  string  `json:"commitStatus,omitempty"`
+	BlockNumber   *uint64 `json:"blockNumber,omitempty"`
+}
+
+// auditLog appends audit records to a file, one JSON object per line. Records are never
+// changed or removed by the server.
+type auditLog struct {
+	path string
+	// mu serializes appends, so each record is written whole before the next starts
+	mu sync.Mutex
+}
+
+// auditTrail records write requests, or is nil when AUDIT_LOG_FILE is empty
+var auditTrail *auditLog
+
+// newAuditLog creates an audit log appending to path
+func newAuditLog(path string) *auditLog {
+	return &auditLog{path: path}
+}
+
+// append adds a record to the end of the log
+func (l *auditLog) append(record auditRecord) error {
+	line, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	file, err := os.OpenFile(l.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+	if err != nil {
+		return err
+	}
+	if _, err := file.Write(append(line, '\n')); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+// auditFilter selects audit records; empty fields match every record
+type auditFilter struct {
+	User          string
+	Endpoint      string
+	TransactionID string
+	Since         time.Time
+	Until         time.Time
+}
+
+// matches reports whether the record passes the filter
+func (f auditFilter) matches(record auditRecord) bool {
+	return (f.User == "" || record.User == f.User) &&
+		(f.Endpoint == "" || record.Endpoint == f.Endpoint) &&
+		(f.TransactionID == "" || record.TransactionID == f.TransactionID) &&
+		(f.Since.IsZero() || !record.Time.Before(f.Since)) &&
+		(f.Until.IsZero() || record.Time.Before(f.Until))
+}
+
+// query returns the most recent records passing the filter, newest first, up to limit. It reads
+// the log through its own handle, without holding up appends while it scans; records appended
+// after the query starts are left out, so a record still being written is never read.
+func (l *auditLog) query(filter auditFilter, limit int) ([]auditRecord, error) {
+	records := []auditRecord{}
+
+	file, err := os.Open(l.path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return records, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read audit log: %w", err)
+	}
+	defer file.Close()
+
+	// Appends write whole records under the lock, so the size between them ends a record
+	l.mu.Lock()
+	info, err := file.Stat()
+	l.mu.Unlock()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read audit log: %w", err)
+	}
+
+	scanner := bufio.NewScanner(io.LimitReader(file, info.Size()))
+	scanner.Buffer(nil, 1<<20)
+	for scanner.Scan() {
+		var record auditRecord
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			return nil, fmt.Errorf("failed to parse audit log: %w", err)
+		}
+		if !filter.matches(record) {
+			continue
+		}
+		records = append(records, record)
+		// Keep only the newest records, so the whole log need not be held in memory
+		if len(records) > 2*limit {
+			records = append(records[:0], records[len(records)-limit:]...)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read audit log: %w", err)
+	}
+
+	if len(records) > limit {
+		records = records[len(records)-limit:]
+	}
+	for i, j := 0, len(records)-1; i < j; i, j = i+1, j-1 {
+		records[i], records[j] = records[j], records[i]
+	}
+	return records, nil
+}
+
+// auditMiddleware appends a record of each write request to the audit trail once it has been
+// handled, including requests that were refused or failed. It runs before authentication, so
+// refused requests are recorded too, and picks up the user once they are known.
+func auditMiddleware(c *gin.Context) {
+	if auditTrail == nil || !isWriteRequest(c) {
+		c.Next()
+		return
+	}
+
+	// The body is hashed here and put back for the handler. A body over the size limit is
+	// hashed as far as it was read, and the handler still sees the error.
+	body, err := io.ReadAll(c.Request.Body)
+	if err != nil {
+		c.Request.Body = io.NopCloser(io.MultiReader(bytes.NewReader(body), c.Request.Body))
+	} else {
+		c.Request.Body = io.NopCloser(bytes.NewReader(body))
+	}
+
+	record := auditRecord{
+		Time:      time.Now().UTC(),
+		RequestID: requestIDFrom(c.Request.Context()),
+		ClientIP:  c.ClientIP(),
+		Method:    c.Request.Method,
+		Path:      c.Request.URL.Path,
+		Endpoint:  routePattern(c),
+	}
+	if len(body) > 0 {
+		record.PayloadHash = contentHash(cfg.HashSalt, body)
+	}
+
+	c.Next()
+
+	record.User, _ = requestSubject(c)
+	record.Status = c.Writer.Status()
+	ctx := c.Request.Context()
+	if fc := connectionFrom(ctx); fc != nil {
+		record.Org = fc.org.MSPID
+		record.Channel = channelFrom(ctx)
+	}
+	if info := transactionInfoFrom(ctx); info != nil {
+		record.TransactionID = info.TransactionID
+		record.CommitStatus = info.CommitStatus
+		record.BlockNumber = info.BlockNumber
+	}
+
+	if err := auditTrail.append(record); err != nil {
+		requestLogger(c).Error("Failed to write audit record", "error", err)
+	}
+}
+
+// listAuditRecords returns the most recent audit records, newest first. They can be filtered by
+// user, by route pattern with endpoint, by transactionId, and by time with since and until,
+// given in RFC 3339. limit sets how many are returned, 100 by default.
+func listAuditRecords(c *gin.Context) {
+	filter := auditFilter{
+		User:          c.Query("user"),
+		Endpoint:      c.Query("endpoint"),
+		TransactionID: c.Query("transactionId"),
+	}
+	bounds := []struct {
+		name  string
+		value *time.Time
+	}{{"since", &filter.Since}, {"until", &filter.Until}}
+	for _, bound := range bounds {
+		if value := c.Query(bound.name); value != "" {
+			t, err := time.Parse(time.RFC3339, value)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("%s must be an RFC 3339 time such as 2024-01-31T09:00:00Z, got %q", bound.name, value)})
+				return
+			}
+			*bound.value = t
+		}
+	}
+
+	limit := defaultAuditPageSize
+	if value := c.Query("limit"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 1 || n > maxAuditPageSize {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("limit must be a number from 1 to %d, got %q", maxAuditPageSize, value)})
+			return
+		}
+		limit = n
+	}
+
+	records, err := auditTrail.query(filter, limit)
+	if err != nil {
+		requestLogger(c).Error("Failed to query audit log", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("Failed to read audit records: %v", err)})
+		return
+	}
+	c.JSON(http.StatusOK, records)
+}
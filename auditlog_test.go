@@ -0,0 +1,77 @@
+/*
+Copyright 2021 IBM All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestAuditLogQueryDuringAppends(t *testing.T) {
+	log := newAuditLog(filepath.Join(t.TempDir(), "audit.jsonl"))
+	appendRecord := func(n int) {
+		record := auditRecord{Time: time.Now().UTC(), RequestID: fmt.Sprintf("req-%d", n), Method: "POST", Path: strings.Repeat("/students", 50)}
+		if err := log.append(record); err != nil {
+			t.Errorf("appending record %d: %v", n, err)
+		}
+	}
+	const existing, appended = 1000, 1000
+	for n := 0; n < existing; n++ {
+		appendRecord(n)
+	}
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for n := existing; n < existing+appended; n++ {
+			appendRecord(n)
+		}
+	}()
+
+	// Every query sees whole records, newest first, however far the appends have got
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	for querying := true; querying; {
+		select {
+		case <-done:
+			querying = false
+		default:
+		}
+
+		records, err := log.query(auditFilter{}, 10)
+		if err != nil {
+			t.Fatalf("query during appends: %v", err)
+		}
+		if len(records) != 10 {
+			t.Fatalf("query returned %d records, want 10", len(records))
+		}
+		for i := 1; i < len(records); i++ {
+			newer, _ := strconv.Atoi(strings.TrimPrefix(records[i-1].RequestID, "req-"))
+			older, _ := strconv.Atoi(strings.TrimPrefix(records[i].RequestID, "req-"))
+			if newer != older+1 {
+				t.Fatalf("query returned %s after %s, want consecutive records newest first", records[i].RequestID, records[i-1].RequestID)
+			}
+		}
+	}
+
+	records, err := log.query(auditFilter{}, 1)
+	if err != nil {
+		t.Fatalf("query after appends: %v", err)
+	}
+	if want := fmt.Sprintf("req-%d", existing+appended-1); len(records) != 1 || records[0].RequestID != want {
+		t.Errorf("newest record = %+v, want %s", records, want)
+	}
+}
@@ -0,0 +1,240 @@
+/*
+Copyright 2021 IBM All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// tokenIssuer is the issuer claim of the tokens this server signs, and the only one it accepts
+const tokenIssuer = "studentrecords-rest-api"
+
+// Gin context keys under which the authenticated user name and roles are stored
+const (
+	subjectKey = "subject"
+	rolesKey   = "roles"
+)
+
+// UserConfig is an API user who can log in for a token
+type UserConfig struct {
+	Username string `yaml:"username"`
+
+	// bcrypt hash of the user's password
+	PasswordHash string `yaml:"passwordHash"`
+
+	// Roles granted to the user, such as admin, registrar, or viewer
+	Roles []string `yaml:"roles"`
+
+	// The user's own Fabric identity, as the label of a wallet identity or as directories holding
+	// their signing certificate and private key. Users without one transact with their
+	// organization's shared identity.
+	MSPID    string `yaml:"mspId"`
+	Identity string `yaml:"identity"`
+	CertPath string `yaml:"certPath"`
+	KeyPath  string `yaml:"keyPath"`
+}
+
+// hasIdentity reports whether the user transacts with their own Fabric identity
+func (u UserConfig) hasIdentity() bool {
+	return u.Identity != "" || u.CertPath != ""
+}
+
+// tokenClaims are the claims of an API token
+type tokenClaims struct {
+	Roles []string `json:"roles"`
+	jwt.RegisteredClaims
+}
+
+// loginRequest is the body of a login request
+type loginRequest struct {
+	Username string `json:"username" binding:"required"`
+	Password string `json:"password" binding:"required"`
+}
+
+// dummyPasswordHash is compared against when the user is unknown, so a login takes as long
+// whether or not the user exists
+var dummyPasswordHash, _ = bcrypt.GenerateFromPassword([]byte("dummy password"), bcrypt.DefaultCost)
+
+// authEnabled reports whether API requests must carry a token
+func authEnabled() bool {
+	return cfg.JWTSecret != ""
+}
+
+// login checks a user's password and issues a signed token for the API
+func login(c *gin.Context) {
+	if !authEnabled() {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Authentication is not enabled"})
+		return
+	}
+
+	var request loginRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		writeBindError(c, err)
+		return
+	}
+
+	user, ok := findUser(request.Username)
+	hash := dummyPasswordHash
+	if ok {
+		hash = []byte(user.PasswordHash)
+	}
+	if err := bcrypt.CompareHashAndPassword(hash, []byte(request.Password)); err != nil || !ok {
+		requestLogger(c).Warn("Login failed", "username", request.Username)
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid username or password"})
+		return
+	}
+
+	now := time.Now()
+	expiresAt := now.Add(cfg.JWTTTL)
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, tokenClaims{
+		Roles: user.Roles,
+		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:    tokenIssuer,
+			Subject:   user.Username,
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(expiresAt),
+		},
+	}).SignedString([]byte(cfg.JWTSecret))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("Failed to sign token: %v", err)})
+		return
+	}
+
+	requestLogger(c).Info("Issued token", "username", user.Username, "roles", user.Roles, "expiresAt", expiresAt)
+	c.JSON(http.StatusOK, gin.H{"token": token, "tokenType": "Bearer", "expiresAt": expiresAt.UTC(), "roles": user.Roles})
+}
+
+// findUser returns the configured user with the given name
+func findUser(username string) (UserConfig, bool) {
+	for _, user := range cfg.Users {
+		if user.Username == username {
+			return user, true
+		}
+	}
+	return UserConfig{}, false
+}
+
+// requireAuth rejects API requests without a valid bearer token, and records the token's
+// subject and roles for authorization, the handlers, and the per-client rate limit
+func requireAuth(c *gin.Context) {
+	if !authEnabled() {
+		c.Next()
+		return
+	}
+
+	tokenString, ok := strings.CutPrefix(c.GetHeader("Authorization"), "Bearer ")
+	if !ok || tokenString == "" {
+		c.Header("WWW-Authenticate", `Bearer realm="api"`)
+		c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Missing bearer token"})
+		return
+	}
+
+	claims, err := parseToken(tokenString)
+	if err != nil {
+		c.Header("WWW-Authenticate", `Bearer realm="api", error="invalid_token"`)
+		c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": fmt.Sprintf("Invalid token: %v", err)})
+		return
+	}
+
+	c.Set(subjectKey, claims.Subject)
+	c.Set(rolesKey, claims.Roles)
+	c.Request = c.Request.WithContext(context.WithValue(c.Request.Context(), loggerKey{}, requestLogger(c).With("user", claims.Subject)))
+	c.Next()
+}
+
+// parseToken verifies a token's signature, issuer, and expiry, returning its claims
+func parseToken(tokenString string) (*tokenClaims, error) {
+	claims := &tokenClaims{}
+	_, err := jwt.ParseWithClaims(tokenString, claims, func(*jwt.Token) (interface{}, error) {
+		return []byte(cfg.JWTSecret), nil
+	}, jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg()}), jwt.WithIssuer(tokenIssuer), jwt.WithExpirationRequired())
+	if err != nil {
+		return nil, err
+	}
+	if claims.Subject == "" {
+		return nil, errors.New("token has no subject")
+	}
+	return claims, nil
+}
+
+// requestUserIdentity returns the authenticated user making the request if they have their own Fabric identity
+func requestUserIdentity(c *gin.Context) (UserConfig, bool) {
+	subject, ok := requestSubject(c)
+	if !ok {
+		return UserConfig{}, false
+	}
+	user, ok := findUser(subject)
+	return user, ok && user.hasIdentity()
+}
+
+// requestSubject returns the authenticated user making the request, if any
+func requestSubject(c *gin.Context) (string, bool) {
+	subject := c.GetString(subjectKey)
+	return subject, subject != ""
+}
+
+// validateUsers checks that every configured user can log in, holds only known roles, and has
+// a complete Fabric identity in one of the organizations if they have one at all. Users with an
+// identity but no MSP ID belong to the default organization.
+func validateUsers(users []UserConfig, defaultOrg OrgConfig, orgs []OrgConfig) error {
+	seen := make(map[string]bool, len(users))
+	for i := range users {
+		user := &users[i]
+		if user.Username == "" || user.PasswordHash == "" {
+			return fmt.Errorf("user %d must set username and passwordHash", i)
+		}
+		if seen[user.Username] {
+			return fmt.Errorf("user %s is listed more than once", user.Username)
+		}
+		seen[user.Username] = true
+
+		for _, role := range user.Roles {
+			if _, ok := roleRanks[role]; !ok {
+				return fmt.Errorf("user %s has unknown role %q, expected %s, %s, or %s", user.Username, role, roleViewer, roleRegistrar, roleAdmin)
+			}
+		}
+
+		if (user.CertPath == "") != (user.KeyPath == "") {
+			return fmt.Errorf("user %s must set both certPath and keyPath, or neither", user.Username)
+		}
+		if user.Identity != "" && user.CertPath != "" {
+			return fmt.Errorf("user %s must set either identity or certPath and keyPath, not both", user.Username)
+		}
+		if !user.hasIdentity() {
+			continue
+		}
+		if user.MSPID == "" {
+			user.MSPID = defaultOrg.MSPID
+		}
+		if !isOrgConfigured(user.MSPID, defaultOrg, orgs) {
+			return fmt.Errorf("user %s has an identity in organization %s, which is not configured", user.Username, user.MSPID)
+		}
+	}
+	return nil
+}
+
+// isOrgConfigured reports whether mspID names the default organization or one of the others
+func isOrgConfigured(mspID string, defaultOrg OrgConfig, orgs []OrgConfig) bool {
+	if mspID == defaultOrg.MSPID {
+		return true
+	}
+	for _, org := range orgs {
+		if org.MSPID == mspID {
+			return true
+		}
+	}
+	return false
+}
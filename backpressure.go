@@ -0,0 +1,105 @@
+/*
+Copyright 2021 IBM All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package main
+
+import (
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// latencySample is the latency of one transaction submit and when it finished
+type latencySample struct {
+	at      time.Time
+	latency time.Duration
+}
+
+// latencyTracker keeps the submit latencies observed within a sliding window
+type latencyTracker struct {
+	mu      sync.Mutex
+	samples []latencySample
+}
+
+// submitLatency tracks how long recent submits took to endorse and commit
+var submitLatency latencyTracker
+
+// observe records the latency of a submit that finished at the given time. Nothing is recorded
+// while backpressure is disabled, since the samples are only dropped when a write checks them.
+func (t *latencyTracker) observe(latency time.Duration, at time.Time) {
+	if cfg.BackpressureLatency <= 0 {
+		return
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.samples = append(t.samples, latencySample{at: at, latency: latency})
+}
+
+// average returns the mean latency of the submits that finished within the window before now,
+// dropping older samples. It returns false if there are none.
+func (t *latencyTracker) average(window time.Duration, now time.Time) (time.Duration, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	cutoff := now.Add(-window)
+	i := 0
+	for i < len(t.samples) && t.samples[i].at.Before(cutoff) {
+		i++
+	}
+	t.samples = t.samples[i:]
+
+	if len(t.samples) == 0 {
+		return 0, false
+	}
+
+	var total time.Duration
+	for _, sample := range t.samples {
+		total += sample.latency
+	}
+	return total / time.Duration(len(t.samples)), true
+}
+
+// oldest returns when the oldest sample in the window finished
+func (t *latencyTracker) oldest() time.Time {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if len(t.samples) == 0 {
+		return time.Time{}
+	}
+	return t.samples[0].at
+}
+
+// backpressureMiddleware sheds new writes with 503 while the average submit latency over the
+// configured window exceeds the threshold, leaving reads available. Since shed writes add no
+// samples, the slow samples age out of the window and writes resume once it has passed.
+func backpressureMiddleware(c *gin.Context) {
+	if cfg.BackpressureLatency <= 0 || !isWriteRequest(c) {
+		c.Next()
+		return
+	}
+
+	now := time.Now()
+	if latency, ok := submitLatency.average(cfg.BackpressureWindow, now); ok && latency > cfg.BackpressureLatency {
+		// The average next changes when the oldest sample leaves the window
+		retryAfter := submitLatency.oldest().Add(cfg.BackpressureWindow).Sub(now)
+		backpressureRejectedRequests.Inc()
+		c.Header("Retry-After", strconv.Itoa(int(math.Max(1, math.Ceil(retryAfter.Seconds())))))
+		c.AbortWithStatusJSON(http.StatusServiceUnavailable, gin.H{
+			"error":   "Peer is responding slowly, try again later",
+			"latency": latency.String(),
+		})
+		return
+	}
+
+	c.Next()
+}
@@ -0,0 +1,72 @@
+/*
+Copyright 2021 IBM All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package main
+
+import (
+	"net/http"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/hyperledger/fabric-gateway/pkg/client"
+	"github.com/hyperledger/fabric-protos-go-apiv2/peer"
+)
+
+func TestBackpressureShedsWritesUntilLatencyRecovers(t *testing.T) {
+	const window = 300 * time.Millisecond
+
+	submitLatency = latencyTracker{}
+	t.Cleanup(func() { submitLatency = latencyTracker{} })
+
+	// Commits are slow until the peer recovers
+	var commitDelay atomic.Int64
+	commitDelay.Store(int64(100 * time.Millisecond))
+	ledger := &fakeLedger{
+		respond: readStoredStudent,
+		awaitCommit: func(txID string) (*client.Status, error) {
+			time.Sleep(time.Duration(commitDelay.Load()))
+			return &client.Status{Code: peer.TxValidationCode_VALID, Successful: true, BlockNumber: 1, TransactionID: txID}, nil
+		},
+	}
+	router := newTestRouter(t, ledger, func(config *Config) {
+		config.BackpressureLatency = 50 * time.Millisecond
+		config.BackpressureWindow = window
+	})
+
+	create := func(id string) int {
+		response := serveRequest(router, http.MethodPost, "/api/students", `{"id":"`+id+`","name":"Alice"}`, commitStrategyHeader, string(waitForEndorse))
+		backgroundWork.Wait()
+		return response.Code
+	}
+
+	if code := create("S1"); code != http.StatusAccepted {
+		t.Fatalf("status of the first write = %d, want 202", code)
+	}
+
+	response := serveRequest(router, http.MethodPost, "/api/students", `{"id":"S2","name":"Bob"}`)
+	if response.Code != http.StatusServiceUnavailable {
+		t.Fatalf("status of a write after a slow commit = %d, want 503, body %s", response.Code, response.Body)
+	}
+	if retryAfter := response.Header().Get("Retry-After"); retryAfter != "1" {
+		t.Errorf("Retry-After = %q, want 1", retryAfter)
+	}
+	if response := serveRequest(router, http.MethodGet, "/api/students/S1", ""); response.Code != http.StatusOK {
+		t.Errorf("status of a read under backpressure = %d, want 200", response.Code)
+	}
+	if calls := ledger.submitted(); len(calls) != 1 {
+		t.Errorf("submitted %d transactions, want only the first write", len(calls))
+	}
+
+	// Once the slow commit leaves the window, writes go ahead, and fast commits keep them going
+	commitDelay.Store(0)
+	time.Sleep(window + 50*time.Millisecond)
+	for _, id := range []string{"S2", "S3"} {
+		if code := create(id); code != http.StatusAccepted {
+			t.Errorf("status of a write after recovery = %d, want 202", code)
+		}
+	}
+}
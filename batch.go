@@ -0,0 +1,275 @@
+/*
+Copyright 2021 IBM All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+)
+
+// batchRecordResult reports the outcome for one record of a batch request
+type batchRecordResult struct {
+	Index  int          `json:"index"`
+	Line   int          `json:"line,omitempty"` // line of the record in an imported CSV file
+	ID     string       `json:"id"`
+	Status string       `json:"status"`
+	Error  string       `json:"error,omitempty"`
+	Fields []fieldError `json:"fields,omitempty"` // invalid fields of a record that failed validation
+
+	// TransactionID is the transaction that created, or failed to create, the record when a
+	// batch is submitted in chunks
+	TransactionID string `json:"transactionId,omitempty"`
+}
+
+// batchStudentInput is a student of the batch passed to CreateStudents. The chaincode calls the
+// department the student's branch, and does not keep the year.
+type batchStudentInput struct {
+	ID     string `json:"id"`
+	Name   string `json:"name"`
+	Branch string `json:"branch"`
+}
+
+// batchStudentDetails are the private details of a student of the batch, passed to
+// CreateStudents in the transient data rather than with the public batch
+type batchStudentDetails struct {
+	ID   string `json:"id"`
+	CGPA string `json:"cgpa"`
+}
+
+// maxBatchChunkSize bounds the chunk_size a batch may ask for
+const maxBatchChunkSize = 1000
+
+// createStudents adds a batch of students in a single transaction, so either all of them are committed or none are
+func createStudents(c *gin.Context) {
+	var students []Student
+
+	// Parse request body. Records are validated by validateBatch so each problem is reported against its record.
+	if err := decodeJSONBody(c, &students); err != nil {
+		writeBindError(c, err)
+		return
+	}
+	if len(students) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Batch must contain at least one student"})
+		return
+	}
+
+	submitBatch(c, students, nil)
+}
+
+// submitBatch validates a batch of students and creates them, writing the response
+func submitBatch(c *gin.Context, students []Student, lines []int) {
+	code, body, _ := createBatch(c, students, lines, false)
+	c.JSON(code, body)
+}
+
+// createBatch validates a batch of students and creates them in a single transaction, or in
+// transactions of chunk_size students if the request sets it, returning the status code and body
+// of the response along with the result for each record. If the students were read from a file,
+// lines holds the line number of each one. An invalid record rejects the whole batch unless
+// skipInvalid is set, in which case invalid records are reported and the others are created.
+func createBatch(c *gin.Context, students []Student, lines []int, skipInvalid bool) (int, gin.H, []batchRecordResult) {
+	chunkSize, err := parseChunkSize(c)
+	if err != nil {
+		return http.StatusBadRequest, gin.H{"error": err.Error()}, nil
+	}
+
+	// Reject the whole batch before submitting if any record is invalid
+	results, ok := validateBatch(students)
+	for i := range lines {
+		results[i].Line = lines[i]
+	}
+	if !ok && !skipInvalid {
+		return http.StatusBadRequest, gin.H{"error": "Batch contains invalid students", "results": results}, results
+	}
+
+	var valid []int
+	for i := range results {
+		if results[i].Status == "valid" {
+			valid = append(valid, i)
+		}
+	}
+	if len(valid) == 0 {
+		return http.StatusBadRequest, gin.H{"error": "Batch contains no valid students", "results": results}, results
+	}
+	invalid := len(students) - len(valid)
+
+	if chunkSize > 0 && chunkSize < len(valid) {
+		code, body := submitChunks(c, students, valid, results, chunkSize, invalid)
+		return code, body, results
+	}
+
+	requestLogger(c).Info("Creating batch of students", "count", len(valid), "invalid", invalid)
+	if err := submitChunk(c.Request.Context(), requestLedger(c), students, valid); err != nil {
+		markChunk(results, valid, "", err)
+		if isBatchConflict(err) {
+			return http.StatusConflict, gin.H{"error": fmt.Sprintf("Failed to create students, none were committed: %v", err)}, results
+		}
+		code, body := transactionErrorResponse(c, "create students, none were committed", err)
+		return code, body, results
+	}
+	markChunk(results, valid, "", nil)
+
+	data := gin.H{"created": len(valid), "results": results}
+	if invalid > 0 {
+		data["invalid"] = invalid
+	}
+	return http.StatusCreated, transactionEnvelope(c, data), results
+}
+
+// submitChunks creates the valid students of a batch, at the indexes in valid, in transactions of
+// chunkSize students each, so a failure only loses its own chunk. Every chunk is submitted even
+// after one fails, and each record's result says whether it was created and by which
+// transaction. The response is 201 if every chunk was committed, 207 if only some were, and the
+// error of the first failed chunk if none were.
+func submitChunks(c *gin.Context, students []Student, valid []int, results []batchRecordResult, chunkSize, invalid int) (int, gin.H) {
+	logger := requestLogger(c)
+	logger.Info("Creating batch of students in chunks", "count", len(valid), "invalid", invalid, "chunkSize", chunkSize)
+
+	created := 0
+	var firstErr error
+	for start := 0; start < len(valid); start += chunkSize {
+		chunk := valid[start:min(start+chunkSize, len(valid))]
+
+		// Each chunk records its own transaction, so a chunk that fails before submitting has none
+		info := transactionInfoFrom(c.Request.Context())
+		if info != nil {
+			*info = transactionInfo{}
+		}
+
+		err := submitChunk(c.Request.Context(), requestLedger(c), students, chunk)
+		var txID string
+		if info != nil {
+			txID = info.TransactionID
+		}
+		markChunk(results, chunk, txID, err)
+
+		if err != nil {
+			logger.Warn("Chunk of batch failed, continuing with the next chunk", "firstIndex", chunk[0], "count", len(chunk), "error", err)
+			if firstErr == nil {
+				firstErr = err
+			}
+			continue
+		}
+		created += len(chunk)
+	}
+
+	// Each record's result names its chunk's transaction, so none is recorded for the whole batch
+	if info := transactionInfoFrom(c.Request.Context()); info != nil {
+		*info = transactionInfo{}
+	}
+
+	data := gin.H{"created": created, "failed": len(valid) - created, "results": results}
+	if invalid > 0 {
+		data["invalid"] = invalid
+	}
+	switch {
+	case firstErr == nil:
+		return http.StatusCreated, dataBody(c, data)
+	case created > 0:
+		return http.StatusMultiStatus, dataBody(c, data)
+	default:
+		code, _ := transactionFailure(firstErr)
+		if isBatchConflict(firstErr) {
+			code = http.StatusConflict
+		}
+		return code, gin.H{"error": fmt.Sprintf("Failed to create students, none were committed: %v", firstErr), "results": results}
+	}
+}
+
+// submitChunk creates the students at the given indexes of a batch in a single transaction
+func submitChunk(ctx context.Context, ledger ledgerContract, students []Student, indexes []int) error {
+	chunk := make([]batchStudentInput, len(indexes))
+	var details []batchStudentDetails
+	for i, index := range indexes {
+		student := students[index]
+		chunk[i] = batchStudentInput{ID: student.ID, Name: student.Name, Branch: student.Department}
+		if student.CGPA != "" {
+			details = append(details, batchStudentDetails{ID: student.ID, CGPA: student.CGPA})
+		}
+	}
+
+	chunkJSON, err := json.Marshal(chunk)
+	if err != nil {
+		return err
+	}
+	if len(details) > 0 {
+		if ctx, err = contextWithPrivateDetails(ctx, details); err != nil {
+			return err
+		}
+	}
+	_, err = submitWithRetry(ctx, ledger, "CreateStudents", string(chunkJSON))
+	return err
+}
+
+// markChunk records the outcome of the transaction that created the records at the given indexes
+func markChunk(results []batchRecordResult, indexes []int, txID string, err error) {
+	for _, i := range indexes {
+		results[i].TransactionID = txID
+		if err != nil {
+			results[i].Status = "failed"
+			results[i].Error = gatewayErrorText(err)
+		} else {
+			results[i].Status = "created"
+		}
+	}
+}
+
+// parseChunkSize reads the chunk_size query parameter of a batch, returning 0 if it is not set
+func parseChunkSize(c *gin.Context) (int, error) {
+	value := c.Query("chunk_size")
+	if value == "" {
+		return 0, nil
+	}
+	size, err := strconv.Atoi(value)
+	if err != nil || size < 1 || size > maxBatchChunkSize {
+		return 0, fmt.Errorf("chunk_size must be a number from 1 to %d, got %q", maxBatchChunkSize, value)
+	}
+	return size, nil
+}
+
+// isBatchConflict reports whether creating a batch failed because a student already exists or is repeated
+func isBatchConflict(err error) bool {
+	return classifyChaincodeError(err) == failureConflict
+}
+
+// validateBatch checks each student in a batch, returning a result per record and whether all were valid
+func validateBatch(students []Student) ([]batchRecordResult, bool) {
+	results := make([]batchRecordResult, len(students))
+	seen := make(map[string]int, len(students))
+	valid := true
+
+	for i, student := range students {
+		results[i] = batchRecordResult{Index: i, ID: student.ID, Status: "valid"}
+
+		var problem string
+		if err := binding.Validator.ValidateStruct(student); err != nil {
+			problem = err.Error()
+			if fields := fieldErrors(err); fields != nil {
+				results[i].Fields = fields
+				problem = fieldErrorsText(fields)
+			}
+		} else if first, ok := seen[student.ID]; ok {
+			problem = fmt.Sprintf("id duplicates record %d", first)
+		}
+
+		if problem != "" {
+			results[i].Status = "invalid"
+			results[i].Error = problem
+			valid = false
+			continue
+		}
+		seen[student.ID] = i
+	}
+
+	return results, valid
+}
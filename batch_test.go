@@ -0,0 +1,120 @@
+/*
+Copyright 2021 IBM All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"testing"
+)
+
+// batchLedger creates batches of students the way the chaincode does, all of them or none if
+// any already exists or is repeated, in the store it returns
+func batchLedger(existing ...string) (*fakeLedger, map[string]batchStudentInput) {
+	stored := map[string]batchStudentInput{}
+	for _, id := range existing {
+		stored[id] = batchStudentInput{ID: id}
+	}
+
+	ledger := &fakeLedger{respond: func(name string, args []string) ([]byte, error) {
+		if name != "CreateStudents" {
+			return nil, nil
+		}
+		var students []batchStudentInput
+		if err := json.Unmarshal([]byte(args[0]), &students); err != nil {
+			return nil, errors.New("chaincode response 500, failed to parse students: " + err.Error())
+		}
+		seen := map[string]bool{}
+		for _, student := range students {
+			if seen[student.ID] {
+				return nil, errors.New("chaincode response 500, the student " + student.ID + " appears more than once in the batch")
+			}
+			if _, ok := stored[student.ID]; ok {
+				return nil, errors.New("chaincode response 500, the student " + student.ID + " already exists")
+			}
+			seen[student.ID] = true
+		}
+		for _, student := range students {
+			stored[student.ID] = student
+		}
+		return nil, nil
+	}}
+	return ledger, stored
+}
+
+func TestCreateStudentsSendsChaincodeShape(t *testing.T) {
+	ledger, stored := batchLedger()
+	router := newTestRouter(t, ledger, nil)
+
+	response := serveRequest(router, http.MethodPost, "/api/students/batch",
+		`[{"id":"S1","name":"Alice","department":"CSE","year":"2","cgpa":"9.1"},{"id":"S2","name":"Bob","department":"ECE"}]`)
+	if response.Code != http.StatusCreated {
+		t.Fatalf("status = %d, body %s", response.Code, response.Body)
+	}
+
+	calls := ledger.submitted()
+	if len(calls) != 1 || calls[0].name != "CreateStudents" {
+		t.Fatalf("submitted %+v, want a single CreateStudents", calls)
+	}
+	want := `[{"id":"S1","name":"Alice","branch":"CSE"},{"id":"S2","name":"Bob","branch":"ECE"}]`
+	if calls[0].args[0] != want {
+		t.Errorf("CreateStudents got %s, want %s", calls[0].args[0], want)
+	}
+	wantDetails := `[{"id":"S1","cgpa":"9.1"}]`
+	if details := string(calls[0].transient[detailsTransientKey]); details != wantDetails {
+		t.Errorf("CreateStudents got private details %s, want %s", details, wantDetails)
+	}
+	if len(stored) != 2 {
+		t.Errorf("stored %d students, want 2", len(stored))
+	}
+}
+
+func TestCreateStudentsWithDuplicateIDCommitsNothing(t *testing.T) {
+	ledger, stored := batchLedger()
+	router := newTestRouter(t, ledger, nil)
+
+	response := serveRequest(router, http.MethodPost, "/api/students/batch",
+		`[{"id":"S1","name":"Alice"},{"id":"S2","name":"Bob"},{"id":"S1","name":"Carol"}]`)
+	if response.Code != http.StatusBadRequest {
+		t.Fatalf("status = %d, want 400, body %s", response.Code, response.Body)
+	}
+
+	if calls := ledger.submitted(); len(calls) != 0 {
+		t.Errorf("submitted %+v, want nothing", calls)
+	}
+	if len(stored) != 0 {
+		t.Errorf("stored %v, want nothing", stored)
+	}
+}
+
+func TestCreateStudentsWithExistingIDCommitsNothing(t *testing.T) {
+	ledger, stored := batchLedger("S2")
+	router := newTestRouter(t, ledger, nil)
+
+	response := serveRequest(router, http.MethodPost, "/api/students/batch",
+		`[{"id":"S1","name":"Alice"},{"id":"S2","name":"Bob"}]`)
+	if response.Code != http.StatusConflict {
+		t.Fatalf("status = %d, want 409, body %s", response.Code, response.Body)
+	}
+
+	if _, ok := stored["S1"]; ok {
+		t.Error("S1 was stored, want nothing committed")
+	}
+
+	var body struct {
+		Results []batchRecordResult `json:"results"`
+	}
+	if err := json.Unmarshal(response.Body.Bytes(), &body); err != nil {
+		t.Fatalf("parsing response: %v", err)
+	}
+	for _, result := range body.Results {
+		if result.Status != "failed" {
+			t.Errorf("record %d has status %q, want failed", result.Index, result.Status)
+		}
+	}
+}
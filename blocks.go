@@ -0,0 +1,231 @@
+/*
+Copyright 2021 IBM All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/hyperledger/fabric-gateway/pkg/client"
+	"github.com/hyperledger/fabric-protos-go-apiv2/common"
+	"github.com/hyperledger/fabric-protos-go-apiv2/peer"
+	"google.golang.org/protobuf/proto"
+)
+
+// sseKeepAliveInterval is how often an idle block stream sends a comment, so proxies don't
+// close it and clients notice when the server has gone
+const sseKeepAliveInterval = 30 * time.Second
+
+// blockTransaction is a transaction in a block as sent to clients
+type blockTransaction struct {
+	TransactionID  string `json:"transactionId"`
+	Type           string `json:"type"`
+	ValidationCode string `json:"validationCode"`
+	Valid          bool   `json:"valid"`
+}
+
+// blockMessage is a committed block as sent to clients
+type blockMessage struct {
+	BlockNumber  uint64             `json:"blockNumber"`
+	Transactions []blockTransaction `json:"transactions"`
+}
+
+// newBlockMessage summarizes a block for clients, pairing each transaction with the validation
+// code the committing peer gave it
+func newBlockMessage(block *common.Block) (blockMessage, error) {
+	message := blockMessage{
+		BlockNumber:  block.GetHeader().GetNumber(),
+		Transactions: make([]blockTransaction, 0, len(block.GetData().GetData())),
+	}
+
+	var validationCodes []byte
+	if metadata := block.GetMetadata().GetMetadata(); len(metadata) > int(common.BlockMetadataIndex_TRANSACTIONS_FILTER) {
+		validationCodes = metadata[common.BlockMetadataIndex_TRANSACTIONS_FILTER]
+	}
+
+	for i, envelopeBytes := range block.GetData().GetData() {
+		header, err := channelHeader(envelopeBytes)
+		if err != nil {
+			return blockMessage{}, fmt.Errorf("failed to parse transaction %d of block %d: %w", i, message.BlockNumber, err)
+		}
+
+		code := peer.TxValidationCode_NOT_VALIDATED
+		if i < len(validationCodes) {
+			code = peer.TxValidationCode(validationCodes[i])
+		}
+
+		message.Transactions = append(message.Transactions, blockTransaction{
+			TransactionID:  header.GetTxId(),
+			Type:           common.HeaderType(header.GetType()).String(),
+			ValidationCode: code.String(),
+			Valid:          code == peer.TxValidationCode_VALID,
+		})
+	}
+	return message, nil
+}
+
+// channelHeader extracts the channel header of a transaction envelope
+func channelHeader(envelopeBytes []byte) (*common.ChannelHeader, error) {
+	envelope := &common.Envelope{}
+	if err := proto.Unmarshal(envelopeBytes, envelope); err != nil {
+		return nil, err
+	}
+	payload := &common.Payload{}
+	if err := proto.Unmarshal(envelope.GetPayload(), payload); err != nil {
+		return nil, err
+	}
+	header := &common.ChannelHeader{}
+	if err := proto.Unmarshal(payload.GetHeader().GetChannelHeader(), header); err != nil {
+		return nil, err
+	}
+	return header, nil
+}
+
+// blockStreamsDone is closed when the server shuts down. Shutdown waits for requests to finish,
+// and block streams would otherwise never do so.
+var (
+	blockStreamsDone     = make(chan struct{})
+	closeBlockStreamOnce sync.Once
+)
+
+// closeBlockStreams ends every block stream so shutdown can complete
+func closeBlockStreams() {
+	closeBlockStreamOnce.Do(func() { close(blockStreamsDone) })
+}
+
+// gatewayBlockEvents opens a stream of the channel's blocks through the default organization's
+// gateway, starting at the given block, or at the next block to be committed if start is nil
+func gatewayBlockEvents(ctx context.Context, start *uint64) (<-chan *common.Block, error) {
+	fc, err := orgs.connection(orgs.defaultOrg)
+	if err != nil {
+		return nil, err
+	}
+
+	var options []client.BlockEventsOption
+	if start != nil {
+		options = append(options, client.WithStartBlock(*start))
+	}
+	return fc.currentNetwork(channelName).BlockEvents(ctx, options...)
+}
+
+// streamBlocks sends each block committed on the channel as a Server-Sent Event, with the
+// block number as the event ID. A client that reconnects with Last-Event-ID resumes at the
+// block after the last one it received.
+func streamBlocks(c *gin.Context) {
+	var start *uint64
+	if lastEventID := c.GetHeader("Last-Event-ID"); lastEventID != "" {
+		lastBlock, err := strconv.ParseUint(lastEventID, 10, 64)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Last-Event-ID must be a block number"})
+			return
+		}
+		next := lastBlock + 1
+		start = &next
+	}
+
+	logger := requestLogger(c)
+	ctx, cancel := context.WithCancel(c.Request.Context())
+	defer cancel()
+
+	blocks, err := gatewayBlockEvents(ctx, start)
+	if err != nil {
+		writeTransactionError(c, "stream blocks", err)
+		return
+	}
+
+	eventStreamClients.WithLabelValues("blocks").Inc()
+	defer eventStreamClients.WithLabelValues("blocks").Dec()
+
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("Cache-Control", "no-cache")
+	c.Header("X-Accel-Buffering", "no")
+	c.Status(http.StatusOK)
+	c.Writer.Flush()
+
+	logger.Info("Block stream client connected", "lastEventId", c.GetHeader("Last-Event-ID"))
+
+	keepAlive := time.NewTicker(sseKeepAliveInterval)
+	defer keepAlive.Stop()
+
+	for {
+		select {
+		case block, ok := <-blocks:
+			if !ok {
+				if ctx.Err() != nil {
+					logger.Info("Block stream client disconnected")
+					return
+				}
+				// Reopen the gateway stream after the last block sent, so the client misses nothing
+				logger.Warn("Block event stream ended, reconnecting", "delay", eventsReconnectDelay.String())
+				if !waitToReconnect(ctx, eventsReconnectDelay) {
+					return
+				}
+				blocks, err = gatewayBlockEvents(ctx, start)
+				if err != nil {
+					logger.Warn("Failed to reopen block event stream", "error", err)
+					blocks = closedBlocks()
+				}
+				continue
+			}
+
+			message, err := newBlockMessage(block)
+			if err != nil {
+				logger.Error("Failed to read block", "error", err)
+				return
+			}
+			data, _ := json.Marshal(message)
+			if _, err := fmt.Fprintf(c.Writer, "id: %d\nevent: block\ndata: %s\n\n", message.BlockNumber, data); err != nil {
+				logger.Info("Block stream client disconnected", "error", err)
+				return
+			}
+			c.Writer.Flush()
+
+			next := message.BlockNumber + 1
+			start = &next
+		case <-keepAlive.C:
+			if _, err := fmt.Fprint(c.Writer, ": keep-alive\n\n"); err != nil {
+				logger.Info("Block stream client disconnected", "error", err)
+				return
+			}
+			c.Writer.Flush()
+		case <-blockStreamsDone:
+			return
+		case <-ctx.Done():
+			logger.Info("Block stream client disconnected")
+			return
+		}
+	}
+}
+
+// closedBlocks returns a closed block channel, so a failed reconnect is retried like a stream that ended
+func closedBlocks() <-chan *common.Block {
+	blocks := make(chan *common.Block)
+	close(blocks)
+	return blocks
+}
+
+// waitToReconnect waits for the given duration before a block stream is reopened, returning
+// false if the client goes away or the server shuts down first
+func waitToReconnect(ctx context.Context, d time.Duration) bool {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return true
+	case <-ctx.Done():
+		return false
+	case <-blockStreamsDone:
+		return false
+	}
+}
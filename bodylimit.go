@@ -0,0 +1,57 @@
+/*
+Copyright 2021 IBM All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// bodyLimitMiddleware caps request bodies at maxBytes. Requests declaring a larger body are
+// rejected with 413 straight away; bodies without a declared length fail when read past the
+// limit, and the handler reports them with 413 too. A maxBytes of zero disables the limit.
+func bodyLimitMiddleware(maxBytes int64) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if maxBytes <= 0 {
+			c.Next()
+			return
+		}
+
+		if c.Request.ContentLength > maxBytes {
+			c.AbortWithStatusJSON(http.StatusRequestEntityTooLarge, gin.H{"error": fmt.Sprintf("Request body must not exceed %d bytes", maxBytes)})
+			return
+		}
+
+		c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, maxBytes)
+		c.Next()
+	}
+}
+
+// writeBodyTooLarge writes the 413 response and returns true if err came from reading a body
+// past the size limit
+func writeBodyTooLarge(c *gin.Context, err error) bool {
+	var tooLarge *http.MaxBytesError
+	if !errors.As(err, &tooLarge) {
+		return false
+	}
+	c.AbortWithStatusJSON(http.StatusRequestEntityTooLarge, gin.H{"error": fmt.Sprintf("Request body must not exceed %d bytes", tooLarge.Limit)})
+	return true
+}
+
+// decodeJSONBody decodes the request body into v, rejecting fields v does not have unless
+// STRICT_JSON is off, as the handlers binding with ShouldBindJSON do
+func decodeJSONBody(c *gin.Context, v any) error {
+	decoder := json.NewDecoder(c.Request.Body)
+	if cfg.StrictJSON {
+		decoder.DisallowUnknownFields()
+	}
+	return decoder.Decode(v)
+}
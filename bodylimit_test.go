@@ -0,0 +1,64 @@
+/*
+Copyright 2021 IBM All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestOversizedBodyRejected(t *testing.T) {
+	ledger := &fakeLedger{respond: readStoredStudent}
+	router := newTestRouter(t, ledger, func(config *Config) {
+		config.MaxBodyBytes = 64
+	})
+	body := `{"id":"S1","name":"` + strings.Repeat("A", 100) + `","cgpa":"9.1"}`
+
+	tests := []struct {
+		name    string
+		method  string
+		target  string
+		chunked bool
+	}{
+		{name: "create", method: http.MethodPost, target: "/api/students"},
+		{name: "update", method: http.MethodPut, target: "/api/students/S1"},
+		// Without a declared length the body is only found to be too large as it is read
+		{name: "create chunked", method: http.MethodPost, target: "/api/students", chunked: true},
+		{name: "update chunked", method: http.MethodPut, target: "/api/students/S1", chunked: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			request := httptest.NewRequest(test.method, test.target, strings.NewReader(body))
+			request.Header.Set("Content-Type", "application/json")
+			request.Header.Set("If-Match", "*")
+			if test.chunked {
+				request.ContentLength = -1
+			}
+			response := httptest.NewRecorder()
+			router.ServeHTTP(response, request)
+
+			if response.Code != http.StatusRequestEntityTooLarge {
+				t.Errorf("status = %d, want 413, body %s", response.Code, response.Body)
+			}
+			if !strings.Contains(response.Body.String(), "must not exceed 64 bytes") {
+				t.Errorf("body = %s, want the limit", response.Body)
+			}
+		})
+	}
+
+	if calls := ledger.submitted(); len(calls) != 0 {
+		t.Errorf("submitted %+v, want nothing", calls)
+	}
+
+	response := serveRequest(router, http.MethodPost, "/api/students", `{"id":"S1","name":"Alice"}`)
+	if response.Code != http.StatusCreated {
+		t.Errorf("status for a body within the limit = %d, want 201, body %s", response.Code, response.Body)
+	}
+}
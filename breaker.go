@@ -0,0 +1,178 @@
+/*
+Copyright 2021 IBM All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// breakerState is whether a circuit breaker lets calls through to its peer
+type breakerState int
+
+const (
+	// breakerClosed lets every call through
+	breakerClosed breakerState = iota
+	// breakerOpen fails calls straight away until the cooldown has passed
+	breakerOpen
+	// breakerHalfOpen lets a single trial call through to see whether the peer has recovered
+	breakerHalfOpen
+)
+
+func (s breakerState) String() string {
+	switch s {
+	case breakerOpen:
+		return "open"
+	case breakerHalfOpen:
+		return "half_open"
+	default:
+		return "closed"
+	}
+}
+
+// circuitOpenError is returned instead of calling a peer whose circuit breaker is open
+type circuitOpenError struct {
+	Endpoint   string
+	RetryAfter time.Duration
+}
+
+func (e *circuitOpenError) Error() string {
+	return fmt.Sprintf("peer %s is unavailable, not calling it for another %s", e.Endpoint, e.RetryAfter.Round(time.Second))
+}
+
+// circuitBreaker stops calls to a peer after CIRCUIT_BREAKER_THRESHOLD consecutive calls in a
+// row have found it unreachable or too slow, so requests fail fast instead of each waiting out
+// the gateway timeouts. After CIRCUIT_BREAKER_COOLDOWN one trial call is let through, and the
+// breaker closes again if it succeeds.
+type circuitBreaker struct {
+	endpoint string
+
+	mu       sync.Mutex
+	state    breakerState
+	failures int
+	openedAt time.Time
+	probing  bool // a trial call is under way in the half-open state
+}
+
+// peerBreakers holds the circuit breaker of each peer endpoint, shared by every organization
+// and connection that calls it
+var peerBreakers = struct {
+	mu       sync.Mutex
+	breakers map[string]*circuitBreaker
+}{breakers: make(map[string]*circuitBreaker)}
+
+// breakerFor returns the circuit breaker of a peer endpoint, creating it on first use
+func breakerFor(endpoint string) *circuitBreaker {
+	peerBreakers.mu.Lock()
+	defer peerBreakers.mu.Unlock()
+
+	breaker, ok := peerBreakers.breakers[endpoint]
+	if !ok {
+		breaker = &circuitBreaker{endpoint: endpoint}
+		peerBreakers.breakers[endpoint] = breaker
+		circuitBreakerState.WithLabelValues(endpoint).Set(float64(breakerClosed))
+	}
+	return breaker
+}
+
+// allow returns an error if the breaker is open, and otherwise lets the call through. Once the
+// cooldown has passed, only one call at a time is let through until one of them succeeds.
+func (b *circuitBreaker) allow() error {
+	if cfg.CircuitBreakerThreshold <= 0 {
+		return nil
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	switch b.state {
+	case breakerOpen:
+		if remaining := cfg.CircuitBreakerCooldown - time.Since(b.openedAt); remaining > 0 {
+			return &circuitOpenError{Endpoint: b.endpoint, RetryAfter: remaining}
+		}
+		b.setStateLocked(breakerHalfOpen)
+		b.probing = true
+		return nil
+	case breakerHalfOpen:
+		if b.probing {
+			return &circuitOpenError{Endpoint: b.endpoint, RetryAfter: time.Second}
+		}
+		b.probing = true
+		return nil
+	default:
+		return nil
+	}
+}
+
+// record counts the outcome of a call that allow let through. Only failures showing the peer
+// is unreachable or too slow count; a chaincode error means the peer is answering.
+func (b *circuitBreaker) record(err error) {
+	if cfg.CircuitBreakerThreshold <= 0 {
+		return
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.probing = false
+	if !isPeerFailure(err) {
+		b.failures = 0
+		if b.state != breakerClosed {
+			slog.Info("Peer recovered, closing circuit breaker", "endpoint", b.endpoint)
+			b.setStateLocked(breakerClosed)
+		}
+		return
+	}
+
+	b.failures++
+	if b.state == breakerHalfOpen || b.failures >= cfg.CircuitBreakerThreshold {
+		if b.state != breakerOpen {
+			slog.Warn("Peer unavailable, opening circuit breaker", "endpoint", b.endpoint, "failures", b.failures, "cooldown", cfg.CircuitBreakerCooldown.String())
+		}
+		b.openedAt = time.Now()
+		b.setStateLocked(breakerOpen)
+	}
+}
+
+// isOpen reports whether the breaker is failing calls straight away
+func (b *circuitBreaker) isOpen() bool {
+	if cfg.CircuitBreakerThreshold <= 0 {
+		return false
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.state == breakerOpen && time.Since(b.openedAt) < cfg.CircuitBreakerCooldown
+}
+
+// currentState returns the breaker's state
+func (b *circuitBreaker) currentState() breakerState {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.state
+}
+
+// setStateLocked changes the breaker's state. The caller must hold b.mu.
+func (b *circuitBreaker) setStateLocked(state breakerState) {
+	b.state = state
+	circuitBreakerState.WithLabelValues(b.endpoint).Set(float64(state))
+}
+
+// isPeerFailure reports whether a call failed because the peer could not be reached or did not answer in time
+func isPeerFailure(err error) bool {
+	switch status.Code(err) {
+	case codes.Unavailable, codes.DeadlineExceeded:
+		return true
+	default:
+		return false
+	}
+}
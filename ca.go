@@ -0,0 +1,377 @@
+/*
+Copyright 2021 IBM All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package main
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/hyperledger/fabric-gateway/pkg/identity"
+)
+
+// caRequestTimeout bounds each call to the Fabric CA
+const caRequestTimeout = 30 * time.Second
+
+// CAConfig describes the Fabric CA that API identities are registered with and enrolled from
+type CAConfig struct {
+	// Base URL of the CA, such as https://localhost:7054; the CA endpoints are disabled when it is not set
+	URL string `yaml:"url"`
+
+	// Name of the CA to use when the server hosts more than one, such as ca-org1
+	CAName string `yaml:"caName"`
+
+	// PEM file of the CA certificates trusted for the CA's TLS connection
+	TLSCertPath string `yaml:"tlsCertPath"`
+
+	// Wallet label of the registrar identity that registers new users
+	Registrar string `yaml:"registrar"`
+
+	// MSP ID of the identities the CA issues; defaults to the default organization's
+	MSPID string `yaml:"mspId"`
+}
+
+// caResponse is the envelope of every Fabric CA response
+type caResponse struct {
+	Success bool            `json:"success"`
+	Result  json.RawMessage `json:"result"`
+	Errors  []struct {
+		Code    int    `json:"code"`
+		Message string `json:"message"`
+	} `json:"errors"`
+}
+
+// caError is an error reported by the Fabric CA, with the HTTP status it responded with
+type caError struct {
+	StatusCode int
+	Message    string
+}
+
+func (e *caError) Error() string {
+	return fmt.Sprintf("Fabric CA responded %d: %s", e.StatusCode, e.Message)
+}
+
+// caAttribute is an attribute registered for an identity, included in its enrollment
+// certificate by default when ECert is set
+type caAttribute struct {
+	Name  string `json:"name" binding:"required"`
+	Value string `json:"value"`
+	ECert bool   `json:"ecert,omitempty"`
+}
+
+// registerRequest is the body of a request to register a new identity with the CA
+type registerRequest struct {
+	EnrollmentID   string        `json:"enrollmentId" binding:"required"`
+	Secret         string        `json:"secret"`
+	Type           string        `json:"type"`
+	Affiliation    string        `json:"affiliation"`
+	MaxEnrollments int           `json:"maxEnrollments"`
+	Attributes     []caAttribute `json:"attributes"`
+}
+
+// enrollRequest is the body of a request to enroll a registered identity and store it in the wallet
+type enrollRequest struct {
+	EnrollmentID string `json:"enrollmentId" binding:"required"`
+	Secret       string `json:"secret" binding:"required"`
+
+	// Wallet label to store the identity under; defaults to the enrollment ID
+	Label string `json:"label"`
+}
+
+// caClient calls the Fabric CA REST API
+type caClient struct {
+	config CAConfig
+	http   *http.Client
+}
+
+// newCAClient creates a client for the configured CA, trusting its TLS certificates if given
+func newCAClient(config CAConfig) (*caClient, error) {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if config.TLSCertPath != "" {
+		certificatePEM, err := os.ReadFile(config.TLSCertPath)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read CA TLS certificate file: %w", err)
+		}
+		certPool := x509.NewCertPool()
+		if !certPool.AppendCertsFromPEM(certificatePEM) {
+			return nil, fmt.Errorf("no certificates found in %s", config.TLSCertPath)
+		}
+		transport.TLSClientConfig = &tls.Config{RootCAs: certPool, MinVersion: tls.VersionTLS12}
+	}
+
+	return &caClient{config: config, http: &http.Client{Transport: transport, Timeout: caRequestTimeout}}, nil
+}
+
+// enroll has the CA sign a certificate for a new key pair, authenticating with the enrollment
+// ID and secret, and returns the PEM-encoded certificate and private key
+func (ca *caClient) enroll(enrollmentID, secret string) (string, string, error) {
+	privateKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return "", "", fmt.Errorf("failed to generate private key: %w", err)
+	}
+
+	csr, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{Subject: pkix.Name{CommonName: enrollmentID}}, privateKey)
+	if err != nil {
+		return "", "", fmt.Errorf("failed to create certificate request: %w", err)
+	}
+
+	body, err := json.Marshal(map[string]string{
+		"certificate_request": string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE REQUEST", Bytes: csr})),
+		"caname":              ca.config.CAName,
+	})
+	if err != nil {
+		return "", "", err
+	}
+
+	request, err := ca.newRequest("/api/v1/enroll", body)
+	if err != nil {
+		return "", "", err
+	}
+	request.SetBasicAuth(enrollmentID, secret)
+
+	var result struct {
+		Cert string `json:"Cert"`
+	}
+	if err := ca.do(request, &result); err != nil {
+		return "", "", err
+	}
+
+	certificatePEM, err := base64.StdEncoding.DecodeString(result.Cert)
+	if err != nil {
+		return "", "", fmt.Errorf("failed to decode enrollment certificate: %w", err)
+	}
+
+	keyDER, err := x509.MarshalPKCS8PrivateKey(privateKey)
+	if err != nil {
+		return "", "", err
+	}
+	return string(certificatePEM), string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER})), nil
+}
+
+// register registers a new identity with the CA on behalf of the registrar, returning its
+// enrollment secret, which the CA generates if none is given
+func (ca *caClient) register(registrar walletIdentity, registration registerRequest) (string, error) {
+	body, err := json.Marshal(map[string]interface{}{
+		"id":              registration.EnrollmentID,
+		"secret":          registration.Secret,
+		"type":            registration.Type,
+		"affiliation":     registration.Affiliation,
+		"max_enrollments": registration.MaxEnrollments,
+		"attrs":           registration.Attributes,
+		"caname":          ca.config.CAName,
+	})
+	if err != nil {
+		return "", err
+	}
+
+	request, err := ca.newRequest("/api/v1/register", body)
+	if err != nil {
+		return "", err
+	}
+
+	token, err := caAuthToken(registrar, http.MethodPost, request.URL.RequestURI(), body)
+	if err != nil {
+		return "", err
+	}
+	request.Header.Set("Authorization", token)
+
+	var result struct {
+		Secret string `json:"secret"`
+	}
+	if err := ca.do(request, &result); err != nil {
+		return "", err
+	}
+	return result.Secret, nil
+}
+
+// newRequest creates a JSON POST request to a CA endpoint
+func (ca *caClient) newRequest(endpoint string, body []byte) (*http.Request, error) {
+	request, err := http.NewRequest(http.MethodPost, strings.TrimSuffix(ca.config.URL, "/")+endpoint, bytes.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("invalid CA URL: %w", err)
+	}
+	request.Header.Set("Content-Type", "application/json")
+	return request, nil
+}
+
+// do sends a request to the CA and decodes the result of a successful response into result
+func (ca *caClient) do(request *http.Request, result interface{}) error {
+	response, err := ca.http.Do(request)
+	if err != nil {
+		return fmt.Errorf("failed to reach Fabric CA: %w", err)
+	}
+	defer response.Body.Close()
+
+	var envelope caResponse
+	if err := json.NewDecoder(response.Body).Decode(&envelope); err != nil {
+		return &caError{StatusCode: response.StatusCode, Message: fmt.Sprintf("unreadable response: %v", err)}
+	}
+
+	if !envelope.Success {
+		messages := make([]string, 0, len(envelope.Errors))
+		for _, e := range envelope.Errors {
+			messages = append(messages, fmt.Sprintf("%s (code %d)", e.Message, e.Code))
+		}
+		return &caError{StatusCode: response.StatusCode, Message: strings.Join(messages, "; ")}
+	}
+
+	if err := json.Unmarshal(envelope.Result, result); err != nil {
+		return fmt.Errorf("failed to parse Fabric CA result: %w", err)
+	}
+	return nil
+}
+
+// caAuthToken builds the token with which an enrolled identity authenticates a CA request: its
+// certificate and its signature over the request method, URI, body, and certificate
+func caAuthToken(id walletIdentity, method, uri string, body []byte) (string, error) {
+	privateKey, err := identity.PrivateKeyFromPEM([]byte(id.Credentials.PrivateKey))
+	if err != nil {
+		return "", fmt.Errorf("invalid registrar private key: %w", err)
+	}
+	sign, err := identity.NewPrivateKeySign(privateKey)
+	if err != nil {
+		return "", err
+	}
+
+	encoding := base64.StdEncoding
+	certificate := encoding.EncodeToString([]byte(id.Credentials.Certificate))
+	payload := method + "." + encoding.EncodeToString([]byte(uri)) + "." + encoding.EncodeToString(body) + "." + certificate
+
+	digest := sha256.Sum256([]byte(payload))
+	signature, err := sign(digest[:])
+	if err != nil {
+		return "", fmt.Errorf("failed to sign CA request: %w", err)
+	}
+	return certificate + "." + encoding.EncodeToString(signature), nil
+}
+
+// requestCAClient returns a client for the configured CA, writing an error response if it is unavailable
+func requestCAClient(c *gin.Context) (*caClient, bool) {
+	if cfg.CA.URL == "" {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Fabric CA is not configured"})
+		return nil, false
+	}
+
+	ca, err := newCAClient(cfg.CA)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("Failed to create Fabric CA client: %v", err)})
+		return nil, false
+	}
+	return ca, true
+}
+
+// writeCAError responds to a failed CA call. Requests the CA rejects, such as an enrollment with
+// the wrong secret, are reported as 400 rather than passing on a 401 that would look like the
+// API token was refused; anything else is reported as a bad gateway.
+func writeCAError(c *gin.Context, action string, err error) {
+	status := http.StatusBadGateway
+	var caErr *caError
+	if errors.As(err, &caErr) && caErr.StatusCode >= 400 && caErr.StatusCode < 500 {
+		status = http.StatusBadRequest
+	}
+	c.JSON(status, gin.H{"error": fmt.Sprintf("Failed to %s: %v", action, err)})
+}
+
+// registerIdentity registers a new identity with the Fabric CA using the registrar identity from the wallet
+func registerIdentity(c *gin.Context) {
+	var request registerRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		writeBindError(c, err)
+		return
+	}
+	if request.Type == "" {
+		request.Type = "client"
+	}
+
+	ca, ok := requestCAClient(c)
+	if !ok {
+		return
+	}
+
+	if cfg.CA.Registrar == "" {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "No registrar identity is configured for the Fabric CA"})
+		return
+	}
+	registrar, err := identities.get(cfg.CA.Registrar)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("Failed to load registrar identity: %v", err)})
+		return
+	}
+
+	requestLogger(c).Info("Registering identity", "enrollmentId", request.EnrollmentID, "type", request.Type, "affiliation", request.Affiliation)
+
+	secret, err := ca.register(registrar, request)
+	if err != nil {
+		writeCAError(c, "register identity", err)
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{"enrollmentId": request.EnrollmentID, "secret": secret})
+}
+
+// enrollIdentity enrolls a registered identity with the Fabric CA and stores the issued certificate
+// and its new private key in the wallet
+func enrollIdentity(c *gin.Context) {
+	var request enrollRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		writeBindError(c, err)
+		return
+	}
+	if request.Label == "" {
+		request.Label = request.EnrollmentID
+	}
+	if !validWalletLabel.MatchString(request.Label) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Invalid identity label %q", request.Label)})
+		return
+	}
+
+	ca, ok := requestCAClient(c)
+	if !ok {
+		return
+	}
+
+	// Check the label is free before the CA uses up one of the identity's enrollments
+	if _, err := identities.get(request.Label); !errors.Is(err, errIdentityNotFound) {
+		c.JSON(http.StatusConflict, gin.H{"error": fmt.Sprintf("Identity %s already exists", request.Label)})
+		return
+	}
+
+	requestLogger(c).Info("Enrolling identity", "enrollmentId", request.EnrollmentID, "label", request.Label)
+
+	certificatePEM, privateKeyPEM, err := ca.enroll(request.EnrollmentID, request.Secret)
+	if err != nil {
+		writeCAError(c, "enroll identity", err)
+		return
+	}
+
+	mspID := cfg.CA.MSPID
+	if mspID == "" {
+		mspID = cfg.Org.MSPID
+	}
+	id, err := newX509WalletIdentity(mspID, certificatePEM, privateKeyPEM)
+	if err != nil {
+		c.JSON(http.StatusBadGateway, gin.H{"error": fmt.Sprintf("Fabric CA issued an unusable certificate: %v", err)})
+		return
+	}
+
+	storeIdentity(c, request.Label, id)
+}
@@ -0,0 +1,319 @@
+/*
+Copyright 2021 IBM All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+)
+
+// maxCacheEntries bounds the number of responses cached in memory, so many distinct URLs cannot exhaust memory
+const maxCacheEntries = 1000
+
+// responseCache caches successful GET responses for as long as the TTL configured for their
+// route pattern. Routes without a TTL are not cached.
+type responseCache struct {
+	ttls  map[string]time.Duration
+	store cacheStore
+}
+
+// cachedHeaders are the response headers kept with a cached response and sent again with it
+var cachedHeaders = []string{"ETag", "Cache-Control"}
+
+// cacheEntry is a cached response, the student it shows if its route names one, and the time it stops being fresh
+type cacheEntry struct {
+	contentType string
+	headers     map[string]string
+	body        []byte
+	studentID   string
+	expires     time.Time
+}
+
+// cacheStore holds the cached responses, along with the student each one shows. Failures are
+// the store's to log; a response that cannot be read is treated as not cached.
+type cacheStore interface {
+	get(ctx context.Context, key, studentID string) (cacheEntry, bool)
+	put(ctx context.Context, key string, entry cacheEntry)
+
+	// invalidate drops the responses showing the given students, along with every response not
+	// about a single student, such as listings, which may include them
+	invalidate(ctx context.Context, ids []string)
+
+	clear(ctx context.Context)
+	close()
+}
+
+// readCache is the response cache of the API routes
+var readCache *responseCache
+
+// newResponseCache creates a cache using the given TTL per route pattern, such as
+// "/api/students/:id", keeping responses in Redis if a URL is given and in memory otherwise
+func newResponseCache(ttls map[string]time.Duration, redisURL string) (*responseCache, error) {
+	if redisURL == "" {
+		return &responseCache{ttls: ttls, store: &memoryCache{entries: make(map[string]cacheEntry)}}, nil
+	}
+
+	store, err := newRedisCache(redisURL)
+	if err != nil {
+		return nil, err
+	}
+	return &responseCache{ttls: ttls, store: store}, nil
+}
+
+// enabled reports whether any route is cached
+func (rc *responseCache) enabled() bool {
+	for _, ttl := range rc.ttls {
+		if ttl > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// close releases the cache's store
+func (rc *responseCache) close() {
+	rc.store.close()
+}
+
+// cachingWriter captures the body written by a handler so it can be cached
+type cachingWriter struct {
+	gin.ResponseWriter
+	body bytes.Buffer
+}
+
+// Write writes the data to the response and keeps a copy
+func (w *cachingWriter) Write(data []byte) (int, error) {
+	w.body.Write(data)
+	return w.ResponseWriter.Write(data)
+}
+
+// WriteString writes the string to the response and keeps a copy
+func (w *cachingWriter) WriteString(s string) (int, error) {
+	w.body.WriteString(s)
+	return w.ResponseWriter.WriteString(s)
+}
+
+// middleware returns a Gin handler that serves fresh cached responses and caches new ones.
+// A successful write to a student drops the responses showing that student and every
+// listing, and any other successful write clears the whole cache.
+func (rc *responseCache) middleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx := c.Request.Context()
+		if c.Request.Method != http.MethodGet {
+			c.Next()
+			if isWriteRequest(c) && c.Writer.Status() < http.StatusBadRequest {
+				if id := c.Param("id"); id != "" {
+					rc.store.invalidate(ctx, []string{id})
+				} else {
+					rc.store.clear(ctx)
+				}
+			}
+			return
+		}
+
+		ttl := rc.ttls[routePattern(c)]
+		if ttl <= 0 {
+			c.Next()
+			return
+		}
+
+		key := cacheKey(c)
+		if entry, ok := rc.store.get(ctx, key, c.Param("id")); ok {
+			c.Header("X-Cache", "HIT")
+			for name, value := range entry.headers {
+				c.Header(name, value)
+			}
+			// A client that already has this version is told so without the ledger being read
+			if etag := entry.headers["ETag"]; etag != "" && ifNoneMatch(c, etag) {
+				c.Status(http.StatusNotModified)
+			} else {
+				c.Data(http.StatusOK, entry.contentType, entry.body)
+			}
+			c.Abort()
+			return
+		}
+
+		c.Header("X-Cache", "MISS")
+		writer := &cachingWriter{ResponseWriter: c.Writer}
+		c.Writer = writer
+		c.Next()
+
+		if writer.Status() == http.StatusOK {
+			headers := make(map[string]string)
+			for _, name := range cachedHeaders {
+				if value := writer.Header().Get(name); value != "" {
+					headers[name] = value
+				}
+			}
+			rc.store.put(ctx, key, cacheEntry{
+				contentType: writer.Header().Get("Content-Type"),
+				headers:     headers,
+				body:        writer.body.Bytes(),
+				studentID:   c.Param("id"),
+				expires:     time.Now().Add(ttl),
+			})
+		}
+	}
+}
+
+// cacheKey returns the key a response to the request is cached under. Organizations and
+// channels may see different data, and lists may be sent in different formats, so each gets its
+// own entries. So does each user transacting with their own Fabric identity, which the chaincode
+// may let read what the organization's shared identity cannot, such as private data.
+func cacheKey(c *gin.Context) string {
+	ctx := c.Request.Context()
+	key := channelFrom(ctx) + " " + c.NegotiateFormat(studentListFormats...) + " " + c.Request.URL.RequestURI()
+	if fc := connectionFrom(ctx); fc != nil {
+		key = fc.org.MSPID + " " + key
+	}
+	if user, ok := requestUserIdentity(c); ok {
+		key = "user:" + user.Username + " " + key
+	}
+	return key
+}
+
+// invalidateOnEvents subscribes the cache to the chaincode's events, so changes committed by
+// other clients are seen before the TTL expires. Events naming students drop the responses
+// showing them, and other events clear the whole cache.
+func (rc *responseCache) invalidateOnEvents() error {
+	sub := newEventSubscriber(nil)
+	if err := chaincodeEvents.add(sub); err != nil {
+		return err
+	}
+	go rc.listen(sub)
+	return nil
+}
+
+// listen invalidates cached responses for each event from the hub until the hub shuts down. If
+// the hub drops the cache for falling behind, events may have been missed, so the whole cache
+// is cleared and the cache subscribes again.
+func (rc *responseCache) listen(sub *eventSubscriber) {
+	ctx := context.Background()
+	for {
+		select {
+		case message := <-sub.events:
+			var event struct {
+				IDs []string `json:"ids"`
+			}
+			if err := json.Unmarshal(message.Payload, &event); err != nil || len(event.IDs) == 0 {
+				rc.store.clear(ctx)
+				continue
+			}
+			rc.store.invalidate(ctx, event.IDs)
+		case <-sub.done:
+			if sub.closeCode == websocket.CloseGoingAway {
+				return
+			}
+			slog.Warn("Response cache dropped by the event hub, subscribing again", "reason", sub.closeText)
+			rc.store.clear(ctx)
+			if err := rc.invalidateOnEvents(); err != nil {
+				slog.Error("Failed to subscribe response cache to events", "error", err)
+			}
+			return
+		}
+	}
+}
+
+// readOnlyRoutes are the routes, by method and route pattern, that use a write method but
+// only read records, such as a query too large for a URL
+var readOnlyRoutes = map[string]bool{
+	"POST /api/students/query":  true,
+	"POST /api/students/lookup": true,
+	"POST /api/tx/evaluate":     true,
+
+	// Creating an export job or cancelling a job writes no records
+	"POST /api/students/export/jobs": true,
+	"DELETE /api/jobs/:id":           true,
+}
+
+// isWriteMethod reports whether requests with the method may change records
+func isWriteMethod(method string) bool {
+	switch method {
+	case http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete:
+		return true
+	default:
+		return false
+	}
+}
+
+// isWriteRequest reports whether a request may change records, going by its method unless its
+// route only reads
+func isWriteRequest(c *gin.Context) bool {
+	return isWriteMethod(c.Request.Method) && !readOnlyRoutes[c.Request.Method+" "+routePattern(c)]
+}
+
+// memoryCache keeps cached responses in the server's memory
+type memoryCache struct {
+	mu      sync.Mutex
+	entries map[string]cacheEntry
+}
+
+// get returns the cached entry for key if it is still fresh
+func (mc *memoryCache) get(_ context.Context, key, _ string) (cacheEntry, bool) {
+	mc.mu.Lock()
+	defer mc.mu.Unlock()
+
+	entry, ok := mc.entries[key]
+	if !ok {
+		return cacheEntry{}, false
+	}
+	if time.Now().After(entry.expires) {
+		delete(mc.entries, key)
+		return cacheEntry{}, false
+	}
+	return entry, true
+}
+
+// put caches an entry, first dropping expired entries if the cache is full. If the
+// cache is still full the entry is not cached.
+func (mc *memoryCache) put(_ context.Context, key string, entry cacheEntry) {
+	mc.mu.Lock()
+	defer mc.mu.Unlock()
+
+	if len(mc.entries) >= maxCacheEntries {
+		now := time.Now()
+		for k, e := range mc.entries {
+			if now.After(e.expires) {
+				delete(mc.entries, k)
+			}
+		}
+		if len(mc.entries) >= maxCacheEntries {
+			return
+		}
+	}
+	mc.entries[key] = entry
+}
+
+// invalidate drops the entries showing the given students and every entry not about a single student
+func (mc *memoryCache) invalidate(_ context.Context, ids []string) {
+	mc.mu.Lock()
+	defer mc.mu.Unlock()
+
+	for k, e := range mc.entries {
+		if e.studentID == "" || slices.Contains(ids, e.studentID) {
+			delete(mc.entries, k)
+		}
+	}
+}
+
+// clear drops every cached entry
+func (mc *memoryCache) clear(context.Context) {
+	mc.mu.Lock()
+	defer mc.mu.Unlock()
+	clear(mc.entries)
+}
+
+func (mc *memoryCache) close() {}
@@ -0,0 +1,97 @@
+/*
+Copyright 2021 IBM All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestCacheTTLsExpireIndependently(t *testing.T) {
+	const shortTTL = 100 * time.Millisecond
+
+	evaluations := map[string]int{}
+	ledger := &fakeLedger{respond: func(name string, _ []string) ([]byte, error) {
+		evaluations[name]++
+		switch name {
+		case "ReadStudent":
+			return []byte(storedStudent), nil
+		case "StudentStats":
+			return []byte(`{"total":1,"branches":[]}`), nil
+		}
+		return nil, nil
+	}}
+	router := newTestRouter(t, ledger, func(config *Config) {
+		config.CacheTTLs = map[string]time.Duration{"/api/students/:id": shortTTL, "/api/students/stats": time.Hour}
+	})
+
+	get := func(target, wantCache string) {
+		t.Helper()
+		response := serveRequest(router, http.MethodGet, target, "")
+		if response.Code != http.StatusOK {
+			t.Fatalf("GET %s: status = %d, body %s", target, response.Code, response.Body)
+		}
+		if cache := response.Header().Get("X-Cache"); cache != wantCache {
+			t.Errorf("GET %s: X-Cache = %q, want %s", target, cache, wantCache)
+		}
+	}
+
+	get("/api/students/S1", "MISS")
+	get("/api/students/stats", "MISS")
+	get("/api/students/S1", "HIT")
+	get("/api/students/stats", "HIT")
+
+	time.Sleep(shortTTL + 50*time.Millisecond)
+
+	get("/api/students/S1", "MISS")
+	get("/api/students/stats", "HIT")
+
+	if evaluations["ReadStudent"] != 2 || evaluations["StudentStats"] != 1 {
+		t.Errorf("evaluated %v, want ReadStudent twice and StudentStats once", evaluations)
+	}
+
+	// Routes without a TTL are not cached
+	response := serveRequest(router, http.MethodGet, "/api/students/digest", "")
+	if cache := response.Header().Get("X-Cache"); cache != "" {
+		t.Errorf("X-Cache = %q for a route without a TTL", cache)
+	}
+}
+
+func TestCacheKeyScopesUsersWithOwnIdentity(t *testing.T) {
+	useConfig(t, func(config *Config) {
+		config.Users = []UserConfig{
+			{Username: "alice", Identity: "alice"},
+			{Username: "bob", CertPath: "/wallet/bob/signcerts", KeyPath: "/wallet/bob/keystore"},
+			{Username: "carol"},
+			{Username: "dave"},
+		}
+	})
+
+	key := func(subject string) string {
+		c, _ := gin.CreateTestContext(httptest.NewRecorder())
+		c.Request = httptest.NewRequest(http.MethodGet, "/api/students/S1/private", nil)
+		if subject != "" {
+			c.Set(subjectKey, subject)
+		}
+		return cacheKey(c)
+	}
+
+	if key("alice") == key("bob") || key("alice") == key("carol") || key("bob") == key("") {
+		t.Error("users with their own identity share cached responses with other users")
+	}
+	if key("alice") != key("alice") {
+		t.Error("a user's requests are cached under different keys")
+	}
+	// Users transacting with the organization's shared identity see the same data
+	if key("carol") != key("dave") || key("carol") != key("") {
+		t.Error("users of the shared identity are cached apart")
+	}
+}
@@ -0,0 +1,113 @@
+/*
+Copyright 2021 IBM All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// channelNameHeader is the request header used to choose the channel a request transacts on,
+// for routes that don't name one in the URL
+const channelNameHeader = "X-Channel"
+
+// channelRoutePrefix is the route prefix of the ledger routes that name their channel in the URL
+const channelRoutePrefix = "/api/channels/:channel"
+
+// validChannelName matches the channel names Fabric accepts
+var validChannelName = regexp.MustCompile(`^[a-z][a-z0-9.-]{0,248}$`)
+
+// channelKey is the context key under which the channel chosen for a request is stored
+type channelKey struct{}
+
+// contextWithChannel returns a copy of ctx that transacts on the given channel
+func contextWithChannel(ctx context.Context, channel string) context.Context {
+	return context.WithValue(ctx, channelKey{}, channel)
+}
+
+// channelFrom returns the channel chosen for a request, falling back to the configured channel
+func channelFrom(ctx context.Context) string {
+	if channel, ok := ctx.Value(channelKey{}).(string); ok {
+		return channel
+	}
+	return channelName
+}
+
+// allowedChannels returns the channels requests may choose: the configured channel and the
+// additional ones listed in CHANNELS, in sorted order
+func allowedChannels() []string {
+	channels := []string{channelName}
+	for _, channel := range cfg.Channels {
+		if channel != channelName {
+			channels = append(channels, channel)
+		}
+	}
+	sort.Strings(channels)
+	return channels
+}
+
+// isAllowedChannel reports whether requests may transact on the channel
+func isAllowedChannel(channel string) bool {
+	for _, allowed := range allowedChannels() {
+		if channel == allowed {
+			return true
+		}
+	}
+	return false
+}
+
+// selectChannel resolves the channel named in the URL under /api/channels/:channel, or by the
+// X-Channel header, defaulting to the configured channel, and makes it available to the handlers.
+// Each connection creates its network and contract handles for a channel when first asked.
+func selectChannel(c *gin.Context) {
+	channel := c.Param("channel")
+	if channel == "" {
+		channel = c.GetHeader(channelNameHeader)
+	}
+	if channel == "" {
+		c.Next()
+		return
+	}
+
+	if !isAllowedChannel(channel) {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Unknown channel %q", channel), "channels": allowedChannels()})
+		return
+	}
+
+	ctx := contextWithChannel(c.Request.Context(), channel)
+	ctx = context.WithValue(ctx, loggerKey{}, requestLogger(c).With("channel", channel))
+	c.Request = c.Request.WithContext(ctx)
+
+	c.Next()
+}
+
+// routePattern returns the pattern of the route a request matched, with the version prefix and
+// the channel prefix of the per-channel routes replaced by /api, so every form of a route shares
+// its settings
+func routePattern(c *gin.Context) string {
+	route := unversionedRoute(c.FullPath())
+	if rest, ok := strings.CutPrefix(route, channelRoutePrefix); ok {
+		return "/api" + rest
+	}
+	return route
+}
+
+// validateChannels checks that the additional channels are valid channel names
+func validateChannels(channels []string) error {
+	for _, channel := range channels {
+		if !validChannelName.MatchString(channel) {
+			return fmt.Errorf("invalid channel name %q in CHANNELS", channel)
+		}
+	}
+	return nil
+}
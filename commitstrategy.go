@@ -0,0 +1,207 @@
+/*
+Copyright 2021 IBM All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/hyperledger/fabric-gateway/pkg/client"
+	"google.golang.org/grpc"
+)
+
+// commitStrategy controls how long a write request waits before responding
+type commitStrategy string
+
+const (
+	// waitForCommit responds once the transaction has committed
+	waitForCommit commitStrategy = "wait-for-commit"
+	// waitForEndorse responds once the transaction is endorsed and sent to the orderer
+	waitForEndorse commitStrategy = "wait-for-endorse"
+	// fireAndForget responds straight away and submits the transaction in the background
+	fireAndForget commitStrategy = "fire-and-forget"
+)
+
+// commitStrategyHeader is the request header used to choose a commit strategy
+const commitStrategyHeader = "X-Commit-Strategy"
+
+// parseCommitStrategy validates a commit strategy name
+func parseCommitStrategy(name string) (commitStrategy, error) {
+	switch strategy := commitStrategy(name); strategy {
+	case waitForCommit, waitForEndorse, fireAndForget:
+		return strategy, nil
+	default:
+		return "", fmt.Errorf("unknown commit strategy %q, expected %s, %s, or %s", name, waitForCommit, waitForEndorse, fireAndForget)
+	}
+}
+
+// requestCommitStrategy returns the commit strategy chosen by the request header, or the configured
+// default. A request with ?async=true that doesn't choose one uses wait-for-endorse.
+func requestCommitStrategy(c *gin.Context) (commitStrategy, error) {
+	name := c.GetHeader(commitStrategyHeader)
+	if name != "" {
+		return parseCommitStrategy(name)
+	}
+
+	if value, ok := c.GetQuery("async"); ok {
+		async, err := strconv.ParseBool(value)
+		if err != nil {
+			return "", fmt.Errorf("invalid async %q, expected true or false", value)
+		}
+		if async {
+			return waitForEndorse, nil
+		}
+	}
+	return cfg.CommitStrategy, nil
+}
+
+// commitTimeoutHeader is the request header used to override how long a write waits for its commit
+const commitTimeoutHeader = "X-Commit-Timeout"
+
+// applyCommitTimeout sets the commit status timeout requested in the X-Commit-Timeout header, such
+// as "2m", on the request context. Timeouts above the configured maximum are clamped to it. It
+// writes a 400 response and returns false if the header is invalid.
+func applyCommitTimeout(c *gin.Context) bool {
+	value := c.GetHeader(commitTimeoutHeader)
+	if value == "" {
+		return true
+	}
+
+	timeout, err := time.ParseDuration(value)
+	if err != nil || timeout <= 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Invalid %s %q, expected a positive duration such as 30s", commitTimeoutHeader, value)})
+		return false
+	}
+	if timeout > cfg.MaxCommitTimeout {
+		requestLogger(c).Info("Clamping requested commit timeout", "requested", timeout.String(), "max", cfg.MaxCommitTimeout.String())
+		timeout = cfg.MaxCommitTimeout
+	}
+
+	c.Request = c.Request.WithContext(contextWithCommitTimeout(c.Request.Context(), timeout))
+	return true
+}
+
+// submitWithoutCommitWait submits a transaction using a strategy that does not wait for
+// the commit, and writes a 202 response carrying the transaction ID. The transaction's
+// progress can be followed at /api/transactions/:txid.
+func submitWithoutCommitWait(c *gin.Context, strategy commitStrategy, fn string, args ...string) {
+	// The transaction outlives the request, so it must not be cancelled along with it
+	ctx := context.WithoutCancel(c.Request.Context())
+
+	tx, err := requestLedger(c).ProposeTransaction(ctx, fn, args...)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("Failed to create transaction proposal: %v", err)})
+		return
+	}
+
+	start := time.Now()
+	txID := tx.TransactionID()
+	if info := transactionInfoFrom(ctx); info != nil {
+		*info = transactionInfo{TransactionID: txID}
+	}
+	logger := loggerFrom(ctx).With("function", fn, "transactionId", txID, "commitStrategy", string(strategy))
+	transactions.start(txID, fn)
+	c.Header("Location", transactionStatusPath(c, txID))
+
+	if strategy == fireAndForget {
+		logger.Info("Submitting transaction in the background")
+		goBackground(func() {
+			err := tx.Submit(ctx)
+			transactions.submitted(txID, err)
+			if err != nil {
+				logger.Warn("Background transaction failed", "outcome", transactionOutcome(err), "error", err)
+				return
+			}
+			awaitCommit(ctx, logger, fn, start, tx)
+		})
+
+		c.JSON(http.StatusAccepted, gin.H{"transactionId": txID, "status": "accepted", "statusUrl": transactionStatusPath(c, txID)})
+		return
+	}
+
+	logger.Info("Submitting transaction")
+	err = tx.Submit(ctx)
+	transactions.submitted(txID, err)
+	if err != nil {
+		logger.Warn("Transaction failed", "outcome", transactionOutcome(err), "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("Failed to submit transaction: %v", err), "transactionId": txID})
+		return
+	}
+
+	// The commit status is still worth logging and counting once it arrives
+	goBackground(func() { awaitCommit(ctx, logger, fn, start, tx) })
+
+	c.JSON(http.StatusAccepted, gin.H{"transactionId": txID, "status": "submitted", "statusUrl": transactionStatusPath(c, txID)})
+}
+
+// gatewayProposal is a transaction proposed through one of the gateway peers of the
+// organization chosen for the request, to be submitted without waiting for its commit
+type gatewayProposal struct {
+	peer     *peerConnection
+	conn     *grpc.ClientConn
+	proposal *client.Proposal
+	fn       string
+	start    time.Time
+	commit   *client.Commit
+}
+
+// ProposeTransaction creates a proposal for a transaction through the preferred peer, endorsed by
+// the organizations chosen for the request, if any
+func (gatewayContract) ProposeTransaction(ctx context.Context, name string, args ...string) (proposedTransaction, error) {
+	peer := connectionFrom(ctx).preferredPeer()
+	conn, proposal, err := peer.newProposal(ctx, name, withEndorsingOrgs(ctx, []client.ProposalOption{client.WithArguments(args...)})...)
+	if err != nil {
+		return nil, err
+	}
+	return &gatewayProposal{peer: peer, conn: conn, proposal: proposal, fn: name, start: time.Now()}, nil
+}
+
+// TransactionID returns the ID of the proposed transaction
+func (p *gatewayProposal) TransactionID() string {
+	return p.proposal.TransactionID()
+}
+
+// Submit endorses the proposal through the peer that created it and sends it to the orderer,
+// retrying transient failures. A failure is recorded with its latency since the proposal was made.
+func (p *gatewayProposal) Submit(ctx context.Context) error {
+	err := retryTransient(ctx, p.fn, func() error {
+		var err error
+		_, p.commit, err = endorseAndSubmit(ctx, p.proposal)
+		return err
+	})
+	if err != nil {
+		recordTransaction("submit", p.fn, p.start, err)
+		p.peer.reconnectIfUnavailable(p.conn, err)
+		return err
+	}
+	return nil
+}
+
+// AwaitCommit waits for the submitted transaction to commit
+func (p *gatewayProposal) AwaitCommit(ctx context.Context) (*client.Status, error) {
+	return awaitCommitStatus(ctx, p.commit)
+}
+
+// awaitCommit waits for a submitted transaction to commit and records the outcome, along
+// with the latency since the submit started
+func awaitCommit(ctx context.Context, logger *slog.Logger, fn string, start time.Time, tx proposedTransaction) {
+	status, err := tx.AwaitCommit(ctx)
+	submitLatency.observe(time.Since(start), time.Now())
+	recordTransaction("submit", fn, start, err)
+	transactions.committed(tx.TransactionID(), status, err)
+
+	if err != nil {
+		logger.Warn("Transaction did not commit", "outcome", transactionOutcome(err), "error", err)
+		return
+	}
+	logger.Info("Transaction committed", "blockNumber", status.BlockNumber)
+}
@@ -0,0 +1,196 @@
+/*
+Copyright 2021 IBM All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/hyperledger/fabric-gateway/pkg/client"
+	"github.com/hyperledger/fabric-protos-go-apiv2/peer"
+)
+
+// blockingLedger is a fakeLedger whose submits wait for submitted to be closed and whose
+// commits wait for committed to be closed
+func blockingLedger() (ledger *fakeLedger, submitted, committed chan struct{}) {
+	submitted, committed = make(chan struct{}), make(chan struct{})
+	ledger = &fakeLedger{
+		respond: func(string, []string) ([]byte, error) {
+			<-submitted
+			return nil, nil
+		},
+		awaitCommit: func(txID string) (*client.Status, error) {
+			<-committed
+			return &client.Status{Code: peer.TxValidationCode_VALID, Successful: true, BlockNumber: 9, TransactionID: txID}, nil
+		},
+	}
+	return ledger, submitted, committed
+}
+
+// serveAsync serves a request in the background, returning the channel its response is sent on
+func serveAsync(router *gin.Engine, method, target, body string, headers ...string) <-chan *httptest.ResponseRecorder {
+	responses := make(chan *httptest.ResponseRecorder, 1)
+	go func() {
+		responses <- serveRequest(router, method, target, body, headers...)
+	}()
+	return responses
+}
+
+// awaitResponse returns the response sent on responses, failing the test if there is none within a second
+func awaitResponse(t *testing.T, responses <-chan *httptest.ResponseRecorder) *httptest.ResponseRecorder {
+	t.Helper()
+
+	select {
+	case response := <-responses:
+		return response
+	case <-time.After(time.Second):
+		t.Fatal("no response")
+		return nil
+	}
+}
+
+// assertPending checks that no response has been sent on responses yet
+func assertPending(t *testing.T, responses <-chan *httptest.ResponseRecorder, waitingFor string) {
+	t.Helper()
+
+	select {
+	case response := <-responses:
+		t.Fatalf("responded %d before the %s", response.Code, waitingFor)
+	case <-time.After(20 * time.Millisecond):
+	}
+}
+
+// acceptedTransaction parses the body of a 202 response
+func acceptedTransaction(t *testing.T, response *httptest.ResponseRecorder) (txID, status string) {
+	t.Helper()
+
+	if response.Code != http.StatusAccepted {
+		t.Fatalf("status = %d, want 202, body %s", response.Code, response.Body)
+	}
+	var body struct {
+		TransactionID string `json:"transactionId"`
+		Status        string `json:"status"`
+		StatusURL     string `json:"statusUrl"`
+	}
+	if err := json.Unmarshal(response.Body.Bytes(), &body); err != nil {
+		t.Fatalf("parsing response: %v", err)
+	}
+	if body.TransactionID == "" || body.StatusURL != "/api/transactions/"+body.TransactionID {
+		t.Errorf("body = %+v, want the transaction ID and its status URL", body)
+	}
+	if location := response.Header().Get("Location"); location != body.StatusURL {
+		t.Errorf("Location = %q, want %q", location, body.StatusURL)
+	}
+	return body.TransactionID, body.Status
+}
+
+func TestFireAndForgetRespondsBeforeSubmitting(t *testing.T) {
+	ledger, submitted, committed := blockingLedger()
+	router := newTestRouter(t, ledger, nil)
+
+	response := serveRequest(router, http.MethodPost, "/api/students", `{"id":"S1","name":"Alice"}`, commitStrategyHeader, string(fireAndForget))
+	txID, status := acceptedTransaction(t, response)
+	if status != "accepted" {
+		t.Errorf("status = %q, want accepted", status)
+	}
+
+	close(submitted)
+	close(committed)
+	backgroundWork.Wait()
+
+	assertSubmitted(t, ledger, "CreateStudent", "S1", "Alice", "")
+	if tracked, _ := transactions.get(txID); tracked.Status != txCommitted {
+		t.Errorf("tracked status = %q, want committed", tracked.Status)
+	}
+}
+
+func TestWaitForEndorseRespondsBeforeCommit(t *testing.T) {
+	ledger, submitted, committed := blockingLedger()
+	router := newTestRouter(t, ledger, nil)
+
+	responses := serveAsync(router, http.MethodDelete, "/api/students/S1", "", commitStrategyHeader, string(waitForEndorse))
+	assertPending(t, responses, "transaction was endorsed and submitted")
+
+	close(submitted)
+	txID, status := acceptedTransaction(t, awaitResponse(t, responses))
+	if status != "submitted" {
+		t.Errorf("status = %q, want submitted", status)
+	}
+	if tracked, _ := transactions.get(txID); tracked.Status != txSubmitted {
+		t.Errorf("tracked status before the commit = %q, want submitted", tracked.Status)
+	}
+
+	close(committed)
+	backgroundWork.Wait()
+	if tracked, _ := transactions.get(txID); tracked.Status != txCommitted || *tracked.BlockNumber != 9 {
+		t.Errorf("tracked status = %+v, want committed in block 9", tracked)
+	}
+}
+
+func TestWaitForCommitRespondsAfterCommit(t *testing.T) {
+	ledger, submitted, _ := blockingLedger()
+	router := newTestRouter(t, ledger, nil)
+
+	responses := serveAsync(router, http.MethodPost, "/api/students", `{"id":"S1","name":"Alice"}`, commitStrategyHeader, string(waitForCommit))
+	assertPending(t, responses, "transaction committed")
+
+	close(submitted)
+	response := awaitResponse(t, responses)
+	if response.Code != http.StatusCreated {
+		t.Fatalf("status = %d, want 201, body %s", response.Code, response.Body)
+	}
+	var body struct {
+		Data Student `json:"data"`
+	}
+	if err := json.Unmarshal(response.Body.Bytes(), &body); err != nil || body.Data.ID != "S1" {
+		t.Errorf("body = %s, want the created student", response.Body)
+	}
+}
+
+func TestCommitStrategySelection(t *testing.T) {
+	tests := []struct {
+		name    string
+		target  string
+		header  string
+		want    commitStrategy
+		invalid bool
+	}{
+		{name: "default", target: "/", want: waitForCommit},
+		{name: "header", target: "/", header: "fire-and-forget", want: fireAndForget},
+		{name: "async", target: "/?async=true", want: waitForEndorse},
+		{name: "header over async", target: "/?async=true", header: "wait-for-commit", want: waitForCommit},
+		{name: "async false", target: "/?async=false", want: waitForCommit},
+		{name: "unknown header", target: "/", header: "eventually", invalid: true},
+		{name: "invalid async", target: "/?async=maybe", invalid: true},
+	}
+
+	useConfig(t, nil)
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			c, _ := gin.CreateTestContext(httptest.NewRecorder())
+			c.Request = httptest.NewRequest(http.MethodPost, test.target, nil)
+			if test.header != "" {
+				c.Request.Header.Set(commitStrategyHeader, test.header)
+			}
+
+			strategy, err := requestCommitStrategy(c)
+			if test.invalid {
+				if err == nil {
+					t.Errorf("strategy = %q, want an error", strategy)
+				}
+				return
+			}
+			if err != nil || strategy != test.want {
+				t.Errorf("strategy = %q, %v, want %q", strategy, err, test.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,99 @@
+/*
+Copyright 2021 IBM All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package main
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+)
+
+// timeoutLedger is a fakeLedger that records how long each submit would wait for its commit
+type timeoutLedger struct {
+	fakeLedger
+	mu       sync.Mutex
+	timeouts []time.Duration
+}
+
+func (l *timeoutLedger) SubmitTransaction(ctx context.Context, name string, args ...string) ([]byte, error) {
+	l.mu.Lock()
+	l.timeouts = append(l.timeouts, commitTimeoutFrom(ctx))
+	l.mu.Unlock()
+	return l.fakeLedger.SubmitTransaction(ctx, name, args...)
+}
+
+func TestCommitTimeoutOverride(t *testing.T) {
+	endpoints := []struct {
+		name   string
+		method string
+		target string
+		body   string
+		// headers are sent with every request to the endpoint, as name/value pairs
+		headers []string
+	}{
+		{name: "create", method: http.MethodPost, target: "/api/students", body: `{"id":"S1","name":"Alice"}`},
+		{name: "update", method: http.MethodPut, target: "/api/students/S1", body: `{"name":"Alice","department":"CSE"}`, headers: []string{"If-Match", studentETag("S1", 3)}},
+		{name: "raw-tx", method: http.MethodPost, target: "/api/tx/submit", body: `{"function":"CreateStudent","args":["S1","Alice"]}`},
+	}
+	tests := []struct {
+		name   string
+		header string
+		want   time.Duration
+	}{
+		{name: "default", want: commitStatusTimeout},
+		{name: "longer", header: "2m", want: 2 * time.Minute},
+		{name: "at the cap", header: "5m", want: 5 * time.Minute},
+		{name: "above the cap", header: "10m", want: 5 * time.Minute},
+	}
+
+	for _, endpoint := range endpoints {
+		for _, test := range tests {
+			t.Run(endpoint.name+" "+test.name, func(t *testing.T) {
+				ledger := &timeoutLedger{fakeLedger: fakeLedger{respond: readStoredStudent}}
+				router := newTestRouter(t, ledger, func(config *Config) {
+					config.MaxCommitTimeout = 5 * time.Minute
+					config.TxProxyAllow = []string{chaincodeName + ":CreateStudent"}
+				})
+
+				headers := append([]string{}, endpoint.headers...)
+				if test.header != "" {
+					headers = append(headers, commitTimeoutHeader, test.header)
+				}
+				response := serveRequest(router, endpoint.method, endpoint.target, endpoint.body, headers...)
+				if response.Code >= http.StatusBadRequest {
+					t.Fatalf("status = %d, body %s", response.Code, response.Body)
+				}
+				if len(ledger.timeouts) != 1 {
+					t.Fatalf("submitted %d transactions, want 1", len(ledger.timeouts))
+				}
+				if got := ledger.timeouts[0]; got != test.want {
+					t.Errorf("commit timeout = %s, want %s", got, test.want)
+				}
+			})
+		}
+
+		t.Run(endpoint.name+" invalid", func(t *testing.T) {
+			ledger := &timeoutLedger{fakeLedger: fakeLedger{respond: readStoredStudent}}
+			router := newTestRouter(t, ledger, func(config *Config) {
+				config.TxProxyAllow = []string{chaincodeName + ":CreateStudent"}
+			})
+
+			for _, value := range []string{"soon", "0s", "-1m"} {
+				headers := append([]string{commitTimeoutHeader, value}, endpoint.headers...)
+				response := serveRequest(router, endpoint.method, endpoint.target, endpoint.body, headers...)
+				if response.Code != http.StatusBadRequest {
+					t.Errorf("status for %q = %d, want 400, body %s", value, response.Code, response.Body)
+				}
+			}
+			if len(ledger.timeouts) != 0 {
+				t.Errorf("submitted %d transactions, want none", len(ledger.timeouts))
+			}
+		})
+	}
+}
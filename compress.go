@@ -0,0 +1,135 @@
+/*
+Copyright 2021 IBM All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package main
+
+import (
+	"compress/gzip"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+)
+
+// gzipWriters are reused between responses, since each holds sizeable compression state
+var gzipWriters = sync.Pool{New: func() any { return gzip.NewWriter(nil) }}
+
+// compressibleTypes are the media types worth compressing; other responses, such as already
+// compressed files, are sent as they are
+var compressibleTypes = map[string]bool{
+	"application/json":     true,
+	"application/x-ndjson": true,
+	"application/yaml":     true,
+	"text/csv":             true,
+	"text/event-stream":    true,
+	"text/html":            true,
+	"text/plain":           true,
+}
+
+// gzipWriter compresses a response once its first write shows it is worth compressing: a
+// compressible type, not already encoded, and at least minSize bytes, or streamed in flushes
+type gzipWriter struct {
+	gin.ResponseWriter
+	minSize int
+	gz      *gzip.Writer
+	decided bool
+}
+
+// Write compresses the data if the response is being compressed
+func (w *gzipWriter) Write(data []byte) (int, error) {
+	if !w.decided {
+		w.decide(len(data) >= w.minSize)
+	}
+	if w.gz != nil {
+		return w.gz.Write(data)
+	}
+	return w.ResponseWriter.Write(data)
+}
+
+// WriteString compresses the string if the response is being compressed
+func (w *gzipWriter) WriteString(s string) (int, error) {
+	return w.Write([]byte(s))
+}
+
+// Flush sends what has been compressed so far. A response flushed before it is written is a
+// stream, and is compressed however small its first write.
+func (w *gzipWriter) Flush() {
+	if !w.decided {
+		w.decide(true)
+	}
+	if w.gz != nil {
+		w.gz.Flush()
+	}
+	w.ResponseWriter.Flush()
+}
+
+// decide starts compressing the response if it is large enough and of a compressible type, and
+// its headers have not been sent yet
+func (w *gzipWriter) decide(large bool) {
+	w.decided = true
+	header := w.Header()
+	mediaType, _, _ := mime.ParseMediaType(header.Get("Content-Type"))
+	if !large || w.Written() || !compressibleTypes[mediaType] || header.Get("Content-Encoding") != "" {
+		return
+	}
+
+	header.Set("Content-Encoding", "gzip")
+	header.Del("Content-Length")
+	w.gz = gzipWriters.Get().(*gzip.Writer)
+	w.gz.Reset(w.ResponseWriter)
+}
+
+// close finishes the compressed stream, if any, and returns its writer to the pool
+func (w *gzipWriter) close() {
+	if w.gz == nil {
+		return
+	}
+	w.gz.Close()
+	gzipWriters.Put(w.gz)
+	w.gz = nil
+}
+
+// gzipMiddleware compresses the responses of clients that accept gzip, once they reach minSize
+// bytes. A minSize of zero disables compression. WebSocket upgrades are left alone.
+func gzipMiddleware(minSize int) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if minSize <= 0 || !acceptsGzip(c.Request) || c.GetHeader("Upgrade") != "" {
+			c.Next()
+			return
+		}
+
+		// Caches must not serve the compressed response to clients that cannot read it
+		c.Writer.Header().Add("Vary", "Accept-Encoding")
+
+		writer := &gzipWriter{ResponseWriter: c.Writer, minSize: minSize}
+		c.Writer = writer
+		defer func() {
+			writer.close()
+			c.Writer = writer.ResponseWriter
+		}()
+		c.Next()
+	}
+}
+
+// acceptsGzip reports whether the request's Accept-Encoding header accepts gzip
+func acceptsGzip(r *http.Request) bool {
+	for _, coding := range strings.Split(r.Header.Get("Accept-Encoding"), ",") {
+		name, params, _ := strings.Cut(coding, ";")
+		if strings.TrimSpace(name) != "gzip" {
+			continue
+		}
+		// A quality of zero refuses the coding
+		q := 1.0
+		if value, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			q, _ = strconv.ParseFloat(value, 64)
+		}
+		return q > 0
+	}
+	return false
+}
@@ -0,0 +1,607 @@
+/*
+Copyright 2021 IBM All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/hyperledger/fabric-gateway/pkg/client"
+	"github.com/hyperledger/fabric-gateway/pkg/hash"
+	"github.com/hyperledger/fabric-gateway/pkg/identity"
+	"github.com/hyperledger/fabric-protos-go-apiv2/common"
+	"github.com/hyperledger/fabric-protos-go-apiv2/gateway"
+	"github.com/hyperledger/fabric-protos-go-apiv2/peer"
+	"go.opentelemetry.io/otel/attribute"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/connectivity"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+)
+
+// connectionCheckInterval is how often the connection monitor looks at a connection whose state
+// has not changed
+const connectionCheckInterval = 5 * time.Second
+
+// fabricConnection is the gateway connection used to transact as one organization, made up of
+// a connection to each of the organization's gateway peers
+type fabricConnection struct {
+	org  OrgConfig
+	id   *identity.X509Identity
+	sign identity.Sign
+
+	// closeSign releases the private key of sign, such as its session on an HSM token
+	closeSign func() error
+
+	// peers are the connections to the organization's gateway peers, the preferred one first
+	peers []*peerConnection
+
+	// next counts evaluations, so they take turns among the peers
+	next atomic.Uint64
+}
+
+// peerConnection is the connection to one gateway peer. The gRPC connection, gateway, and
+// channel handles are replaced together when the connection is rebuilt.
+type peerConnection struct {
+	org     OrgConfig // the organization's settings, with this peer's endpoint and TLS settings
+	id      *identity.X509Identity
+	sign    identity.Sign
+	breaker *circuitBreaker
+
+	// stopMonitor ends the goroutine watching the connection's state
+	stopMonitor context.CancelFunc
+
+	// mu guards the fields below while they are read or rebuilt
+	mu       sync.Mutex
+	conn     *grpc.ClientConn
+	gateway  *client.Gateway
+	channels map[string]*channelHandles
+}
+
+// channelHandles are the network and contract of one channel, created from the gateway the
+// first time a request transacts on the channel
+type channelHandles struct {
+	network  *client.Network
+	contract *client.Contract
+}
+
+// newFabricConnection loads the organization's identity, checks that its key signs for its
+// certificate, and connects to each of its gateway peers
+func newFabricConnection(org OrgConfig) (*fabricConnection, error) {
+	id, err := newIdentity(org)
+	if err != nil {
+		return nil, err
+	}
+	sign, closeSign, err := newSign(org, id)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := checkSign(id, sign); err != nil {
+		closeSign()
+		return nil, err
+	}
+
+	fc := &fabricConnection{org: org, id: id, sign: sign, closeSign: closeSign}
+	for _, peerOrg := range org.peerOrgs() {
+		peer := &peerConnection{org: peerOrg, id: id, sign: sign, breaker: breakerFor(peerOrg.PeerEndpoint)}
+		if err := peer.connect(); err != nil {
+			fc.close()
+			return nil, fmt.Errorf("peer %s: %w", peerOrg.PeerEndpoint, err)
+		}
+
+		ctx, cancel := context.WithCancel(context.Background())
+		peer.stopMonitor = cancel
+		go peer.monitor(ctx)
+
+		fc.peers = append(fc.peers, peer)
+	}
+	return fc, nil
+}
+
+// connect dials the peer and sets up the Gateway. Network and contract instances are set up
+// per channel when first used. The caller must hold pc.mu once the connection is shared.
+func (pc *peerConnection) connect() error {
+	// The gRPC client connection is shared by all Gateway connections to this endpoint
+	conn, err := newGrpcConnection(pc.org)
+	if err != nil {
+		return err
+	}
+
+	gw, err := pc.newGateway(conn)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+
+	pc.conn = conn
+	pc.gateway = gw
+	pc.channels = make(map[string]*channelHandles)
+	return nil
+}
+
+// newGateway establishes a Gateway connection over conn using the peer connection's identity
+// and sign function
+func (pc *peerConnection) newGateway(conn *grpc.ClientConn) (*client.Gateway, error) {
+	return client.Connect(
+		pc.id,
+		client.WithSign(pc.sign),
+		client.WithHash(hash.SHA256),
+		client.WithClientConnection(conn),
+		// Set timeouts for different gRPC calls
+		client.WithEvaluateTimeout(evaluateTimeout),
+		client.WithEndorseTimeout(endorseTimeout),
+		client.WithSubmitTimeout(submitTimeout),
+		client.WithCommitStatusTimeout(commitStatusTimeout),
+	)
+}
+
+// useIdentity switches the peer connection to another identity, replacing its gateway and
+// channel handles. The gRPC connection is kept, so calls already made with the old identity
+// finish normally.
+func (pc *peerConnection) useIdentity(id *identity.X509Identity, sign identity.Sign) error {
+	pc.mu.Lock()
+	defer pc.mu.Unlock()
+
+	oldID, oldSign := pc.id, pc.sign
+	pc.id, pc.sign = id, sign
+	gw, err := pc.newGateway(pc.conn)
+	if err != nil {
+		pc.id, pc.sign = oldID, oldSign
+		return err
+	}
+
+	oldGateway := pc.gateway
+	pc.gateway = gw
+	pc.channels = make(map[string]*channelHandles)
+	oldGateway.Close()
+	return nil
+}
+
+// channelLocked returns the handles for a channel, creating them on first use. The caller must hold pc.mu.
+func (pc *peerConnection) channelLocked(channel string) *channelHandles {
+	handles, ok := pc.channels[channel]
+	if !ok {
+		network := pc.gateway.GetNetwork(channel)
+		handles = &channelHandles{network: network, contract: network.GetContract(chaincodeName)}
+		pc.channels[channel] = handles
+	}
+	return handles
+}
+
+// healthy reports whether the peer looks reachable: its circuit breaker is not open and its
+// connection is not failing
+func (pc *peerConnection) healthy() bool {
+	if pc.breaker.isOpen() {
+		return false
+	}
+	switch pc.currentConnection().GetState() {
+	case connectivity.TransientFailure, connectivity.Shutdown:
+		return false
+	default:
+		return true
+	}
+}
+
+// preferredPeer returns the first healthy peer in the configured order, or the first peer if none is healthy
+func (fc *fabricConnection) preferredPeer() *peerConnection {
+	return fc.submitPeers()[0]
+}
+
+// submitPeers returns the peers in the order submits try them: the healthy ones in the
+// configured order, then the others in case they have recovered
+func (fc *fabricConnection) submitPeers() []*peerConnection {
+	return healthyFirst(fc.peers)
+}
+
+// evaluatePeers returns the peers in the order an evaluation tries them: the healthy ones
+// first, starting from a different peer for each evaluation to spread the load
+func (fc *fabricConnection) evaluatePeers() []*peerConnection {
+	start := int(fc.next.Add(1)-1) % len(fc.peers)
+	rotated := append(append([]*peerConnection{}, fc.peers[start:]...), fc.peers[:start]...)
+	return healthyFirst(rotated)
+}
+
+// healthyFirst reorders peers so the healthy ones come first, keeping their order otherwise
+func healthyFirst(peers []*peerConnection) []*peerConnection {
+	ordered := make([]*peerConnection, 0, len(peers))
+	var unhealthy []*peerConnection
+	for _, peer := range peers {
+		if peer.healthy() {
+			ordered = append(ordered, peer)
+		} else {
+			unhealthy = append(unhealthy, peer)
+		}
+	}
+	return append(ordered, unhealthy...)
+}
+
+// canFailOver reports whether a call that failed on one peer can be made on another. Submits
+// can only move to another peer while the transaction has not reached the orderer.
+func canFailOver(err error, submitting bool) bool {
+	var circuitErr *circuitOpenError
+	if errors.As(err, &circuitErr) {
+		return true
+	}
+	if !isPeerFailure(err) {
+		return false
+	}
+	if !submitting {
+		return true
+	}
+	var endorseErr *client.EndorseError
+	return errors.As(err, &endorseErr)
+}
+
+// gatewayContract is the ledger used by the handlers when serving. It calls the gateway contract of
+// the organization chosen for the request, rebuilding its connection if the peer is unavailable, so
+// handlers keep working after a connection has been rebuilt.
+type gatewayContract struct{}
+
+// SubmitTransaction submits a transaction using the current connection and waits for it to commit.
+// Once started, the submit runs to completion even if the request context is cancelled.
+func (g gatewayContract) SubmitTransaction(ctx context.Context, name string, args ...string) ([]byte, error) {
+	return g.submit(ctx, name, client.WithArguments(args...))
+}
+
+// SubmitTransient submits a transaction carrying transient data, which is passed to the chaincode
+// but not recorded in the transaction, and waits for it to commit
+func (g gatewayContract) SubmitTransient(ctx context.Context, name string, transient map[string][]byte, args ...string) ([]byte, error) {
+	return g.submit(ctx, name, client.WithArguments(args...), client.WithTransient(proposalTransient(ctx, transient)))
+}
+
+// submit submits a transaction proposal built with the given options and waits for it to commit.
+// It is endorsed by the organizations chosen for the request, if any. If the gateway peer cannot
+// be reached to endorse it, the next peer is tried.
+func (g gatewayContract) submit(ctx context.Context, name string, options ...client.ProposalOption) ([]byte, error) {
+	ctx = context.WithoutCancel(ctx)
+	options = withEndorsingOrgs(ctx, options)
+
+	peers := connectionFrom(ctx).submitPeers()
+	for i, peer := range peers {
+		result, err := g.submitOn(ctx, peer, name, options...)
+		if err == nil || i == len(peers)-1 || !canFailOver(err, true) {
+			return result, err
+		}
+		loggerFrom(ctx).Warn("Gateway peer unavailable, failing over to the next peer", "function", name, "endpoint", peer.org.PeerEndpoint, "error", err)
+	}
+	panic("unreachable")
+}
+
+// submitOn submits a transaction proposal through the given peer and waits for it to commit
+func (gatewayContract) submitOn(ctx context.Context, peer *peerConnection, name string, options ...client.ProposalOption) ([]byte, error) {
+	if err := peer.breaker.allow(); err != nil {
+		return nil, err
+	}
+	conn, proposal, err := peer.newProposal(ctx, name, options...)
+	if err != nil {
+		peer.breaker.record(nil)
+		return nil, err
+	}
+
+	ctx, span := startTransactionSpan(ctx, "submit", name, peer)
+	span.SetAttributes(attribute.String("fabric.transaction_id", proposal.TransactionID()))
+
+	logger := loggerFrom(ctx).With("function", name, "transactionId", proposal.TransactionID())
+	if mspIDs := endorsingOrgsFrom(ctx); len(mspIDs) > 0 {
+		logger = logger.With("endorsingOrgs", mspIDs)
+	}
+	logger.Info("Submitting transaction")
+
+	info := transactionInfoFrom(ctx)
+	if info != nil {
+		*info = transactionInfo{TransactionID: proposal.TransactionID()}
+	}
+
+	start := time.Now()
+	result, commit, err := endorseAndSubmit(ctx, proposal)
+	var status *client.Status
+	if err == nil {
+		status, err = awaitCommitStatus(ctx, commit)
+	}
+	if info != nil && status != nil {
+		blockNumber := status.BlockNumber
+		info.BlockNumber = &blockNumber
+		info.CommitStatus = status.Code.String()
+	}
+	submitLatency.observe(time.Since(start), time.Now())
+
+	recordTransaction("submit", name, start, err)
+	endSpan(span, err)
+	peer.breaker.record(err)
+	peer.reconnectIfUnavailable(conn, err)
+
+	if err != nil {
+		logger.Warn("Transaction failed", "outcome", transactionOutcome(err), "error", err)
+		return nil, err
+	}
+
+	logger.Info("Transaction committed", "blockNumber", status.BlockNumber)
+	return result, nil
+}
+
+// EvaluateTransaction evaluates a transaction using the current connection
+func (g gatewayContract) EvaluateTransaction(ctx context.Context, name string, args ...string) ([]byte, error) {
+	return g.evaluate(ctx, name, client.WithArguments(args...))
+}
+
+// EvaluateTransient evaluates a transaction carrying transient data, such as a key needed to read private data
+func (g gatewayContract) EvaluateTransient(ctx context.Context, name string, transient map[string][]byte, args ...string) ([]byte, error) {
+	return g.evaluate(ctx, name, client.WithArguments(args...), client.WithTransient(proposalTransient(ctx, transient)))
+}
+
+// evaluate evaluates a transaction proposal built with the given options. Evaluations change
+// nothing, so every one is retried on transient failures, with a new proposal each attempt.
+func (g gatewayContract) evaluate(ctx context.Context, name string, options ...client.ProposalOption) ([]byte, error) {
+	var result []byte
+	err := retryWithPolicy(ctx, evaluateRetryPolicy(), name, func() error {
+		var err error
+		result, err = g.evaluateOnce(ctx, name, options...)
+		return err
+	})
+	return result, err
+}
+
+// evaluateOnce evaluates a transaction proposal built with the given options, taking turns
+// among the healthy peers and moving on to the next peer if one cannot be reached
+func (g gatewayContract) evaluateOnce(ctx context.Context, name string, options ...client.ProposalOption) ([]byte, error) {
+	peers := connectionFrom(ctx).evaluatePeers()
+	for i, peer := range peers {
+		result, err := g.evaluateOn(ctx, peer, name, options...)
+		if err == nil || i == len(peers)-1 || !canFailOver(err, false) {
+			return result, err
+		}
+		loggerFrom(ctx).Warn("Gateway peer unavailable, evaluating on the next peer", "function", name, "endpoint", peer.org.PeerEndpoint, "error", err)
+	}
+	panic("unreachable")
+}
+
+// evaluateOn evaluates a transaction proposal through the given peer
+func (gatewayContract) evaluateOn(ctx context.Context, peer *peerConnection, name string, options ...client.ProposalOption) ([]byte, error) {
+	if err := peer.breaker.allow(); err != nil {
+		return nil, err
+	}
+	conn, proposal, err := peer.newProposal(ctx, name, options...)
+	if err != nil {
+		peer.breaker.record(nil)
+		return nil, err
+	}
+
+	ctx, span := startTransactionSpan(ctx, "evaluate", name, peer)
+	span.SetAttributes(attribute.String("fabric.transaction_id", proposal.TransactionID()))
+
+	logger := loggerFrom(ctx).With("function", name, "transactionId", proposal.TransactionID())
+	logger.Info("Evaluating transaction")
+
+	ctx, cancel := context.WithTimeout(ctx, callTimeoutFrom(ctx, evaluateTimeout))
+	defer cancel()
+
+	start := time.Now()
+	result, err := proposal.EvaluateWithContext(ctx)
+
+	recordTransaction("evaluate", name, start, err)
+	endSpan(span, err)
+	peer.breaker.record(err)
+	peer.reconnectIfUnavailable(conn, err)
+
+	if err != nil {
+		logger.Warn("Evaluation failed", "error", err)
+		return nil, err
+	}
+	return result, nil
+}
+
+// newProposal creates a transaction proposal on the channel and chaincode chosen for the request using
+// the current connection, returning the connection alongside it so failures can trigger a reconnect.
+// The proposal carries the request ID and any transient data sent with the request; options giving
+// their own transient data must include those with proposalTransient.
+func (pc *peerConnection) newProposal(ctx context.Context, name string, options ...client.ProposalOption) (*grpc.ClientConn, *client.Proposal, error) {
+	conn, contract := pc.currentContract(channelFrom(ctx))
+	if chaincode := chaincodeFrom(ctx); chaincode != contract.ChaincodeName() {
+		contract = pc.currentNetwork(channelFrom(ctx)).GetContract(chaincode)
+	}
+	if transient := proposalTransient(ctx, nil); transient != nil {
+		options = append([]client.ProposalOption{client.WithTransient(transient)}, options...)
+	}
+	proposal, err := contract.NewProposal(name, options...)
+	return conn, proposal, err
+}
+
+// processLocalProposal sends a proposal signed by the peer's identity straight to the peer's
+// endorser, returning the response payload. It is used for system chaincode functions that
+// concern the peer itself rather than a channel, such as installing chaincode packages or listing
+// the channels the peer has joined, which the gateway cannot run as it only runs proposals on a
+// channel.
+func (pc *peerConnection) processLocalProposal(ctx context.Context, chaincode, name string, args ...[]byte) ([]byte, error) {
+	pc.mu.Lock()
+	conn, gw, sign := pc.conn, pc.gateway, pc.sign
+	pc.mu.Unlock()
+
+	proposal, err := gw.GetNetwork("").GetContract(chaincode).NewProposal(name, client.WithBytesArguments(args...))
+	if err != nil {
+		return nil, err
+	}
+	proposalBytes, err := proposal.Bytes()
+	if err != nil {
+		return nil, err
+	}
+	transaction := &gateway.ProposedTransaction{}
+	if err := proto.Unmarshal(proposalBytes, transaction); err != nil {
+		return nil, err
+	}
+	signed := transaction.GetProposal()
+	if signed.Signature, err = sign(proposal.Digest()); err != nil {
+		return nil, fmt.Errorf("failed to sign proposal: %w", err)
+	}
+
+	response, err := peer.NewEndorserClient(conn).ProcessProposal(ctx, signed)
+	pc.reconnectIfUnavailable(conn, err)
+	if err != nil {
+		return nil, err
+	}
+	if result := response.GetResponse(); result.GetStatus() != int32(common.Status_SUCCESS) {
+		return nil, fmt.Errorf("%s failed with status %d: %s", name, result.GetStatus(), result.GetMessage())
+	}
+	return response.GetResponse().GetPayload(), nil
+}
+
+// currentConnection returns the preferred peer's current gRPC connection
+func (fc *fabricConnection) currentConnection() *grpc.ClientConn {
+	return fc.preferredPeer().currentConnection()
+}
+
+// currentContract returns the preferred peer's current contract on a channel along with the connection it uses
+func (fc *fabricConnection) currentContract(channel string) (*grpc.ClientConn, *client.Contract) {
+	return fc.preferredPeer().currentContract(channel)
+}
+
+// currentNetwork returns the preferred peer's current network of a channel
+func (fc *fabricConnection) currentNetwork(channel string) *client.Network {
+	return fc.preferredPeer().currentNetwork(channel)
+}
+
+// currentConnection returns the current gRPC connection
+func (pc *peerConnection) currentConnection() *grpc.ClientConn {
+	pc.mu.Lock()
+	defer pc.mu.Unlock()
+	return pc.conn
+}
+
+// currentContract returns the current contract on a channel along with the connection it uses
+func (pc *peerConnection) currentContract(channel string) (*grpc.ClientConn, *client.Contract) {
+	pc.mu.Lock()
+	defer pc.mu.Unlock()
+	return pc.conn, pc.channelLocked(channel).contract
+}
+
+// currentNetwork returns the current network of a channel
+func (pc *peerConnection) currentNetwork(channel string) *client.Network {
+	pc.mu.Lock()
+	defer pc.mu.Unlock()
+	return pc.channelLocked(channel).network
+}
+
+// reconnectIfUnavailable rebuilds the connection if a call made on it failed because the peer was unreachable
+func (pc *peerConnection) reconnectIfUnavailable(conn *grpc.ClientConn, err error) {
+	if err != nil && status.Code(err) == codes.Unavailable {
+		pc.ensureConnection(conn)
+	}
+}
+
+// ensureConnection replaces the given stale connection with a new one. Concurrent callers
+// that saw the same failure share a single rebuild: once the connection has been replaced,
+// later callers holding the stale one return without dialing again.
+func (pc *peerConnection) ensureConnection(stale *grpc.ClientConn) {
+	pc.mu.Lock()
+	defer pc.mu.Unlock()
+
+	if pc.conn != stale {
+		return
+	}
+
+	logger := slog.With("org", pc.org.MSPID, "endpoint", pc.org.PeerEndpoint)
+	logger.Warn("Peer unavailable, rebuilding gateway connection")
+
+	oldConnection, oldGateway := pc.conn, pc.gateway
+	if err := pc.connect(); err != nil {
+		// Keep the old connection; the next failed call tries again
+		logger.Error("Failed to rebuild gateway connection", "error", err)
+		return
+	}
+
+	// Close the old connection so it is not leaked; calls still using it fail and can be retried
+	oldGateway.Close()
+	oldConnection.Close()
+
+	connectionRebuilds.WithLabelValues(pc.org.MSPID, pc.org.PeerEndpoint).Inc()
+	logger.Info("Gateway connection rebuilt")
+}
+
+// monitor watches the state of the gRPC connection until ctx ends, so a peer restart is
+// recovered from without waiting for a request to fail on it. gRPC reconnects by itself after
+// a dropped connection; a connection that still cannot reach the peer after
+// CONNECTION_REBUILD_DELAY is rebuilt with its gateway and channel handles, and an idle
+// connection is prompted to connect.
+func (pc *peerConnection) monitor(ctx context.Context) {
+	logger := slog.With("org", pc.org.MSPID, "endpoint", pc.org.PeerEndpoint)
+
+	var failingSince time.Time
+	previous := pc.currentConnection().GetState()
+	for {
+		conn := pc.currentConnection()
+		state := conn.GetState()
+		connectionState.WithLabelValues(pc.org.MSPID, pc.org.PeerEndpoint).Set(float64(state))
+
+		if state != previous {
+			logger.Info("Peer connection state changed", "from", previous.String(), "to", state.String())
+			previous = state
+		}
+
+		switch state {
+		case connectivity.Ready:
+			failingSince = time.Time{}
+		case connectivity.Idle:
+			conn.Connect()
+		case connectivity.TransientFailure:
+			if failingSince.IsZero() {
+				failingSince = time.Now()
+			} else if time.Since(failingSince) >= cfg.ConnectionRebuildDelay {
+				pc.ensureConnection(conn)
+				failingSince = time.Time{}
+				continue
+			}
+		case connectivity.Shutdown:
+			// The connection was closed, either because it was rebuilt or at shutdown
+			if ctx.Err() != nil {
+				return
+			}
+			if pc.currentConnection() != conn {
+				continue
+			}
+		}
+
+		// Check again on the next state change, or after a while to notice a failure outlasting the delay
+		waitCtx, cancel := context.WithTimeout(ctx, connectionCheckInterval)
+		conn.WaitForStateChange(waitCtx, state)
+		cancel()
+		if ctx.Err() != nil {
+			return
+		}
+	}
+}
+
+// close stops monitoring the connection and closes the gateway and gRPC connection
+func (pc *peerConnection) close() {
+	pc.stopMonitor()
+
+	pc.mu.Lock()
+	defer pc.mu.Unlock()
+
+	pc.gateway.Close()
+	pc.conn.Close()
+}
+
+// close closes the connections to every peer of the organization and releases its private key
+func (fc *fabricConnection) close() {
+	for _, peer := range fc.peers {
+		peer.close()
+	}
+	if err := fc.closeSign(); err != nil {
+		slog.Warn("Failed to release private key", "mspId", fc.org.MSPID, "error", err)
+	}
+}
+
+// closeConnection closes the gateway connections of every organization at shutdown
+func closeConnection() {
+	orgs.close()
+	closeHSMs()
+}
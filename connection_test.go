@@ -0,0 +1,118 @@
+/*
+Copyright 2021 IBM All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/hyperledger/fabric-gateway/pkg/identity"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/connectivity"
+	"google.golang.org/grpc/status"
+)
+
+// newTestCertificate returns a self-signed certificate for name, its PEM encoding, and the
+// PEM encoding of its private key
+func newTestCertificate(t *testing.T, name string) (certificate *x509.Certificate, certificatePEM, keyPEM []byte) {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("generating key: %v", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: name},
+		DNSNames:     []string{name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("creating certificate: %v", err)
+	}
+	if certificate, err = x509.ParseCertificate(der); err != nil {
+		t.Fatalf("parsing certificate: %v", err)
+	}
+	keyDER, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatalf("encoding private key: %v", err)
+	}
+	return certificate, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER})
+}
+
+// newTestPeerConnection returns a connection to a peer that is never dialled, since gRPC
+// connects lazily on the first call
+func newTestPeerConnection(t *testing.T) *peerConnection {
+	t.Helper()
+
+	certificate, certificatePEM, _ := newTestCertificate(t, "peer0.org1.example.com")
+	id, err := identity.NewX509Identity("Org1MSP", certificate)
+	if err != nil {
+		t.Fatalf("creating identity: %v", err)
+	}
+	org := OrgConfig{MSPID: "Org1MSP", PeerEndpoint: "127.0.0.1:1", GatewayPeer: "peer0.org1.example.com", TLSCertPEM: certificatePEM}
+
+	pc := &peerConnection{org: org, id: id, breaker: breakerFor(org.PeerEndpoint)}
+	if err := pc.connect(); err != nil {
+		t.Fatalf("connect: %v", err)
+	}
+	t.Cleanup(func() {
+		pc.gateway.Close()
+		pc.conn.Close()
+	})
+	return pc
+}
+
+func TestReconnectAfterConnectionClosed(t *testing.T) {
+	pc := newTestPeerConnection(t)
+	network := pc.currentNetwork("mychannel")
+
+	// The peer went away and the connection was shut down under us
+	stale := pc.currentConnection()
+	stale.Close()
+
+	pc.reconnectIfUnavailable(stale, status.Error(codes.Unavailable, "connection closed"))
+
+	current := pc.currentConnection()
+	if current == stale {
+		t.Fatal("connection was not rebuilt")
+	}
+	if state := current.GetState(); state == connectivity.Shutdown {
+		t.Errorf("rebuilt connection is %s", state)
+	}
+	if pc.currentNetwork("mychannel") == network {
+		t.Error("channel handles still use the old gateway")
+	}
+
+	// Callers that saw the same failure share the rebuild rather than dialling again
+	pc.reconnectIfUnavailable(stale, status.Error(codes.Unavailable, "connection closed"))
+	if pc.currentConnection() != current {
+		t.Error("connection was rebuilt again for the same stale connection")
+	}
+}
+
+func TestNoReconnectForOtherFailures(t *testing.T) {
+	pc := newTestPeerConnection(t)
+	conn := pc.currentConnection()
+
+	for _, err := range []error{nil, errors.New("chaincode response 500, the student S1 does not exist"), status.Error(codes.Aborted, "endorsement failed")} {
+		pc.reconnectIfUnavailable(conn, err)
+		if pc.currentConnection() != conn {
+			t.Fatalf("connection was rebuilt after %v", err)
+		}
+	}
+}
@@ -0,0 +1,51 @@
+/*
+Copyright 2021 IBM All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package main
+
+import "testing"
+
+func TestContentHash(t *testing.T) {
+	data := []byte(`{"id":"S1","name":"Alice"}`)
+
+	if contentHash("salt", data) != contentHash("salt", data) {
+		t.Error("hashes of the same content with the same salt differ")
+	}
+	if contentHash("salt", data) == contentHash("other salt", data) {
+		t.Error("hashes with different salts are the same")
+	}
+	if contentHash("salt", data) == contentHash("salt", []byte(`{"id":"S2","name":"Alice"}`)) {
+		t.Error("hashes of different content are the same")
+	}
+	if hash := contentHash("", data); len(hash) != 64 {
+		t.Errorf("hash %q is %d characters, want 64 hex characters of SHA-256", hash, len(hash))
+	}
+}
+
+func TestStudentETagFollowsHashSalt(t *testing.T) {
+	previous := cfg
+	t.Cleanup(func() { cfg = previous })
+
+	cfg.HashSalt = "tenant-a"
+	etag := studentETag("S1", 3)
+	if want := `"` + contentHash("tenant-a", []byte("S1\n3")) + `"`; etag != want {
+		t.Errorf("ETag = %s, want the quoted salted hash %s", etag, want)
+	}
+	if studentETag("S1", 3) != etag {
+		t.Error("ETag of the same version changed")
+	}
+	if studentETag("S1", 4) == etag {
+		t.Error("ETag did not change with the version")
+	}
+	if studentETag("S2", 3) == etag {
+		t.Error("ETags of different students at the same version are equal")
+	}
+
+	cfg.HashSalt = "tenant-b"
+	if studentETag("S1", 3) == etag {
+		t.Error("ETag did not change with HASH_SALT")
+	}
+}
@@ -0,0 +1,67 @@
+/*
+Copyright 2021 IBM All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package main
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// corsMaxAge is how long, in seconds, browsers may reuse the answer to a preflight request
+const corsMaxAge = "600"
+
+// corsMiddleware allows browsers on the given origins to call the API with the given methods
+// and request headers, answering preflight requests itself, and lets their scripts read the
+// exposed response headers. An origin of "*" allows any origin, but without credentials.
+func corsMiddleware(allowedOrigins, methods, headers, exposeHeaders []string) gin.HandlerFunc {
+	allowed := make(map[string]bool, len(allowedOrigins))
+	for _, origin := range allowedOrigins {
+		allowed[origin] = true
+	}
+	allowMethods := strings.Join(methods, ", ")
+	allowHeaders := strings.Join(headers, ", ")
+	exposed := strings.Join(exposeHeaders, ", ")
+
+	return func(c *gin.Context) {
+		origin := c.GetHeader("Origin")
+		if origin == "" {
+			c.Next()
+			return
+		}
+
+		// Responses differ by origin, so caches must key on it
+		c.Writer.Header().Add("Vary", "Origin")
+
+		switch {
+		case allowed[origin]:
+			c.Header("Access-Control-Allow-Origin", origin)
+			c.Header("Access-Control-Allow-Credentials", "true")
+		case allowed["*"]:
+			c.Header("Access-Control-Allow-Origin", "*")
+		default:
+			// Leave the CORS headers off so the browser blocks the response
+			c.Next()
+			return
+		}
+
+		if c.Request.Method == http.MethodOptions && c.GetHeader("Access-Control-Request-Method") != "" {
+			c.Header("Access-Control-Allow-Methods", allowMethods)
+			c.Header("Access-Control-Allow-Headers", allowHeaders)
+			c.Header("Access-Control-Max-Age", corsMaxAge)
+			c.AbortWithStatus(http.StatusNoContent)
+			return
+		}
+
+		// Let scripts read headers such as the ETag they need to send back in If-Match
+		if exposed != "" {
+			c.Header("Access-Control-Expose-Headers", exposed)
+		}
+		c.Next()
+	}
+}
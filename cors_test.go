@@ -0,0 +1,71 @@
+/*
+Copyright 2021 IBM All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package main
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+)
+
+const allowedOrigin = "https://app.example.com"
+
+func TestCORSPreflight(t *testing.T) {
+	router := newTestRouter(t, &fakeLedger{}, func(config *Config) { config.CORSOrigins = []string{allowedOrigin} })
+
+	response := serveRequest(router, http.MethodOptions, "/api/students/S1", "",
+		"Origin", allowedOrigin,
+		"Access-Control-Request-Method", http.MethodPut,
+		"Access-Control-Request-Headers", "Authorization, Content-Type")
+	if response.Code != http.StatusNoContent {
+		t.Fatalf("status = %d, want 204", response.Code)
+	}
+
+	header := response.Header()
+	if got := header.Get("Access-Control-Allow-Origin"); got != allowedOrigin {
+		t.Errorf("Access-Control-Allow-Origin = %q, want %q", got, allowedOrigin)
+	}
+	if got := header.Get("Access-Control-Allow-Credentials"); got != "true" {
+		t.Errorf("Access-Control-Allow-Credentials = %q, want true", got)
+	}
+	for _, method := range []string{http.MethodPost, http.MethodPut, http.MethodDelete} {
+		if !strings.Contains(header.Get("Access-Control-Allow-Methods"), method) {
+			t.Errorf("Access-Control-Allow-Methods = %q, want it to include %s", header.Get("Access-Control-Allow-Methods"), method)
+		}
+	}
+	for _, name := range []string{"Authorization", "Content-Type"} {
+		if !strings.Contains(header.Get("Access-Control-Allow-Headers"), name) {
+			t.Errorf("Access-Control-Allow-Headers = %q, want it to include %s", header.Get("Access-Control-Allow-Headers"), name)
+		}
+	}
+	if header.Get("Access-Control-Max-Age") == "" {
+		t.Error("Access-Control-Max-Age is not set")
+	}
+}
+
+func TestCORSRejectsUnlistedOrigin(t *testing.T) {
+	router := newTestRouter(t, &fakeLedger{}, func(config *Config) { config.CORSOrigins = []string{allowedOrigin} })
+
+	response := serveRequest(router, http.MethodOptions, "/api/students", "",
+		"Origin", "https://evil.example.com",
+		"Access-Control-Request-Method", http.MethodPost)
+	if got := response.Header().Get("Access-Control-Allow-Origin"); got != "" {
+		t.Errorf("Access-Control-Allow-Origin = %q, want none", got)
+	}
+}
+
+func TestCORSWildcardOmitsCredentials(t *testing.T) {
+	router := newTestRouter(t, &fakeLedger{}, func(config *Config) { config.CORSOrigins = []string{"*"} })
+
+	response := serveRequest(router, http.MethodGet, "/health", "", "Origin", allowedOrigin)
+	if got := response.Header().Get("Access-Control-Allow-Origin"); got != "*" {
+		t.Errorf("Access-Control-Allow-Origin = %q, want *", got)
+	}
+	if got := response.Header().Get("Access-Control-Allow-Credentials"); got != "" {
+		t.Errorf("Access-Control-Allow-Credentials = %q, want none with a wildcard origin", got)
+	}
+}
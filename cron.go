@@ -0,0 +1,161 @@
+/*
+Copyright 2021 IBM All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// cronShorthands are the named schedules accepted in place of the five fields
+var cronShorthands = map[string]string{
+	"@yearly":   "0 0 1 1 *",
+	"@annually": "0 0 1 1 *",
+	"@monthly":  "0 0 1 * *",
+	"@weekly":   "0 0 * * 0",
+	"@daily":    "0 0 * * *",
+	"@midnight": "0 0 * * *",
+	"@hourly":   "0 * * * *",
+}
+
+// cronSchedule is a standard five-field cron expression, evaluated in UTC. Each field is held as
+// a set of bits, one per value it matches.
+type cronSchedule struct {
+	minute, hour, dayOfMonth, month, dayOfWeek uint64
+
+	// As in cron, a day matches if either day field does, unless one of them is "*"
+	anyDayOfMonth, anyDayOfWeek bool
+}
+
+// parseCronSchedule parses an expression of minute, hour, day of month, month, and day of week,
+// such as "30 2 * * 1-5". Each field is "*", a value, a range such as "1-5", or a list of them
+// such as "0,30", optionally with a step such as "*/15". Day of week 0 and 7 are both Sunday.
+// The shorthands @hourly, @daily, @weekly, @monthly, and @yearly are also accepted.
+func parseCronSchedule(spec string) (*cronSchedule, error) {
+	if expanded, ok := cronShorthands[strings.TrimSpace(spec)]; ok {
+		spec = expanded
+	}
+	fields := strings.Fields(spec)
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("cron schedule %q must have 5 fields, got %d", spec, len(fields))
+	}
+
+	var s cronSchedule
+	var err error
+	if s.minute, err = parseCronField(fields[0], 0, 59); err != nil {
+		return nil, fmt.Errorf("minute: %w", err)
+	}
+	if s.hour, err = parseCronField(fields[1], 0, 23); err != nil {
+		return nil, fmt.Errorf("hour: %w", err)
+	}
+	if s.dayOfMonth, err = parseCronField(fields[2], 1, 31); err != nil {
+		return nil, fmt.Errorf("day of month: %w", err)
+	}
+	if s.month, err = parseCronField(fields[3], 1, 12); err != nil {
+		return nil, fmt.Errorf("month: %w", err)
+	}
+	if s.dayOfWeek, err = parseCronField(fields[4], 0, 7); err != nil {
+		return nil, fmt.Errorf("day of week: %w", err)
+	}
+	if s.dayOfWeek&(1<<7) != 0 {
+		s.dayOfWeek |= 1
+	}
+	s.anyDayOfMonth, s.anyDayOfWeek = fields[2] == "*", fields[4] == "*"
+
+	if s.next(time.Now()).IsZero() {
+		return nil, fmt.Errorf("cron schedule %q never fires", spec)
+	}
+	return &s, nil
+}
+
+// parseCronField parses a comma-separated list of values, ranges, and steps between min and max
+func parseCronField(field string, min, max int) (uint64, error) {
+	var bits uint64
+	for _, part := range strings.Split(field, ",") {
+		values, stepText, hasStep := strings.Cut(part, "/")
+		step := 1
+		if hasStep {
+			var err error
+			if step, err = strconv.Atoi(stepText); err != nil || step < 1 {
+				return 0, fmt.Errorf("invalid step %q", stepText)
+			}
+		}
+
+		first, last := min, max
+		if values != "*" {
+			lowText, highText, isRange := strings.Cut(values, "-")
+			var err error
+			if first, err = parseCronValue(lowText, min, max); err != nil {
+				return 0, err
+			}
+			last = first
+			if isRange {
+				if last, err = parseCronValue(highText, min, max); err != nil {
+					return 0, err
+				}
+				if last < first {
+					return 0, fmt.Errorf("range %q ends before it starts", values)
+				}
+			} else if hasStep {
+				// "5/15" means from 5 to the end of the range in steps of 15
+				last = max
+			}
+		}
+
+		for value := first; value <= last; value += step {
+			bits |= 1 << uint(value)
+		}
+	}
+	return bits, nil
+}
+
+// parseCronValue parses a single value of a field, which must be between min and max
+func parseCronValue(text string, min, max int) (int, error) {
+	value, err := strconv.Atoi(text)
+	if err != nil {
+		return 0, fmt.Errorf("invalid value %q", text)
+	}
+	if value < min || value > max {
+		return 0, fmt.Errorf("value %d is not between %d and %d", value, min, max)
+	}
+	return value, nil
+}
+
+// next returns the first minute after t that the schedule matches, or the zero time if it
+// matches none in the next five years, as with "0 0 30 2 *"
+func (s *cronSchedule) next(t time.Time) time.Time {
+	t = t.UTC().Truncate(time.Minute).Add(time.Minute)
+	limit := t.AddDate(5, 0, 0)
+
+	for t.Before(limit) {
+		switch {
+		case s.month&(1<<uint(t.Month())) == 0:
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, time.UTC)
+		case !s.matchesDay(t):
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, time.UTC)
+		case s.hour&(1<<uint(t.Hour())) == 0:
+			t = t.Truncate(time.Hour).Add(time.Hour)
+		case s.minute&(1<<uint(t.Minute())) == 0:
+			t = t.Add(time.Minute)
+		default:
+			return t
+		}
+	}
+	return time.Time{}
+}
+
+// matchesDay reports whether the schedule matches the day of t
+func (s *cronSchedule) matchesDay(t time.Time) bool {
+	dayOfMonth := s.dayOfMonth&(1<<uint(t.Day())) != 0
+	dayOfWeek := s.dayOfWeek&(1<<uint(t.Weekday())) != 0
+	if s.anyDayOfMonth || s.anyDayOfWeek {
+		return dayOfMonth && dayOfWeek
+	}
+	return dayOfMonth || dayOfWeek
+}
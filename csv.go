@@ -0,0 +1,467 @@
+/*
+Copyright 2021 IBM All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// exportPageSize is the number of students fetched from the ledger per page while exporting
+const exportPageSize = 100
+
+// csvColumns are the columns of an exported file, in order. Imported files must have a
+// header row naming the id column; the other columns are optional and may be in any order.
+var csvColumns = []string{"id", "name", "department", "year", "cgpa"}
+
+// studentPage is one page of students returned by the GetStudentsPage chaincode function
+type studentPage struct {
+	Students []Student `json:"students"`
+	Bookmark string    `json:"bookmark"`
+}
+
+// csvRowError reports a row of an imported CSV file that could not be parsed
+type csvRowError struct {
+	Line  int    `json:"line"`
+	Error string `json:"error"`
+}
+
+// exportFormats are the file formats students can be exported in, by the format query parameter
+var exportFormats = map[string]func(w io.Writer) studentExporter{
+	"csv":  newCSVExporter,
+	"json": newJSONExporter,
+}
+
+// studentExporter writes exported students to a file in one format
+type studentExporter interface {
+	contentType() string
+	extension() string
+
+	// begin writes anything the file starts with, write writes a student, and end finishes the file
+	begin()
+	write(student Student)
+	end()
+
+	// flush sends what has been written so far, returning an error if the client stopped reading
+	flush() error
+}
+
+// csvExporter exports students as CSV with a header row naming the columns
+type csvExporter struct {
+	writer *csv.Writer
+}
+
+func newCSVExporter(w io.Writer) studentExporter {
+	return &csvExporter{writer: csv.NewWriter(w)}
+}
+
+func (e *csvExporter) contentType() string { return "text/csv; charset=utf-8" }
+func (e *csvExporter) extension() string   { return "csv" }
+func (e *csvExporter) begin()              { e.writer.Write(csvColumns) }
+func (e *csvExporter) end()                {}
+
+func (e *csvExporter) write(student Student) {
+	e.writer.Write([]string{student.ID, student.Name, student.Department, student.Year, student.CGPA})
+}
+
+func (e *csvExporter) flush() error {
+	e.writer.Flush()
+	return e.writer.Error()
+}
+
+// jsonExporter exports students as a JSON array of student records
+type jsonExporter struct {
+	writer *bufio.Writer
+	count  int
+	err    error
+}
+
+func newJSONExporter(w io.Writer) studentExporter {
+	return &jsonExporter{writer: bufio.NewWriter(w)}
+}
+
+func (e *jsonExporter) contentType() string { return "application/json; charset=utf-8" }
+func (e *jsonExporter) extension() string   { return "json" }
+func (e *jsonExporter) begin()              { e.writer.WriteString("[\n") }
+func (e *jsonExporter) end()                { e.writer.WriteString("\n]\n") }
+
+func (e *jsonExporter) write(student Student) {
+	data, err := json.Marshal(student)
+	if err != nil {
+		e.err = err
+		return
+	}
+	if e.count > 0 {
+		e.writer.WriteString(",\n")
+	}
+	e.writer.Write(data)
+	e.count++
+}
+
+func (e *jsonExporter) flush() error {
+	if e.err != nil {
+		return e.err
+	}
+	return e.writer.Flush()
+}
+
+// exportStudents streams all students as a file download, as CSV or, with format=json, as a
+// JSON array, fetching them from the ledger a page at a time. Soft deleted students are left out
+// unless include_deleted=true.
+func exportStudents(c *gin.Context) {
+	format := c.DefaultQuery("format", "csv")
+	newExporter, ok := exportFormats[format]
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Unknown export format %q, expected csv or json", format)})
+		return
+	}
+	includeDeleted, err := parseIncludeDeleted(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	requestLogger(c).Info("Exporting students", "format", format)
+
+	// Fetch the first page before writing anything, so a failure can still be reported with a status code
+	page, err := fetchStudentsPage(c.Request.Context(), requestLedger(c), "")
+	if err != nil {
+		writeTransactionError(c, "get students", err)
+		return
+	}
+
+	exporter := newExporter(c.Writer)
+	c.Header("Content-Type", exporter.contentType())
+	c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="students.%s"`, exporter.extension()))
+	c.Status(http.StatusOK)
+
+	exporter.begin()
+
+	exported := 0
+	for {
+		for _, student := range page.Students {
+			if includeDeleted || student.Status != statusInactive {
+				exporter.write(student)
+				exported++
+			}
+		}
+
+		if err := exporter.flush(); err != nil {
+			requestLogger(c).Warn("Export aborted, client stopped reading", "exported", exported, "error", err)
+			return
+		}
+		c.Writer.Flush()
+
+		if page.Bookmark == "" {
+			break
+		}
+
+		// The status line has already been sent, so a failure part way can only truncate the file
+		if page, err = fetchStudentsPage(c.Request.Context(), requestLedger(c), page.Bookmark); err != nil {
+			requestLogger(c).Error("Export aborted, failed to get students", "exported", exported, "error", err)
+			return
+		}
+	}
+
+	exporter.end()
+	if err := exporter.flush(); err != nil {
+		requestLogger(c).Warn("Export aborted, client stopped reading", "exported", exported, "error", err)
+		return
+	}
+
+	requestLogger(c).Info("Exported students", "format", format, "count", exported)
+}
+
+// fetchStudentsPage evaluates one page of students starting from the given bookmark
+func fetchStudentsPage(ctx context.Context, ledger ledgerContract, bookmark string) (studentPage, error) {
+	var page studentPage
+
+	result, err := ledger.EvaluateTransaction(ctx, "GetStudentsPage", strconv.Itoa(exportPageSize), bookmark)
+	if err != nil {
+		return page, err
+	}
+	if err := json.Unmarshal(result, &page); err != nil {
+		return page, fmt.Errorf("failed to parse student data: %w", err)
+	}
+	return page, nil
+}
+
+// importStudents creates the students listed in an uploaded CSV file in a single transaction, or
+// in chunks with chunk_size. The file is sent as the "file" field of a multipart form. Any
+// malformed or invalid row rejects the whole import, and every such row is reported with its line
+// number, unless skip_invalid=true asks for those rows to be skipped and the others imported.
+// When any row is not imported, the response links to a CSV report of those rows and why.
+func importStudents(c *gin.Context) {
+	upload, ok := readImportFile(c)
+	if !ok {
+		return
+	}
+	students, lines, rowErrors, skipInvalid := upload.students, upload.lines, upload.rowErrors, upload.skipInvalid
+
+	var report []importReportRow
+	for _, rowErr := range rowErrors {
+		report = append(report, importReportRow{Line: rowErr.Line, Status: "malformed", Error: rowErr.Error})
+	}
+
+	var code int
+	var body gin.H
+	switch {
+	case len(rowErrors) > 0 && !skipInvalid:
+		code, body = http.StatusBadRequest, gin.H{"error": "CSV file contains malformed rows", "rows": rowErrors}
+	case len(students) == 0:
+		code, body = http.StatusBadRequest, gin.H{"error": "CSV file must contain at least one student"}
+	default:
+		requestLogger(c).Info("Importing students from CSV", "filename", upload.filename, "count", len(students), "malformed", len(rowErrors), "skipInvalid", skipInvalid)
+
+		var results []batchRecordResult
+		code, body, results = createBatch(c, students, lines, skipInvalid)
+		for _, result := range results {
+			if result.Status != "created" {
+				report = append(report, importReportRow{Line: result.Line, ID: result.ID, Status: result.Status, Error: result.Error})
+			}
+		}
+	}
+
+	if len(report) > 0 {
+		sort.Slice(report, func(i, j int) bool { return report[i].Line < report[j].Line })
+		if id, err := importReports.save(rateLimitKey(c), report); err != nil {
+			requestLogger(c).Warn("Failed to save import report", "error", err)
+		} else {
+			body["reportUrl"] = apiPath(c, "/students/import/reports/"+id)
+		}
+	}
+	c.JSON(code, body)
+}
+
+// importFile is a CSV file uploaded to import students from
+type importFile struct {
+	filename  string
+	students  []Student
+	lines     []int // line each student was read from
+	rowErrors []csvRowError
+	// skipInvalid is set by skip_invalid=true to skip malformed and invalid rows and import the others
+	skipInvalid bool
+}
+
+// readImportFile reads the CSV file uploaded to import, sent as the "file" field of a multipart
+// form. It writes the response and returns false if the request is not a usable import.
+func readImportFile(c *gin.Context) (importFile, bool) {
+	header, err := c.FormFile("file")
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Missing CSV file upload: %v", err)})
+		return importFile{}, false
+	}
+	upload := importFile{filename: header.Filename}
+	if upload.skipInvalid, err = strconv.ParseBool(c.DefaultQuery("skip_invalid", "false")); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("skip_invalid must be true or false, got %q", c.Query("skip_invalid"))})
+		return importFile{}, false
+	}
+
+	file, err := header.Open()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("Failed to open uploaded file: %v", err)})
+		return importFile{}, false
+	}
+	defer file.Close()
+
+	if upload.students, upload.lines, upload.rowErrors, err = parseStudentsCSV(file); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Invalid CSV file: %v", err)})
+		return importFile{}, false
+	}
+	return upload, true
+}
+
+// parseStudentsCSV reads students from CSV with a header row, returning the line each one was
+// read from. Rows that cannot be parsed are collected as row errors so they can all be reported;
+// an error is returned only if the file as a whole is unusable.
+func parseStudentsCSV(r io.Reader) ([]Student, []int, []csvRowError, error) {
+	reader := csv.NewReader(r)
+	reader.TrimLeadingSpace = true
+
+	headerRow, err := reader.Read()
+	if err == io.EOF {
+		return nil, nil, nil, errors.New("file is empty")
+	}
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("failed to read header row: %w", err)
+	}
+
+	columns := make(map[string]int, len(headerRow))
+	for i, name := range headerRow {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if !isCSVColumn(name) {
+			return nil, nil, nil, fmt.Errorf("unknown column %q in header row, expected %s", name, strings.Join(csvColumns, ", "))
+		}
+		if _, ok := columns[name]; ok {
+			return nil, nil, nil, fmt.Errorf("column %q appears more than once in header row", name)
+		}
+		columns[name] = i
+	}
+	if _, ok := columns["id"]; !ok {
+		return nil, nil, nil, errors.New("header row must include an id column")
+	}
+
+	var students []Student
+	var lines []int
+	var rowErrors []csvRowError
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+
+		var parseErr *csv.ParseError
+		if errors.As(err, &parseErr) {
+			rowErrors = append(rowErrors, csvRowError{Line: parseErr.StartLine, Error: parseErr.Err.Error()})
+			continue
+		}
+		if err != nil {
+			return nil, nil, nil, err
+		}
+
+		line, _ := reader.FieldPos(0)
+		field := func(name string) string {
+			if i, ok := columns[name]; ok {
+				return strings.TrimSpace(record[i])
+			}
+			return ""
+		}
+
+		students = append(students, Student{
+			ID:         field("id"),
+			Name:       field("name"),
+			Department: field("department"),
+			Year:       field("year"),
+			CGPA:       field("cgpa"),
+		})
+		lines = append(lines, line)
+	}
+
+	return students, lines, rowErrors, nil
+}
+
+// isCSVColumn reports whether name is one of the student CSV columns
+func isCSVColumn(name string) bool {
+	for _, column := range csvColumns {
+		if name == column {
+			return true
+		}
+	}
+	return false
+}
+
+// importReportTTL is how long the report of an import can be downloaded after the import
+const importReportTTL = time.Hour
+
+// maxImportReports bounds the number of reports kept, so many imports cannot exhaust memory
+const maxImportReports = 100
+
+// importReportRow is a row of an imported file that was not imported, and why
+type importReportRow struct {
+	Line   int    `json:"line"`
+	ID     string `json:"id,omitempty"`
+	Status string `json:"status"` // malformed, invalid, failed, or valid if the rest of its batch stopped it
+	Error  string `json:"error,omitempty"`
+}
+
+// importReport is the report of an import, which only the client that made the import may download
+type importReport struct {
+	owner   string
+	rows    []importReportRow
+	expires time.Time
+}
+
+// importReportStore keeps the reports of recent imports in memory
+type importReportStore struct {
+	mu      sync.Mutex
+	reports map[string]*importReport
+}
+
+// importReports holds the reports of the imports made in the last importReportTTL
+var importReports = &importReportStore{reports: make(map[string]*importReport)}
+
+// save keeps a report for importReportTTL and returns its ID. If the store is full, the report
+// closest to expiring is dropped to make room.
+func (s *importReportStore) save(owner string, rows []importReportRow) (string, error) {
+	id, err := randomHex(16)
+	if err != nil {
+		return "", err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	for k, report := range s.reports {
+		if now.After(report.expires) {
+			delete(s.reports, k)
+		}
+	}
+	if len(s.reports) >= maxImportReports {
+		var oldest string
+		for k, report := range s.reports {
+			if oldest == "" || report.expires.Before(s.reports[oldest].expires) {
+				oldest = k
+			}
+		}
+		delete(s.reports, oldest)
+	}
+
+	s.reports[id] = &importReport{owner: owner, rows: rows, expires: now.Add(importReportTTL)}
+	return id, nil
+}
+
+// get returns a report that has not expired, if it belongs to the owner
+func (s *importReportStore) get(owner, id string) (*importReport, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	report, ok := s.reports[id]
+	if !ok || report.owner != owner || time.Now().After(report.expires) {
+		return nil, false
+	}
+	return report, true
+}
+
+// getImportReport downloads the report of an import as a CSV file with a line, id, status, and
+// error column for each row that was not imported
+func getImportReport(c *gin.Context) {
+	report, ok := importReports.get(rateLimitKey(c), c.Param("id"))
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Import report not found; reports can be downloaded for an hour by the client that made the import"})
+		return
+	}
+
+	writeImportReport(c, "import-report-"+c.Param("id")+".csv", report.rows)
+}
+
+// writeImportReport writes the rows of an import report as a CSV file download
+func writeImportReport(c *gin.Context, filename string, rows []importReportRow) {
+	c.Header("Content-Type", "text/csv")
+	c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="%s"`, filename))
+	c.Status(http.StatusOK)
+
+	writer := csv.NewWriter(c.Writer)
+	writer.Write([]string{"line", "id", "status", "error"})
+	for _, row := range rows {
+		writer.Write([]string{strconv.Itoa(row.Line), row.ID, row.Status, row.Error})
+	}
+	writer.Flush()
+}
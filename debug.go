@@ -0,0 +1,93 @@
+/*
+Copyright 2021 IBM All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package main
+
+import (
+	"expvar"
+	"net/http/pprof"
+	"runtime"
+	"sort"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// gatewayPeerState is the state of one gateway peer connection, as published at /debug/vars
+type gatewayPeerState struct {
+	// Connection is the organization's MSP ID, followed by the user name for a user's own identity
+	Connection     string `json:"connection"`
+	MSPID          string `json:"mspId"`
+	Endpoint       string `json:"endpoint"`
+	State          string `json:"state"`
+	CircuitBreaker string `json:"circuitBreaker"`
+}
+
+// publishDebugVars adds the goroutine count and the state of the gateway connections to the
+// variables served at /debug/vars, alongside expvar's cmdline and memstats. It must only be
+// called once, as expvar refuses to publish a name twice.
+func publishDebugVars() {
+	expvar.Publish("goroutines", expvar.Func(func() any { return runtime.NumGoroutine() }))
+	expvar.Publish("gatewayConnections", expvar.Func(func() any { return gatewayPeerStates() }))
+}
+
+// gatewayPeerStates returns the state of each open connection to a gateway peer, ordered by
+// connection and with each connection's preferred peer first
+func gatewayPeerStates() []gatewayPeerState {
+	states := []gatewayPeerState{}
+	if orgs == nil {
+		return states
+	}
+
+	orgs.mu.Lock()
+	keys := make([]string, 0, len(orgs.connections))
+	for key := range orgs.connections {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	connections := make([]*fabricConnection, len(keys))
+	for i, key := range keys {
+		connections[i] = orgs.connections[key]
+	}
+	orgs.mu.Unlock()
+
+	for i, fc := range connections {
+		for _, peer := range fc.peers {
+			states = append(states, gatewayPeerState{
+				Connection:     keys[i],
+				MSPID:          peer.org.MSPID,
+				Endpoint:       peer.org.PeerEndpoint,
+				State:          peer.currentConnection().GetState().String(),
+				CircuitBreaker: peer.breaker.currentState().String(),
+			})
+		}
+	}
+	return states
+}
+
+// debugVars serves the variables published with expvar as JSON
+func debugVars(c *gin.Context) {
+	expvar.Handler().ServeHTTP(c.Writer, c.Request)
+}
+
+// debugProfile serves the net/http/pprof profiles under the route's *profile parameter, such
+// as heap, goroutine, profile for a CPU profile, or trace, and their index when it is empty
+func debugProfile(c *gin.Context) {
+	switch name := strings.TrimPrefix(c.Param("profile"), "/"); name {
+	case "":
+		pprof.Index(c.Writer, c.Request)
+	case "cmdline":
+		pprof.Cmdline(c.Writer, c.Request)
+	case "profile":
+		pprof.Profile(c.Writer, c.Request)
+	case "symbol":
+		pprof.Symbol(c.Writer, c.Request)
+	case "trace":
+		pprof.Trace(c.Writer, c.Request)
+	default:
+		pprof.Handler(name).ServeHTTP(c.Writer, c.Request)
+	}
+}
@@ -0,0 +1,91 @@
+/*
+Copyright 2021 IBM All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+	"sort"
+
+	"github.com/gin-gonic/gin"
+)
+
+// diffIgnoredFields are the record fields that identify or timestamp a student rather than describe it,
+// so they are left out of a diff
+var diffIgnoredFields = map[string]bool{"id": true, "updatedAt": true}
+
+// fieldDifference is one field whose value differs between the two students compared.
+// A field missing from one of the records has a null value on that side.
+type fieldDifference struct {
+	Field string      `json:"field"`
+	A     interface{} `json:"a"`
+	B     interface{} `json:"b"`
+}
+
+// diffStudents compares the students named by the a and b query parameters field by field
+func diffStudents(c *gin.Context) {
+	idA, idB := c.Query("a"), c.Query("b")
+	if idA == "" || idB == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Query parameters a and b must both name a student"})
+		return
+	}
+
+	requestLogger(c).Info("Comparing students", "a", idA, "b", idB)
+
+	studentA, ok := readStudentFields(c, idA)
+	if !ok {
+		return
+	}
+	studentB, ok := readStudentFields(c, idB)
+	if !ok {
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"a": idA, "b": idB, "differences": diffFields(studentA, studentB)})
+}
+
+// readStudentFields reads a student as a map of its fields, writing an error response if it cannot be read
+func readStudentFields(c *gin.Context, id string) (map[string]interface{}, bool) {
+	result, err := requestLedger(c).EvaluateTransaction(c.Request.Context(), "ReadStudent", id)
+	if err != nil {
+		if !writeStudentError(c, id, err) {
+			writeTransactionError(c, "read student "+id, err)
+		}
+		return nil, false
+	}
+
+	var student map[string]interface{}
+	if err := json.Unmarshal(result, &student); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("Failed to parse student data: %v", err)})
+		return nil, false
+	}
+	return student, true
+}
+
+// diffFields returns the fields whose values differ between two records, sorted by field name
+func diffFields(a, b map[string]interface{}) []fieldDifference {
+	fields := make(map[string]bool, len(a)+len(b))
+	for field := range a {
+		fields[field] = true
+	}
+	for field := range b {
+		fields[field] = true
+	}
+
+	differences := []fieldDifference{}
+	for field := range fields {
+		if diffIgnoredFields[field] || reflect.DeepEqual(a[field], b[field]) {
+			continue
+		}
+		differences = append(differences, fieldDifference{Field: field, A: a[field], B: b[field]})
+	}
+
+	sort.Slice(differences, func(i, j int) bool { return differences[i].Field < differences[j].Field })
+	return differences
+}
@@ -0,0 +1,94 @@
+/*
+Copyright 2021 IBM All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+	"testing"
+)
+
+// diffLedger answers ReadStudent with the given records, and as the chaincode does for any other student
+func diffLedger(records map[string]string) *fakeLedger {
+	return &fakeLedger{respond: func(name string, args []string) ([]byte, error) {
+		record, ok := records[args[0]]
+		if !ok {
+			return nil, fmt.Errorf("chaincode response 500, the student %s does not exist", args[0])
+		}
+		return []byte(record), nil
+	}}
+}
+
+func TestDiffStudents(t *testing.T) {
+	ledger := diffLedger(map[string]string{
+		"S1": `{"id":"S1","name":"Alice","branch":"CSE","cgpa":"9.1","updatedAt":"2024-01-02T10:30:00Z","version":1}`,
+		"S2": `{"id":"S2","name":"Bob","branch":"CSE","cgpa":"8.4","labels":{"cohort":"2024"},"updatedAt":"2024-02-01T09:00:00Z","version":1}`,
+		"S3": `{"id":"S3","name":"Alice","branch":"CSE","cgpa":"9.1","updatedAt":"2024-03-05T12:00:00Z","version":1}`,
+	})
+	router := newTestRouter(t, ledger, nil)
+
+	tests := []struct {
+		name string
+		a, b string
+		want []fieldDifference
+	}{
+		{
+			name: "differing",
+			a:    "S1",
+			b:    "S2",
+			want: []fieldDifference{
+				{Field: "cgpa", A: "9.1", B: "8.4"},
+				{Field: "labels", A: nil, B: map[string]interface{}{"cohort": "2024"}},
+				{Field: "name", A: "Alice", B: "Bob"},
+			},
+		},
+		{name: "identical but for id and updatedAt", a: "S1", b: "S3", want: []fieldDifference{}},
+		{name: "same student", a: "S1", b: "S1", want: []fieldDifference{}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			response := serveRequest(router, http.MethodGet, "/api/students/diff?a="+test.a+"&b="+test.b, "")
+			if response.Code != http.StatusOK {
+				t.Fatalf("status = %d, body %s", response.Code, response.Body)
+			}
+
+			var body struct {
+				A           string            `json:"a"`
+				B           string            `json:"b"`
+				Differences []fieldDifference `json:"differences"`
+			}
+			if err := json.Unmarshal(response.Body.Bytes(), &body); err != nil {
+				t.Fatalf("parsing response: %v", err)
+			}
+			if body.A != test.a || body.B != test.b || !reflect.DeepEqual(body.Differences, test.want) {
+				t.Errorf("body = %s, want differences %+v", response.Body, test.want)
+			}
+		})
+	}
+
+	if calls := ledger.submitted(); len(calls) != 0 {
+		t.Errorf("submitted %+v, want the students only read", calls)
+	}
+}
+
+func TestDiffStudentsMissing(t *testing.T) {
+	ledger := diffLedger(map[string]string{"S1": `{"id":"S1","name":"Alice","version":1}`})
+	router := newTestRouter(t, ledger, nil)
+
+	for _, target := range []string{"/api/students/diff?a=S1&b=S9", "/api/students/diff?a=S9&b=S1"} {
+		if response := serveRequest(router, http.MethodGet, target, ""); response.Code != http.StatusNotFound {
+			t.Errorf("GET %s: status = %d, want 404, body %s", target, response.Code, response.Body)
+		}
+	}
+
+	if response := serveRequest(router, http.MethodGet, "/api/students/diff?a=S1", ""); response.Code != http.StatusBadRequest {
+		t.Errorf("status without b = %d, want 400", response.Code)
+	}
+}
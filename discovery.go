@@ -0,0 +1,148 @@
+/*
+Copyright 2021 IBM All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sort"
+
+	"github.com/gin-gonic/gin"
+	"github.com/hyperledger/fabric-gateway/pkg/client"
+	"github.com/hyperledger/fabric-protos-go-apiv2/peer"
+	"github.com/hyperledger/fabric-protos-go-apiv2/peer/lifecycle"
+	"google.golang.org/protobuf/proto"
+)
+
+// System chaincodes queried to discover what the gateway peer can serve
+const (
+	// configChaincode answers which channels the peer has joined
+	configChaincode = "cscc"
+	// legacyLifecycleChaincode answers which chaincodes were instantiated on a channel before
+	// the Fabric 2.0 lifecycle was enabled on it
+	legacyLifecycleChaincode = "lscc"
+)
+
+// JoinedChannel is a channel the gateway peer has joined
+type JoinedChannel struct {
+	Name string `json:"name"`
+	// Allowed reports whether requests may choose the channel, as the configured channel or one listed in CHANNELS
+	Allowed bool `json:"allowed"`
+}
+
+// DeployedChaincode is a chaincode defined on a channel
+type DeployedChaincode struct {
+	Name         string   `json:"name"`
+	Version      string   `json:"version"`
+	Sequence     int64    `json:"sequence,omitempty"`
+	InitRequired bool     `json:"initRequired"`
+	Collections  []string `json:"collections,omitempty"`
+	// Lifecycle is the lifecycle that defined the chaincode: _lifecycle, or lscc for a chaincode
+	// instantiated before the Fabric 2.0 lifecycle was enabled and not defined again since
+	Lifecycle string `json:"lifecycle"`
+}
+
+// writeDiscoveryError writes the response for a failed discovery query
+func writeDiscoveryError(c *gin.Context, action string, err error) {
+	if isLifecycleAccessDenied(err) {
+		c.JSON(http.StatusForbidden, gin.H{"error": fmt.Sprintf("Not permitted to %s", action), "details": gatewayErrorText(err)})
+		return
+	}
+	writeTransactionError(c, action, err)
+}
+
+// listChannels lists the channels the gateway peer of the organization chosen for the request
+// has joined, marking those requests may choose
+func listChannels(c *gin.Context) {
+	fc := requireConnection(c)
+	if fc == nil {
+		return
+	}
+
+	gatewayPeer := fc.preferredPeer()
+	ctx, cancel := context.WithTimeout(c.Request.Context(), callTimeoutFrom(c.Request.Context(), evaluateTimeout))
+	defer cancel()
+
+	payload, err := gatewayPeer.processLocalProposal(ctx, configChaincode, "GetChannels")
+	result := &peer.ChannelQueryResponse{}
+	if err == nil {
+		err = proto.Unmarshal(payload, result)
+	}
+	if err != nil {
+		writeDiscoveryError(c, "list the peer's channels", err)
+		return
+	}
+
+	channels := make([]JoinedChannel, 0, len(result.GetChannels()))
+	for _, channel := range result.GetChannels() {
+		channels = append(channels, JoinedChannel{Name: channel.GetChannelId(), Allowed: isAllowedChannel(channel.GetChannelId())})
+	}
+	sort.Slice(channels, func(i, j int) bool { return channels[i].Name < channels[j].Name })
+
+	c.JSON(http.StatusOK, gin.H{"peer": gatewayPeer.org.PeerEndpoint, "channels": channels})
+}
+
+// listChaincodes lists the chaincodes defined on the request's channel, both those committed
+// with _lifecycle and those still running from a legacy instantiation, sorted by name
+func listChaincodes(c *gin.Context) {
+	if requireConnection(c) == nil {
+		return
+	}
+
+	ctx := c.Request.Context()
+	args, err := proto.Marshal(&lifecycle.QueryChaincodeDefinitionsArgs{})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("Failed to list chaincodes: %v", err)})
+		return
+	}
+	payload, err := gatewayContract{}.evaluate(contextWithChaincode(ctx, lifecycleChaincode), "QueryChaincodeDefinitions", client.WithBytesArguments(args))
+	definitions := &lifecycle.QueryChaincodeDefinitionsResult{}
+	if err == nil {
+		err = proto.Unmarshal(payload, definitions)
+	}
+	if err != nil {
+		writeDiscoveryError(c, "list the channel's chaincodes", err)
+		return
+	}
+
+	chaincodes := []DeployedChaincode{}
+	defined := make(map[string]bool)
+	for _, definition := range definitions.GetChaincodeDefinitions() {
+		chaincode := DeployedChaincode{
+			Name:         definition.GetName(),
+			Version:      definition.GetVersion(),
+			Sequence:     definition.GetSequence(),
+			InitRequired: definition.GetInitRequired(),
+			Lifecycle:    lifecycleChaincode,
+		}
+		for _, collection := range definition.GetCollections().GetConfig() {
+			chaincode.Collections = append(chaincode.Collections, collection.GetStaticCollectionConfig().GetName())
+		}
+		chaincodes = append(chaincodes, chaincode)
+		defined[chaincode.Name] = true
+	}
+
+	// Channels that enabled the new lifecycle later may still run chaincodes instantiated before.
+	// Peers that cannot answer for them are not worth failing the request over.
+	payload, err = gatewayContract{}.evaluate(contextWithChaincode(ctx, legacyLifecycleChaincode), "GetChaincodes")
+	legacy := &peer.ChaincodeQueryResponse{}
+	if err == nil {
+		err = proto.Unmarshal(payload, legacy)
+	}
+	if err != nil {
+		requestLogger(c).Warn("Failed to list legacy chaincodes", "error", err)
+	}
+	for _, instantiated := range legacy.GetChaincodes() {
+		if !defined[instantiated.GetName()] {
+			chaincodes = append(chaincodes, DeployedChaincode{Name: instantiated.GetName(), Version: instantiated.GetVersion(), Lifecycle: legacyLifecycleChaincode})
+		}
+	}
+	sort.Slice(chaincodes, func(i, j int) bool { return chaincodes[i].Name < chaincodes[j].Name })
+
+	c.JSON(http.StatusOK, gin.H{"channel": channelFrom(ctx), "chaincodes": chaincodes})
+}
@@ -0,0 +1,79 @@
+/*
+Copyright 2021 IBM All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package main
+
+import (
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"gopkg.in/yaml.v3"
+)
+
+// openAPISpecYAML is the OpenAPI document describing the REST API
+//
+//go:embed openapi.yaml
+var openAPISpecYAML []byte
+
+// openAPISpecJSON is the OpenAPI document converted to JSON when the server starts
+var openAPISpecJSON = mustConvertToJSON(openAPISpecYAML)
+
+// swaggerUIVersion is the release of swagger-ui-dist that the docs page loads
+const swaggerUIVersion = "5.17.14"
+
+// swaggerUIPage renders the OpenAPI document with Swagger UI. Tokens entered with the
+// Authorize button are kept across page loads.
+var swaggerUIPage = fmt.Sprintf(`<!DOCTYPE html>
+<html lang="en">
+<head>
+  <meta charset="utf-8">
+  <title>Student Records API</title>
+  <link rel="stylesheet" href="https://unpkg.com/swagger-ui-dist@%[1]s/swagger-ui.css">
+</head>
+<body>
+  <div id="swagger-ui"></div>
+  <script src="https://unpkg.com/swagger-ui-dist@%[1]s/swagger-ui-bundle.js" crossorigin></script>
+  <script>
+    window.ui = SwaggerUIBundle({
+      url: "/api/docs/openapi.json",
+      dom_id: "#swagger-ui",
+      persistAuthorization: true,
+    });
+  </script>
+</body>
+</html>
+`, swaggerUIVersion)
+
+// mustConvertToJSON converts a YAML document to JSON, panicking if it is invalid
+func mustConvertToJSON(document []byte) []byte {
+	var value interface{}
+	if err := yaml.Unmarshal(document, &value); err != nil {
+		panic(fmt.Errorf("invalid OpenAPI document: %w", err))
+	}
+	converted, err := json.Marshal(value)
+	if err != nil {
+		panic(fmt.Errorf("invalid OpenAPI document: %w", err))
+	}
+	return converted
+}
+
+// swaggerUI serves the interactive API documentation
+func swaggerUI(c *gin.Context) {
+	c.Data(http.StatusOK, "text/html; charset=utf-8", []byte(swaggerUIPage))
+}
+
+// openAPISpec serves the OpenAPI document as JSON
+func openAPISpec(c *gin.Context) {
+	c.Data(http.StatusOK, "application/json", openAPISpecJSON)
+}
+
+// openAPISpecYAMLFile serves the OpenAPI document as the YAML it is written in
+func openAPISpecYAMLFile(c *gin.Context) {
+	c.Data(http.StatusOK, "application/yaml", openAPISpecYAML)
+}
@@ -0,0 +1,91 @@
+/*
+Copyright 2021 IBM All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"regexp"
+
+	"github.com/gin-gonic/gin"
+	"github.com/hyperledger/fabric-gateway/pkg/client"
+)
+
+// endorsingOrgsHeader is the request header used to choose the organizations whose peers
+// endorse the transactions a request submits
+const endorsingOrgsHeader = "X-Endorsing-Orgs"
+
+// maxEndorsingOrgs is the most endorsing organizations a request or the configuration may name
+const maxEndorsingOrgs = 16
+
+// validMSPID matches the MSP IDs that may be named as endorsing organizations
+var validMSPID = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]{0,127}$`)
+
+// validateEndorsingOrgs checks that a list of endorsing organizations names valid MSP IDs
+func validateEndorsingOrgs(mspIDs []string) error {
+	if len(mspIDs) > maxEndorsingOrgs {
+		return fmt.Errorf("at most %d endorsing organizations may be named, got %d", maxEndorsingOrgs, len(mspIDs))
+	}
+	for _, mspID := range mspIDs {
+		if !validMSPID.MatchString(mspID) {
+			return fmt.Errorf("invalid MSP ID %q", mspID)
+		}
+	}
+	return nil
+}
+
+// endorsingOrgsKey is the context key under which the endorsing organizations of a request are stored
+type endorsingOrgsKey struct{}
+
+// contextWithEndorsingOrgs returns a copy of ctx whose submits are endorsed by the given organizations
+func contextWithEndorsingOrgs(ctx context.Context, mspIDs []string) context.Context {
+	return context.WithValue(ctx, endorsingOrgsKey{}, mspIDs)
+}
+
+// endorsingOrgsFrom returns the organizations chosen to endorse the submits made with ctx,
+// falling back to the configured ones. Nil leaves the choice to the gateway's discovery.
+func endorsingOrgsFrom(ctx context.Context) []string {
+	if mspIDs, ok := ctx.Value(endorsingOrgsKey{}).([]string); ok {
+		return mspIDs
+	}
+	return cfg.EndorsingOrgs
+}
+
+// withEndorsingOrgs adds the endorsing organizations chosen for ctx, if any, to the options
+// of a proposal to submit. Evaluations are left to run on the gateway peer's own organization.
+func withEndorsingOrgs(ctx context.Context, options []client.ProposalOption) []client.ProposalOption {
+	if mspIDs := endorsingOrgsFrom(ctx); len(mspIDs) > 0 {
+		return append(options, client.WithEndorsingOrganizations(mspIDs...))
+	}
+	return options
+}
+
+// endorsingOrgsMiddleware sets the organizations that endorse the transactions a request
+// submits to those listed in the X-Endorsing-Orgs header, such as "Org1MSP,Org2MSP". Other
+// requests use the configured ones, or without those, the gateway chooses endorsers from the
+// endorsement policy. An invalid header is rejected with 400.
+func endorsingOrgsMiddleware(c *gin.Context) {
+	value := c.GetHeader(endorsingOrgsHeader)
+	if value == "" {
+		c.Next()
+		return
+	}
+
+	mspIDs := splitList(value)
+	if len(mspIDs) == 0 {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Invalid %s %q, expected a comma-separated list of MSP IDs", endorsingOrgsHeader, value)})
+		return
+	}
+	if err := validateEndorsingOrgs(mspIDs); err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Invalid %s: %v", endorsingOrgsHeader, err)})
+		return
+	}
+
+	c.Request = c.Request.WithContext(contextWithEndorsingOrgs(c.Request.Context(), mspIDs))
+	c.Next()
+}
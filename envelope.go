@@ -0,0 +1,178 @@
+/*
+Copyright 2021 IBM All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"mime"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// wrappedDataKey is the Gin context key marking a response whose body carries its data under a
+// data field, as the responses to writes do
+const wrappedDataKey = "wrappedData"
+
+// bookmarkKey is the Gin context key under which a paged handler stores the bookmark of the
+// next page, for the envelope's meta
+const bookmarkKey = "bookmark"
+
+// unenvelopedRoutes are the routes, by method and route pattern, whose responses are streamed,
+// so they cannot be held back to be wrapped in an envelope, or are documents with a format of
+// their own
+var unenvelopedRoutes = map[string]bool{
+	"GET /api/students/export":   true,
+	"GET /api/events/ws":         true,
+	"GET /api/blocks/stream":     true,
+	"GET /api/docs/openapi.json": true,
+}
+
+// responseEnvelope is the body of every JSON response under an API root that uses envelopes.
+// Exactly one of Data and Error is set.
+type responseEnvelope struct {
+	Data  json.RawMessage `json:"data"`
+	Error json.RawMessage `json:"error"`
+	Meta  responseMeta    `json:"meta"`
+}
+
+// responseMeta describes the request and the transaction it made, if any
+type responseMeta struct {
+	RequestID   string  `json:"requestId"`
+	TxID        string  `json:"txId,omitempty"`
+	BlockNumber *uint64 `json:"blockNumber,omitempty"`
+	Bookmark    string  `json:"bookmark,omitempty"`
+}
+
+// envelopeWriter holds back the body of a response so it can be wrapped in an envelope once
+// the handler has finished
+type envelopeWriter struct {
+	gin.ResponseWriter
+	body bytes.Buffer
+}
+
+// Write keeps the body for the envelope
+func (w *envelopeWriter) Write(data []byte) (int, error) {
+	return w.body.Write(data)
+}
+
+// WriteString keeps the body for the envelope
+func (w *envelopeWriter) WriteString(s string) (int, error) {
+	return w.body.WriteString(s)
+}
+
+// envelopeMiddleware wraps each JSON response in a responseEnvelope. A successful response
+// becomes the data, and a failed one the error, with its error field named code, or message if
+// it has no message of its own. Other responses, such as CSV downloads, are written as they are.
+func envelopeMiddleware(c *gin.Context) {
+	if unenvelopedRoutes[c.Request.Method+" "+routePattern(c)] {
+		c.Next()
+		return
+	}
+
+	writer := &envelopeWriter{ResponseWriter: c.Writer}
+	c.Writer = writer
+	c.Next()
+	c.Writer = writer.ResponseWriter
+
+	body := writer.body.Bytes()
+	mediaType, _, _ := mime.ParseMediaType(writer.Header().Get("Content-Type"))
+	if mediaType != "application/json" || len(body) == 0 || !json.Valid(body) {
+		writer.ResponseWriter.Write(body)
+		return
+	}
+
+	envelope := responseEnvelope{Meta: responseMeta{
+		RequestID: writer.Header().Get(requestIDHeader),
+		Bookmark:  c.GetString(bookmarkKey),
+	}}
+	if info := transactionInfoFrom(c.Request.Context()); info != nil {
+		envelope.Meta.TxID = info.TransactionID
+		envelope.Meta.BlockNumber = info.BlockNumber
+	}
+	switch {
+	case writer.Status() >= http.StatusBadRequest:
+		envelope.Error = envelopeError(body)
+	case c.GetBool(wrappedDataKey):
+		envelope.Data = unwrapData(body)
+	default:
+		envelope.Data = body
+	}
+
+	wrapped, err := json.Marshal(envelope)
+	if err != nil {
+		requestLogger(c).Error("Failed to wrap response in envelope", "error", err)
+		writer.ResponseWriter.Write(body)
+		return
+	}
+	writer.ResponseWriter.Write(wrapped)
+}
+
+// unwrapData returns the data of a body carrying it under a data field. The transaction is
+// left out, since the envelope's meta names it, and any other fields are added to the data.
+func unwrapData(body []byte) json.RawMessage {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(body, &fields); err != nil {
+		return body
+	}
+	data := fields["data"]
+	delete(fields, "data")
+	delete(fields, "transaction")
+	if len(fields) == 0 {
+		return data
+	}
+
+	var dataFields map[string]json.RawMessage
+	if err := json.Unmarshal(data, &dataFields); err != nil {
+		return data
+	}
+	for name, value := range fields {
+		dataFields[name] = value
+	}
+	merged, err := json.Marshal(dataFields)
+	if err != nil {
+		return data
+	}
+	return merged
+}
+
+// dataBody returns a body carrying the data under a data field, and marks the response so that
+// an envelope takes its data from that field
+func dataBody(c *gin.Context, data any) gin.H {
+	c.Set(wrappedDataKey, true)
+	return gin.H{"data": data}
+}
+
+// envelopeError returns an error body in the envelope's form, {"code": ..., "message": ...}
+// along with any other fields it has. Error bodies name the failure, or describe it when they
+// have no message, in their error field.
+func envelopeError(body []byte) json.RawMessage {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(body, &fields); err != nil {
+		return body
+	}
+	if value, ok := fields["error"]; ok {
+		delete(fields, "error")
+		if _, ok := fields["message"]; ok {
+			fields["code"] = value
+		} else {
+			fields["message"] = value
+		}
+	}
+
+	normalized, err := json.Marshal(fields)
+	if err != nil {
+		return body
+	}
+	return normalized
+}
+
+// usesEnvelope reports whether the request is under an API root that wraps responses in an envelope
+func usesEnvelope(c *gin.Context) bool {
+	return apiRootFrom(c).envelope
+}
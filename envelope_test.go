@@ -0,0 +1,158 @@
+/*
+Copyright 2021 IBM All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"testing"
+)
+
+// committingLedger is a fakeLedger that records the transaction of each submit on the request,
+// as gatewayContract does once the transaction commits
+type committingLedger struct {
+	fakeLedger
+}
+
+func (l *committingLedger) SubmitTransaction(ctx context.Context, name string, args ...string) ([]byte, error) {
+	if info := transactionInfoFrom(ctx); info != nil {
+		blockNumber := uint64(12)
+		*info = transactionInfo{TransactionID: "tx-commit", BlockNumber: &blockNumber, CommitStatus: "VALID"}
+	}
+	return l.fakeLedger.SubmitTransaction(ctx, name, args...)
+}
+
+func TestEnvelope(t *testing.T) {
+	ledger := &committingLedger{fakeLedger: fakeLedger{respond: func(name string, args []string) ([]byte, error) {
+		switch name {
+		case "SearchStudentsByName":
+			return []byte(`{"students":[{"id":"S1","name":"Alice"}],"bookmark":"g1"}`), nil
+		case "ReadStudent":
+			if args[0] == "S1" {
+				return []byte(storedStudent), nil
+			}
+			return nil, endorseFailure("the student " + args[0] + " does not exist")
+		}
+		return nil, nil
+	}}}
+	router := newTestRouter(t, ledger, nil)
+	blockNumber := uint64(12)
+
+	tests := []struct {
+		name   string
+		method string
+		target string
+		body   string
+		want   int
+		// wantData and wantError are the envelope's data and error, compared as JSON
+		wantData  string
+		wantError string
+		wantMeta  responseMeta
+	}{
+		{
+			name:     "write",
+			method:   http.MethodPost,
+			target:   "/api/v2/students",
+			body:     `{"id":"S2","name":"Bob","department":"ECE"}`,
+			want:     http.StatusCreated,
+			wantData: `{"id":"S2","name":"Bob","department":"ECE","year":"","cgpa":""}`,
+			wantMeta: responseMeta{RequestID: "req-1", TxID: "tx-commit", BlockNumber: &blockNumber},
+		},
+		{
+			name:     "read",
+			method:   http.MethodGet,
+			target:   "/api/v2/students/S1",
+			want:     http.StatusOK,
+			wantData: storedStudent,
+			wantMeta: responseMeta{RequestID: "req-1"},
+		},
+		{
+			name:     "page",
+			method:   http.MethodGet,
+			target:   "/api/v2/students/search?name=ali",
+			want:     http.StatusOK,
+			wantData: `[{"id":"S1","name":"Alice"}]`,
+			wantMeta: responseMeta{RequestID: "req-1", Bookmark: "g1"},
+		},
+		{
+			name:      "failure with a code",
+			method:    http.MethodGet,
+			target:    "/api/v2/students/S9",
+			want:      http.StatusNotFound,
+			wantError: `{"code":"not_found","id":"S9","message":"student does not exist"}`,
+			wantMeta:  responseMeta{RequestID: "req-1"},
+		},
+		{
+			name:      "failure without a code",
+			method:    http.MethodGet,
+			target:    "/api/v2/students/search",
+			want:      http.StatusBadRequest,
+			wantError: `{"message":"Query parameter name must give the text to search for"}`,
+			wantMeta:  responseMeta{RequestID: "req-1"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			response := serveRequest(router, test.method, test.target, test.body, requestIDHeader, "req-1")
+			if response.Code != test.want {
+				t.Fatalf("status = %d, want %d, body %s", response.Code, test.want, response.Body)
+			}
+
+			var envelope responseEnvelope
+			if err := json.Unmarshal(response.Body.Bytes(), &envelope); err != nil {
+				t.Fatalf("parsing envelope %s: %v", response.Body, err)
+			}
+			assertJSONEqual(t, "data", envelope.Data, test.wantData)
+			assertJSONEqual(t, "error", envelope.Error, test.wantError)
+			if !reflect.DeepEqual(envelope.Meta, test.wantMeta) {
+				t.Errorf("body = %s, want meta %+v", response.Body, test.wantMeta)
+			}
+		})
+	}
+}
+
+func TestEnvelopeOnlyUnderV2(t *testing.T) {
+	router := newTestRouter(t, &committingLedger{}, nil)
+
+	response := serveRequest(router, http.MethodPost, "/api/v1/students", `{"id":"S1","name":"Alice"}`)
+	if response.Code != http.StatusCreated {
+		t.Fatalf("status = %d, body %s", response.Code, response.Body)
+	}
+	var body map[string]json.RawMessage
+	if err := json.Unmarshal(response.Body.Bytes(), &body); err != nil {
+		t.Fatalf("parsing response %s: %v", response.Body, err)
+	}
+	if _, ok := body["meta"]; ok {
+		t.Errorf("body = %s, want the v1 response without an envelope", response.Body)
+	}
+	assertJSONEqual(t, "transaction", body["transaction"], `{"transactionId":"tx-commit","blockNumber":12,"commitStatus":"VALID"}`)
+}
+
+// assertJSONEqual checks that got holds the same JSON value as want, or null if want is empty
+func assertJSONEqual(t *testing.T, name string, got json.RawMessage, want string) {
+	t.Helper()
+
+	if want == "" {
+		want = "null"
+	}
+	var gotValue, wantValue interface{}
+	if err := json.Unmarshal(got, &gotValue); err != nil {
+		t.Errorf("%s = %s, not JSON: %v", name, got, err)
+		return
+	}
+	if err := json.Unmarshal([]byte(want), &wantValue); err != nil {
+		t.Fatalf("parsing wanted %s %s: %v", name, want, err)
+	}
+	gotJSON, _ := json.Marshal(gotValue)
+	wantJSON, _ := json.Marshal(wantValue)
+	if string(gotJSON) != string(wantJSON) {
+		t.Errorf("%s = %s, want %s", name, gotJSON, wantJSON)
+	}
+}
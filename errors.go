@@ -0,0 +1,323 @@
+/*
+Copyright 2021 IBM All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/hyperledger/fabric-gateway/pkg/client"
+	"github.com/hyperledger/fabric-protos-go-apiv2/gateway"
+	"github.com/hyperledger/fabric-protos-go-apiv2/peer"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// gatewayErrorText returns the gRPC status message of a gateway error together with
+// the messages reported by each peer in its error details
+func gatewayErrorText(err error) string {
+	statusErr := status.Convert(err)
+	messages := []string{statusErr.Message()}
+
+	for _, detail := range statusErr.Details() {
+		switch detail := detail.(type) {
+		case *gateway.ErrorDetail:
+			messages = append(messages, detail.Message)
+		}
+	}
+
+	return strings.Join(messages, "; ")
+}
+
+// transactionOutcome classifies the result of a transaction by the stage at which it failed
+func transactionOutcome(err error) string {
+	var endorseErr *client.EndorseError
+	var submitErr *client.SubmitError
+	var commitStatusErr *client.CommitStatusError
+	var commitErr *client.CommitError
+	var commitFailedErr *commitFailedError
+
+	switch {
+	case err == nil:
+		return "success"
+	case errors.As(err, &endorseErr):
+		return "endorse_error"
+	case errors.As(err, &submitErr):
+		return "submit_error"
+	case errors.As(err, &commitStatusErr):
+		return "commit_status_error"
+	case errors.As(err, &commitErr), errors.As(err, &commitFailedErr):
+		return "commit_error"
+	default:
+		return "error"
+	}
+}
+
+// writeStudentError writes a typed response for a chaincode error reporting that a student
+// already exists (409), does not exist (404), is already or is not soft deleted (409), or is
+// soft deleted when updating it (410), returning false for any other error
+func writeStudentError(c *gin.Context, id string, err error) bool {
+	switch studentErr := studentError(err); studentErr {
+	case errStudentExists, errStudentDeleted, errStudentNotDeleted:
+		c.JSON(http.StatusConflict, gin.H{"error": "conflict", "id": id, "message": studentErr.Error()})
+	case errStudentNotFound:
+		c.JSON(http.StatusNotFound, gin.H{"error": "not_found", "id": id, "message": studentErr.Error()})
+	case errStudentGone:
+		c.JSON(http.StatusGone, gin.H{"error": "gone", "id": id, "message": studentErr.Error()})
+	case errStudentChanged:
+		c.JSON(http.StatusPreconditionFailed, gin.H{"error": "precondition_failed", "id": id, "message": studentErr.Error()})
+	default:
+		return false
+	}
+	return true
+}
+
+// Failures where the gateway could not run the transaction as requested, as opposed to an
+// error returned by the chaincode itself
+const (
+	failureChaincodeNotFound = "chaincode_not_found"
+	failureEndorsementPolicy = "endorsement_policy_failure"
+)
+
+// chaincodeNotFoundMessages are fragments of the messages peers report when the chaincode is
+// not committed on the channel or not installed on the peer
+var chaincodeNotFoundMessages = []string{
+	"could not find chaincode",
+	"chaincode definition for",
+	"is not installed",
+	"not found in channel",
+	"make sure the chaincode",
+}
+
+// endorsementPolicyMessages are fragments of the messages reported when endorsements cannot
+// satisfy the chaincode's endorsement policy
+var endorsementPolicyMessages = []string{
+	"endorsement policy",
+	"ENDORSEMENT_POLICY_FAILURE",
+	"implicit policy evaluation failed",
+}
+
+// classifyGatewayFailure reports whether a transaction failed because the chaincode could not
+// be found or because the endorsement policy was not satisfied, returning "" for other errors
+func classifyGatewayFailure(err error) string {
+	var commitFailedErr *commitFailedError
+	if errors.As(err, &commitFailedErr) && commitFailedErr.Code == peer.TxValidationCode_ENDORSEMENT_POLICY_FAILURE {
+		return failureEndorsementPolicy
+	}
+
+	text := gatewayErrorText(err)
+	for _, fragment := range chaincodeNotFoundMessages {
+		if strings.Contains(text, fragment) {
+			return failureChaincodeNotFound
+		}
+	}
+	for _, fragment := range endorsementPolicyMessages {
+		if strings.Contains(text, fragment) {
+			return failureEndorsementPolicy
+		}
+	}
+	return ""
+}
+
+// Further failures that writeTransactionError reports with their own status code
+const (
+	failureMVCCConflict = "mvcc_conflict"
+	failureTimeout      = "timeout"
+	failureTransaction  = "transaction_failed"
+	failurePeerDown     = "peer_unavailable"
+)
+
+// Errors returned by the chaincode itself, as told apart by classifyChaincodeError
+const (
+	failureConflict        = "conflict"
+	failureNotFound        = "not_found"
+	failureGone            = "gone"
+	failureInvalidArgument = "invalid_argument"
+)
+
+// chaincodeResponsePattern matches the error a peer reports when the chaincode returned an
+// error, such as "chaincode response 500, the student S1 does not exist", capturing the
+// chaincode's own message
+var chaincodeResponsePattern = regexp.MustCompile(`chaincode response \d+, (.+)`)
+
+// chaincodeConflictMessages are fragments of the chaincode's errors for a write that conflicts
+// with the current state of a record
+var chaincodeConflictMessages = []string{
+	"already exists",
+	"appears more than once",
+	"is already deleted",
+	"is not deleted",
+	"is at version",
+}
+
+// chaincodeInvalidMessages are fragments of the errors the chaincode, or the contract API
+// calling it, reports for arguments it cannot accept
+var chaincodeInvalidMessages = []string{
+	"must not be empty",
+	"must be passed in the transient data",
+	"failed to parse",
+	"Incorrect number of params",
+	"Error managing parameter",
+	"was not passed in expected format",
+	"Cannot convert passed value",
+	"not found in contract",
+}
+
+// chaincodeErrorMessage returns the message of the error the chaincode returned, as reported by
+// the first peer to report one, or the whole text of the gateway error if no peer did
+func chaincodeErrorMessage(err error) string {
+	text := gatewayErrorText(err)
+	if match := chaincodeResponsePattern.FindStringSubmatch(text); match != nil {
+		// Peers reporting the same error are joined with "; ", so keep only the first message
+		message, _, _ := strings.Cut(match[1], "; ")
+		return message
+	}
+	return text
+}
+
+// classifyChaincodeError reports whether the chaincode failed because a record conflicts with
+// the write, does not exist, is soft deleted, or because the arguments were invalid, returning
+// "" for other errors
+func classifyChaincodeError(err error) string {
+	message := chaincodeErrorMessage(err)
+	for _, fragment := range chaincodeConflictMessages {
+		if strings.Contains(message, fragment) {
+			return failureConflict
+		}
+	}
+	if strings.Contains(message, "does not exist") {
+		return failureNotFound
+	}
+	if strings.Contains(message, "is deleted and must be restored") {
+		return failureGone
+	}
+	for _, fragment := range chaincodeInvalidMessages {
+		if strings.Contains(message, fragment) {
+			return failureInvalidArgument
+		}
+	}
+	return ""
+}
+
+// isMVCCConflict reports whether a transaction was invalidated because a key it read was
+// changed by another transaction committed first
+func isMVCCConflict(err error) bool {
+	var commitFailedErr *commitFailedError
+	if errors.As(err, &commitFailedErr) {
+		return commitFailedErr.Code == peer.TxValidationCode_MVCC_READ_CONFLICT || commitFailedErr.Code == peer.TxValidationCode_PHANTOM_READ_CONFLICT
+	}
+	var commitErr *client.CommitError
+	if errors.As(err, &commitErr) {
+		return commitErr.Code == peer.TxValidationCode_MVCC_READ_CONFLICT || commitErr.Code == peer.TxValidationCode_PHANTOM_READ_CONFLICT
+	}
+	return strings.Contains(gatewayErrorText(err), peer.TxValidationCode_MVCC_READ_CONFLICT.String())
+}
+
+// isTimeout reports whether a transaction failed because the gateway, or the wait for its
+// commit status, ran out of time
+func isTimeout(err error) bool {
+	return errors.Is(err, context.DeadlineExceeded) || status.Code(err) == codes.DeadlineExceeded
+}
+
+// transactionFailure maps a failed transaction to the HTTP status and failure it is reported with
+func transactionFailure(err error) (int, string) {
+	var circuitErr *circuitOpenError
+	if errors.As(err, &circuitErr) {
+		return http.StatusServiceUnavailable, failurePeerDown
+	}
+
+	switch failure := classifyGatewayFailure(err); {
+	case failure == failureChaincodeNotFound:
+		return http.StatusBadGateway, failure
+	case failure == failureEndorsementPolicy:
+		return http.StatusForbidden, failure
+	case isMVCCConflict(err):
+		return http.StatusConflict, failureMVCCConflict
+	case isTimeout(err):
+		return http.StatusGatewayTimeout, failureTimeout
+	}
+
+	switch failure := classifyChaincodeError(err); failure {
+	case failureConflict:
+		return http.StatusConflict, failure
+	case failureNotFound:
+		return http.StatusNotFound, failure
+	case failureGone:
+		return http.StatusGone, failure
+	case failureInvalidArgument:
+		return http.StatusUnprocessableEntity, failure
+	default:
+		return http.StatusInternalServerError, failureTransaction
+	}
+}
+
+// gatewayErrorDetails returns the error reported by each peer in a gateway error's details
+func gatewayErrorDetails(err error) []gin.H {
+	var details []gin.H
+	for _, detail := range status.Convert(err).Details() {
+		if detail, ok := detail.(*gateway.ErrorDetail); ok {
+			details = append(details, gin.H{"address": detail.Address, "mspId": detail.MspId, "message": detail.Message})
+		}
+	}
+	return details
+}
+
+// writeTransactionError writes the response for a failed transaction, with a status code chosen
+// by the stage and cause of the failure, the same way studentrecords_client.go tells endorse,
+// submit, commit status, and commit errors apart. A chaincode that cannot be found is a
+// deployment problem upstream of the server and gets 502, an unsatisfied endorsement policy 403,
+// an MVCC read conflict 409, and a timeout 504. A record the chaincode reports missing gets 404,
+// a write the chaincode refuses because of a record's state 409, an update to a soft deleted
+// record 410, and arguments it rejects 422. A
+// peer whose circuit breaker is open gets 503 with Retry-After.
+// The body names the failure and carries the error reported by each peer, when there are any.
+func writeTransactionError(c *gin.Context, action string, err error) {
+	c.JSON(transactionErrorResponse(c, action, err))
+}
+
+// transactionErrorResponse returns the status code and body writeTransactionError writes for a
+// failed transaction, setting Retry-After if the peer is unavailable
+func transactionErrorResponse(c *gin.Context, action string, err error) (int, gin.H) {
+	code, failure := transactionFailure(err)
+	c.Set(failureKey, failure)
+
+	var message string
+	switch failure {
+	case failureChaincodeNotFound:
+		message = fmt.Sprintf("Chaincode %s is not committed on channel %s or not installed on the peer", chaincodeFrom(c.Request.Context()), channelFrom(c.Request.Context()))
+	case failureEndorsementPolicy:
+		message = "The transaction was not endorsed by enough organizations to satisfy the endorsement policy"
+	case failureMVCCConflict:
+		message = fmt.Sprintf("Failed to %s: a concurrent transaction changed the same records first; retry the request", action)
+	case failureTimeout:
+		message = fmt.Sprintf("Timed out trying to %s; a submitted transaction may still commit", action)
+	case failureNotFound:
+		message = fmt.Sprintf("Failed to %s: the record does not exist", action)
+	case failureConflict, failureGone, failureInvalidArgument:
+		message = fmt.Sprintf("Failed to %s: %s", action, chaincodeErrorMessage(err))
+	case failurePeerDown:
+		var circuitErr *circuitOpenError
+		errors.As(err, &circuitErr)
+		c.Header("Retry-After", strconv.Itoa(int(math.Ceil(circuitErr.RetryAfter.Seconds()))))
+		message = fmt.Sprintf("Failed to %s: the peer is unavailable; retry later", action)
+	default:
+		message = fmt.Sprintf("Failed to %s", action)
+	}
+
+	body := gin.H{"error": failure, "message": message, "detail": err.Error()}
+	if details := gatewayErrorDetails(err); len(details) > 0 {
+		body["details"] = details
+	}
+	return code, body
+}
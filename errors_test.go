@@ -0,0 +1,266 @@
+/*
+Copyright 2021 IBM All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/hyperledger/fabric-protos-go-apiv2/gateway"
+	"github.com/hyperledger/fabric-protos-go-apiv2/peer"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// endorseFailure returns the error the gateway reports when every endorsing peer ran the
+// chaincode and it returned message, each peer's message carried in the error details
+func endorseFailure(message string) error {
+	st, err := status.New(codes.Aborted, "failed to endorse transaction, see attached details for more info").WithDetails(
+		&gateway.ErrorDetail{Address: "peer0.org1.example.com:7051", MspId: "Org1MSP", Message: "chaincode response 500, " + message},
+		&gateway.ErrorDetail{Address: "peer0.org2.example.com:9051", MspId: "Org2MSP", Message: "chaincode response 500, " + message},
+	)
+	if err != nil {
+		panic(err)
+	}
+	return st.Err()
+}
+
+func TestStudentErrorResponses(t *testing.T) {
+	tests := []struct {
+		name     string
+		method   string
+		target   string
+		body     string
+		headers  []string
+		err      error
+		want     int
+		wantBody string
+	}{
+		{
+			name:     "create existing",
+			method:   http.MethodPost,
+			target:   "/api/students",
+			body:     `{"id":"S1","name":"Alice"}`,
+			err:      endorseFailure("the student S1 already exists"),
+			want:     http.StatusConflict,
+			wantBody: `{"error":"conflict","id":"S1","message":"student already exists","requestId":"req-1"}`,
+		},
+		{
+			name:     "read missing",
+			method:   http.MethodGet,
+			target:   "/api/students/S9",
+			err:      errors.New("chaincode response 500, the student S9 does not exist"),
+			want:     http.StatusNotFound,
+			wantBody: `{"error":"not_found","id":"S9","message":"student does not exist","requestId":"req-1"}`,
+		},
+		{
+			name:     "delete missing",
+			method:   http.MethodDelete,
+			target:   "/api/students/S9",
+			err:      endorseFailure("the student S9 does not exist"),
+			want:     http.StatusNotFound,
+			wantBody: `{"error":"not_found","id":"S9","message":"student does not exist","requestId":"req-1"}`,
+		},
+		{
+			name:     "update deleted",
+			method:   http.MethodPut,
+			target:   "/api/students/S1",
+			body:     `{"name":"Alice"}`,
+			headers:  []string{"If-Match", "*"},
+			err:      endorseFailure("the student S1 is deleted and must be restored before it is updated"),
+			want:     http.StatusGone,
+			wantBody: `{"error":"gone","id":"S1","message":"student is deleted and must be restored before it is updated","requestId":"req-1"}`,
+		},
+		{
+			name:     "delete already deleted",
+			method:   http.MethodDelete,
+			target:   "/api/students/S1",
+			err:      endorseFailure("the student S1 is already deleted"),
+			want:     http.StatusConflict,
+			wantBody: `{"error":"conflict","id":"S1","message":"student is already deleted","requestId":"req-1"}`,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ledger := &fakeLedger{respond: func(string, []string) ([]byte, error) {
+				return nil, test.err
+			}}
+			router := newTestRouter(t, ledger, nil)
+
+			response := serveRequest(router, test.method, test.target, test.body, append([]string{requestIDHeader, "req-1"}, test.headers...)...)
+			if response.Code != test.want {
+				t.Fatalf("status = %d, want %d, body %s", response.Code, test.want, response.Body)
+			}
+			if got := response.Body.String(); got != test.wantBody {
+				t.Errorf("body = %s, want %s", got, test.wantBody)
+			}
+		})
+	}
+}
+
+// gatewayFailure returns an error the gateway reports with the given code when each peer
+// fails with peerMessage
+func gatewayFailure(code codes.Code, message, peerMessage string) error {
+	st, err := status.New(code, message).WithDetails(
+		&gateway.ErrorDetail{Address: "peer0.org1.example.com:7051", MspId: "Org1MSP", Message: peerMessage},
+	)
+	if err != nil {
+		panic(err)
+	}
+	return st.Err()
+}
+
+func TestGatewayFailureResponses(t *testing.T) {
+	notFound := fmt.Sprintf("Chaincode %s is not committed on channel %s or not installed on the peer", chaincodeName, channelName)
+	policy := "The transaction was not endorsed by enough organizations to satisfy the endorsement policy"
+
+	tests := []struct {
+		name        string
+		err         error
+		want        int
+		wantFailure string
+		wantMessage string
+		wantDetails int
+	}{
+		{
+			name:        "chaincode not committed",
+			err:         gatewayFailure(codes.Unavailable, "failed to endorse transaction, see attached details for more info", "make sure the chaincode studentrecords has been successfully defined on channel mychannel and try again: chaincode definition for 'studentrecords' not found"),
+			want:        http.StatusBadGateway,
+			wantFailure: failureChaincodeNotFound,
+			wantMessage: notFound,
+			wantDetails: 1,
+		},
+		{
+			name:        "chaincode not installed",
+			err:         gatewayFailure(codes.Aborted, "failed to endorse transaction, see attached details for more info", "chaincode studentrecords:1.0 is not installed on this peer"),
+			want:        http.StatusBadGateway,
+			wantFailure: failureChaincodeNotFound,
+			wantMessage: notFound,
+			wantDetails: 1,
+		},
+		{
+			name:        "endorsement policy not satisfied",
+			err:         gatewayFailure(codes.FailedPrecondition, "no combination of peers can be derived which satisfy the endorsement policy", "implicit policy evaluation failed - 1 sub-policies were satisfied, but this policy requires 2"),
+			want:        http.StatusForbidden,
+			wantFailure: failureEndorsementPolicy,
+			wantMessage: policy,
+			wantDetails: 1,
+		},
+		{
+			name:        "commit invalidated by the endorsement policy",
+			err:         &commitFailedError{TransactionID: "tx1", Code: peer.TxValidationCode_ENDORSEMENT_POLICY_FAILURE},
+			want:        http.StatusForbidden,
+			wantFailure: failureEndorsementPolicy,
+			wantMessage: policy,
+		},
+		{
+			name:        "other gateway failure",
+			err:         gatewayFailure(codes.Internal, "failed to endorse transaction", "peer ran out of disk"),
+			want:        http.StatusInternalServerError,
+			wantFailure: failureTransaction,
+			wantMessage: "Failed to create student",
+			wantDetails: 1,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ledger := &fakeLedger{respond: func(string, []string) ([]byte, error) {
+				return nil, test.err
+			}}
+			router := newTestRouter(t, ledger, nil)
+
+			response := serveRequest(router, http.MethodPost, "/api/students", `{"id":"S1","name":"Alice"}`)
+			if response.Code != test.want {
+				t.Fatalf("status = %d, want %d, body %s", response.Code, test.want, response.Body)
+			}
+			var body struct {
+				Error   string        `json:"error"`
+				Message string        `json:"message"`
+				Detail  string        `json:"detail"`
+				Details []interface{} `json:"details"`
+			}
+			if err := json.Unmarshal(response.Body.Bytes(), &body); err != nil {
+				t.Fatalf("parsing response %s: %v", response.Body, err)
+			}
+			if body.Error != test.wantFailure || body.Message != test.wantMessage {
+				t.Errorf("error = %q, message = %q, want %q and %q", body.Error, body.Message, test.wantFailure, test.wantMessage)
+			}
+			if body.Detail != test.err.Error() || len(body.Details) != test.wantDetails {
+				t.Errorf("detail = %q with %d peer details, want %q with %d", body.Detail, len(body.Details), test.err.Error(), test.wantDetails)
+			}
+		})
+	}
+}
+
+func TestClassifyChaincodeError(t *testing.T) {
+	tests := []struct {
+		message     string
+		want        int
+		wantFailure string
+	}{
+		{message: "the student S1 already exists", want: http.StatusConflict, wantFailure: failureConflict},
+		{message: "the student S1 appears more than once in the batch", want: http.StatusConflict, wantFailure: failureConflict},
+		{message: "the student S1 is already deleted", want: http.StatusConflict, wantFailure: failureConflict},
+		{message: "the student S1 is not deleted", want: http.StatusConflict, wantFailure: failureConflict},
+		{message: "the student S1 is at version 4, not version 3", want: http.StatusConflict, wantFailure: failureConflict},
+		{message: "the student S9 does not exist", want: http.StatusNotFound, wantFailure: failureNotFound},
+		{message: "the student S1 is deleted and must be restored before it is updated", want: http.StatusGone, wantFailure: failureGone},
+		{message: "the student S9 does not exist in the private data collection", want: http.StatusNotFound, wantFailure: failureNotFound},
+		{message: "student ID must not be empty", want: http.StatusUnprocessableEntity, wantFailure: failureInvalidArgument},
+		{message: "the label key must not be empty", want: http.StatusUnprocessableEntity, wantFailure: failureInvalidArgument},
+		{message: `the student must be passed in the transient data under the "student" key`, want: http.StatusUnprocessableEntity, wantFailure: failureInvalidArgument},
+		{message: "failed to parse students: unexpected end of JSON input", want: http.StatusUnprocessableEntity, wantFailure: failureInvalidArgument},
+		{message: "Incorrect number of params. Expected 5, received 6", want: http.StatusUnprocessableEntity, wantFailure: failureInvalidArgument},
+		{message: "Function RemoveStudent not found in contract SmartContract", want: http.StatusUnprocessableEntity, wantFailure: failureInvalidArgument},
+		{message: "failed to put to world state: state database unavailable", want: http.StatusInternalServerError, wantFailure: failureTransaction},
+	}
+
+	// The forms in which the gateway reports an error the chaincode returned
+	forms := []struct {
+		name string
+		wrap func(message string) error
+	}{
+		{name: "endorse", wrap: endorseFailure},
+		{name: "evaluate", wrap: func(message string) error {
+			return status.Error(codes.Unknown, "evaluate call to endorser returned error: chaincode response 500, "+message)
+		}},
+		{name: "plain", wrap: func(message string) error {
+			return errors.New("chaincode response 500, " + message)
+		}},
+	}
+
+	for _, test := range tests {
+		for _, form := range forms {
+			t.Run(form.name+" "+test.message, func(t *testing.T) {
+				err := form.wrap(test.message)
+				if code, failure := transactionFailure(err); code != test.want || failure != test.wantFailure {
+					t.Errorf("mapped to %d %s, want %d %s", code, failure, test.want, test.wantFailure)
+				}
+				if message := chaincodeErrorMessage(err); message != test.message {
+					t.Errorf("chaincode message = %q, want %q", message, test.message)
+				}
+			})
+		}
+	}
+}
+
+func TestClassifyChaincodeErrorIgnoresOtherErrors(t *testing.T) {
+	for _, err := range []error{
+		errors.New("failed to submit transaction: orderer unreachable"),
+		status.Error(codes.Unavailable, "connection refused"),
+		status.Error(codes.Internal, "peer ran out of disk"),
+	} {
+		if failure := classifyChaincodeError(err); failure != "" {
+			t.Errorf("%v classified as %s, want no chaincode failure", err, failure)
+		}
+	}
+}
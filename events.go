@@ -0,0 +1,376 @@
+/*
+Copyright 2021 IBM All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+	"github.com/hyperledger/fabric-gateway/pkg/client"
+)
+
+const (
+	// eventBufferSize is the number of events queued for a client before it is disconnected as too slow
+	eventBufferSize = 64
+
+	// eventsReconnectDelay is how long to wait before reopening the event stream after it fails
+	eventsReconnectDelay = 5 * time.Second
+
+	// Timeouts for WebSocket clients, which are pinged so dead connections are noticed
+	wsWriteTimeout = 10 * time.Second
+	wsPongTimeout  = 60 * time.Second
+	wsPingInterval = wsPongTimeout * 9 / 10
+)
+
+// errEventHubClosed is returned when subscribing to the event hub after shutdown has begun
+var errEventHubClosed = errors.New("event hub is shutting down")
+
+// chaincodeEventMessage is a chaincode event as sent to clients
+type chaincodeEventMessage struct {
+	EventName     string          `json:"eventName"`
+	BlockNumber   uint64          `json:"blockNumber"`
+	TransactionID string          `json:"transactionId"`
+	Chaincode     string          `json:"chaincode"`
+	Payload       json.RawMessage `json:"payload,omitempty"`
+}
+
+// newChaincodeEventMessage converts a chaincode event for clients. A payload that is not
+// JSON is sent as a JSON string.
+func newChaincodeEventMessage(event *client.ChaincodeEvent) chaincodeEventMessage {
+	return chaincodeEventMessage{
+		EventName:     event.EventName,
+		BlockNumber:   event.BlockNumber,
+		TransactionID: event.TransactionID,
+		Chaincode:     event.ChaincodeName,
+		Payload:       jsonOrString(event.Payload),
+	}
+}
+
+// jsonOrString returns data as JSON if it is valid JSON, and otherwise as a JSON string
+func jsonOrString(data []byte) json.RawMessage {
+	if len(data) > 0 && !json.Valid(data) {
+		quoted, _ := json.Marshal(string(data))
+		return quoted
+	}
+	return json.RawMessage(data)
+}
+
+// eventSubscriber is one client's share of the event stream
+type eventSubscriber struct {
+	// names are the event names the client wants, or nil for every event
+	names map[string]bool
+
+	events chan chaincodeEventMessage
+
+	// done is closed when the hub drops the subscriber, after setting why in closeCode and closeText
+	done      chan struct{}
+	closeCode int
+	closeText string
+}
+
+// newEventSubscriber creates a subscriber to the named events, or to every event if none are named
+func newEventSubscriber(names []string) *eventSubscriber {
+	sub := &eventSubscriber{events: make(chan chaincodeEventMessage, eventBufferSize), done: make(chan struct{})}
+	if len(names) > 0 {
+		sub.names = make(map[string]bool, len(names))
+		for _, name := range names {
+			sub.names[name] = true
+		}
+	}
+	return sub
+}
+
+// wants reports whether the subscriber asked for events with the given name
+func (s *eventSubscriber) wants(name string) bool {
+	return s.names == nil || s.names[name]
+}
+
+// eventHub reads the chaincode's events from the gateway once and fans them out to every
+// subscriber. The gateway stream is open only while there are subscribers.
+type eventHub struct {
+	// subscribe opens the gateway's stream of chaincode events, resuming from the checkpoint
+	subscribe func(ctx context.Context, checkpoint client.Checkpoint) (<-chan *client.ChaincodeEvent, error)
+
+	mu          sync.Mutex
+	subscribers map[*eventSubscriber]bool
+	cancel      context.CancelFunc // stops the gateway stream; nil while there are no subscribers
+	closed      bool
+
+	// checkpoint records the last event delivered across gateway streams and restarts. Without
+	// one, each stream opened for new subscribers starts at the next block to be committed.
+	checkpoint *client.FileCheckpointer
+}
+
+// chaincodeEvents is the hub streaming the configured chaincode's events to WebSocket clients
+var chaincodeEvents = newEventHub(gatewayChaincodeEvents)
+
+// newEventHub creates a hub that reads events with the given subscribe function
+func newEventHub(subscribe func(ctx context.Context, checkpoint client.Checkpoint) (<-chan *client.ChaincodeEvent, error)) *eventHub {
+	return &eventHub{subscribe: subscribe, subscribers: make(map[*eventSubscriber]bool)}
+}
+
+// useCheckpointFile makes the hub record the last event delivered in the named file and resume
+// after it, so events committed while the server was down or had no subscribers are not lost.
+// If startBlock is set, events are replayed from that block instead.
+func (h *eventHub) useCheckpointFile(path string, startBlock *uint64) error {
+	checkpoint, err := client.NewFileCheckpointer(path)
+	if err != nil {
+		return fmt.Errorf("failed to open event checkpoint file: %w", err)
+	}
+
+	if startBlock != nil {
+		// A checkpoint at block 0 means no checkpoint at all, but the genesis block holds no
+		// chaincode events, so replaying from block 1 is the same as replaying from block 0
+		if err := checkpoint.CheckpointTransaction(max(*startBlock, 1), ""); err != nil {
+			checkpoint.Close()
+			return fmt.Errorf("failed to save event checkpoint: %w", err)
+		}
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.checkpoint = checkpoint
+
+	slog.Info("Chaincode events resume from checkpoint", "file", path, "blockNumber", checkpoint.BlockNumber(), "transactionId", checkpoint.TransactionID())
+	return nil
+}
+
+// gatewayChaincodeEvents opens a stream of the configured chaincode's events through the default
+// organization's gateway. Events are visible to every member of the channel, so any organization will do.
+func gatewayChaincodeEvents(ctx context.Context, checkpoint client.Checkpoint) (<-chan *client.ChaincodeEvent, error) {
+	fc, err := orgs.connection(orgs.defaultOrg)
+	if err != nil {
+		return nil, err
+	}
+	return fc.currentNetwork(channelName).ChaincodeEvents(ctx, chaincodeName, client.WithCheckpoint(checkpoint))
+}
+
+// add subscribes to the hub, opening the gateway stream for the first subscriber
+func (h *eventHub) add(sub *eventSubscriber) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.closed {
+		return errEventHubClosed
+	}
+
+	h.subscribers[sub] = true
+	eventStreamClients.WithLabelValues("chaincode_events").Inc()
+
+	if h.cancel == nil {
+		ctx, cancel := context.WithCancel(context.Background())
+		h.cancel = cancel
+		go h.run(ctx)
+	}
+	return nil
+}
+
+// remove unsubscribes from the hub, closing the gateway stream after the last subscriber
+func (h *eventHub) remove(sub *eventSubscriber) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.subscribers[sub] {
+		h.removeLocked(sub)
+	}
+}
+
+// removeLocked unsubscribes a subscriber. The caller must hold h.mu.
+func (h *eventHub) removeLocked(sub *eventSubscriber) {
+	delete(h.subscribers, sub)
+	eventStreamClients.WithLabelValues("chaincode_events").Dec()
+
+	if len(h.subscribers) == 0 && h.cancel != nil {
+		h.cancel()
+		h.cancel = nil
+	}
+}
+
+// drop unsubscribes a subscriber and tells it why. The caller must hold h.mu.
+func (h *eventHub) drop(sub *eventSubscriber, code int, text string) {
+	sub.closeCode, sub.closeText = code, text
+	close(sub.done)
+	h.removeLocked(sub)
+}
+
+// shutdown disconnects every subscriber and refuses new ones
+func (h *eventHub) shutdown() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.closed = true
+	for sub := range h.subscribers {
+		h.drop(sub, websocket.CloseGoingAway, "server is shutting down")
+	}
+
+	if h.checkpoint != nil {
+		h.checkpoint.Close()
+	}
+}
+
+// run reads events from the gateway until ctx is cancelled, reopening the stream from the last
+// event delivered whenever it fails, so subscribers see each event once
+func (h *eventHub) run(ctx context.Context) {
+	h.mu.Lock()
+	var checkpoint client.Checkpoint = h.checkpoint
+	h.mu.Unlock()
+
+	// Without a checkpoint file, the stream's position is only kept while it runs
+	var memory *client.InMemoryCheckpointer
+	if checkpoint == nil {
+		memory = &client.InMemoryCheckpointer{}
+		checkpoint = memory
+	}
+
+	for {
+		events, err := h.subscribe(ctx, checkpoint)
+		if err != nil {
+			slog.Warn("Failed to open chaincode event stream", "chaincode", chaincodeName, "error", err)
+		} else {
+			for event := range events {
+				h.publish(ctx, event)
+				if memory != nil {
+					memory.CheckpointChaincodeEvent(event)
+				}
+			}
+		}
+
+		if ctx.Err() != nil {
+			return
+		}
+		slog.Warn("Chaincode event stream ended, reconnecting", "chaincode", chaincodeName, "delay", eventsReconnectDelay.String(), "blockNumber", checkpoint.BlockNumber())
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(eventsReconnectDelay):
+		}
+	}
+}
+
+// publish queues an event for every subscriber that wants it, dropping subscribers whose queue
+// is full, and records it in the checkpoint file if there is one
+func (h *eventHub) publish(ctx context.Context, event *client.ChaincodeEvent) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	// The stream may have been stopped, and another started for new subscribers, since the event was read
+	if ctx.Err() != nil {
+		return
+	}
+
+	if h.checkpoint != nil {
+		if err := h.checkpoint.CheckpointChaincodeEvent(event); err != nil {
+			slog.Error("Failed to save event checkpoint", "blockNumber", event.BlockNumber, "transactionId", event.TransactionID, "error", err)
+		}
+	}
+
+	message := newChaincodeEventMessage(event)
+
+	for sub := range h.subscribers {
+		if !sub.wants(message.EventName) {
+			continue
+		}
+		select {
+		case sub.events <- message:
+		default:
+			slog.Warn("Disconnecting slow event stream client", "queued", eventBufferSize)
+			h.drop(sub, websocket.CloseTryAgainLater, "client is not keeping up with events")
+		}
+	}
+}
+
+// eventsUpgrader upgrades event stream requests to WebSocket connections
+var eventsUpgrader = websocket.Upgrader{CheckOrigin: allowedWebSocketOrigin}
+
+// allowedWebSocketOrigin accepts WebSocket handshakes from clients that are not browsers, from
+// pages served by this server, and from the origins allowed to make cross-origin requests
+func allowedWebSocketOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	if u, err := url.Parse(origin); err == nil && u.Host == r.Host {
+		return true
+	}
+	for _, allowed := range cfg.CORSOrigins {
+		if allowed == "*" || allowed == origin {
+			return true
+		}
+	}
+	return false
+}
+
+// streamChaincodeEvents upgrades the request to a WebSocket and sends the chaincode's events as
+// JSON messages as they are committed, optionally only those named in ?events=student.created,...
+func streamChaincodeEvents(c *gin.Context) {
+	conn, err := eventsUpgrader.Upgrade(c.Writer, c.Request, nil)
+	if err != nil {
+		// The upgrader has already replied with an error status
+		requestLogger(c).Warn("WebSocket upgrade failed", "error", err)
+		return
+	}
+	defer conn.Close()
+
+	logger := requestLogger(c)
+	sub := newEventSubscriber(splitList(c.Query("events")))
+	if err := chaincodeEvents.add(sub); err != nil {
+		conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseGoingAway, "server is shutting down"), time.Now().Add(wsWriteTimeout))
+		return
+	}
+	defer chaincodeEvents.remove(sub)
+
+	logger.Info("Event stream client connected", "events", c.Query("events"))
+
+	// Clients only send control frames; reading handles pongs and notices when the client goes away
+	closed := make(chan struct{})
+	go func() {
+		defer close(closed)
+		conn.SetReadLimit(512)
+		conn.SetReadDeadline(time.Now().Add(wsPongTimeout))
+		conn.SetPongHandler(func(string) error { return conn.SetReadDeadline(time.Now().Add(wsPongTimeout)) })
+		for {
+			if _, _, err := conn.NextReader(); err != nil {
+				return
+			}
+		}
+	}()
+
+	ping := time.NewTicker(wsPingInterval)
+	defer ping.Stop()
+
+	for {
+		select {
+		case message := <-sub.events:
+			conn.SetWriteDeadline(time.Now().Add(wsWriteTimeout))
+			if err := conn.WriteJSON(message); err != nil {
+				logger.Info("Event stream client disconnected", "error", err)
+				return
+			}
+		case <-ping.C:
+			if err := conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(wsWriteTimeout)); err != nil {
+				logger.Info("Event stream client disconnected", "error", err)
+				return
+			}
+		case <-sub.done:
+			conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(sub.closeCode, sub.closeText), time.Now().Add(wsWriteTimeout))
+			return
+		case <-closed:
+			logger.Info("Event stream client disconnected")
+			return
+		}
+	}
+}
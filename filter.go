@@ -0,0 +1,227 @@
+/*
+Copyright 2021 IBM All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync/atomic"
+
+	"github.com/gin-gonic/gin"
+)
+
+// sortableFields are the student record fields the list can be sorted by
+var sortableFields = map[string]bool{"id": true, "name": true, "branch": true, "cgpa": true, "updatedAt": true}
+
+// richQueriesUnsupported is set once the peer reports that its state database cannot run rich
+// queries, so later filtered lists go straight to filtering every student on the server
+var richQueriesUnsupported atomic.Bool
+
+// studentFilter is the filtering and sorting requested by the query parameters of the student list,
+// such as ?branch=CSE&min_cgpa=8.0&sort=cgpa:desc
+type studentFilter struct {
+	branch  string
+	name    string
+	minCGPA *float64
+	maxCGPA *float64
+
+	// includeDeleted lists soft deleted students along with the active ones
+	includeDeleted bool
+
+	sortField string
+	sortDesc  bool
+}
+
+// parseStudentFilter reads the filter and sort query parameters of a request
+func parseStudentFilter(c *gin.Context) (studentFilter, error) {
+	filter := studentFilter{branch: c.Query("branch"), name: c.Query("name")}
+
+	var err error
+	if filter.includeDeleted, err = parseIncludeDeleted(c); err != nil {
+		return filter, err
+	}
+	if filter.minCGPA, err = parseCGPABound(c, "min_cgpa"); err != nil {
+		return filter, err
+	}
+	if filter.maxCGPA, err = parseCGPABound(c, "max_cgpa"); err != nil {
+		return filter, err
+	}
+
+	if value := c.Query("sort"); value != "" {
+		field, direction, _ := strings.Cut(value, ":")
+		if !sortableFields[field] {
+			return filter, fmt.Errorf("cannot sort by %q, expected id, name, branch, cgpa, or updatedAt", field)
+		}
+		switch direction {
+		case "", "asc":
+		case "desc":
+			filter.sortDesc = true
+		default:
+			return filter, fmt.Errorf("unknown sort direction %q, expected asc or desc", direction)
+		}
+		filter.sortField = field
+	}
+
+	return filter, nil
+}
+
+// parseCGPABound parses a CGPA bound query parameter, returning nil if it is not set
+func parseCGPABound(c *gin.Context, name string) (*float64, error) {
+	value := c.Query(name)
+	if value == "" {
+		return nil, nil
+	}
+	bound, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		return nil, fmt.Errorf("%s must be a number, got %q", name, value)
+	}
+	return &bound, nil
+}
+
+// parseIncludeDeleted reads the include_deleted query parameter of the student lists, which
+// lists soft deleted students along with the active ones
+func parseIncludeDeleted(c *gin.Context) (bool, error) {
+	value := c.Query("include_deleted")
+	if value == "" {
+		return false, nil
+	}
+	include, err := strconv.ParseBool(value)
+	if err != nil {
+		return false, fmt.Errorf("include_deleted must be true or false, got %q", value)
+	}
+	return include, nil
+}
+
+// active reports whether the request filters or sorts the students at all. Leaving out soft
+// deleted students does not count, since it can be done as they are streamed.
+func (f studentFilter) active() bool {
+	return f.selective() || f.minCGPA != nil || f.maxCGPA != nil || f.sortField != ""
+}
+
+// selective reports whether the request filters on fields CouchDB can select by
+func (f studentFilter) selective() bool {
+	return f.branch != "" || f.name != ""
+}
+
+// richQuery translates the equality filters into a CouchDB rich query. CGPAs are stored as
+// strings, which CouchDB compares as text, so the CGPA bounds are applied by matches instead.
+func (f studentFilter) richQuery() (string, error) {
+	selector := map[string]string{}
+	if f.branch != "" {
+		selector["branch"] = f.branch
+	}
+	if f.name != "" {
+		selector["name"] = f.name
+	}
+
+	query, err := json.Marshal(map[string]interface{}{"selector": selector})
+	return string(query), err
+}
+
+// matches reports whether a student record passes every filter
+func (f studentFilter) matches(student map[string]interface{}) bool {
+	if !f.includeDeleted && isDeleted(student) {
+		return false
+	}
+	if f.branch != "" && stringField(student, "branch") != f.branch {
+		return false
+	}
+	if f.name != "" && stringField(student, "name") != f.name {
+		return false
+	}
+	if f.minCGPA == nil && f.maxCGPA == nil {
+		return true
+	}
+
+	// Students without a public numeric CGPA cannot satisfy a bound
+	cgpa, err := strconv.ParseFloat(stringField(student, "cgpa"), 64)
+	if err != nil {
+		return false
+	}
+	return (f.minCGPA == nil || cgpa >= *f.minCGPA) && (f.maxCGPA == nil || cgpa <= *f.maxCGPA)
+}
+
+// apply returns the students that pass the filters, in the requested order
+func (f studentFilter) apply(students []map[string]interface{}) []map[string]interface{} {
+	selected := make([]map[string]interface{}, 0, len(students))
+	for _, student := range students {
+		if f.matches(student) {
+			selected = append(selected, student)
+		}
+	}
+
+	if f.sortField != "" {
+		sort.SliceStable(selected, func(i, j int) bool { return f.before(selected[i], selected[j]) })
+	}
+	return selected
+}
+
+// before reports whether student a sorts before student b. CGPAs compare as numbers, and
+// students without one come last in either direction.
+func (f studentFilter) before(a, b map[string]interface{}) bool {
+	if f.sortField != "cgpa" {
+		if f.sortDesc {
+			return stringField(a, f.sortField) > stringField(b, f.sortField)
+		}
+		return stringField(a, f.sortField) < stringField(b, f.sortField)
+	}
+
+	cgpaA, errA := strconv.ParseFloat(stringField(a, "cgpa"), 64)
+	cgpaB, errB := strconv.ParseFloat(stringField(b, "cgpa"), 64)
+	switch {
+	case errA != nil || errB != nil:
+		return errA == nil && errB != nil
+	case f.sortDesc:
+		return cgpaA > cgpaB
+	default:
+		return cgpaA < cgpaB
+	}
+}
+
+// isDeleted reports whether a student record has been soft deleted
+func isDeleted(student map[string]interface{}) bool {
+	return stringField(student, "status") == statusInactive
+}
+
+// stringField returns a string field of a student record, or "" if it is missing
+func stringField(student map[string]interface{}, field string) string {
+	value, _ := student[field].(string)
+	return value
+}
+
+// evaluateStudentList evaluates the students a filter needs from the ledger. Filters on fields
+// CouchDB can select by are run as a rich query, so only matching students leave the peer; with
+// LevelDB, or with no such filter, every student is fetched for the server to filter.
+func evaluateStudentList(c *gin.Context, filter studentFilter) ([]byte, error) {
+	ledger := requestLedger(c)
+	ctx := c.Request.Context()
+
+	if filter.selective() && !richQueriesUnsupported.Load() {
+		query, err := filter.richQuery()
+		if err != nil {
+			return nil, err
+		}
+
+		result, err := ledger.EvaluateTransaction(ctx, "QueryStudents", query)
+		if err == nil || !isRichQueryUnsupported(err) {
+			return result, err
+		}
+
+		richQueriesUnsupported.Store(true)
+		requestLogger(c).Info("State database does not support rich queries, filtering students on the server")
+	}
+
+	return ledger.EvaluateTransaction(ctx, "GetAllStudents")
+}
+
+// isRichQueryUnsupported reports whether an error says the peer's state database cannot run rich queries
+func isRichQueryUnsupported(err error) bool {
+	return strings.Contains(strings.ToLower(gatewayErrorText(err)), "not supported for leveldb")
+}
@@ -0,0 +1,196 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/golang/protobuf/ptypes/timestamp"
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+	"github.com/hyperledger/fabric-protos-go/ledger/queryresult"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// fakeStub holds the world state and private data collection the contract's functions run
+// against. As on a peer, a transaction's writes are not visible to its own reads, and are only
+// applied if the function succeeds.
+type fakeStub struct {
+	shim.ChaincodeStubInterface
+
+	state   map[string][]byte
+	private map[string][]byte
+
+	// The writes of the running transaction, a nil value deleting the key
+	writes        map[string][]byte
+	privateWrites map[string][]byte
+
+	transient map[string][]byte
+	events    map[string][]byte
+	txTime    time.Time
+}
+
+func newFakeStub() *fakeStub {
+	return &fakeStub{
+		state:   make(map[string][]byte),
+		private: make(map[string][]byte),
+		txTime:  time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC),
+	}
+}
+
+// transact runs fn as a transaction, applying its writes only if it succeeds
+func (s *fakeStub) transact(fn func(ctx contractapi.TransactionContextInterface) error) error {
+	s.writes = make(map[string][]byte)
+	s.privateWrites = make(map[string][]byte)
+	s.events = make(map[string][]byte)
+
+	ctx := new(contractapi.TransactionContext)
+	ctx.SetStub(s)
+	if err := fn(ctx); err != nil {
+		return err
+	}
+
+	for key, value := range s.writes {
+		if value == nil {
+			delete(s.state, key)
+		} else {
+			s.state[key] = value
+		}
+	}
+	for key, value := range s.privateWrites {
+		s.private[key] = value
+	}
+	return nil
+}
+
+// putStudents writes students to the world state as they are, outside any transaction
+func (s *fakeStub) putStudents(students ...Student) {
+	for _, student := range students {
+		s.state[student.ID], _ = json.Marshal(student)
+	}
+}
+
+// student returns a student from the world state, or nil if there is none
+func (s *fakeStub) student(id string) *Student {
+	studentJSON, ok := s.state[id]
+	if !ok {
+		return nil
+	}
+	var student Student
+	if err := json.Unmarshal(studentJSON, &student); err != nil {
+		panic(err)
+	}
+	return &student
+}
+
+func (s *fakeStub) GetState(key string) ([]byte, error) {
+	return s.state[key], nil
+}
+
+func (s *fakeStub) PutState(key string, value []byte) error {
+	s.writes[key] = value
+	return nil
+}
+
+func (s *fakeStub) DelState(key string) error {
+	s.writes[key] = nil
+	return nil
+}
+
+func (s *fakeStub) GetStateByRange(startKey, endKey string) (shim.StateQueryIteratorInterface, error) {
+	var results []*queryresult.KV
+	for _, key := range s.sortedKeys() {
+		if key >= startKey && (endKey == "" || key < endKey) {
+			results = append(results, &queryresult.KV{Key: key, Value: s.state[key]})
+		}
+	}
+	return &fakeIterator{results: results}, nil
+}
+
+// GetQueryResult runs a rich query whose selector only compares top-level fields for equality
+func (s *fakeStub) GetQueryResult(query string) (shim.StateQueryIteratorInterface, error) {
+	var parsed struct {
+		Selector map[string]interface{} `json:"selector"`
+	}
+	if err := json.Unmarshal([]byte(query), &parsed); err != nil {
+		return nil, fmt.Errorf("invalid query: %v", err)
+	}
+
+	var results []*queryresult.KV
+	for _, key := range s.sortedKeys() {
+		var fields map[string]interface{}
+		if err := json.Unmarshal(s.state[key], &fields); err != nil {
+			return nil, err
+		}
+		matches := true
+		for field, value := range parsed.Selector {
+			if fields[field] != value {
+				matches = false
+			}
+		}
+		if matches {
+			results = append(results, &queryresult.KV{Key: key, Value: s.state[key]})
+		}
+	}
+	return &fakeIterator{results: results}, nil
+}
+
+func (s *fakeStub) GetPrivateData(collection, key string) ([]byte, error) {
+	if collection != privateCollection {
+		return nil, fmt.Errorf("collection %s does not exist", collection)
+	}
+	return s.private[key], nil
+}
+
+func (s *fakeStub) PutPrivateData(collection, key string, value []byte) error {
+	if collection != privateCollection {
+		return fmt.Errorf("collection %s does not exist", collection)
+	}
+	s.privateWrites[key] = value
+	return nil
+}
+
+func (s *fakeStub) GetTransient() (map[string][]byte, error) {
+	return s.transient, nil
+}
+
+func (s *fakeStub) GetTxTimestamp() (*timestamp.Timestamp, error) {
+	return timestamppb.New(s.txTime), nil
+}
+
+func (s *fakeStub) SetEvent(name string, payload []byte) error {
+	s.events[name] = payload
+	return nil
+}
+
+func (s *fakeStub) sortedKeys() []string {
+	keys := make([]string, 0, len(s.state))
+	for key := range s.state {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// fakeIterator iterates over the results of a range or rich query
+type fakeIterator struct {
+	results []*queryresult.KV
+}
+
+func (i *fakeIterator) HasNext() bool {
+	return len(i.results) > 0
+}
+
+func (i *fakeIterator) Next() (*queryresult.KV, error) {
+	if len(i.results) == 0 {
+		return nil, fmt.Errorf("no more results")
+	}
+	next := i.results[0]
+	i.results = i.results[1:]
+	return next, nil
+}
+
+func (i *fakeIterator) Close() error {
+	return nil
+}
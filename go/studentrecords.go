@@ -1,8 +1,16 @@
 package main
 
 import (
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"time"
+
 	"github.com/hyperledger/fabric-contract-api-go/contractapi"
 )
 
@@ -11,7 +19,66 @@ type Student struct {
 	ID     string `json:"id"`
 	Name   string `json:"name"`
 	Branch string `json:"branch"`
-	CGPA   string `json:"cgpa"`
+	// CGPA is only held in the public state by students written before CGPAs were kept in the
+	// private data collection. The next write to such a student moves it there.
+	CGPA string `json:"cgpa,omitempty"`
+	// UpdatedAt is the RFC 3339 timestamp of the transaction that last wrote the student
+	UpdatedAt string `json:"updatedAt,omitempty"`
+	// Labels are free-form key/value tags attached to the student
+	Labels map[string]string `json:"labels,omitempty"`
+	// Status is inactive for a student soft deleted with DeactivateStudent, and absent otherwise
+	Status string `json:"status,omitempty"`
+	// Version counts the writes to the student, starting from 1 when it is created. Students
+	// written before versions were kept are at version 0.
+	Version uint64 `json:"version"`
+}
+
+// statusInactive is the status of a soft deleted student
+const statusInactive = "inactive"
+
+// StudentPrivateDetails holds the sensitive fields of a student, kept in the private data collection
+type StudentPrivateDetails struct {
+	ID      string `json:"id"`
+	CGPA    string `json:"cgpa"`
+	Email   string `json:"email,omitempty"`
+	Phone   string `json:"phone,omitempty"`
+	Address string `json:"address,omitempty"`
+}
+
+// privateCollection is the private data collection holding StudentPrivateDetails, as named in collections_config.json
+const privateCollection = "studentPrivateDetails"
+
+// studentTransientKey is the transient data key under which CreateStudentPrivate expects the student
+const studentTransientKey = "student"
+
+// detailsTransientKey is the transient data key under which CreateStudentPrivateDetails expects the
+// private details, and CreateStudent, CreateStudents, and UpdateStudent accept them
+const detailsTransientKey = "details"
+
+// StudentsTaggedEvent is the payload of the StudentsTagged event
+type StudentsTaggedEvent struct {
+	Query string `json:"query"`
+	Key   string `json:"key"`
+	Value string `json:"value"`
+	Count int    `json:"count"`
+}
+
+// eventStudentCreated is the name of the event emitted when students are created. A
+// transaction can emit only one event, so a batch emits a single event naming every student.
+const eventStudentCreated = "student.created"
+
+// Names of the events emitted when a student is updated, soft deleted, restored, or deleted for good
+const (
+	eventStudentUpdated  = "student.updated"
+	eventStudentDeleted  = "student.deleted"
+	eventStudentRestored = "student.restored"
+	eventStudentPurged   = "student.purged"
+)
+
+// StudentEvent is the payload of the student events. It names the students rather than
+// carrying them, since events are visible to every member of the channel.
+type StudentEvent struct {
+	IDs []string `json:"ids"`
 }
 
 // SmartContract provides functions for managing students
@@ -22,11 +89,22 @@ type SmartContract struct {
 // InitLedger adds initial students
 func (s *SmartContract) InitLedger(ctx contractapi.TransactionContextInterface) error {
 	students := []Student{
-		{ID: "S1", Name: "Alice", Branch: "CSE", CGPA: "9.1"},
-		{ID: "S2", Name: "Bob", Branch: "ECE", CGPA: "8.5"},
+		{ID: "S1", Name: "Alice", Branch: "CSE"},
+		{ID: "S2", Name: "Bob", Branch: "ECE"},
+	}
+	privateDetails := []StudentPrivateDetails{
+		{ID: "S1", CGPA: "9.1"},
+		{ID: "S2", CGPA: "8.5"},
+	}
+
+	updatedAt, err := txTimestamp(ctx)
+	if err != nil {
+		return err
 	}
 
 	for _, student := range students {
+		student.UpdatedAt = updatedAt
+		student.Version = 1
 		studentJSON, err := json.Marshal(student)
 		if err != nil {
 			return err
@@ -36,12 +114,23 @@ func (s *SmartContract) InitLedger(ctx contractapi.TransactionContextInterface)
 			return fmt.Errorf("failed to put to world state: %v", err)
 		}
 	}
+	for i := range privateDetails {
+		if err := putStudentPrivateDetails(ctx, &privateDetails[i]); err != nil {
+			return err
+		}
+	}
 
 	return nil
 }
 
-// CreateStudent adds a new student
-func (s *SmartContract) CreateStudent(ctx contractapi.TransactionContextInterface, id string, name string, branch string, cgpa string) error {
+// CreateStudent adds a new student. Its CGPA and contact details may be passed as JSON in the
+// transient data under the "details" key, and are written only to the private data collection.
+func (s *SmartContract) CreateStudent(ctx contractapi.TransactionContextInterface, id string, name string, branch string) error {
+	private, err := transientPrivateDetails(ctx)
+	if err != nil {
+		return err
+	}
+
 	exists, err := s.StudentExists(ctx, id)
 	if err != nil {
 		return err
@@ -50,11 +139,17 @@ func (s *SmartContract) CreateStudent(ctx contractapi.TransactionContextInterfac
 		return fmt.Errorf("the student %s already exists", id)
 	}
 
+	updatedAt, err := txTimestamp(ctx)
+	if err != nil {
+		return err
+	}
+
 	student := Student{
-		ID:     id,
-		Name:   name,
-		Branch: branch,
-		CGPA:   cgpa,
+		ID:        id,
+		Name:      name,
+		Branch:    branch,
+		UpdatedAt: updatedAt,
+		Version:   1,
 	}
 
 	studentJSON, err := json.Marshal(student)
@@ -62,7 +157,242 @@ func (s *SmartContract) CreateStudent(ctx contractapi.TransactionContextInterfac
 		return err
 	}
 
-	return ctx.GetStub().PutState(id, studentJSON)
+	err = ctx.GetStub().PutState(id, studentJSON)
+	if err != nil {
+		return fmt.Errorf("failed to put to world state: %v", err)
+	}
+
+	if private != nil {
+		private.ID = id
+		if err := putStudentPrivateDetails(ctx, private); err != nil {
+			return err
+		}
+	}
+
+	return setStudentEvent(ctx, eventStudentCreated, id)
+}
+
+// CreateStudents adds a batch of students in a single transaction. If any student
+// already exists, or an ID is repeated in the batch, none of them are added. Their CGPAs and
+// contact details may be passed as a JSON array in the transient data under the "details" key,
+// and are written only to the private data collection; a CGPA given in the public batch is
+// moved there too.
+func (s *SmartContract) CreateStudents(ctx contractapi.TransactionContextInterface, studentsJSON string) error {
+	var students []Student
+	err := json.Unmarshal([]byte(studentsJSON), &students)
+	if err != nil {
+		return fmt.Errorf("failed to parse students: %v", err)
+	}
+
+	transientMap, err := ctx.GetStub().GetTransient()
+	if err != nil {
+		return fmt.Errorf("failed to read transient data: %v", err)
+	}
+	var privateDetails []StudentPrivateDetails
+	if detailsJSON, ok := transientMap[detailsTransientKey]; ok {
+		if err := json.Unmarshal(detailsJSON, &privateDetails); err != nil {
+			return fmt.Errorf("failed to parse transient private details: %v", err)
+		}
+	}
+	private := make(map[string]*StudentPrivateDetails, len(privateDetails))
+	for i := range privateDetails {
+		private[privateDetails[i].ID] = &privateDetails[i]
+	}
+
+	seen := make(map[string]bool, len(students))
+	for _, student := range students {
+		if student.ID == "" {
+			return fmt.Errorf("student ID must not be empty")
+		}
+		// Writes within a transaction are not visible to GetState, so check the batch itself
+		if seen[student.ID] {
+			return fmt.Errorf("the student %s appears more than once in the batch", student.ID)
+		}
+		seen[student.ID] = true
+
+		exists, err := s.StudentExists(ctx, student.ID)
+		if err != nil {
+			return err
+		}
+		if exists {
+			return fmt.Errorf("the student %s already exists", student.ID)
+		}
+	}
+	for id := range private {
+		if !seen[id] {
+			return fmt.Errorf("private details were passed for the student %s, which is not in the batch", id)
+		}
+	}
+
+	updatedAt, err := txTimestamp(ctx)
+	if err != nil {
+		return err
+	}
+
+	for _, student := range students {
+		if details, ok := private[student.ID]; ok && details.CGPA == "" {
+			details.CGPA = student.CGPA
+		} else if !ok && student.CGPA != "" {
+			private[student.ID] = &StudentPrivateDetails{ID: student.ID, CGPA: student.CGPA}
+		}
+		student.CGPA = ""
+		student.UpdatedAt = updatedAt
+		student.Version = 1
+		studentJSON, err := json.Marshal(student)
+		if err != nil {
+			return err
+		}
+		err = ctx.GetStub().PutState(student.ID, studentJSON)
+		if err != nil {
+			return fmt.Errorf("failed to put to world state: %v", err)
+		}
+		if details, ok := private[student.ID]; ok {
+			if err := putStudentPrivateDetails(ctx, details); err != nil {
+				return err
+			}
+		}
+	}
+
+	ids := make([]string, len(students))
+	for i, student := range students {
+		ids[i] = student.ID
+	}
+	return setStudentEvent(ctx, eventStudentCreated, ids...)
+}
+
+// CreateStudentPrivate adds a new student whose sensitive fields are kept out of the public state.
+// The student is passed as JSON in the transient data under the "student" key, so it is not
+// recorded in the transaction. The ID, name, and branch are written to the public state and the
+// CGPA and contact details only to the private data collection.
+func (s *SmartContract) CreateStudentPrivate(ctx contractapi.TransactionContextInterface) error {
+	transientMap, err := ctx.GetStub().GetTransient()
+	if err != nil {
+		return fmt.Errorf("failed to read transient data: %v", err)
+	}
+
+	studentJSON, ok := transientMap[studentTransientKey]
+	if !ok {
+		return fmt.Errorf("the student must be passed in the transient data under the %q key", studentTransientKey)
+	}
+
+	var input Student
+	err = json.Unmarshal(studentJSON, &input)
+	if err != nil {
+		return fmt.Errorf("failed to parse transient student: %v", err)
+	}
+	var private StudentPrivateDetails
+	err = json.Unmarshal(studentJSON, &private)
+	if err != nil {
+		return fmt.Errorf("failed to parse transient student: %v", err)
+	}
+	if input.ID == "" {
+		return fmt.Errorf("the student id must not be empty")
+	}
+
+	exists, err := s.StudentExists(ctx, input.ID)
+	if err != nil {
+		return err
+	}
+	if exists {
+		return fmt.Errorf("the student %s already exists", input.ID)
+	}
+
+	updatedAt, err := txTimestamp(ctx)
+	if err != nil {
+		return err
+	}
+
+	public := Student{
+		ID:        input.ID,
+		Name:      input.Name,
+		Branch:    input.Branch,
+		UpdatedAt: updatedAt,
+		Version:   1,
+	}
+	publicJSON, err := json.Marshal(public)
+	if err != nil {
+		return err
+	}
+	err = ctx.GetStub().PutState(public.ID, publicJSON)
+	if err != nil {
+		return fmt.Errorf("failed to put to world state: %v", err)
+	}
+
+	if err := putStudentPrivateDetails(ctx, &private); err != nil {
+		return err
+	}
+
+	return setStudentEvent(ctx, eventStudentCreated, input.ID)
+}
+
+// CreateStudentPrivateDetails sets the private details of an existing student, replacing any it
+// had. The details are passed as JSON in the transient data under the "details" key, so they
+// are not recorded in the transaction. A CGPA still held in the public state is moved into the
+// private details, unless they give a new one, and removed from the public state.
+func (s *SmartContract) CreateStudentPrivateDetails(ctx contractapi.TransactionContextInterface, id string) error {
+	transientMap, err := ctx.GetStub().GetTransient()
+	if err != nil {
+		return fmt.Errorf("failed to read transient data: %v", err)
+	}
+
+	detailsJSON, ok := transientMap[detailsTransientKey]
+	if !ok {
+		return fmt.Errorf("the private details must be passed in the transient data under the %q key", detailsTransientKey)
+	}
+
+	var private StudentPrivateDetails
+	err = json.Unmarshal(detailsJSON, &private)
+	if err != nil {
+		return fmt.Errorf("failed to parse transient private details: %v", err)
+	}
+	private.ID = id
+
+	student, err := s.ReadStudent(ctx, id)
+	if err != nil {
+		return err
+	}
+
+	if private.CGPA == "" {
+		private.CGPA = student.CGPA
+	}
+	if err := putStudentPrivateDetails(ctx, &private); err != nil {
+		return err
+	}
+
+	if student.CGPA != "" {
+		student.CGPA = ""
+		if err := putStudent(ctx, student); err != nil {
+			return err
+		}
+	}
+
+	return setStudentEvent(ctx, eventStudentUpdated, id)
+}
+
+// ReadStudentPrivateDetails returns the private details of a student. It can only be evaluated
+// on peers of organizations that are members of the private data collection.
+func (s *SmartContract) ReadStudentPrivateDetails(ctx contractapi.TransactionContextInterface, id string) (*StudentPrivateDetails, error) {
+	privateJSON, err := ctx.GetStub().GetPrivateData(privateCollection, id)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read private data: %v", err)
+	}
+	if privateJSON == nil {
+		return nil, fmt.Errorf("the student %s does not exist in the private data collection", id)
+	}
+
+	var private StudentPrivateDetails
+	err = json.Unmarshal(privateJSON, &private)
+	if err != nil {
+		return nil, err
+	}
+
+	return &private, nil
+}
+
+// ReadStudentPrivate returns the private details of a student, as ReadStudentPrivateDetails does.
+// It is kept for clients written against earlier versions of the contract.
+func (s *SmartContract) ReadStudentPrivate(ctx contractapi.TransactionContextInterface, id string) (*StudentPrivateDetails, error) {
+	return s.ReadStudentPrivateDetails(ctx, id)
 }
 
 // ReadStudent returns a student
@@ -110,6 +440,480 @@ func (s *SmartContract) GetAllStudents(ctx contractapi.TransactionContextInterfa
 	return students, nil
 }
 
+// StudentStats summarizes the students, overall and per branch
+type StudentStats struct {
+	Total int `json:"total"`
+	// AverageCGPA is over the students with a numeric public CGPA; it is absent if there are none
+	AverageCGPA *float64       `json:"averageCgpa,omitempty" metadata:",optional"`
+	Branches    []*BranchStats `json:"branches"`
+}
+
+// BranchStats summarizes the students of one branch
+type BranchStats struct {
+	Branch      string   `json:"branch"`
+	Count       int      `json:"count"`
+	AverageCGPA *float64 `json:"averageCgpa,omitempty" metadata:",optional"`
+}
+
+// cgpaSum accumulates CGPAs for an average
+type cgpaSum struct {
+	total float64
+	count int
+}
+
+// add adds a CGPA to the sum if it is a number
+func (s *cgpaSum) add(cgpa string) {
+	if value, err := strconv.ParseFloat(cgpa, 64); err == nil {
+		s.total += value
+		s.count++
+	}
+}
+
+// average returns the average of the CGPAs added, or nil if there were none
+func (s *cgpaSum) average() *float64 {
+	if s.count == 0 {
+		return nil
+	}
+	average := s.total / float64(s.count)
+	return &average
+}
+
+// StudentStats counts the students overall and per branch, in branch order, and averages
+// their CGPAs. Students created with CreateStudentPrivate have no public CGPA, so they are
+// counted but not averaged. Soft deleted students are left out unless includeDeleted is set.
+func (s *SmartContract) StudentStats(ctx contractapi.TransactionContextInterface, includeDeleted bool) (*StudentStats, error) {
+	resultsIterator, err := ctx.GetStub().GetStateByRange("", "")
+	if err != nil {
+		return nil, err
+	}
+	defer resultsIterator.Close()
+
+	stats := &StudentStats{Branches: []*BranchStats{}}
+	var overall cgpaSum
+	branches := make(map[string]*BranchStats)
+	sums := make(map[string]*cgpaSum)
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return nil, err
+		}
+
+		var student Student
+		err = json.Unmarshal(queryResponse.Value, &student)
+		if err != nil {
+			return nil, err
+		}
+		if !includeDeleted && student.Status == statusInactive {
+			continue
+		}
+
+		branch, ok := branches[student.Branch]
+		if !ok {
+			branch = &BranchStats{Branch: student.Branch}
+			branches[student.Branch] = branch
+			sums[student.Branch] = &cgpaSum{}
+			stats.Branches = append(stats.Branches, branch)
+		}
+		branch.Count++
+		sums[student.Branch].add(student.CGPA)
+		overall.add(student.CGPA)
+		stats.Total++
+	}
+
+	for _, branch := range stats.Branches {
+		branch.AverageCGPA = sums[branch.Branch].average()
+	}
+	sort.Slice(stats.Branches, func(i, j int) bool {
+		return stats.Branches[i].Branch < stats.Branches[j].Branch
+	})
+	stats.AverageCGPA = overall.average()
+
+	return stats, nil
+}
+
+// StateDigest returns a hex-encoded Merkle root over all students, so two ledgers holding the
+// same records can be compared by a single value. Each leaf hashes a student's ID and its
+// canonical JSON encoding, taken in ID order; an empty ledger has the digest of no data.
+func (s *SmartContract) StateDigest(ctx contractapi.TransactionContextInterface) (string, error) {
+	// Range queries return keys in sorted order, so every peer visits the students in the same order
+	resultsIterator, err := ctx.GetStub().GetStateByRange("", "")
+	if err != nil {
+		return "", err
+	}
+	defer resultsIterator.Close()
+
+	var level [][]byte
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return "", err
+		}
+
+		// Re-encode through the struct so the stored formatting does not affect the digest
+		var student Student
+		err = json.Unmarshal(queryResponse.Value, &student)
+		if err != nil {
+			return "", err
+		}
+		canonicalJSON, err := json.Marshal(student)
+		if err != nil {
+			return "", err
+		}
+
+		// Length-prefix the key so no two key and value pairs encode to the same leaf
+		leaf := sha256.New()
+		leaf.Write([]byte{0})
+		binary.Write(leaf, binary.BigEndian, uint32(len(queryResponse.Key)))
+		leaf.Write([]byte(queryResponse.Key))
+		leaf.Write(canonicalJSON)
+		level = append(level, leaf.Sum(nil))
+	}
+
+	if len(level) == 0 {
+		empty := sha256.Sum256(nil)
+		return hex.EncodeToString(empty[:]), nil
+	}
+
+	// Hash pairs of nodes until one remains, carrying an unpaired last node up unchanged
+	for len(level) > 1 {
+		var next [][]byte
+		for i := 0; i < len(level); i += 2 {
+			if i+1 == len(level) {
+				next = append(next, level[i])
+				continue
+			}
+			node := sha256.New()
+			node.Write([]byte{1})
+			node.Write(level[i])
+			node.Write(level[i+1])
+			next = append(next, node.Sum(nil))
+		}
+		level = next
+	}
+
+	return hex.EncodeToString(level[0]), nil
+}
+
+// StudentPage is one page of students along with the bookmark for the next page
+type StudentPage struct {
+	Students []*Student `json:"students"`
+	// Bookmark is passed to the next call to continue the range; it is empty after the last page
+	Bookmark string `json:"bookmark"`
+}
+
+// GetStudentsPage returns up to pageSize students in ID order, starting from the bookmark returned by the previous page
+func (s *SmartContract) GetStudentsPage(ctx contractapi.TransactionContextInterface, pageSize int32, bookmark string) (*StudentPage, error) {
+	resultsIterator, metadata, err := ctx.GetStub().GetStateByRangeWithPagination("", "", pageSize, bookmark)
+	if err != nil {
+		return nil, err
+	}
+	defer resultsIterator.Close()
+
+	page := &StudentPage{Students: []*Student{}}
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return nil, err
+		}
+
+		var student Student
+		err = json.Unmarshal(queryResponse.Value, &student)
+		if err != nil {
+			return nil, err
+		}
+		page.Students = append(page.Students, &student)
+	}
+
+	// A short page means the range is exhausted
+	if metadata.FetchedRecordsCount == pageSize {
+		page.Bookmark = metadata.Bookmark
+	}
+
+	return page, nil
+}
+
+// StudentHistoryEntry is one version of a student, as written by a transaction
+type StudentHistoryEntry struct {
+	TxID string `json:"txId"`
+	// Timestamp is the RFC 3339 timestamp of the transaction
+	Timestamp string `json:"timestamp"`
+	IsDelete  bool   `json:"isDelete"`
+	// Value is the student as the transaction left it; it is absent for a delete
+	Value *Student `json:"value,omitempty" metadata:",optional"`
+}
+
+// GetStudentHistory returns every version of a student, oldest first, including deletes.
+// It needs the peer's history database, which is enabled by default.
+func (s *SmartContract) GetStudentHistory(ctx contractapi.TransactionContextInterface, id string) ([]*StudentHistoryEntry, error) {
+	resultsIterator, err := ctx.GetStub().GetHistoryForKey(id)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read history: %v", err)
+	}
+	defer resultsIterator.Close()
+
+	history := []*StudentHistoryEntry{}
+	for resultsIterator.HasNext() {
+		modification, err := resultsIterator.Next()
+		if err != nil {
+			return nil, err
+		}
+
+		entry := &StudentHistoryEntry{
+			TxID:      modification.TxId,
+			Timestamp: modification.Timestamp.AsTime().UTC().Format(time.RFC3339Nano),
+			IsDelete:  modification.IsDelete,
+		}
+		if !modification.IsDelete {
+			var student Student
+			err = json.Unmarshal(modification.Value, &student)
+			if err != nil {
+				return nil, err
+			}
+			entry.Value = &student
+		}
+		history = append(history, entry)
+	}
+
+	if len(history) == 0 {
+		return nil, fmt.Errorf("the student %s does not exist", id)
+	}
+
+	// The peer returns the newest version first
+	for i, j := 0, len(history)-1; i < j; i, j = i+1, j-1 {
+		history[i], history[j] = history[j], history[i]
+	}
+
+	return history, nil
+}
+
+// QueryStudents returns the students matching a CouchDB rich query, such as
+// {"selector":{"branch":"CSE"}}. Rich queries require CouchDB as the state database.
+func (s *SmartContract) QueryStudents(ctx contractapi.TransactionContextInterface, queryJSON string) ([]*Student, error) {
+	resultsIterator, err := ctx.GetStub().GetQueryResult(queryJSON)
+	if err != nil {
+		return nil, fmt.Errorf("failed to run query: %v", err)
+	}
+	defer resultsIterator.Close()
+
+	students := []*Student{}
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return nil, err
+		}
+
+		var student Student
+		err = json.Unmarshal(queryResponse.Value, &student)
+		if err != nil {
+			return nil, err
+		}
+		students = append(students, &student)
+	}
+
+	return students, nil
+}
+
+// nameIndex is the CouchDB index on student names, defined in META-INF/statedb/couchdb/indexes
+var nameIndex = []string{"_design/indexNameDoc", "indexName"}
+
+// SearchStudentsByName returns up to pageSize students whose name contains text, ignoring case,
+// in name order starting from the bookmark returned by the previous page. With prefix set, only
+// names starting with text match. Searching requires CouchDB as the state database.
+func (s *SmartContract) SearchStudentsByName(ctx contractapi.TransactionContextInterface, text string, prefix bool, pageSize int32, bookmark string) (*StudentPage, error) {
+	pattern := "(?i)" + regexp.QuoteMeta(text)
+	if prefix {
+		pattern = "(?i)^" + regexp.QuoteMeta(text)
+	}
+
+	// The $gt bound lets CouchDB use the name index, which $regex alone cannot
+	query, err := json.Marshal(map[string]interface{}{
+		"selector":  map[string]interface{}{"name": map[string]interface{}{"$gt": nil, "$regex": pattern}},
+		"sort":      []map[string]string{{"name": "asc"}},
+		"use_index": nameIndex,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	resultsIterator, metadata, err := ctx.GetStub().GetQueryResultWithPagination(string(query), pageSize, bookmark)
+	if err != nil {
+		return nil, fmt.Errorf("failed to run query: %v", err)
+	}
+	defer resultsIterator.Close()
+
+	page := &StudentPage{Students: []*Student{}}
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return nil, err
+		}
+
+		var student Student
+		err = json.Unmarshal(queryResponse.Value, &student)
+		if err != nil {
+			return nil, err
+		}
+		page.Students = append(page.Students, &student)
+	}
+
+	// A short page means the search is exhausted
+	if metadata.FetchedRecordsCount == pageSize {
+		page.Bookmark = metadata.Bookmark
+	}
+
+	return page, nil
+}
+
+// TagStudentsByQuery sets the label key to value on every student matching a CouchDB rich query,
+// such as {"selector":{"branch":"CSE"}}, and returns the number of students tagged. It emits a
+// StudentsTagged event summarizing the change. Rich queries require CouchDB as the state database,
+// and the query is not re-run at validation, so students that start matching between endorsement
+// and commit are not tagged.
+func (s *SmartContract) TagStudentsByQuery(ctx contractapi.TransactionContextInterface, queryJSON string, key string, value string) (int, error) {
+	if key == "" {
+		return 0, fmt.Errorf("the label key must not be empty")
+	}
+
+	resultsIterator, err := ctx.GetStub().GetQueryResult(queryJSON)
+	if err != nil {
+		return 0, fmt.Errorf("failed to run query: %v", err)
+	}
+	defer resultsIterator.Close()
+
+	updatedAt, err := txTimestamp(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	count := 0
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return 0, err
+		}
+
+		var student Student
+		err = json.Unmarshal(queryResponse.Value, &student)
+		if err != nil {
+			return 0, err
+		}
+
+		if student.Labels == nil {
+			student.Labels = make(map[string]string)
+		}
+		student.Labels[key] = value
+		student.UpdatedAt = updatedAt
+		student.Version++
+
+		studentJSON, err := json.Marshal(student)
+		if err != nil {
+			return 0, err
+		}
+		err = ctx.GetStub().PutState(queryResponse.Key, studentJSON)
+		if err != nil {
+			return 0, fmt.Errorf("failed to put to world state: %v", err)
+		}
+		count++
+	}
+
+	eventJSON, err := json.Marshal(StudentsTaggedEvent{Query: queryJSON, Key: key, Value: value, Count: count})
+	if err != nil {
+		return 0, err
+	}
+	err = ctx.GetStub().SetEvent("StudentsTagged", eventJSON)
+	if err != nil {
+		return 0, fmt.Errorf("failed to set event: %v", err)
+	}
+
+	return count, nil
+}
+
+// UpdateStudent replaces the name and branch of a student. If version is not empty, the
+// student must still be at that version, so a client cannot overwrite a change it has not seen.
+// A new CGPA or contact details may be passed as JSON in the transient data under the "details"
+// key, and replace those in the private data collection, leaving any they do not give as they
+// were. A soft deleted student cannot be updated until it is restored.
+func (s *SmartContract) UpdateStudent(ctx contractapi.TransactionContextInterface, id string, name string, branch string, version string) error {
+	private, err := transientPrivateDetails(ctx)
+	if err != nil {
+		return err
+	}
+
+	student, err := s.ReadStudent(ctx, id)
+	if err != nil {
+		return err
+	}
+	if student.Status == statusInactive {
+		return fmt.Errorf("the student %s is deleted and must be restored before it is updated", id)
+	}
+	if version != "" && version != strconv.FormatUint(student.Version, 10) {
+		return fmt.Errorf("the student %s is at version %d, not version %s", id, student.Version, version)
+	}
+
+	student.Name = name
+	student.Branch = branch
+	if err := updateStudentPrivateDetails(ctx, student, private); err != nil {
+		return err
+	}
+	if err := putStudent(ctx, student); err != nil {
+		return err
+	}
+	return setStudentEvent(ctx, eventStudentUpdated, id)
+}
+
+// DeactivateStudent soft deletes a student, marking it inactive while keeping its record so it
+// can be brought back with RestoreStudent
+func (s *SmartContract) DeactivateStudent(ctx contractapi.TransactionContextInterface, id string) error {
+	student, err := s.ReadStudent(ctx, id)
+	if err != nil {
+		return err
+	}
+	if student.Status == statusInactive {
+		return fmt.Errorf("the student %s is already deleted", id)
+	}
+
+	student.Status = statusInactive
+	if err := putStudent(ctx, student); err != nil {
+		return err
+	}
+	return setStudentEvent(ctx, eventStudentDeleted, id)
+}
+
+// RestoreStudent makes a soft deleted student active again
+func (s *SmartContract) RestoreStudent(ctx contractapi.TransactionContextInterface, id string) error {
+	student, err := s.ReadStudent(ctx, id)
+	if err != nil {
+		return err
+	}
+	if student.Status != statusInactive {
+		return fmt.Errorf("the student %s is not deleted", id)
+	}
+
+	student.Status = ""
+	if err := putStudent(ctx, student); err != nil {
+		return err
+	}
+	return setStudentEvent(ctx, eventStudentRestored, id)
+}
+
+// DeleteStudent removes a student from the world state for good, whether or not it was soft
+// deleted first. Its earlier versions remain in the student's history.
+func (s *SmartContract) DeleteStudent(ctx contractapi.TransactionContextInterface, id string) error {
+	exists, err := s.StudentExists(ctx, id)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return fmt.Errorf("the student %s does not exist", id)
+	}
+
+	err = ctx.GetStub().DelState(id)
+	if err != nil {
+		return fmt.Errorf("failed to delete from world state: %v", err)
+	}
+	return setStudentEvent(ctx, eventStudentPurged, id)
+}
+
 // StudentExists returns true if student exists
 func (s *SmartContract) StudentExists(ctx contractapi.TransactionContextInterface, id string) (bool, error) {
 	studentJSON, err := ctx.GetStub().GetState(id)
@@ -120,6 +924,123 @@ func (s *SmartContract) StudentExists(ctx contractapi.TransactionContextInterfac
 	return studentJSON != nil, nil
 }
 
+// putStudent writes a student to the world state as its next version, stamped with the
+// transaction timestamp
+func putStudent(ctx contractapi.TransactionContextInterface, student *Student) error {
+	updatedAt, err := txTimestamp(ctx)
+	if err != nil {
+		return err
+	}
+	student.UpdatedAt = updatedAt
+	student.Version++
+
+	studentJSON, err := json.Marshal(student)
+	if err != nil {
+		return err
+	}
+	err = ctx.GetStub().PutState(student.ID, studentJSON)
+	if err != nil {
+		return fmt.Errorf("failed to put to world state: %v", err)
+	}
+	return nil
+}
+
+// putStudentPrivateDetails writes a student's private details to the private data collection
+func putStudentPrivateDetails(ctx contractapi.TransactionContextInterface, private *StudentPrivateDetails) error {
+	privateJSON, err := json.Marshal(private)
+	if err != nil {
+		return err
+	}
+	err = ctx.GetStub().PutPrivateData(privateCollection, private.ID, privateJSON)
+	if err != nil {
+		return fmt.Errorf("failed to put private data: %v", err)
+	}
+	return nil
+}
+
+// transientPrivateDetails returns the private details passed in the transient data under the
+// "details" key, or nil if none were passed
+func transientPrivateDetails(ctx contractapi.TransactionContextInterface) (*StudentPrivateDetails, error) {
+	transientMap, err := ctx.GetStub().GetTransient()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read transient data: %v", err)
+	}
+
+	detailsJSON, ok := transientMap[detailsTransientKey]
+	if !ok {
+		return nil, nil
+	}
+	var private StudentPrivateDetails
+	err = json.Unmarshal(detailsJSON, &private)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse transient private details: %v", err)
+	}
+	return &private, nil
+}
+
+// updateStudentPrivateDetails writes the fields given in changes over the private details the
+// student already has, and moves a CGPA the student still holds in the public state into them,
+// removing it from the student. It writes nothing if there is nothing to change.
+func updateStudentPrivateDetails(ctx contractapi.TransactionContextInterface, student *Student, changes *StudentPrivateDetails) error {
+	if changes == nil && student.CGPA == "" {
+		return nil
+	}
+
+	private := StudentPrivateDetails{ID: student.ID}
+	privateJSON, err := ctx.GetStub().GetPrivateData(privateCollection, student.ID)
+	if err != nil {
+		return fmt.Errorf("failed to read private data: %v", err)
+	}
+	if privateJSON != nil {
+		if err := json.Unmarshal(privateJSON, &private); err != nil {
+			return err
+		}
+	}
+
+	if student.CGPA != "" {
+		private.CGPA = student.CGPA
+		student.CGPA = ""
+	}
+	if changes != nil {
+		if changes.CGPA != "" {
+			private.CGPA = changes.CGPA
+		}
+		if changes.Email != "" {
+			private.Email = changes.Email
+		}
+		if changes.Phone != "" {
+			private.Phone = changes.Phone
+		}
+		if changes.Address != "" {
+			private.Address = changes.Address
+		}
+	}
+	return putStudentPrivateDetails(ctx, &private)
+}
+
+// setStudentEvent emits a student event naming the given students
+func setStudentEvent(ctx contractapi.TransactionContextInterface, name string, ids ...string) error {
+	eventJSON, err := json.Marshal(StudentEvent{IDs: ids})
+	if err != nil {
+		return err
+	}
+	err = ctx.GetStub().SetEvent(name, eventJSON)
+	if err != nil {
+		return fmt.Errorf("failed to set event: %v", err)
+	}
+	return nil
+}
+
+// txTimestamp returns the transaction timestamp, which is the same on every endorsing peer
+func txTimestamp(ctx contractapi.TransactionContextInterface) (string, error) {
+	timestamp, err := ctx.GetStub().GetTxTimestamp()
+	if err != nil {
+		return "", fmt.Errorf("failed to read transaction timestamp: %v", err)
+	}
+
+	return timestamp.AsTime().UTC().Format(time.RFC3339Nano), nil
+}
+
 func main() {
 	chaincode, err := contractapi.NewChaincode(&SmartContract{})
 	if err != nil {
@@ -130,4 +1051,3 @@ func main() {
 		panic(fmt.Sprintf("Error starting chaincode: %v", err))
 	}
 }
-
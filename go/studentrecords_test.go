@@ -0,0 +1,382 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+func TestCreateStudents(t *testing.T) {
+	stub := newFakeStub()
+	contract := new(SmartContract)
+
+	err := stub.transact(func(ctx contractapi.TransactionContextInterface) error {
+		return contract.CreateStudents(ctx, `[{"id":"S1","name":"Alice","branch":"CSE","cgpa":"9.1"},{"id":"S2","name":"Bob","branch":"ECE"}]`)
+	})
+	if err != nil {
+		t.Fatalf("CreateStudents: %v", err)
+	}
+
+	for _, id := range []string{"S1", "S2"} {
+		student := stub.student(id)
+		if student == nil {
+			t.Fatalf("student %s was not created", id)
+		}
+		if student.Version != 1 || student.UpdatedAt == "" {
+			t.Errorf("student %s has version %d and updatedAt %q, want version 1 and a timestamp", id, student.Version, student.UpdatedAt)
+		}
+	}
+	if _, ok := stub.events[eventStudentCreated]; !ok {
+		t.Errorf("no %s event was emitted", eventStudentCreated)
+	}
+
+	// A CGPA given in the public batch is moved to the private data collection
+	if public := string(stub.state["S1"]); strings.Contains(public, "cgpa") {
+		t.Errorf("public state holds the CGPA: %s", public)
+	}
+	if details := privateDetails(t, stub, "S1"); details != (StudentPrivateDetails{ID: "S1", CGPA: "9.1"}) {
+		t.Errorf("private details = %+v, want the CGPA", details)
+	}
+}
+
+func TestCreateStudentsWithExistingIDCommitsNothing(t *testing.T) {
+	stub := newFakeStub()
+	stub.putStudents(Student{ID: "S2", Name: "Bob", Branch: "ECE", Version: 1})
+	contract := new(SmartContract)
+
+	err := stub.transact(func(ctx contractapi.TransactionContextInterface) error {
+		return contract.CreateStudents(ctx, `[{"id":"S1","name":"Alice","branch":"CSE"},{"id":"S2","name":"Robert","branch":"CSE"},{"id":"S3","name":"Carol","branch":"ME"}]`)
+	})
+	if err == nil || !strings.Contains(err.Error(), "the student S2 already exists") {
+		t.Fatalf("CreateStudents returned %v, want S2 to already exist", err)
+	}
+
+	if len(stub.writes) != 0 {
+		t.Errorf("CreateStudents wrote %d keys, want none", len(stub.writes))
+	}
+	if stub.student("S1") != nil || stub.student("S3") != nil {
+		t.Error("students of the failed batch were committed")
+	}
+	if student := stub.student("S2"); student.Name != "Bob" {
+		t.Errorf("existing student was changed to %+v", student)
+	}
+}
+
+func TestCreateStudentsWithRepeatedIDCommitsNothing(t *testing.T) {
+	stub := newFakeStub()
+	contract := new(SmartContract)
+
+	err := stub.transact(func(ctx contractapi.TransactionContextInterface) error {
+		return contract.CreateStudents(ctx, `[{"id":"S1","name":"Alice"},{"id":"S1","name":"Alice again"}]`)
+	})
+	if err == nil || !strings.Contains(err.Error(), "appears more than once") {
+		t.Fatalf("CreateStudents returned %v, want S1 to be repeated", err)
+	}
+	if len(stub.state) != 0 {
+		t.Errorf("world state holds %d students, want none", len(stub.state))
+	}
+}
+
+// stateDigest evaluates StateDigest against the stub's world state
+func stateDigest(t *testing.T, stub *fakeStub) string {
+	t.Helper()
+
+	var digest string
+	err := stub.transact(func(ctx contractapi.TransactionContextInterface) error {
+		var err error
+		digest, err = new(SmartContract).StateDigest(ctx)
+		return err
+	})
+	if err != nil {
+		t.Fatalf("StateDigest: %v", err)
+	}
+	return digest
+}
+
+func TestStateDigest(t *testing.T) {
+	students := []Student{
+		{ID: "S1", Name: "Alice", Branch: "CSE", CGPA: "9.1", Version: 1},
+		{ID: "S2", Name: "Bob", Branch: "ECE", Version: 2, Labels: map[string]string{"cohort": "2024", "advisor": "Rao"}},
+		{ID: "S3", Name: "Carol", Branch: "ME", CGPA: "8.2", Version: 1},
+	}
+
+	ledger := newFakeStub()
+	ledger.putStudents(students...)
+	digest := stateDigest(t, ledger)
+
+	// The same students, written in another order and formatting, as a backup might hold them
+	backup := newFakeStub()
+	for i := len(students) - 1; i >= 0; i-- {
+		studentJSON, _ := json.MarshalIndent(students[i], "", "  ")
+		backup.state[students[i].ID] = studentJSON
+	}
+	if backupDigest := stateDigest(t, backup); backupDigest != digest {
+		t.Errorf("identical students have digests %s and %s", digest, backupDigest)
+	}
+	if again := stateDigest(t, ledger); again != digest {
+		t.Errorf("digest changed from %s to %s without any writes", digest, again)
+	}
+
+	changes := map[string]func(*fakeStub){
+		"cgpa changed": func(s *fakeStub) {
+			s.putStudents(Student{ID: "S1", Name: "Alice", Branch: "CSE", CGPA: "9.2", Version: 1})
+		},
+		"label changed": func(s *fakeStub) {
+			s.putStudents(Student{ID: "S2", Name: "Bob", Branch: "ECE", Version: 2, Labels: map[string]string{"cohort": "2025", "advisor": "Rao"}})
+		},
+		"student added":   func(s *fakeStub) { s.putStudents(Student{ID: "S4", Name: "Dave", Branch: "CSE", Version: 1}) },
+		"student removed": func(s *fakeStub) { delete(s.state, "S3") },
+	}
+	for name, change := range changes {
+		t.Run(name, func(t *testing.T) {
+			changed := newFakeStub()
+			changed.putStudents(students...)
+			change(changed)
+			if stateDigest(t, changed) == digest {
+				t.Errorf("digest %s did not change", digest)
+			}
+		})
+	}
+
+	empty := sha256.Sum256(nil)
+	if emptyDigest := stateDigest(t, newFakeStub()); emptyDigest != hex.EncodeToString(empty[:]) {
+		t.Errorf("empty ledger has digest %s, want the digest of no data", emptyDigest)
+	}
+}
+
+func TestTagStudentsByQuery(t *testing.T) {
+	stub := newFakeStub()
+	stub.putStudents(
+		Student{ID: "S1", Name: "Alice", Branch: "CSE", Version: 1},
+		Student{ID: "S2", Name: "Bob", Branch: "ECE", Version: 1, Labels: map[string]string{"cohort": "2023"}},
+		Student{ID: "S3", Name: "Carol", Branch: "CSE", Version: 4, Labels: map[string]string{"advisor": "Rao"}},
+	)
+	untouched := string(stub.state["S2"])
+
+	var tagged int
+	query := `{"selector":{"branch":"CSE"}}`
+	err := stub.transact(func(ctx contractapi.TransactionContextInterface) error {
+		var err error
+		tagged, err = new(SmartContract).TagStudentsByQuery(ctx, query, "cohort", "2024")
+		return err
+	})
+	if err != nil {
+		t.Fatalf("TagStudentsByQuery: %v", err)
+	}
+	if tagged != 2 {
+		t.Errorf("tagged %d students, want 2", tagged)
+	}
+
+	alice, carol := stub.student("S1"), stub.student("S3")
+	if alice.Labels["cohort"] != "2024" || alice.Version != 2 || alice.UpdatedAt == "" {
+		t.Errorf("S1 = %+v, want it tagged at version 2", alice)
+	}
+	if carol.Labels["cohort"] != "2024" || carol.Labels["advisor"] != "Rao" || carol.Version != 5 {
+		t.Errorf("S3 = %+v, want it tagged at version 5 keeping its other labels", carol)
+	}
+	if string(stub.state["S2"]) != untouched {
+		t.Errorf("S2 was changed to %s", stub.state["S2"])
+	}
+
+	var event StudentsTaggedEvent
+	if err := json.Unmarshal(stub.events["StudentsTagged"], &event); err != nil {
+		t.Fatalf("parsing StudentsTagged event: %v", err)
+	}
+	if event != (StudentsTaggedEvent{Query: query, Key: "cohort", Value: "2024", Count: 2}) {
+		t.Errorf("event = %+v, want a summary of the 2 students tagged", event)
+	}
+}
+
+func TestTagStudentsByQueryWithoutKey(t *testing.T) {
+	stub := newFakeStub()
+	stub.putStudents(Student{ID: "S1", Name: "Alice", Branch: "CSE", Version: 1})
+
+	err := stub.transact(func(ctx contractapi.TransactionContextInterface) error {
+		_, err := new(SmartContract).TagStudentsByQuery(ctx, `{"selector":{}}`, "", "2024")
+		return err
+	})
+	if err == nil || !strings.Contains(err.Error(), "must not be empty") {
+		t.Errorf("TagStudentsByQuery returned %v, want the empty key rejected", err)
+	}
+	if student := stub.student("S1"); student.Labels != nil || student.Version != 1 {
+		t.Errorf("S1 was changed to %+v", student)
+	}
+}
+
+func TestCreateStudentPrivateKeepsCGPAOutOfPublicState(t *testing.T) {
+	stub := newFakeStub()
+	stub.transient = map[string][]byte{
+		studentTransientKey: []byte(`{"id":"S1","name":"Alice","branch":"CSE","cgpa":"9.1","email":"alice@example.com"}`),
+	}
+	contract := new(SmartContract)
+
+	err := stub.transact(func(ctx contractapi.TransactionContextInterface) error {
+		return contract.CreateStudentPrivate(ctx)
+	})
+	if err != nil {
+		t.Fatalf("CreateStudentPrivate: %v", err)
+	}
+
+	public := string(stub.state["S1"])
+	if strings.Contains(public, "cgpa") || strings.Contains(public, "9.1") || strings.Contains(public, "alice@example.com") {
+		t.Errorf("public state holds private fields: %s", public)
+	}
+	if student := stub.student("S1"); student.Name != "Alice" || student.Branch != "CSE" || student.Version != 1 {
+		t.Errorf("public student = %+v, want the ID, name, and branch", student)
+	}
+
+	var details *StudentPrivateDetails
+	err = stub.transact(func(ctx contractapi.TransactionContextInterface) error {
+		var err error
+		details, err = contract.ReadStudentPrivateDetails(ctx, "S1")
+		return err
+	})
+	if err != nil {
+		t.Fatalf("ReadStudentPrivateDetails: %v", err)
+	}
+	if *details != (StudentPrivateDetails{ID: "S1", CGPA: "9.1", Email: "alice@example.com"}) {
+		t.Errorf("private details = %+v, want the CGPA and email", details)
+	}
+}
+
+func TestCreateStudentPrivateDetailsMovesCGPA(t *testing.T) {
+	stub := newFakeStub()
+	stub.putStudents(Student{ID: "S1", Name: "Alice", Branch: "CSE", CGPA: "9.1", Version: 1})
+	stub.transient = map[string][]byte{detailsTransientKey: []byte(`{"phone":"555-0100"}`)}
+
+	err := stub.transact(func(ctx contractapi.TransactionContextInterface) error {
+		return new(SmartContract).CreateStudentPrivateDetails(ctx, "S1")
+	})
+	if err != nil {
+		t.Fatalf("CreateStudentPrivateDetails: %v", err)
+	}
+
+	if public := string(stub.state["S1"]); strings.Contains(public, "cgpa") {
+		t.Errorf("public state still holds the CGPA: %s", public)
+	}
+	var details StudentPrivateDetails
+	if err := json.Unmarshal(stub.private["S1"], &details); err != nil {
+		t.Fatalf("parsing private details: %v", err)
+	}
+	if details != (StudentPrivateDetails{ID: "S1", CGPA: "9.1", Phone: "555-0100"}) {
+		t.Errorf("private details = %+v, want the CGPA moved in with the phone", details)
+	}
+}
+
+// privateDetails returns the private details of a student in the private data collection
+func privateDetails(t *testing.T, stub *fakeStub, id string) StudentPrivateDetails {
+	t.Helper()
+
+	var details StudentPrivateDetails
+	if err := json.Unmarshal(stub.private[id], &details); err != nil {
+		t.Fatalf("parsing private details of %s: %v", id, err)
+	}
+	return details
+}
+
+func TestCreateStudentKeepsCGPAOutOfPublicState(t *testing.T) {
+	stub := newFakeStub()
+	stub.transient = map[string][]byte{detailsTransientKey: []byte(`{"cgpa":"9.1"}`)}
+
+	err := stub.transact(func(ctx contractapi.TransactionContextInterface) error {
+		return new(SmartContract).CreateStudent(ctx, "S1", "Alice", "CSE")
+	})
+	if err != nil {
+		t.Fatalf("CreateStudent: %v", err)
+	}
+
+	if public := string(stub.state["S1"]); strings.Contains(public, "cgpa") || strings.Contains(public, "9.1") {
+		t.Errorf("public state holds the CGPA: %s", public)
+	}
+	if details := privateDetails(t, stub, "S1"); details != (StudentPrivateDetails{ID: "S1", CGPA: "9.1"}) {
+		t.Errorf("private details = %+v, want the CGPA", details)
+	}
+}
+
+func TestUpdateStudentKeepsCGPAOutOfPublicState(t *testing.T) {
+	stub := newFakeStub()
+	// A student written before CGPAs were kept privately, with contact details set since
+	stub.putStudents(Student{ID: "S1", Name: "Alice", Branch: "CSE", CGPA: "9.1", Version: 2})
+	stub.private["S1"] = []byte(`{"id":"S1","email":"alice@example.com"}`)
+	contract := new(SmartContract)
+
+	err := stub.transact(func(ctx contractapi.TransactionContextInterface) error {
+		return contract.UpdateStudent(ctx, "S1", "Alice B", "ECE", "2")
+	})
+	if err != nil {
+		t.Fatalf("UpdateStudent: %v", err)
+	}
+	if public := string(stub.state["S1"]); strings.Contains(public, "cgpa") {
+		t.Errorf("public state still holds the CGPA: %s", public)
+	}
+	if details := privateDetails(t, stub, "S1"); details != (StudentPrivateDetails{ID: "S1", CGPA: "9.1", Email: "alice@example.com"}) {
+		t.Errorf("private details = %+v, want the CGPA moved in with the email", details)
+	}
+
+	stub.transient = map[string][]byte{detailsTransientKey: []byte(`{"cgpa":"8.7"}`)}
+	err = stub.transact(func(ctx contractapi.TransactionContextInterface) error {
+		return contract.UpdateStudent(ctx, "S1", "Alice B", "ECE", "3")
+	})
+	if err != nil {
+		t.Fatalf("UpdateStudent: %v", err)
+	}
+	if public := string(stub.state["S1"]); strings.Contains(public, "cgpa") || strings.Contains(public, "8.7") {
+		t.Errorf("public state holds the new CGPA: %s", public)
+	}
+	if student := stub.student("S1"); student.Name != "Alice B" || student.Branch != "ECE" || student.Version != 4 {
+		t.Errorf("public student = %+v, want the new name and branch at version 4", student)
+	}
+	if details := privateDetails(t, stub, "S1"); details != (StudentPrivateDetails{ID: "S1", CGPA: "8.7", Email: "alice@example.com"}) {
+		t.Errorf("private details = %+v, want the new CGPA and the email kept", details)
+	}
+}
+
+func TestStudentStatsLeavesOutDeletedStudents(t *testing.T) {
+	stub := newFakeStub()
+	stub.putStudents(
+		Student{ID: "S1", Name: "Alice", Branch: "CSE", CGPA: "9.0", Version: 1},
+		Student{ID: "S2", Name: "Bob", Branch: "CSE", CGPA: "7.0", Status: statusInactive, Version: 2},
+		Student{ID: "S3", Name: "Carol", Branch: "ECE", Status: statusInactive, Version: 2},
+	)
+	contract := new(SmartContract)
+
+	var active, all *StudentStats
+	err := stub.transact(func(ctx contractapi.TransactionContextInterface) error {
+		var err error
+		if active, err = contract.StudentStats(ctx, false); err != nil {
+			return err
+		}
+		all, err = contract.StudentStats(ctx, true)
+		return err
+	})
+	if err != nil {
+		t.Fatalf("StudentStats: %v", err)
+	}
+
+	if active.Total != 1 || len(active.Branches) != 1 || active.Branches[0].Count != 1 || *active.AverageCGPA != 9.0 {
+		t.Errorf("stats without deleted students = %+v, want only S1", active)
+	}
+	if all.Total != 3 || len(all.Branches) != 2 || *all.AverageCGPA != 8.0 {
+		t.Errorf("stats with deleted students = %+v, want all three", all)
+	}
+}
+
+func TestUpdateDeletedStudentFails(t *testing.T) {
+	stub := newFakeStub()
+	stub.putStudents(Student{ID: "S1", Name: "Alice", Branch: "CSE", Status: statusInactive, Version: 2})
+	contract := new(SmartContract)
+
+	err := stub.transact(func(ctx contractapi.TransactionContextInterface) error {
+		return contract.UpdateStudent(ctx, "S1", "Alice B", "ECE", "")
+	})
+	if err == nil || !strings.Contains(err.Error(), "the student S1 is deleted") {
+		t.Fatalf("UpdateStudent returned %v, want S1 to be deleted", err)
+	}
+	if student := stub.student("S1"); student.Name != "Alice" || student.Version != 2 {
+		t.Errorf("deleted student was changed to %+v", student)
+	}
+}
@@ -0,0 +1,412 @@
+/*
+Copyright 2021 IBM All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/VishnuKC26/studentrecords/studentpb"
+	"github.com/gin-gonic/gin/binding"
+	"go.opentelemetry.io/contrib/instrumentation/google.golang.org/grpc/otelgrpc"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// Metadata keys of gRPC calls, which gRPC requires to be lower case
+var (
+	authorizationMetadata = "authorization"
+	requestIDMetadata     = strings.ToLower(requestIDHeader)
+)
+
+// grpcMethodRoles names the least privileged role allowed to call each StudentService method,
+// matching the roles of the equivalent REST routes
+var grpcMethodRoles = map[string]string{
+	studentpb.StudentService_GetStudent_FullMethodName:      roleViewer,
+	studentpb.StudentService_ListStudents_FullMethodName:    roleViewer,
+	studentpb.StudentService_SubscribeEvents_FullMethodName: roleViewer,
+	studentpb.StudentService_CreateStudent_FullMethodName:   roleRegistrar,
+	studentpb.StudentService_UpdateStudent_FullMethodName:   roleRegistrar,
+	studentpb.StudentService_DeleteStudent_FullMethodName:   roleAdmin,
+}
+
+// grpcFailureCodes are the status codes failed transactions are reported with, by the failure
+// writeTransactionError names
+var grpcFailureCodes = map[string]codes.Code{
+	failureChaincodeNotFound: codes.FailedPrecondition,
+	failureEndorsementPolicy: codes.PermissionDenied,
+	failureMVCCConflict:      codes.Aborted,
+	failureTimeout:           codes.DeadlineExceeded,
+	failureConflict:          codes.FailedPrecondition,
+	failureNotFound:          codes.NotFound,
+	failureGone:              codes.FailedPrecondition,
+	failureInvalidArgument:   codes.InvalidArgument,
+	failurePeerDown:          codes.Unavailable,
+	failureTransaction:       codes.Internal,
+}
+
+// newGRPCServer creates the gRPC server for StudentService, which transacts on the given
+// ledger. When TLS is enabled it uses the REST server's certificate and client CA.
+func newGRPCServer(ledger ledgerContract) (*grpc.Server, error) {
+	options := []grpc.ServerOption{
+		grpc.StatsHandler(otelgrpc.NewServerHandler()),
+		grpc.ChainUnaryInterceptor(unaryCallInterceptor),
+		grpc.ChainStreamInterceptor(streamCallInterceptor),
+	}
+
+	if cfg.TLS.enabled() {
+		tlsConfig, err := serverTLSConfig()
+		if err != nil {
+			return nil, err
+		}
+		certificate, err := tls.LoadX509KeyPair(cfg.TLS.CertFile, cfg.TLS.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load TLS certificate: %w", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{certificate}
+		options = append(options, grpc.Creds(credentials.NewTLS(tlsConfig)))
+	}
+
+	server := grpc.NewServer(options...)
+	studentpb.RegisterStudentServiceServer(server, &studentService{ledger: ledger})
+	return server, nil
+}
+
+// serveGRPC serves gRPC calls on GRPC_LISTEN_ADDR until the server is stopped
+func serveGRPC(server *grpc.Server) error {
+	listener, err := net.Listen("tcp", cfg.GRPCListenAddr)
+	if err != nil {
+		return fmt.Errorf("failed to listen for gRPC calls: %w", err)
+	}
+	slog.Info("Starting gRPC server", "address", cfg.GRPCListenAddr, "tls", cfg.TLS.enabled())
+	return server.Serve(listener)
+}
+
+// unaryCallInterceptor prepares each unary call as startCall does and logs it once handled
+func unaryCallInterceptor(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+	start := time.Now()
+	ctx, err := startCall(ctx, info.FullMethod)
+	var resp any
+	if err == nil {
+		resp, err = handler(ctx, req)
+	}
+	logCall(ctx, info.FullMethod, start, err)
+	return resp, err
+}
+
+// streamCallInterceptor prepares each streaming call as startCall does and logs it once it ends
+func streamCallInterceptor(srv any, stream grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	start := time.Now()
+	ctx, err := startCall(stream.Context(), info.FullMethod)
+	if err == nil {
+		err = handler(srv, &callStream{ServerStream: stream, ctx: ctx})
+	}
+	logCall(ctx, info.FullMethod, start, err)
+	return err
+}
+
+// callStream is a server stream whose context startCall has prepared
+type callStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+// Context returns the prepared context of the call
+func (s *callStream) Context() context.Context {
+	return s.ctx
+}
+
+// startCall gives a call what the REST middleware gives a request: a request ID, taken from the
+// x-request-id metadata or generated, and a logger carrying it; the caller's bearer token, from
+// the authorization metadata, checked against the method's role; the caller's own Fabric
+// identity if they have one; and somewhere to record the transaction it makes. The returned
+// context carries the logger even when the call is refused.
+func startCall(ctx context.Context, method string) (context.Context, error) {
+	md, _ := metadata.FromIncomingContext(ctx)
+
+	var requestID string
+	if values := md.Get(requestIDMetadata); len(values) > 0 && validRequestID.MatchString(values[0]) {
+		requestID = values[0]
+	} else {
+		var err error
+		if requestID, err = randomHex(16); err != nil {
+			slog.Error("Failed to generate request ID", "error", err)
+		}
+	}
+	grpc.SetHeader(ctx, metadata.Pairs(requestIDMetadata, requestID))
+
+	logger := slog.Default().With("requestId", requestID)
+	if span := trace.SpanContextFromContext(ctx); span.IsValid() {
+		logger = logger.With("traceId", span.TraceID().String())
+	}
+	ctx = context.WithValue(ctx, requestIDKey{}, requestID)
+	ctx = context.WithValue(ctx, loggerKey{}, logger)
+	ctx = context.WithValue(ctx, transactionInfoKey{}, &transactionInfo{})
+
+	if !authEnabled() {
+		return ctx, nil
+	}
+
+	var tokenString string
+	if values := md.Get(authorizationMetadata); len(values) > 0 {
+		tokenString, _ = strings.CutPrefix(values[0], "Bearer ")
+	}
+	if tokenString == "" {
+		return ctx, status.Error(codes.Unauthenticated, "missing bearer token")
+	}
+	claims, err := parseToken(tokenString)
+	if err != nil {
+		return ctx, status.Errorf(codes.Unauthenticated, "invalid token: %v", err)
+	}
+	ctx = context.WithValue(ctx, loggerKey{}, logger.With("user", claims.Subject))
+
+	if role := grpcMethodRoles[method]; !rolesInclude(claims.Roles, role) {
+		return ctx, status.Errorf(codes.PermissionDenied, "user %s needs the %s role for this call", claims.Subject, role)
+	}
+
+	// Users with their own Fabric identity transact as themselves, as they do over REST
+	if user, ok := findUser(claims.Subject); ok && user.hasIdentity() && orgs != nil {
+		fc, err := orgs.userConnection(user.MSPID, user)
+		if err != nil {
+			return ctx, status.Errorf(codes.Unavailable, "failed to connect as %s: %v", user.Username, err)
+		}
+		ctx = contextWithConnection(ctx, fc)
+	}
+	return ctx, nil
+}
+
+// logCall writes the access log line of a call once it has been handled
+func logCall(ctx context.Context, method string, start time.Time, err error) {
+	code := status.Code(err)
+	attrs := []any{
+		"method", method,
+		"code", code.String(),
+		"latencyMs", time.Since(start).Milliseconds(),
+	}
+	if info := transactionInfoFrom(ctx); info != nil && info.TransactionID != "" {
+		attrs = append(attrs, "transactionId", info.TransactionID)
+	}
+	if err != nil {
+		attrs = append(attrs, "error", status.Convert(err).Message())
+	}
+
+	level := slog.LevelInfo
+	switch code {
+	case codes.Internal, codes.Unknown, codes.DataLoss:
+		level = slog.LevelError
+	}
+	loggerFrom(ctx).Log(ctx, level, "Call handled", attrs...)
+}
+
+// studentService serves StudentService with the same ledger operations as the REST handlers
+type studentService struct {
+	studentpb.UnimplementedStudentServiceServer
+	ledger ledgerContract
+}
+
+// GetStudent reads one student
+func (s *studentService) GetStudent(ctx context.Context, req *studentpb.GetStudentRequest) (*studentpb.StudentRecord, error) {
+	loggerFrom(ctx).Info("Retrieving student", "studentId", req.GetId())
+
+	result, err := readStudentRecord(ctx, s.ledger, req.GetId())
+	if err != nil {
+		return nil, grpcTransactionError("read student", err)
+	}
+	return newStudentRecord(result)
+}
+
+// ListStudents streams every student, fetching them from the ledger a page at a time, leaving
+// out soft deleted students unless the request includes them
+func (s *studentService) ListStudents(req *studentpb.ListStudentsRequest, stream studentpb.StudentService_ListStudentsServer) error {
+	ctx := stream.Context()
+	loggerFrom(ctx).Info("Retrieving all students")
+
+	bookmark := ""
+	for {
+		page, err := fetchStudentRecordsPage(ctx, s.ledger, bookmark)
+		if err != nil {
+			return grpcTransactionError("get students", err)
+		}
+		for _, student := range page.Students {
+			if !req.GetIncludeDeleted() && isDeleted(student) {
+				continue
+			}
+			data, err := json.Marshal(student)
+			if err != nil {
+				return status.Errorf(codes.Internal, "failed to encode student data: %v", err)
+			}
+			record, err := newStudentRecord(data)
+			if err != nil {
+				return err
+			}
+			if err := stream.Send(record); err != nil {
+				return err
+			}
+		}
+		if page.Bookmark == "" {
+			return nil
+		}
+		bookmark = page.Bookmark
+	}
+}
+
+// CreateStudent adds a student once the transaction commits
+func (s *studentService) CreateStudent(ctx context.Context, req *studentpb.CreateStudentRequest) (*studentpb.WriteStudentResponse, error) {
+	student, err := studentFromMessage(req.GetStudent())
+	if err != nil {
+		return nil, err
+	}
+	loggerFrom(ctx).Info("Creating student", "studentId", student.ID)
+
+	if err := createStudentRecord(ctx, s.ledger, student); err != nil {
+		return nil, grpcTransactionError("create student", err)
+	}
+	return &studentpb.WriteStudentResponse{Student: req.GetStudent(), Transaction: transactionMessage(ctx)}, nil
+}
+
+// UpdateStudent replaces a student's fields once the transaction commits
+func (s *studentService) UpdateStudent(ctx context.Context, req *studentpb.UpdateStudentRequest) (*studentpb.WriteStudentResponse, error) {
+	student, err := studentFromMessage(req.GetStudent())
+	if err != nil {
+		return nil, err
+	}
+	loggerFrom(ctx).Info("Updating student", "studentId", student.ID)
+
+	var version string
+	if req.Version != nil {
+		version = strconv.FormatUint(req.GetVersion(), 10)
+	}
+	if err := updateStudentRecord(ctx, s.ledger, student, version); err != nil {
+		return nil, grpcTransactionError("update student", err)
+	}
+	return &studentpb.WriteStudentResponse{Student: req.GetStudent(), Transaction: transactionMessage(ctx)}, nil
+}
+
+// DeleteStudent removes a student for good once the transaction commits
+func (s *studentService) DeleteStudent(ctx context.Context, req *studentpb.DeleteStudentRequest) (*studentpb.DeleteStudentResponse, error) {
+	if req.GetId() == "" {
+		return nil, status.Error(codes.InvalidArgument, "id is required")
+	}
+	loggerFrom(ctx).Info("Deleting student", "studentId", req.GetId())
+
+	if err := deleteStudentRecord(ctx, s.ledger, req.GetId()); err != nil {
+		return nil, grpcTransactionError("delete student", err)
+	}
+	return &studentpb.DeleteStudentResponse{Transaction: transactionMessage(ctx)}, nil
+}
+
+// SubscribeEvents streams the chaincode's events until the client cancels the call, or the hub
+// drops the subscriber because it is too slow or the server is shutting down
+func (s *studentService) SubscribeEvents(req *studentpb.SubscribeEventsRequest, stream studentpb.StudentService_SubscribeEventsServer) error {
+	ctx := stream.Context()
+	sub := newEventSubscriber(req.GetEventNames())
+	if err := chaincodeEvents.add(sub); err != nil {
+		return status.Error(codes.Unavailable, "server is shutting down")
+	}
+	defer chaincodeEvents.remove(sub)
+
+	loggerFrom(ctx).Info("Event stream client connected", "events", strings.Join(req.GetEventNames(), ","))
+
+	for {
+		select {
+		case message := <-sub.events:
+			err := stream.Send(&studentpb.ChaincodeEvent{
+				EventName:     message.EventName,
+				BlockNumber:   message.BlockNumber,
+				TransactionId: message.TransactionID,
+				Chaincode:     message.Chaincode,
+				Payload:       message.Payload,
+			})
+			if err != nil {
+				return err
+			}
+		case <-sub.done:
+			return status.Error(codes.Unavailable, sub.closeText)
+		case <-ctx.Done():
+			return status.FromContextError(ctx.Err()).Err()
+		}
+	}
+}
+
+// studentFromMessage converts and validates a student sent in a call, applying the same binding
+// rules as a REST request body
+func studentFromMessage(message *studentpb.Student) (Student, error) {
+	student := Student{
+		ID:         message.GetId(),
+		Name:       message.GetName(),
+		Department: message.GetDepartment(),
+		Year:       message.GetYear(),
+		CGPA:       message.GetCgpa(),
+	}
+	if err := binding.Validator.ValidateStruct(student); err != nil {
+		if fields := fieldErrors(err); fields != nil {
+			return student, status.Error(codes.InvalidArgument, "invalid student: "+fieldErrorsText(fields))
+		}
+		return student, status.Errorf(codes.InvalidArgument, "invalid student: %v", err)
+	}
+	return student, nil
+}
+
+// newStudentRecord returns the message for a student's complete record as read from the ledger
+func newStudentRecord(data []byte) (*studentpb.StudentRecord, error) {
+	var student Student
+	if err := json.Unmarshal(data, &student); err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to parse student data: %v", err)
+	}
+	return &studentpb.StudentRecord{
+		Student: &studentpb.Student{
+			Id:         student.ID,
+			Name:       student.Name,
+			Department: student.Department,
+			Year:       student.Year,
+			Cgpa:       student.CGPA,
+		},
+		RecordJson: string(data),
+		Version:    student.Version,
+	}, nil
+}
+
+// transactionMessage returns the transaction the call recorded, or nil if it made none
+func transactionMessage(ctx context.Context) *studentpb.Transaction {
+	info := transactionInfoFrom(ctx)
+	if info == nil || info.TransactionID == "" {
+		return nil
+	}
+	message := &studentpb.Transaction{TransactionId: info.TransactionID, CommitStatus: info.CommitStatus}
+	if info.BlockNumber != nil {
+		message.BlockNumber = *info.BlockNumber
+	}
+	return message
+}
+
+// grpcTransactionError returns the status a failed transaction is reported with: ALREADY_EXISTS
+// or NOT_FOUND when the chaincode reports the student already exists or does not exist, and
+// otherwise the code of the failure writeTransactionError would report
+func grpcTransactionError(action string, err error) error {
+	switch studentError(err) {
+	case errStudentExists:
+		return status.Error(codes.AlreadyExists, errStudentExists.Error())
+	case errStudentNotFound:
+		return status.Error(codes.NotFound, errStudentNotFound.Error())
+	case errStudentChanged, errStudentGone:
+		return status.Error(codes.FailedPrecondition, studentError(err).Error())
+	}
+
+	_, failure := transactionFailure(err)
+	return status.Errorf(grpcFailureCodes[failure], "failed to %s (%s): %v", action, failure, err)
+}
@@ -0,0 +1,103 @@
+/*
+Copyright 2021 IBM All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package main
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/connectivity"
+)
+
+// readyTimeout bounds how long the readiness check waits for the peer connection
+const readyTimeout = 2 * time.Second
+
+// probeTimeout bounds how long the deep readiness check waits for its chaincode query
+const probeTimeout = 3 * time.Second
+
+// probeFunction is evaluated by the deep readiness check. Every chaincode built with the
+// contract API answers it from its own metadata, without reading the ledger.
+const probeFunction = "org.hyperledger.fabric:GetMetadata"
+
+// health reports that the process is up, without touching the Fabric network
+func health(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"status": "ok"})
+}
+
+// ready reports whether the default organization's gateway connection to the peer is usable
+func ready(c *gin.Context) {
+	_, state, ok := checkConnection(c)
+	if !ok {
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"status": "ready", "connection": state.String()})
+}
+
+// checkConnection waits briefly for the default organization's connection to the peer to be
+// ready, writing a 503 response and returning false if it is not
+func checkConnection(c *gin.Context) (*fabricConnection, connectivity.State, bool) {
+	fc := orgs.defaultConnection()
+	if fc == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"status": "unavailable", "error": "Fabric client is not initialized"})
+		return nil, connectivity.Shutdown, false
+	}
+
+	ctx, cancel := context.WithTimeout(c.Request.Context(), readyTimeout)
+	defer cancel()
+
+	state, ok := waitForReady(ctx, fc.currentConnection())
+	if !ok {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"status": "unavailable", "connection": state.String()})
+		return nil, state, false
+	}
+	return fc, state, true
+}
+
+// readyDeep reports whether the default organization can reach the chaincode, by evaluating a
+// query that needs a working gRPC connection, gateway peer, and chaincode container
+func readyDeep(c *gin.Context) {
+	fc, state, ok := checkConnection(c)
+	if !ok {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(c.Request.Context(), probeTimeout)
+	defer cancel()
+
+	_, contract := fc.currentContract(channelName)
+	if _, err := contract.EvaluateWithContext(ctx, probeFunction); err != nil {
+		requestLogger(c).Warn("Readiness probe failed", "error", err)
+		c.JSON(http.StatusServiceUnavailable, gin.H{"status": "unavailable", "connection": state.String(), "chaincode": "unavailable", "error": gatewayErrorText(err)})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"status": "ready", "connection": state.String(), "chaincode": "ok"})
+}
+
+// waitForReady waits until the connection is ready or the context ends, prompting an
+// idle connection to connect. It returns the last observed state.
+func waitForReady(ctx context.Context, conn *grpc.ClientConn) (connectivity.State, bool) {
+	for {
+		state := conn.GetState()
+		switch state {
+		case connectivity.Ready:
+			return state, true
+		case connectivity.Shutdown:
+			return state, false
+		case connectivity.Idle:
+			conn.Connect()
+		}
+
+		if !conn.WaitForStateChange(ctx, state) {
+			return conn.GetState(), false
+		}
+	}
+}
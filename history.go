@@ -0,0 +1,47 @@
+/*
+Copyright 2021 IBM All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// studentVersion is one historical version of a student, as returned by GetStudentHistory
+type studentVersion struct {
+	TxID      string `json:"txId"`
+	Timestamp string `json:"timestamp"`
+	IsDelete  bool   `json:"isDelete"`
+
+	// Value is the student as the transaction left it, or null for a delete
+	Value map[string]interface{} `json:"value"`
+}
+
+// getStudentHistory returns every version of a student, oldest first, including any deletes
+func getStudentHistory(c *gin.Context) {
+	id := c.Param("id")
+	requestLogger(c).Info("Retrieving student history", "studentId", id)
+
+	result, err := requestLedger(c).EvaluateTransaction(c.Request.Context(), "GetStudentHistory", id)
+	if err != nil {
+		if !writeStudentError(c, id, err) {
+			writeTransactionError(c, "read student history", err)
+		}
+		return
+	}
+
+	var history []studentVersion
+	if err := json.Unmarshal(result, &history); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("Failed to parse student history: %v", err)})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"id": id, "versions": history})
+}
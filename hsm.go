@@ -0,0 +1,106 @@
+/*
+Copyright 2021 IBM All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/hyperledger/fabric-gateway/pkg/identity"
+)
+
+// HSMConfig is a PKCS#11 token, such as a SoftHSM or CloudHSM slot, holding the private key of
+// an organization's identity, so the key never has to be written to the filesystem
+type HSMConfig struct {
+	// PKCS#11 library of the HSM, such as /usr/lib/softhsm/libsofthsm2.so
+	Library string `json:"library" yaml:"library"`
+	// Label of the token to use; the slot holding it is found by its label
+	Label string `json:"label" yaml:"label"`
+	// User PIN of the token, or a file holding it, such as a mounted secret
+	Pin     string `json:"pin" yaml:"pin"`
+	PinFile string `json:"pinFile" yaml:"pinFile"`
+	// Hex-encoded CKA_ID of the private key. It defaults to the SHA-256 hash of the certificate's
+	// public key, the ID Fabric's own PKCS#11 tooling gives keys.
+	KeyID string `json:"keyId" yaml:"keyId"`
+}
+
+// validate checks that the token and its PIN are given, and that this build can use them
+func (h HSMConfig) validate() error {
+	if !hsmSupported {
+		return errors.New("hsm is set, but this build has no PKCS#11 support; build with -tags pkcs11")
+	}
+	if h.Library == "" || h.Label == "" {
+		return errors.New("hsm must set library and label")
+	}
+	if (h.Pin == "") == (h.PinFile == "") {
+		return errors.New("hsm must set one of pin and pinFile")
+	}
+	if _, err := hex.DecodeString(h.KeyID); err != nil {
+		return fmt.Errorf("invalid hsm keyId: %w", err)
+	}
+	return nil
+}
+
+// pin returns the user PIN of the token, reading it from its file if it has one
+func (h HSMConfig) pin() (string, error) {
+	if h.PinFile == "" {
+		return h.Pin, nil
+	}
+	pin, err := os.ReadFile(h.PinFile)
+	if err != nil {
+		return "", fmt.Errorf("failed to read HSM PIN file: %w", err)
+	}
+	return strings.TrimSpace(string(pin)), nil
+}
+
+// keyIdentifier returns the CKA_ID of the private key matching the certificate
+func (h HSMConfig) keyIdentifier(certificate *x509.Certificate) (string, error) {
+	if h.KeyID != "" {
+		id, err := hex.DecodeString(h.KeyID)
+		return string(id), err
+	}
+
+	publicKey, ok := certificate.PublicKey.(*ecdsa.PublicKey)
+	if !ok {
+		return "", errors.New("HSM keys must be ECDSA keys")
+	}
+	point, err := publicKey.ECDH()
+	if err != nil {
+		return "", err
+	}
+	ski := sha256.Sum256(point.Bytes())
+	return string(ski[:]), nil
+}
+
+// newHSMSign creates a signing function using the private key held by the organization's HSM
+// for the identity's certificate, and a function closing its session on the token
+func newHSMSign(org OrgConfig, id *identity.X509Identity) (identity.Sign, func() error, error) {
+	certificate, err := identity.CertificateFromPEM(id.Credentials())
+	if err != nil {
+		return nil, nil, err
+	}
+	keyID, err := org.HSM.keyIdentifier(certificate)
+	if err != nil {
+		return nil, nil, err
+	}
+	pin, err := org.HSM.pin()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	sign, closeSign, err := openHSMSign(org.HSM.Library, org.HSM.Label, pin, keyID)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to open HSM token %s: %w", org.HSM.Label, err)
+	}
+	return sign, closeSign, nil
+}
@@ -0,0 +1,27 @@
+//go:build !pkcs11
+
+/*
+Copyright 2021 IBM All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package main
+
+import (
+	"errors"
+
+	"github.com/hyperledger/fabric-gateway/pkg/identity"
+)
+
+// hsmSupported reports whether this build can sign with keys held by a PKCS#11 token. PKCS#11
+// needs cgo and the pkcs11 build tag, so default builds go without it.
+const hsmSupported = false
+
+// openHSMSign always fails, as this build has no PKCS#11 support
+func openHSMSign(_, _, _, _ string) (identity.Sign, func() error, error) {
+	return nil, nil, errors.New("this build has no PKCS#11 support; build with -tags pkcs11")
+}
+
+// closeHSMs does nothing, as this build opens no PKCS#11 libraries
+func closeHSMs() {}
@@ -0,0 +1,54 @@
+//go:build pkcs11
+
+/*
+Copyright 2021 IBM All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package main
+
+import (
+	"sync"
+
+	"github.com/hyperledger/fabric-gateway/pkg/identity"
+)
+
+// hsmSupported reports whether this build can sign with keys held by a PKCS#11 token
+const hsmSupported = true
+
+// hsmFactories holds one signer factory per PKCS#11 library, each initializing its library
+// once for the life of the process, as the library requires
+var hsmFactories = struct {
+	sync.Mutex
+	byLibrary map[string]*identity.HSMSignerFactory
+}{byLibrary: make(map[string]*identity.HSMSignerFactory)}
+
+// openHSMSign logs in to the token with the label and opens a session signing with the
+// private key with the given CKA_ID, returning a function that closes the session
+func openHSMSign(library, label, pin, keyID string) (identity.Sign, func() error, error) {
+	hsmFactories.Lock()
+	defer hsmFactories.Unlock()
+
+	factory, ok := hsmFactories.byLibrary[library]
+	if !ok {
+		var err error
+		if factory, err = identity.NewHSMSignerFactory(library); err != nil {
+			return nil, nil, err
+		}
+		hsmFactories.byLibrary[library] = factory
+	}
+
+	return factory.NewHSMSigner(identity.HSMSignerOptions{Label: label, Pin: pin, Identifier: keyID})
+}
+
+// closeHSMs finalizes the PKCS#11 libraries at shutdown, once their sessions are closed
+func closeHSMs() {
+	hsmFactories.Lock()
+	defer hsmFactories.Unlock()
+
+	for library, factory := range hsmFactories.byLibrary {
+		factory.Dispose()
+		delete(hsmFactories.byLibrary, library)
+	}
+}
@@ -0,0 +1,197 @@
+/*
+Copyright 2021 IBM All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// idempotencyKeyHeader is the request header a client sets to make retries of a create safe
+const idempotencyKeyHeader = "Idempotency-Key"
+
+const (
+	// maxIdempotencyKeyLength bounds the keys clients may send
+	maxIdempotencyKeyLength = 255
+
+	// maxIdempotencyEntries bounds the number of remembered requests, so many distinct keys cannot exhaust memory
+	maxIdempotencyEntries = 10000
+)
+
+// idempotentResponse is the response remembered for an Idempotency-Key. Until the first
+// request with the key has finished, done is open and the response is not yet known.
+type idempotentResponse struct {
+	fingerprint string
+	done        chan struct{}
+	status      int
+	contentType string
+	location    string
+	body        []byte
+	expires     time.Time
+}
+
+// idempotencyStore remembers the responses to requests made with an Idempotency-Key for
+// IDEMPOTENCY_TTL, so a retried request gets the original response instead of being submitted again
+type idempotencyStore struct {
+	mu        sync.Mutex
+	responses map[string]*idempotentResponse
+}
+
+// idempotencyKeys holds the responses of the routes that honour Idempotency-Key
+var idempotencyKeys = &idempotencyStore{responses: make(map[string]*idempotentResponse)}
+
+// begin returns the response remembered for key, or records that the request is under way and
+// returns nil if there is none. A request that cannot be remembered because the store is full
+// is let through as if it had no key.
+func (s *idempotencyStore) begin(key, fingerprint string) (*idempotentResponse, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	if response, ok := s.responses[key]; ok {
+		if response.expires.IsZero() || now.Before(response.expires) {
+			return response, true
+		}
+		delete(s.responses, key)
+	}
+
+	if len(s.responses) >= maxIdempotencyEntries {
+		for k, response := range s.responses {
+			if !response.expires.IsZero() && now.After(response.expires) {
+				delete(s.responses, k)
+			}
+		}
+		if len(s.responses) >= maxIdempotencyEntries {
+			return nil, false
+		}
+	}
+
+	s.responses[key] = &idempotentResponse{fingerprint: fingerprint, done: make(chan struct{})}
+	return nil, true
+}
+
+// finish remembers the response to the request under way for key. Server errors are forgotten
+// instead, so the client can retry the request.
+func (s *idempotencyStore) finish(key string, status int, contentType, location string, body []byte, ttl time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	response := s.responses[key]
+	if status >= http.StatusInternalServerError {
+		delete(s.responses, key)
+	} else {
+		response.status = status
+		response.contentType = contentType
+		response.location = location
+		response.body = body
+		response.expires = time.Now().Add(ttl)
+	}
+	close(response.done)
+}
+
+// idempotent makes the route replay the response to the first request made with an
+// Idempotency-Key when a request with the same key is repeated, rather than running the handler
+// again. Keys are scoped to the client, organization, and channel. Reusing a key for a
+// different request gets 422, and repeating it while the first request is still running gets 409.
+func idempotent(c *gin.Context) {
+	key := c.GetHeader(idempotencyKeyHeader)
+	if key == "" || cfg.IdempotencyTTL <= 0 {
+		c.Next()
+		return
+	}
+	if len(key) > maxIdempotencyKeyLength {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("%s must be at most %d characters", idempotencyKeyHeader, maxIdempotencyKeyLength)})
+		return
+	}
+
+	body, err := io.ReadAll(c.Request.Body)
+	if err != nil {
+		if !writeBodyTooLarge(c, err) {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Failed to read request body: %v", err)})
+		}
+		return
+	}
+	c.Request.Body = io.NopCloser(bytes.NewReader(body))
+	fingerprint := idempotencyFingerprint(c, body)
+
+	scope := rateLimitKey(c) + " " + channelFrom(c.Request.Context())
+	if fc := connectionFrom(c.Request.Context()); fc != nil {
+		scope += " " + fc.org.MSPID
+	}
+	scopedKey := scope + " " + key
+
+	response, tracked := idempotencyKeys.begin(scopedKey, fingerprint)
+	if !tracked {
+		requestLogger(c).Warn("Idempotency store is full, handling request without its key", "idempotencyKey", key)
+		c.Next()
+		return
+	}
+	if response != nil {
+		replayIdempotentResponse(c, key, fingerprint, response)
+		return
+	}
+
+	// A handler that panics is treated as a server error, so the key is not left in progress
+	writer := &cachingWriter{ResponseWriter: c.Writer}
+	c.Writer = writer
+	completed := false
+	defer func() {
+		status := writer.Status()
+		if !completed {
+			status = http.StatusInternalServerError
+		}
+		idempotencyKeys.finish(scopedKey, status, writer.Header().Get("Content-Type"), writer.Header().Get("Location"), writer.body.Bytes(), cfg.IdempotencyTTL)
+	}()
+	c.Next()
+	completed = true
+}
+
+// idempotencyFingerprint returns the salted hash telling apart the requests an Idempotency-Key
+// may be sent with: the method, the API version, the route without the prefix of the root it
+// was served under, the path parameters, the body, and the transient data. An unversioned path
+// and the same path under its version are the same request, so a retry may switch between them.
+func idempotencyFingerprint(c *gin.Context, body []byte) string {
+	var request bytes.Buffer
+	request.WriteString(c.Request.Method + " " + apiRootFrom(c).version + " " + routePattern(c) + "\n")
+	for _, param := range c.Params {
+		request.WriteString(param.Key + "=" + param.Value + "\n")
+	}
+	request.Write(body)
+	transientFingerprint(c.Request.Context(), &request)
+	return contentHash(cfg.HashSalt, request.Bytes())
+}
+
+// replayIdempotentResponse writes the response remembered for an Idempotency-Key, or the
+// error for a key that was reused for a different request or whose request is still running
+func replayIdempotentResponse(c *gin.Context, key, fingerprint string, response *idempotentResponse) {
+	if response.fingerprint != fingerprint {
+		c.AbortWithStatusJSON(http.StatusUnprocessableEntity, gin.H{"error": fmt.Sprintf("%s %q was already used for a different request", idempotencyKeyHeader, key)})
+		return
+	}
+
+	select {
+	case <-response.done:
+	default:
+		c.Header("Retry-After", "1")
+		c.AbortWithStatusJSON(http.StatusConflict, gin.H{"error": fmt.Sprintf("A request with %s %q is still being processed", idempotencyKeyHeader, key)})
+		return
+	}
+
+	requestLogger(c).Info("Replaying response for repeated request", "idempotencyKey", key, "status", response.status)
+	c.Header("Idempotent-Replayed", "true")
+	if response.location != "" {
+		c.Header("Location", response.location)
+	}
+	c.Data(response.status, response.contentType, response.body)
+	c.Abort()
+}
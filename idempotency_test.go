@@ -0,0 +1,116 @@
+/*
+Copyright 2021 IBM All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// newIdempotentRouter returns a router with one idempotent route, which counts how often its
+// handler runs, and an empty idempotency store
+func newIdempotentRouter(t *testing.T, handled *int) *gin.Engine {
+	previousCfg, previousKeys := cfg, idempotencyKeys
+	t.Cleanup(func() { cfg, idempotencyKeys = previousCfg, previousKeys })
+	cfg.IdempotencyTTL = time.Minute
+	idempotencyKeys = &idempotencyStore{responses: make(map[string]*idempotentResponse)}
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.POST("/api/students", idempotent, func(c *gin.Context) {
+		*handled++
+		c.JSON(http.StatusCreated, gin.H{"handled": *handled})
+	})
+	return router
+}
+
+// postIdempotent posts body with the given Idempotency-Key
+func postIdempotent(router *gin.Engine, key, body string) *httptest.ResponseRecorder {
+	request := httptest.NewRequest(http.MethodPost, "/api/students", strings.NewReader(body))
+	request.Header.Set(idempotencyKeyHeader, key)
+	response := httptest.NewRecorder()
+	router.ServeHTTP(response, request)
+	return response
+}
+
+const idempotentStudent = `{"id":"S1","name":"Alice","department":"CSE","cgpa":"9.1"}`
+
+func TestIdempotentRequestIsReplayed(t *testing.T) {
+	var handled int
+	router := newIdempotentRouter(t, &handled)
+
+	first := postIdempotent(router, "key-1", idempotentStudent)
+	if first.Code != http.StatusCreated {
+		t.Fatalf("first status = %d, body %s", first.Code, first.Body)
+	}
+	second := postIdempotent(router, "key-1", idempotentStudent)
+	if second.Code != http.StatusCreated || second.Header().Get("Idempotent-Replayed") != "true" {
+		t.Fatalf("second status = %d, replayed %q, want a replayed 201", second.Code, second.Header().Get("Idempotent-Replayed"))
+	}
+	if second.Body.String() != first.Body.String() {
+		t.Errorf("replayed body %s, want %s", second.Body, first.Body)
+	}
+	if handled != 1 {
+		t.Errorf("handled %d times, want 1", handled)
+	}
+}
+
+func TestIdempotencyKeyReusedForDifferentRequest(t *testing.T) {
+	var handled int
+	router := newIdempotentRouter(t, &handled)
+
+	postIdempotent(router, "key-1", idempotentStudent)
+	if response := postIdempotent(router, "key-1", `{"id":"S2","name":"Bob"}`); response.Code != http.StatusUnprocessableEntity {
+		t.Errorf("status = %d, want 422, body %s", response.Code, response.Body)
+	}
+}
+
+func TestIdempotencyFingerprintIsSalted(t *testing.T) {
+	var handled int
+	router := newIdempotentRouter(t, &handled)
+
+	cfg.HashSalt = "first"
+	postIdempotent(router, "key-1", idempotentStudent)
+
+	// The same request fingerprinted with another salt no longer matches the one remembered
+	cfg.HashSalt = "second"
+	if response := postIdempotent(router, "key-1", idempotentStudent); response.Code != http.StatusUnprocessableEntity {
+		t.Errorf("status = %d, want 422 after the salt changed, body %s", response.Code, response.Body)
+	}
+}
+
+func TestIdempotencyKeyAcrossAPIRoots(t *testing.T) {
+	ledger := &fakeLedger{}
+	router := newTestRouter(t, ledger, func(config *Config) { config.IdempotencyTTL = time.Minute })
+	previousKeys := idempotencyKeys
+	t.Cleanup(func() { idempotencyKeys = previousKeys })
+	idempotencyKeys = &idempotencyStore{responses: make(map[string]*idempotentResponse)}
+
+	first := serveRequest(router, http.MethodPost, "/api/students", idempotentStudent, idempotencyKeyHeader, "key-1")
+	if first.Code != http.StatusCreated {
+		t.Fatalf("first status = %d, body %s", first.Code, first.Body)
+	}
+
+	// The unversioned path is an alias of v1, so a retry under v1 is the same request
+	retry := serveRequest(router, http.MethodPost, "/api/v1/students", idempotentStudent, idempotencyKeyHeader, "key-1")
+	if retry.Code != http.StatusCreated || retry.Header().Get("Idempotent-Replayed") != "true" {
+		t.Errorf("retry under /api/v1: status = %d, replayed %q, want a replayed 201, body %s", retry.Code, retry.Header().Get("Idempotent-Replayed"), retry.Body)
+	}
+	if calls := ledger.submitted(); len(calls) != 1 {
+		t.Errorf("submitted %d transactions, want 1", len(calls))
+	}
+
+	// v2 responds in another shape, so the v1 response is not replayed to it
+	if response := serveRequest(router, http.MethodPost, "/api/v2/students", idempotentStudent, idempotencyKeyHeader, "key-1"); response.Code != http.StatusUnprocessableEntity {
+		t.Errorf("retry under /api/v2: status = %d, want 422, body %s", response.Code, response.Body)
+	}
+}
@@ -0,0 +1,194 @@
+/*
+Copyright 2021 IBM All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package main
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/hyperledger/fabric-gateway/pkg/identity"
+)
+
+// identityReload reports the outcome of reloading the identity of one connection
+type identityReload struct {
+	// Connection is the MSP ID of an organization's shared identity, or the MSP ID and user name of a user's own
+	Connection string `json:"connection"`
+	// Subject and NotAfter describe the certificate now in use, once reloaded
+	Subject  string     `json:"subject,omitempty"`
+	NotAfter *time.Time `json:"notAfter,omitempty"`
+	Error    string     `json:"error,omitempty"`
+}
+
+// reloadIdentity re-reads the connection's certificate and private key, or its wallet
+// identity, and switches every peer connection to them. With onlyChanged, it does nothing
+// unless the certificate differs from the one in use. It returns the certificate of the
+// identity it switched to, or nil if it kept the old one.
+func (fc *fabricConnection) reloadIdentity(onlyChanged bool) (*x509.Certificate, error) {
+	id, err := newIdentity(fc.org)
+	if err != nil {
+		return nil, err
+	}
+	if onlyChanged && bytes.Equal(id.Credentials(), fc.id.Credentials()) {
+		return nil, nil
+	}
+	sign, closeSign, err := newSign(fc.org, id)
+	if err != nil {
+		return nil, err
+	}
+
+	certificate, err := checkSign(id, sign)
+	if err != nil {
+		closeSign()
+		return nil, err
+	}
+
+	for _, peer := range fc.peers {
+		if err := peer.useIdentity(id, sign); err != nil {
+			// Peers switched already keep the new key, so it is not released
+			return nil, fmt.Errorf("peer %s: %w", peer.org.PeerEndpoint, err)
+		}
+	}
+
+	// Requests in flight may still sign with the old key, so it is released once they are done
+	closeOld := fc.closeSign
+	time.AfterFunc(cfg.MaxTransactionTimeout+cfg.MaxCommitTimeout, func() {
+		if err := closeOld(); err != nil {
+			slog.Warn("Failed to release replaced private key", "mspId", fc.org.MSPID, "error", err)
+		}
+	})
+	fc.id, fc.sign, fc.closeSign = id, sign, closeSign
+	return certificate, nil
+}
+
+// checkSign checks that the sign function signs for the identity's certificate, returning the
+// certificate if it does
+func checkSign(id *identity.X509Identity, sign identity.Sign) (*x509.Certificate, error) {
+	certificate, err := identity.CertificateFromPEM(id.Credentials())
+	if err != nil {
+		return nil, err
+	}
+	if err := checkKeyPair(certificate, sign); err != nil {
+		return nil, fmt.Errorf("signing self-test failed: %w", err)
+	}
+	return certificate, nil
+}
+
+// checkKeyPair signs a digest with the private key and verifies the signature with the
+// certificate, so a certificate replaced without its key, or a key without its certificate,
+// is not put into use. Keys of types Fabric does not issue are not checked.
+func checkKeyPair(certificate *x509.Certificate, sign identity.Sign) error {
+	digest := sha256.Sum256([]byte("studentrecords key pair check"))
+	signature, err := sign(digest[:])
+	if err != nil {
+		return fmt.Errorf("failed to sign with the private key: %w", err)
+	}
+
+	var valid bool
+	switch key := certificate.PublicKey.(type) {
+	case *ecdsa.PublicKey:
+		valid = ecdsa.VerifyASN1(key, digest[:], signature)
+	case ed25519.PublicKey:
+		valid = ed25519.Verify(key, digest[:], signature)
+	default:
+		return nil
+	}
+	if !valid {
+		return errors.New("the private key does not match the certificate")
+	}
+	return nil
+}
+
+// reloadIdentities reloads the identity of every open connection, or with onlyChanged of
+// those whose certificate has changed, and reports each one reloaded or failing to reload, in
+// order of their keys. A connection that fails keeps its old identity.
+func (r *orgRegistry) reloadIdentities(onlyChanged bool) []identityReload {
+	r.reloadMu.Lock()
+	defer r.reloadMu.Unlock()
+
+	// Reloading reads files, so new connections are not held up while it runs
+	r.mu.Lock()
+	connections := make(map[string]*fabricConnection, len(r.connections))
+	keys := make([]string, 0, len(r.connections))
+	for key, fc := range r.connections {
+		connections[key] = fc
+		keys = append(keys, key)
+	}
+	r.mu.Unlock()
+	sort.Strings(keys)
+
+	reports := []identityReload{}
+	for _, key := range keys {
+		fc := connections[key]
+		logger := slog.With("connection", key)
+
+		certificate, err := fc.reloadIdentity(onlyChanged)
+		report := identityReload{Connection: key}
+		switch {
+		case err != nil:
+			logger.Error("Failed to reload identity", "error", err)
+			identityReloads.WithLabelValues(fc.org.MSPID, "failure").Inc()
+			report.Error = err.Error()
+		case certificate != nil:
+			logger.Info("Identity reloaded", "subject", certificate.Subject.CommonName, "notAfter", certificate.NotAfter)
+			identityReloads.WithLabelValues(fc.org.MSPID, "success").Inc()
+			report.Subject = certificate.Subject.CommonName
+			report.NotAfter = &certificate.NotAfter
+		default:
+			continue
+		}
+		reports = append(reports, report)
+	}
+	return reports
+}
+
+// watchIdentities checks every interval for connections whose certificate has changed on
+// disk or in the wallet, and reloads them. It runs until the process exits, and does nothing
+// if the interval is not positive.
+func watchIdentities(interval time.Duration) {
+	if interval <= 0 {
+		return
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		orgs.reloadIdentities(true)
+	}
+}
+
+// reloadIdentity re-reads the certificate and private key of every connection the server has
+// opened and switches to them without dropping requests in flight, so expiring enrollment
+// certificates can be rotated without a restart. It answers 500 if any connection failed to
+// reload; those keep their old identity.
+func reloadIdentity(c *gin.Context) {
+	if orgs == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Fabric client is not initialized"})
+		return
+	}
+
+	requestLogger(c).Info("Reloading identities")
+
+	reports := orgs.reloadIdentities(false)
+	for _, report := range reports {
+		if report.Error != "" {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to reload some identities", "connections": reports})
+			return
+		}
+	}
+	c.JSON(http.StatusOK, gin.H{"connections": reports})
+}
@@ -0,0 +1,795 @@
+/*
+Copyright 2021 IBM All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Kinds of background job
+const (
+	jobImport = "import"
+	jobExport = "export"
+)
+
+// Statuses of a background job
+const (
+	jobQueued    = "queued"
+	jobRunning   = "running"
+	jobSucceeded = "succeeded"
+	jobFailed    = "failed"
+	jobCancelled = "cancelled"
+)
+
+// maxQueuedJobs bounds the jobs waiting for a worker, so many requests cannot exhaust memory or disk
+const maxQueuedJobs = 100
+
+// defaultJobChunkSize is how many students an import job creates per transaction, unless chunk_size says otherwise
+const defaultJobChunkSize = 100
+
+var (
+	errJobNotFound  = errors.New("job not found")
+	errJobFinished  = errors.New("job has already finished")
+	errJobQueueFull = errors.New("too many jobs are queued")
+
+	// errJobCancelled is the cause a job's context is cancelled with when its owner cancels it
+	errJobCancelled = errors.New("job cancelled")
+)
+
+// Job reports the progress of a background job to the client that created it
+type Job struct {
+	ID         string      `json:"id"`
+	Type       string      `json:"type"`
+	Status     string      `json:"status"`
+	Org        string      `json:"org,omitempty"`
+	Channel    string      `json:"channel"`
+	Progress   JobProgress `json:"progress"`
+	Error      string      `json:"error,omitempty"`
+	CreatedAt  time.Time   `json:"createdAt"`
+	StartedAt  *time.Time  `json:"startedAt,omitempty"`
+	FinishedAt *time.Time  `json:"finishedAt,omitempty"`
+
+	// Links to the job and, once it has one, its result. They depend on the API version of the
+	// request, so they are filled in by jobView rather than kept.
+	StatusURL string `json:"statusUrl,omitempty"`
+	ResultURL string `json:"resultUrl,omitempty"`
+}
+
+// JobProgress counts the students a job has worked through
+type JobProgress struct {
+	// Total is the number of students an import creates; exports don't know theirs in advance
+	Total     int `json:"total,omitempty"`
+	Processed int `json:"processed"`
+	// Failed counts the students of an import whose transaction failed, and Skipped the rows it
+	// left out as malformed or invalid
+	Failed  int `json:"failed,omitempty"`
+	Skipped int `json:"skipped,omitempty"`
+}
+
+// jobRecord is a job as kept in its file, with what it needs to run again after a restart
+type jobRecord struct {
+	Job
+	Owner   string `json:"owner"`             // rateLimitKey of the client that created the job
+	Subject string `json:"subject,omitempty"` // authenticated user, whose own identity the job transacts with if they have one
+
+	// Options of an export
+	Format         string `json:"format,omitempty"`
+	IncludeDeleted bool   `json:"includeDeleted,omitempty"`
+
+	// Options of an import, and the rows it did not import
+	ChunkSize int               `json:"chunkSize,omitempty"`
+	Report    []importReportRow `json:"report,omitempty"`
+}
+
+// finished reports whether the job has stopped for good
+func (r jobRecord) finished() bool {
+	return r.Status != jobQueued && r.Status != jobRunning
+}
+
+// hasResult reports whether the job has a result to download: the file of a finished export, or
+// the report of the rows a finished import did not import
+func (r jobRecord) hasResult() bool {
+	if r.Type == jobExport {
+		return r.Status == jobSucceeded
+	}
+	return r.finished()
+}
+
+// importJobInput holds the valid students an import job creates and the line each was read
+// from. It is kept in a file next to the job's, so the import can resume after a restart.
+type importJobInput struct {
+	Students []Student `json:"students"`
+	Lines    []int     `json:"lines"`
+}
+
+// jobEntry is a job known to the manager, with the ledger it runs against and, while it runs, a
+// function cancelling it
+type jobEntry struct {
+	record jobRecord
+	ledger ledgerContract
+	cancel context.CancelCauseFunc
+}
+
+// jobManager runs bulk imports and exports in the background on a fixed number of workers. Each
+// job is kept in a file in its directory, so jobs that were queued or running when the server
+// stopped run again once it restarts: imports carry on from the next chunk, and exports start over.
+type jobManager struct {
+	dir       string
+	workers   int
+	retention time.Duration
+
+	// ledger is used by jobs resumed after a restart, which have no request to take theirs from
+	ledger ledgerContract
+
+	// ctx is cancelled at shutdown, stopping the workers and leaving running jobs queued
+	ctx  context.Context
+	stop context.CancelFunc
+
+	queue chan string
+
+	mu   sync.Mutex
+	jobs map[string]*jobEntry
+}
+
+// jobs runs bulk imports and exports in the background; it is nil when JOBS_DIR is empty
+var jobs *jobManager
+
+// newJobManager creates a manager keeping jobs in dir and running workers of them at once
+func newJobManager(dir string, workers int, retention time.Duration, ledger ledgerContract) *jobManager {
+	ctx, stop := context.WithCancel(context.Background())
+	return &jobManager{
+		dir:       dir,
+		workers:   workers,
+		retention: retention,
+		ledger:    ledger,
+		ctx:       ctx,
+		stop:      stop,
+		queue:     make(chan string, maxQueuedJobs+workers),
+		jobs:      make(map[string]*jobEntry),
+	}
+}
+
+// load reads the jobs kept in the directory, creating it if needed, queues again those that had
+// not finished when the server stopped, and starts the workers
+func (m *jobManager) load() error {
+	if err := os.MkdirAll(m.dir, 0o700); err != nil {
+		return fmt.Errorf("failed to create jobs directory: %w", err)
+	}
+	paths, err := filepath.Glob(filepath.Join(m.dir, "*.job.json"))
+	if err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var resumed []jobRecord
+	for _, path := range paths {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return fmt.Errorf("failed to read job file: %w", err)
+		}
+		var record jobRecord
+		if err := json.Unmarshal(data, &record); err != nil {
+			return fmt.Errorf("failed to parse job file %s: %w", path, err)
+		}
+
+		m.jobs[record.ID] = &jobEntry{record: record, ledger: m.ledger}
+		if !record.finished() {
+			resumed = append(resumed, record)
+		}
+	}
+	m.pruneLocked()
+
+	sort.Slice(resumed, func(i, j int) bool { return resumed[i].CreatedAt.Before(resumed[j].CreatedAt) })
+	for _, record := range resumed {
+		entry := m.jobs[record.ID]
+		entry.record.Status = jobQueued
+		if err := m.saveLocked(entry.record); err != nil {
+			return err
+		}
+		m.queue <- record.ID
+	}
+	if len(resumed) > 0 {
+		slog.Info("Resuming background jobs", "count", len(resumed))
+	}
+
+	for i := 0; i < m.workers; i++ {
+		goBackground(m.work)
+	}
+	return nil
+}
+
+// shutdown stops the workers. Running jobs stop at their next chunk or page and are left
+// queued, to run again once the server restarts.
+func (m *jobManager) shutdown() {
+	m.stop()
+}
+
+// create saves a new job for the request's organization, identity, and channel and queues it. An
+// import's input is kept alongside it.
+func (m *jobManager) create(c *gin.Context, record jobRecord, input *importJobInput) (jobRecord, error) {
+	id, err := randomHex(16)
+	if err != nil {
+		return jobRecord{}, err
+	}
+
+	ctx := c.Request.Context()
+	record.ID = id
+	record.Status = jobQueued
+	record.Channel = channelFrom(ctx)
+	record.CreatedAt = time.Now().UTC()
+	record.Owner = rateLimitKey(c)
+	record.Subject, _ = requestSubject(c)
+	if fc := connectionFrom(ctx); fc != nil {
+		record.Org = fc.org.MSPID
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.pruneLocked()
+	queued := 0
+	for _, entry := range m.jobs {
+		if entry.record.Status == jobQueued {
+			queued++
+		}
+	}
+	if queued >= maxQueuedJobs {
+		return jobRecord{}, errJobQueueFull
+	}
+
+	if input != nil {
+		if err := writeFileAtomic(m.inputPath(id), input); err != nil {
+			return jobRecord{}, fmt.Errorf("failed to save job input: %w", err)
+		}
+	}
+	if err := m.saveLocked(record); err != nil {
+		os.Remove(m.inputPath(id))
+		return jobRecord{}, err
+	}
+
+	m.jobs[id] = &jobEntry{record: record, ledger: requestLedger(c)}
+	m.queue <- id
+	return record, nil
+}
+
+// get returns a job, if it belongs to the owner
+func (m *jobManager) get(owner, id string) (jobRecord, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	entry, ok := m.jobs[id]
+	if !ok || entry.record.Owner != owner {
+		return jobRecord{}, false
+	}
+	return entry.record, true
+}
+
+// list returns the owner's jobs, newest first
+func (m *jobManager) list(owner string) []jobRecord {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	records := []jobRecord{}
+	for _, entry := range m.jobs {
+		if entry.record.Owner == owner {
+			records = append(records, entry.record)
+		}
+	}
+	sort.Slice(records, func(i, j int) bool { return records[i].CreatedAt.After(records[j].CreatedAt) })
+	return records
+}
+
+// cancel cancels one of the owner's jobs. A queued job is cancelled straight away; a running one
+// stops once its current chunk or page is done, and is still running when cancel returns.
+func (m *jobManager) cancel(owner, id string) (jobRecord, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	entry, ok := m.jobs[id]
+	if !ok || entry.record.Owner != owner {
+		return jobRecord{}, errJobNotFound
+	}
+
+	switch entry.record.Status {
+	case jobQueued:
+		now := time.Now().UTC()
+		entry.record.Status, entry.record.FinishedAt = jobCancelled, &now
+		if err := m.saveLocked(entry.record); err != nil {
+			return jobRecord{}, err
+		}
+	case jobRunning:
+		entry.cancel(errJobCancelled)
+	default:
+		return entry.record, errJobFinished
+	}
+	return entry.record, nil
+}
+
+// work runs queued jobs one at a time until shutdown
+func (m *jobManager) work() {
+	for {
+		select {
+		case <-m.ctx.Done():
+			return
+		case id := <-m.queue:
+			if m.ctx.Err() != nil {
+				return
+			}
+			m.run(id)
+		}
+	}
+}
+
+// run runs a queued job and records how it ended
+func (m *jobManager) run(id string) {
+	m.mu.Lock()
+	entry, ok := m.jobs[id]
+	if !ok || entry.record.Status != jobQueued {
+		// Cancelled while it was queued
+		m.mu.Unlock()
+		return
+	}
+	record, ledger := entry.record, entry.ledger
+	m.mu.Unlock()
+
+	ctx, cancel, err := m.jobContext(record)
+	if err != nil {
+		m.finish(id, nil, err)
+		return
+	}
+	defer cancel(nil)
+
+	m.mu.Lock()
+	if entry.record.Status != jobQueued {
+		// Cancelled while connecting
+		m.mu.Unlock()
+		return
+	}
+	now := time.Now().UTC()
+	entry.record.Status, entry.record.StartedAt, entry.cancel = jobRunning, &now, cancel
+	if err := m.saveLocked(entry.record); err != nil {
+		slog.Warn("Failed to save background job", "jobId", id, "error", err)
+	}
+	m.mu.Unlock()
+
+	logger := loggerFrom(ctx)
+	logger.Info("Running background job")
+	switch record.Type {
+	case jobImport:
+		err = m.runImport(ctx, ledger, id)
+	case jobExport:
+		err = m.runExport(ctx, ledger, id)
+	default:
+		err = fmt.Errorf("unknown job type %q", record.Type)
+	}
+	m.finish(id, ctx, err)
+}
+
+// finish records how a job ended. A job stopped by shutdown rather than by its owner is left
+// queued, to run again after the restart.
+func (m *jobManager) finish(id string, ctx context.Context, err error) {
+	m.update(id, func(r *jobRecord) {
+		m.jobs[id].cancel = nil
+		if r.finished() {
+			// Cancelled before it started
+			return
+		}
+		now := time.Now().UTC()
+		switch {
+		case err == nil:
+			r.Status = jobSucceeded
+		case errors.Is(err, errJobCancelled):
+			r.Status = jobCancelled
+		case ctx != nil && m.ctx.Err() != nil && errors.Is(err, context.Canceled):
+			slog.Info("Background job interrupted by shutdown, it will resume after the restart", "jobId", id)
+			r.Status = jobQueued
+			return
+		default:
+			r.Status, r.Error = jobFailed, err.Error()
+		}
+		r.FinishedAt = &now
+		slog.Info("Background job finished", "jobId", id, "type", r.Type, "status", r.Status, "processed", r.Progress.Processed, "failed", r.Progress.Failed)
+	})
+}
+
+// jobContext returns the context a job runs with: the organization, identity, and channel of
+// the request that created it, cancelled at shutdown or when the job is cancelled
+func (m *jobManager) jobContext(record jobRecord) (context.Context, context.CancelCauseFunc, error) {
+	ctx := m.ctx
+	if orgs != nil {
+		var fc *fabricConnection
+		var err error
+		if user, ok := findUser(record.Subject); ok && user.hasIdentity() {
+			fc, err = orgs.userConnection(record.Org, user)
+		} else {
+			fc, err = orgs.connection(record.Org)
+		}
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to connect to the gateway: %w", err)
+		}
+		ctx = contextWithConnection(ctx, fc)
+	}
+	ctx = contextWithChannel(ctx, record.Channel)
+	ctx = context.WithValue(ctx, loggerKey{}, slog.With("jobId", record.ID, "jobType", record.Type, "org", record.Org, "channel", record.Channel))
+
+	ctx, cancel := context.WithCancelCause(ctx)
+	return ctx, cancel, nil
+}
+
+// runImport creates the students of an import job a chunk at a time, carrying on from the chunk
+// after the last one it finished. Like a chunked import, a failed chunk does not stop the others;
+// the job only fails if none of its students could be created.
+func (m *jobManager) runImport(ctx context.Context, ledger ledgerContract, id string) error {
+	data, err := os.ReadFile(m.inputPath(id))
+	if err != nil {
+		return fmt.Errorf("failed to read job input: %w", err)
+	}
+	var input importJobInput
+	if err := json.Unmarshal(data, &input); err != nil {
+		return fmt.Errorf("failed to parse job input: %w", err)
+	}
+
+	record, _ := m.record(id)
+	var lastErr error
+	for start := record.Progress.Processed; start < len(input.Students); start += record.ChunkSize {
+		if ctx.Err() != nil {
+			return context.Cause(ctx)
+		}
+
+		end := min(start+record.ChunkSize, len(input.Students))
+		chunk := make([]int, 0, end-start)
+		for i := start; i < end; i++ {
+			chunk = append(chunk, i)
+		}
+
+		err := submitChunk(ctx, ledger, input.Students, chunk)
+		if err != nil {
+			loggerFrom(ctx).Warn("Chunk of import job failed, continuing with the next chunk", "firstLine", input.Lines[start], "count", len(chunk), "error", err)
+			lastErr = err
+		}
+		m.update(id, func(r *jobRecord) {
+			r.Progress.Processed = end
+			if err != nil {
+				r.Progress.Failed += len(chunk)
+				for _, i := range chunk {
+					r.Report = append(r.Report, importReportRow{Line: input.Lines[i], ID: input.Students[i].ID, Status: "failed", Error: gatewayErrorText(err)})
+				}
+			}
+		})
+	}
+
+	if record, _ = m.record(id); record.Progress.Failed == record.Progress.Total {
+		if lastErr != nil {
+			return fmt.Errorf("no students were created: %s", gatewayErrorText(lastErr))
+		}
+		return errors.New("no students were created")
+	}
+	return nil
+}
+
+// runExport writes every student to the job's export file a page at a time, starting over if
+// the job was interrupted. Soft deleted students are left out unless the job includes them.
+func (m *jobManager) runExport(ctx context.Context, ledger ledgerContract, id string) error {
+	record, _ := m.record(id)
+	newExporter, ok := exportFormats[record.Format]
+	if !ok {
+		return fmt.Errorf("unknown export format %q", record.Format)
+	}
+
+	file, err := os.OpenFile(m.exportPath(record), os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0o600)
+	if err != nil {
+		return fmt.Errorf("failed to create export file: %w", err)
+	}
+	defer file.Close()
+
+	exporter := newExporter(file)
+	exporter.begin()
+
+	exported := 0
+	bookmark := ""
+	for {
+		if ctx.Err() != nil {
+			return context.Cause(ctx)
+		}
+		page, err := fetchStudentsPage(ctx, ledger, bookmark)
+		if err != nil {
+			if ctx.Err() != nil {
+				return context.Cause(ctx)
+			}
+			return fmt.Errorf("failed to get students: %s", gatewayErrorText(err))
+		}
+
+		for _, student := range page.Students {
+			if record.IncludeDeleted || student.Status != statusInactive {
+				exporter.write(student)
+				exported++
+			}
+		}
+		if err := exporter.flush(); err != nil {
+			return fmt.Errorf("failed to write export file: %w", err)
+		}
+		m.update(id, func(r *jobRecord) { r.Progress.Processed = exported })
+
+		if page.Bookmark == "" {
+			break
+		}
+		bookmark = page.Bookmark
+	}
+
+	exporter.end()
+	if err := exporter.flush(); err != nil {
+		return fmt.Errorf("failed to write export file: %w", err)
+	}
+	return file.Close()
+}
+
+// record returns a job whatever its owner
+func (m *jobManager) record(id string) (jobRecord, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	entry, ok := m.jobs[id]
+	if !ok {
+		return jobRecord{}, false
+	}
+	return entry.record, true
+}
+
+// update changes a job's record and saves it
+func (m *jobManager) update(id string, change func(r *jobRecord)) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	entry := m.jobs[id]
+	change(&entry.record)
+	if err := m.saveLocked(entry.record); err != nil {
+		slog.Warn("Failed to save background job", "jobId", id, "error", err)
+	}
+}
+
+// pruneLocked removes the jobs that finished longer ago than the retention period, along with
+// their files. The caller must hold m.mu.
+func (m *jobManager) pruneLocked() {
+	cutoff := time.Now().Add(-m.retention)
+	for id, entry := range m.jobs {
+		if finished := entry.record.FinishedAt; finished != nil && finished.Before(cutoff) {
+			paths, _ := filepath.Glob(filepath.Join(m.dir, id+".*"))
+			for _, path := range paths {
+				os.Remove(path)
+			}
+			delete(m.jobs, id)
+		}
+	}
+}
+
+// saveLocked writes a job's record to its file. The caller must hold m.mu.
+func (m *jobManager) saveLocked(record jobRecord) error {
+	if err := writeFileAtomic(filepath.Join(m.dir, record.ID+".job.json"), record); err != nil {
+		return fmt.Errorf("failed to save job: %w", err)
+	}
+	return nil
+}
+
+// inputPath returns the path of the file holding an import job's students
+func (m *jobManager) inputPath(id string) string {
+	return filepath.Join(m.dir, id+".input.json")
+}
+
+// exportPath returns the path of the file an export job writes
+func (m *jobManager) exportPath(record jobRecord) string {
+	return filepath.Join(m.dir, record.ID+".export."+record.Format)
+}
+
+// writeFileAtomic writes v as JSON to a temporary file and renames it into place, so a crash
+// never leaves a partly written file. Only the server may read the file.
+func writeFileAtomic(path string, v any) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+"-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// jobView returns a job as reported to the client that created it, with links to its status
+// and, once it has one, its result
+func jobView(c *gin.Context, record jobRecord) Job {
+	job := record.Job
+	job.StatusURL = apiPath(c, "/jobs/"+job.ID)
+	if record.hasResult() {
+		job.ResultURL = apiPath(c, "/jobs/"+job.ID+"/result")
+	}
+	return job
+}
+
+// startJob creates a job for the request and responds with 202 and a link to its status
+func startJob(c *gin.Context, record jobRecord, input *importJobInput) {
+	record, err := jobs.create(c, record, input)
+	if errors.Is(err, errJobQueueFull) {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": fmt.Sprintf("Too many jobs are queued, at most %d may wait at once; retry later", maxQueuedJobs)})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("Failed to create job: %v", err)})
+		return
+	}
+
+	requestLogger(c).Info("Queued background job", "jobId", record.ID, "type", record.Type)
+	job := jobView(c, record)
+	c.Header("Location", job.StatusURL)
+	c.JSON(http.StatusAccepted, job)
+}
+
+// createImportJob imports the students in an uploaded CSV file in the background, in chunks of
+// chunk_size students (default 100). The file is parsed and validated before the job is queued, so
+// malformed or invalid rows are rejected with 400 straight away unless skip_invalid=true, in which
+// case they are left out and reported in the job's result.
+func createImportJob(c *gin.Context) {
+	upload, ok := readImportFile(c)
+	if !ok {
+		return
+	}
+	chunkSize, err := parseChunkSize(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if chunkSize == 0 {
+		chunkSize = defaultJobChunkSize
+	}
+
+	if len(upload.rowErrors) > 0 && !upload.skipInvalid {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "CSV file contains malformed rows", "rows": upload.rowErrors})
+		return
+	}
+	results, valid := validateBatch(upload.students)
+	for i := range results {
+		results[i].Line = upload.lines[i]
+	}
+	if !valid && !upload.skipInvalid {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "CSV file contains invalid students", "results": results})
+		return
+	}
+
+	record := jobRecord{Job: Job{Type: jobImport}, ChunkSize: chunkSize}
+	input := &importJobInput{}
+	for _, rowErr := range upload.rowErrors {
+		record.Report = append(record.Report, importReportRow{Line: rowErr.Line, Status: "malformed", Error: rowErr.Error})
+	}
+	for i, result := range results {
+		if result.Status != "valid" {
+			record.Report = append(record.Report, importReportRow{Line: result.Line, ID: result.ID, Status: result.Status, Error: result.Error})
+			continue
+		}
+		input.Students = append(input.Students, upload.students[i])
+		input.Lines = append(input.Lines, upload.lines[i])
+	}
+	if len(input.Students) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "CSV file must contain at least one valid student"})
+		return
+	}
+	record.Progress = JobProgress{Total: len(input.Students), Skipped: len(record.Report)}
+
+	startJob(c, record, input)
+}
+
+// createExportJob exports every student in the background to a file downloaded from the job's
+// result, as CSV or, with format=json, as a JSON array. Soft deleted students are left out unless
+// include_deleted=true.
+func createExportJob(c *gin.Context) {
+	format := c.DefaultQuery("format", "csv")
+	if _, ok := exportFormats[format]; !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Unknown export format %q, expected csv or json", format)})
+		return
+	}
+	includeDeleted, err := parseIncludeDeleted(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	startJob(c, jobRecord{Job: Job{Type: jobExport}, Format: format, IncludeDeleted: includeDeleted}, nil)
+}
+
+// listJobs lists the jobs created by the client making the request, newest first
+func listJobs(c *gin.Context) {
+	records := jobs.list(rateLimitKey(c))
+	views := make([]Job, len(records))
+	for i, record := range records {
+		views[i] = jobView(c, record)
+	}
+	c.JSON(http.StatusOK, gin.H{"jobs": views})
+}
+
+// getJob reports the progress of a job created by the client making the request
+func getJob(c *gin.Context) {
+	record, ok := jobs.get(rateLimitKey(c), c.Param("id"))
+	if !ok {
+		writeJobNotFound(c)
+		return
+	}
+	c.JSON(http.StatusOK, jobView(c, record))
+}
+
+// cancelJob cancels a job created by the client making the request. A queued job is cancelled
+// at once; a running one is answered with 202 and stops once its current chunk or page is done.
+// Students an import has already created are kept.
+func cancelJob(c *gin.Context) {
+	record, err := jobs.cancel(rateLimitKey(c), c.Param("id"))
+	switch {
+	case errors.Is(err, errJobNotFound):
+		writeJobNotFound(c)
+	case errors.Is(err, errJobFinished):
+		c.JSON(http.StatusConflict, gin.H{"error": fmt.Sprintf("Job %s has already finished as %s", record.ID, record.Status)})
+	case err != nil:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("Failed to cancel job: %v", err)})
+	case record.Status == jobRunning:
+		requestLogger(c).Info("Cancelling background job", "jobId", record.ID)
+		c.JSON(http.StatusAccepted, jobView(c, record))
+	default:
+		requestLogger(c).Info("Cancelled background job", "jobId", record.ID)
+		c.JSON(http.StatusOK, jobView(c, record))
+	}
+}
+
+// getJobResult downloads the result of a job created by the client making the request: the file
+// an export wrote, or a CSV report of the rows an import did not import, like an import's report
+func getJobResult(c *gin.Context) {
+	record, ok := jobs.get(rateLimitKey(c), c.Param("id"))
+	if !ok {
+		writeJobNotFound(c)
+		return
+	}
+	if !record.hasResult() {
+		c.JSON(http.StatusConflict, gin.H{"error": fmt.Sprintf("Job %s has no result while it is %s", record.ID, record.Status)})
+		return
+	}
+
+	if record.Type == jobImport {
+		report := append([]importReportRow(nil), record.Report...)
+		sort.Slice(report, func(i, j int) bool { return report[i].Line < report[j].Line })
+		writeImportReport(c, "import-report-"+record.ID+".csv", report)
+		return
+	}
+
+	exporter := exportFormats[record.Format](io.Discard)
+	c.Header("Content-Type", exporter.contentType())
+	c.FileAttachment(jobs.exportPath(record), "students."+exporter.extension())
+}
+
+// writeJobNotFound reports a job that does not exist or belongs to another client
+func writeJobNotFound(c *gin.Context) {
+	c.JSON(http.StatusNotFound, gin.H{"error": fmt.Sprintf("Job not found; jobs can be seen by the client that created them until %s after they finish", cfg.JobRetention)})
+}
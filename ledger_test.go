@@ -0,0 +1,165 @@
+/*
+Copyright 2021 IBM All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/hyperledger/fabric-gateway/pkg/client"
+	"github.com/hyperledger/fabric-protos-go-apiv2/peer"
+)
+
+// ledgerCall is a transaction a fakeLedger was asked to submit or evaluate
+type ledgerCall struct {
+	name      string
+	args      []string
+	transient map[string][]byte
+	submitted bool
+}
+
+// fakeLedger is a ledgerContract that records the transactions it is given, with the transient
+// data they carry, and answers each with respond, or with an empty result if respond is nil.
+// Transactions submitted without waiting for their commit are committed by awaitCommit, or
+// straight away if it is nil.
+type fakeLedger struct {
+	mu          sync.Mutex
+	calls       []ledgerCall
+	respond     func(name string, args []string) ([]byte, error)
+	awaitCommit func(txID string) (*client.Status, error)
+}
+
+func (l *fakeLedger) call(name string, args []string, transient map[string][]byte, submitted bool) ([]byte, error) {
+	l.mu.Lock()
+	l.calls = append(l.calls, ledgerCall{name: name, args: args, transient: transient, submitted: submitted})
+	respond := l.respond
+	l.mu.Unlock()
+
+	if respond == nil {
+		return nil, nil
+	}
+	return respond(name, args)
+}
+
+func (l *fakeLedger) SubmitTransaction(ctx context.Context, name string, args ...string) ([]byte, error) {
+	return l.call(name, args, transientFrom(ctx), true)
+}
+
+func (l *fakeLedger) EvaluateTransaction(ctx context.Context, name string, args ...string) ([]byte, error) {
+	return l.call(name, args, transientFrom(ctx), false)
+}
+
+func (l *fakeLedger) SubmitTransient(_ context.Context, name string, transient map[string][]byte, args ...string) ([]byte, error) {
+	return l.call(name, args, transient, true)
+}
+
+func (l *fakeLedger) EvaluateTransient(_ context.Context, name string, transient map[string][]byte, args ...string) ([]byte, error) {
+	return l.call(name, args, transient, false)
+}
+
+// fakeProposals counts the transactions proposed on every fakeLedger, giving each its own ID
+var fakeProposals atomic.Uint64
+
+func (l *fakeLedger) ProposeTransaction(ctx context.Context, name string, args ...string) (proposedTransaction, error) {
+	return &fakeProposal{ledger: l, txID: fmt.Sprintf("tx%d", fakeProposals.Add(1)), name: name, args: args, transient: transientFrom(ctx)}, nil
+}
+
+// fakeProposal is a transaction proposed on a fakeLedger, submitted to it by Submit
+type fakeProposal struct {
+	ledger    *fakeLedger
+	txID      string
+	name      string
+	args      []string
+	transient map[string][]byte
+}
+
+func (p *fakeProposal) TransactionID() string {
+	return p.txID
+}
+
+func (p *fakeProposal) Submit(context.Context) error {
+	_, err := p.ledger.call(p.name, p.args, p.transient, true)
+	return err
+}
+
+func (p *fakeProposal) AwaitCommit(context.Context) (*client.Status, error) {
+	if p.ledger.awaitCommit != nil {
+		return p.ledger.awaitCommit(p.txID)
+	}
+	return &client.Status{Code: peer.TxValidationCode_VALID, Successful: true, BlockNumber: 1, TransactionID: p.txID}, nil
+}
+
+// submitted returns the transactions the ledger was asked to submit
+func (l *fakeLedger) submitted() []ledgerCall {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	var calls []ledgerCall
+	for _, call := range l.calls {
+		if call.submitted {
+			calls = append(calls, call)
+		}
+	}
+	return calls
+}
+
+// useConfig sets the configuration for the rest of the test, starting from the defaults
+// changed by configure, and restores the previous configuration when the test ends
+func useConfig(t *testing.T, configure func(*Config)) {
+	t.Helper()
+
+	previous, previousCache := cfg, readCache
+	t.Cleanup(func() { cfg, readCache = previous, previousCache })
+
+	cfg = defaultConfig()
+	if configure != nil {
+		configure(&cfg)
+	}
+
+	cache, err := newResponseCache(cfg.CacheTTLs, "")
+	if err != nil {
+		t.Fatalf("creating response cache: %v", err)
+	}
+	readCache = cache
+}
+
+// newTestRouter returns the API router over ledger, with the default configuration changed
+// by configure
+func newTestRouter(t *testing.T, ledger ledgerContract, configure func(*Config)) *gin.Engine {
+	t.Helper()
+
+	gin.SetMode(gin.TestMode)
+	useConfig(t, configure)
+	return setupRouter(ledger)
+}
+
+// serveRequest sends a request with the given body and headers, given as name/value pairs, to the router
+func serveRequest(router http.Handler, method, target, body string, headers ...string) *httptest.ResponseRecorder {
+	var reader io.Reader
+	if body != "" {
+		reader = strings.NewReader(body)
+	}
+	request := httptest.NewRequest(method, target, reader)
+	if body != "" {
+		request.Header.Set("Content-Type", "application/json")
+	}
+	for i := 0; i+1 < len(headers); i += 2 {
+		request.Header.Set(headers[i], headers[i+1])
+	}
+
+	recorder := httptest.NewRecorder()
+	router.ServeHTTP(recorder, request)
+	return recorder
+}
@@ -0,0 +1,534 @@
+/*
+Copyright 2021 IBM All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/hyperledger/fabric-gateway/pkg/client"
+	"github.com/hyperledger/fabric-protos-go-apiv2/peer/lifecycle"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+)
+
+// lifecycleChaincode is the system chaincode that manages chaincode definitions
+const lifecycleChaincode = "_lifecycle"
+
+// ContractVersion describes the committed definition of the configured chaincode
+type ContractVersion struct {
+	Channel      string `json:"channel"`
+	Chaincode    string `json:"chaincode"`
+	Version      string `json:"version"`
+	Sequence     int64  `json:"sequence"`
+	InitRequired bool   `json:"initRequired"`
+}
+
+// lifecycleEvaluator is the part of *client.Contract needed to query the _lifecycle chaincode
+type lifecycleEvaluator interface {
+	EvaluateWithContext(ctx context.Context, transactionName string, options ...client.ProposalOption) ([]byte, error)
+}
+
+// getContractVersion returns the version and sequence of the chaincode definition committed on the channel
+func getContractVersion(c *gin.Context) {
+	channel := channelFrom(c.Request.Context())
+	writeContractVersion(c, connectionFrom(c.Request.Context()).currentNetwork(channel).GetContract(lifecycleChaincode), channel)
+}
+
+// writeContractVersion queries the chaincode definition committed on the channel with the
+// _lifecycle contract and writes its version and sequence
+func writeContractVersion(c *gin.Context, lifecycleContract lifecycleEvaluator, channel string) {
+	requestLogger(c).Info("Querying chaincode definition", "chaincode", chaincodeName)
+
+	definition, err := queryChaincodeDefinition(c.Request.Context(), lifecycleContract, chaincodeName)
+	if err != nil {
+		switch {
+		case isLifecycleAccessDenied(err):
+			c.JSON(http.StatusForbidden, gin.H{"error": "Not permitted to query the chaincode definition", "details": gatewayErrorText(err)})
+		case isChaincodeNotDefined(err):
+			c.JSON(http.StatusNotFound, gin.H{"error": fmt.Sprintf("Chaincode %s is not committed on channel %s", chaincodeName, channel)})
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("Failed to query chaincode definition: %v", err)})
+		}
+		return
+	}
+
+	c.JSON(http.StatusOK, ContractVersion{
+		Channel:      channel,
+		Chaincode:    chaincodeName,
+		Version:      definition.GetVersion(),
+		Sequence:     definition.GetSequence(),
+		InitRequired: definition.GetInitRequired(),
+	})
+}
+
+// queryChaincodeDefinition evaluates _lifecycle QueryChaincodeDefinition for the named chaincode
+func queryChaincodeDefinition(ctx context.Context, lifecycleContract lifecycleEvaluator, name string) (*lifecycle.QueryChaincodeDefinitionResult, error) {
+	args, err := proto.Marshal(&lifecycle.QueryChaincodeDefinitionArgs{Name: name})
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, evaluateTimeout)
+	defer cancel()
+
+	result, err := lifecycleContract.EvaluateWithContext(ctx, "QueryChaincodeDefinition", client.WithBytesArguments(args))
+	if err != nil {
+		return nil, err
+	}
+
+	definition := &lifecycle.QueryChaincodeDefinitionResult{}
+	if err := proto.Unmarshal(result, definition); err != nil {
+		return nil, fmt.Errorf("failed to parse chaincode definition: %w", err)
+	}
+
+	return definition, nil
+}
+
+// installTimeout bounds how long a peer may take to install a chaincode package. Peers build
+// packages that aren't chaincode-as-a-service as they install them, which can take minutes.
+const installTimeout = 5 * time.Minute
+
+// Plugins a chaincode definition uses unless the committed definition names others
+const (
+	defaultEndorsementPlugin = "escc"
+	defaultValidationPlugin  = "vscc"
+)
+
+// ChaincodePackage describes a chaincode package installed on the organization's peers
+type ChaincodePackage struct {
+	PackageID string `json:"packageId"`
+	Label     string `json:"label"`
+	Type      string `json:"type,omitempty"`
+}
+
+// PeerInstallReport is the outcome of installing a chaincode package on one peer
+type PeerInstallReport struct {
+	Endpoint string `json:"endpoint"`
+	Status   string `json:"status"`
+	Error    string `json:"error,omitempty"`
+}
+
+// InstalledChaincodes lists the chaincode packages installed on one peer, and for each, the
+// chaincode definitions using it by channel
+type InstalledChaincodes struct {
+	Endpoint string             `json:"endpoint"`
+	Packages []InstalledPackage `json:"packages"`
+	Error    string             `json:"error,omitempty"`
+}
+
+// InstalledPackage is a chaincode package installed on a peer
+type InstalledPackage struct {
+	PackageID  string                         `json:"packageId"`
+	Label      string                         `json:"label"`
+	References map[string][]ContractReference `json:"references,omitempty"`
+}
+
+// ContractReference names a chaincode definition using an installed package
+type ContractReference struct {
+	Chaincode string `json:"chaincode"`
+	Version   string `json:"version"`
+}
+
+// ChaincodeDefinition describes a chaincode definition approved or committed on the channel
+type ChaincodeDefinition struct {
+	Channel      string `json:"channel"`
+	Chaincode    string `json:"chaincode"`
+	Version      string `json:"version"`
+	Sequence     int64  `json:"sequence"`
+	InitRequired bool   `json:"initRequired"`
+	PackageID    string `json:"packageId,omitempty"`
+}
+
+// CommitReadiness reports which organizations have approved a chaincode definition, and for
+// those that approved a different one, the parts that differ
+type CommitReadiness struct {
+	ChaincodeDefinition
+	Approvals  map[string]bool     `json:"approvals"`
+	Mismatches map[string][]string `json:"mismatches,omitempty"`
+}
+
+// chaincodeDefinitionRequest chooses a chaincode definition to approve, check, or commit. The
+// chaincode defaults to the configured one and the sequence to the one after the committed
+// definition's; everything not chosen is kept from the committed definition, so an upgrade
+// keeps its endorsement policy and private data collections.
+type chaincodeDefinitionRequest struct {
+	Name         string `json:"name" form:"name"`
+	Version      string `json:"version" form:"version" binding:"required"`
+	Sequence     int64  `json:"sequence" form:"sequence" binding:"omitempty,min=1"`
+	InitRequired *bool  `json:"initRequired" form:"initRequired"`
+	// PackageID is the installed package the organization's peers run the chaincode from. Only
+	// approvals use it; without one, the organization approves the definition without running it.
+	PackageID string `json:"packageId" form:"packageId"`
+}
+
+// isChaincodeNotDefined reports whether a lifecycle query failed because the chaincode has no
+// committed definition on the channel
+func isChaincodeNotDefined(err error) bool {
+	text := gatewayErrorText(err)
+	return strings.Contains(text, "not defined") || strings.Contains(text, "not found")
+}
+
+// isLifecycleAccessDenied reports whether a lifecycle call was refused because the identity is
+// not an admin of its organization
+func isLifecycleAccessDenied(err error) bool {
+	return status.Code(err) == codes.PermissionDenied || strings.Contains(gatewayErrorText(err), "access denied")
+}
+
+// writeLifecycleError writes the response for a failed chaincode lifecycle call
+func writeLifecycleError(c *gin.Context, action string, err error) {
+	if isLifecycleAccessDenied(err) {
+		c.JSON(http.StatusForbidden, gin.H{"error": fmt.Sprintf("Not permitted to %s; the organization's identity must be an admin", action), "details": gatewayErrorText(err)})
+		return
+	}
+	writeTransactionError(c, action, err)
+}
+
+// readChaincodePackage returns the label, type, and package ID of a chaincode package, a gzipped
+// tar archive holding metadata.json. Peers identify a package by its label and SHA-256 hash.
+func readChaincodePackage(pkg []byte) (ChaincodePackage, error) {
+	gz, err := gzip.NewReader(bytes.NewReader(pkg))
+	if err != nil {
+		return ChaincodePackage{}, fmt.Errorf("not a gzipped chaincode package: %w", err)
+	}
+	archive := tar.NewReader(gz)
+	for {
+		header, err := archive.Next()
+		if err == io.EOF {
+			return ChaincodePackage{}, errors.New("chaincode package has no metadata.json")
+		}
+		if err != nil {
+			return ChaincodePackage{}, fmt.Errorf("invalid chaincode package: %w", err)
+		}
+		if header.Name != "metadata.json" {
+			continue
+		}
+
+		var metadata struct {
+			Type  string `json:"type"`
+			Label string `json:"label"`
+		}
+		if err := json.NewDecoder(archive).Decode(&metadata); err != nil {
+			return ChaincodePackage{}, fmt.Errorf("invalid chaincode package metadata.json: %w", err)
+		}
+		if metadata.Label == "" {
+			return ChaincodePackage{}, errors.New("chaincode package metadata.json has no label")
+		}
+
+		hash := sha256.Sum256(pkg)
+		return ChaincodePackage{PackageID: metadata.Label + ":" + hex.EncodeToString(hash[:]), Label: metadata.Label, Type: metadata.Type}, nil
+	}
+}
+
+// installChaincode installs the chaincode package sent as the request body, such as a
+// chaincode-as-a-service package, on each of the organization's gateway peers. Installing a
+// package a peer already has succeeds, so a failed install can simply be retried.
+func installChaincode(c *gin.Context) {
+	fc := requireConnection(c)
+	if fc == nil {
+		return
+	}
+
+	pkg, err := io.ReadAll(c.Request.Body)
+	if err != nil {
+		writeBindError(c, err)
+		return
+	}
+	chaincodePackage, err := readChaincodePackage(pkg)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Invalid request body: %v", err)})
+		return
+	}
+
+	logger := requestLogger(c).With("packageId", chaincodePackage.PackageID)
+	logger.Info("Installing chaincode package")
+
+	args, err := proto.Marshal(&lifecycle.InstallChaincodeArgs{ChaincodeInstallPackage: pkg})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("Failed to install chaincode package: %v", err)})
+		return
+	}
+
+	ctx := context.WithoutCancel(c.Request.Context())
+	failed := false
+	reports := make([]PeerInstallReport, 0, len(fc.peers))
+	for _, peer := range fc.peers {
+		report := PeerInstallReport{Endpoint: peer.org.PeerEndpoint, Status: "installed"}
+
+		installCtx, cancel := context.WithTimeout(ctx, callTimeoutFrom(ctx, installTimeout))
+		_, err := peer.processLocalProposal(installCtx, lifecycleChaincode, "InstallChaincode", args)
+		cancel()
+		switch {
+		case err == nil:
+		case strings.Contains(err.Error(), "already successfully installed"):
+			report.Status = "already_installed"
+		default:
+			logger.Warn("Failed to install chaincode package", "endpoint", peer.org.PeerEndpoint, "error", err)
+			report.Status, report.Error, failed = "failed", err.Error(), true
+		}
+		reports = append(reports, report)
+	}
+
+	if failed {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to install the chaincode package on some peers", "package": chaincodePackage, "peers": reports})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"package": chaincodePackage, "peers": reports})
+}
+
+// listInstalledChaincodes lists the chaincode packages installed on each of the organization's gateway peers
+func listInstalledChaincodes(c *gin.Context) {
+	fc := requireConnection(c)
+	if fc == nil {
+		return
+	}
+
+	args, err := proto.Marshal(&lifecycle.QueryInstalledChaincodesArgs{})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("Failed to list installed chaincodes: %v", err)})
+		return
+	}
+
+	ctx := c.Request.Context()
+	peers := make([]InstalledChaincodes, 0, len(fc.peers))
+	for _, peer := range fc.peers {
+		installed := InstalledChaincodes{Endpoint: peer.org.PeerEndpoint, Packages: []InstalledPackage{}}
+
+		queryCtx, cancel := context.WithTimeout(ctx, callTimeoutFrom(ctx, evaluateTimeout))
+		payload, err := peer.processLocalProposal(queryCtx, lifecycleChaincode, "QueryInstalledChaincodes", args)
+		cancel()
+		result := &lifecycle.QueryInstalledChaincodesResult{}
+		if err == nil {
+			err = proto.Unmarshal(payload, result)
+		}
+		if err != nil {
+			installed.Error = err.Error()
+			peers = append(peers, installed)
+			continue
+		}
+
+		for _, chaincode := range result.GetInstalledChaincodes() {
+			pkg := InstalledPackage{PackageID: chaincode.GetPackageId(), Label: chaincode.GetLabel()}
+			for channel, references := range chaincode.GetReferences() {
+				if pkg.References == nil {
+					pkg.References = make(map[string][]ContractReference)
+				}
+				for _, reference := range references.GetChaincodes() {
+					pkg.References[channel] = append(pkg.References[channel], ContractReference{Chaincode: reference.GetName(), Version: reference.GetVersion()})
+				}
+			}
+			installed.Packages = append(installed.Packages, pkg)
+		}
+		peers = append(peers, installed)
+	}
+
+	c.JSON(http.StatusOK, gin.H{"peers": peers})
+}
+
+// resolveChaincodeDefinition returns the chaincode definition chosen by a request, filling in what
+// it leaves out from the definition committed on the request's channel, or for a chaincode not
+// yet committed, from the defaults
+func resolveChaincodeDefinition(ctx context.Context, fc *fabricConnection, request chaincodeDefinitionRequest) (*lifecycle.CommitChaincodeDefinitionArgs, error) {
+	definition := &lifecycle.CommitChaincodeDefinitionArgs{
+		Name:              request.Name,
+		Version:           request.Version,
+		Sequence:          1,
+		EndorsementPlugin: defaultEndorsementPlugin,
+		ValidationPlugin:  defaultValidationPlugin,
+	}
+
+	committed, err := queryChaincodeDefinition(ctx, fc.currentNetwork(channelFrom(ctx)).GetContract(lifecycleChaincode), request.Name)
+	switch {
+	case err == nil:
+		definition.Sequence = committed.GetSequence() + 1
+		definition.EndorsementPlugin = committed.GetEndorsementPlugin()
+		definition.ValidationPlugin = committed.GetValidationPlugin()
+		definition.ValidationParameter = committed.GetValidationParameter()
+		definition.Collections = committed.GetCollections()
+		definition.InitRequired = committed.GetInitRequired()
+	case !isChaincodeNotDefined(err):
+		return nil, err
+	}
+
+	if request.Sequence != 0 {
+		definition.Sequence = request.Sequence
+	}
+	if request.InitRequired != nil {
+		definition.InitRequired = *request.InitRequired
+	}
+	return definition, nil
+}
+
+// bindChaincodeDefinition binds the chaincode definition request from the body, or for GET
+// requests the query, and resolves the definition it chooses. It writes the response and
+// returns nil if the request is invalid or the committed definition cannot be read.
+func bindChaincodeDefinition(c *gin.Context, fc *fabricConnection) (*chaincodeDefinitionRequest, *lifecycle.CommitChaincodeDefinitionArgs) {
+	var request chaincodeDefinitionRequest
+	if err := c.ShouldBind(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Invalid request: %v", err)})
+		return nil, nil
+	}
+	if request.Name == "" {
+		request.Name = chaincodeName
+	}
+
+	definition, err := resolveChaincodeDefinition(c.Request.Context(), fc, request)
+	if err != nil {
+		writeLifecycleError(c, "query the committed chaincode definition", err)
+		return nil, nil
+	}
+	return &request, definition
+}
+
+// chaincodeDefinitionOf describes a chaincode definition on the request's channel
+func chaincodeDefinitionOf(c *gin.Context, definition *lifecycle.CommitChaincodeDefinitionArgs) ChaincodeDefinition {
+	return ChaincodeDefinition{
+		Channel:      channelFrom(c.Request.Context()),
+		Chaincode:    definition.GetName(),
+		Version:      definition.GetVersion(),
+		Sequence:     definition.GetSequence(),
+		InitRequired: definition.GetInitRequired(),
+	}
+}
+
+// submitLifecycle submits a _lifecycle transaction on the request's channel and waits for it to commit
+func submitLifecycle(ctx context.Context, name string, args proto.Message) error {
+	argBytes, err := proto.Marshal(args)
+	if err != nil {
+		return err
+	}
+	_, err = gatewayContract{}.submit(contextWithChaincode(ctx, lifecycleChaincode), name, client.WithBytesArguments(argBytes))
+	return err
+}
+
+// approveChaincode approves a chaincode definition on the channel for the organization chosen
+// for the request, naming the installed package its peers run the chaincode from
+func approveChaincode(c *gin.Context) {
+	fc := requireConnection(c)
+	if fc == nil {
+		return
+	}
+	request, definition := bindChaincodeDefinition(c, fc)
+	if definition == nil {
+		return
+	}
+
+	source := &lifecycle.ChaincodeSource{Type: &lifecycle.ChaincodeSource_Unavailable_{Unavailable: &lifecycle.ChaincodeSource_Unavailable{}}}
+	if request.PackageID != "" {
+		source.Type = &lifecycle.ChaincodeSource_LocalPackage{LocalPackage: &lifecycle.ChaincodeSource_Local{PackageId: request.PackageID}}
+	}
+	args := &lifecycle.ApproveChaincodeDefinitionForMyOrgArgs{
+		Sequence:            definition.GetSequence(),
+		Name:                definition.GetName(),
+		Version:             definition.GetVersion(),
+		EndorsementPlugin:   definition.GetEndorsementPlugin(),
+		ValidationPlugin:    definition.GetValidationPlugin(),
+		ValidationParameter: definition.GetValidationParameter(),
+		Collections:         definition.GetCollections(),
+		InitRequired:        definition.GetInitRequired(),
+		Source:              source,
+	}
+
+	requestLogger(c).Info("Approving chaincode definition", "chaincode", args.Name, "version", args.Version, "sequence", args.Sequence, "packageId", request.PackageID)
+
+	// An approval only records the organization's own choice, so only its own peers endorse it
+	ctx := contextWithEndorsingOrgs(c.Request.Context(), []string{fc.org.MSPID})
+	if err := submitLifecycle(ctx, "ApproveChaincodeDefinitionForMyOrg", args); err != nil {
+		writeLifecycleError(c, "approve the chaincode definition", err)
+		return
+	}
+
+	approved := chaincodeDefinitionOf(c, definition)
+	approved.PackageID = request.PackageID
+	writeWithTransaction(c, http.StatusOK, approved)
+}
+
+// checkCommitReadiness reports which organizations have approved a chaincode definition, so
+// it can be seen whether enough have for it to be committed
+func checkCommitReadiness(c *gin.Context) {
+	fc := requireConnection(c)
+	if fc == nil {
+		return
+	}
+	_, definition := bindChaincodeDefinition(c, fc)
+	if definition == nil {
+		return
+	}
+
+	args, err := proto.Marshal(&lifecycle.CheckCommitReadinessArgs{
+		Sequence:            definition.GetSequence(),
+		Name:                definition.GetName(),
+		Version:             definition.GetVersion(),
+		EndorsementPlugin:   definition.GetEndorsementPlugin(),
+		ValidationPlugin:    definition.GetValidationPlugin(),
+		ValidationParameter: definition.GetValidationParameter(),
+		Collections:         definition.GetCollections(),
+		InitRequired:        definition.GetInitRequired(),
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("Failed to check commit readiness: %v", err)})
+		return
+	}
+
+	ctx := contextWithChaincode(c.Request.Context(), lifecycleChaincode)
+	payload, err := gatewayContract{}.evaluate(ctx, "CheckCommitReadiness", client.WithBytesArguments(args))
+	if err != nil {
+		writeLifecycleError(c, "check commit readiness", err)
+		return
+	}
+	result := &lifecycle.CheckCommitReadinessResult{}
+	if err := proto.Unmarshal(payload, result); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("Failed to parse commit readiness: %v", err)})
+		return
+	}
+
+	readiness := CommitReadiness{ChaincodeDefinition: chaincodeDefinitionOf(c, definition), Approvals: result.GetApprovals()}
+	for mspID := range readiness.Approvals {
+		if mismatches := result.GetMismatches()[mspID].GetItems(); len(mismatches) > 0 {
+			if readiness.Mismatches == nil {
+				readiness.Mismatches = make(map[string][]string)
+			}
+			readiness.Mismatches[mspID] = mismatches
+		}
+	}
+	c.JSON(http.StatusOK, readiness)
+}
+
+// commitChaincode commits a chaincode definition on the channel once enough organizations have
+// approved it. It is endorsed by the organizations named with X-Endorsing-Orgs, which must be
+// enough to satisfy the channel's LifecycleEndorsement policy.
+func commitChaincode(c *gin.Context) {
+	fc := requireConnection(c)
+	if fc == nil {
+		return
+	}
+	_, definition := bindChaincodeDefinition(c, fc)
+	if definition == nil {
+		return
+	}
+
+	requestLogger(c).Info("Committing chaincode definition", "chaincode", definition.Name, "version", definition.Version, "sequence", definition.Sequence)
+
+	if err := submitLifecycle(c.Request.Context(), "CommitChaincodeDefinition", definition); err != nil {
+		writeLifecycleError(c, "commit the chaincode definition", err)
+		return
+	}
+	writeWithTransaction(c, http.StatusOK, chaincodeDefinitionOf(c, definition))
+}
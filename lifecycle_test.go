@@ -0,0 +1,92 @@
+/*
+Copyright 2021 IBM All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/hyperledger/fabric-gateway/pkg/client"
+	"github.com/hyperledger/fabric-protos-go-apiv2/peer/lifecycle"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+)
+
+// stubLifecycle answers QueryChaincodeDefinition with definition, or fails with err
+type stubLifecycle struct {
+	definition *lifecycle.QueryChaincodeDefinitionResult
+	err        error
+	called     string
+}
+
+func (l *stubLifecycle) EvaluateWithContext(_ context.Context, name string, _ ...client.ProposalOption) ([]byte, error) {
+	l.called = name
+	if l.err != nil {
+		return nil, l.err
+	}
+	return proto.Marshal(l.definition)
+}
+
+// serveContractVersion writes the contract version queried from lifecycleContract
+func serveContractVersion(lifecycleContract lifecycleEvaluator) *httptest.ResponseRecorder {
+	recorder := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(recorder)
+	c.Request = httptest.NewRequest(http.MethodGet, "/api/contract/version", nil)
+	writeContractVersion(c, lifecycleContract, "mychannel")
+	return recorder
+}
+
+func TestContractVersion(t *testing.T) {
+	previous := chaincodeName
+	t.Cleanup(func() { chaincodeName = previous })
+	chaincodeName = "studentrecords"
+
+	lifecycleContract := &stubLifecycle{definition: &lifecycle.QueryChaincodeDefinitionResult{Version: "1.2", Sequence: 3, InitRequired: true}}
+	response := serveContractVersion(lifecycleContract)
+	if response.Code != http.StatusOK {
+		t.Fatalf("status = %d, body %s", response.Code, response.Body)
+	}
+	if lifecycleContract.called != "QueryChaincodeDefinition" {
+		t.Errorf("evaluated %q, want QueryChaincodeDefinition", lifecycleContract.called)
+	}
+
+	var version ContractVersion
+	if err := json.Unmarshal(response.Body.Bytes(), &version); err != nil {
+		t.Fatalf("parsing response: %v", err)
+	}
+	want := ContractVersion{Channel: "mychannel", Chaincode: "studentrecords", Version: "1.2", Sequence: 3, InitRequired: true}
+	if version != want {
+		t.Errorf("version = %+v, want %+v", version, want)
+	}
+}
+
+func TestContractVersionErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want int
+	}{
+		{name: "permission denied", err: status.Error(codes.PermissionDenied, "access denied"), want: http.StatusForbidden},
+		{name: "not committed", err: errors.New("chaincode response 500, namespace studentrecords is not defined"), want: http.StatusNotFound},
+		{name: "other failure", err: errors.New("connection reset"), want: http.StatusInternalServerError},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			response := serveContractVersion(&stubLifecycle{err: test.err})
+			if response.Code != test.want {
+				t.Errorf("status = %d, want %d, body %s", response.Code, test.want, response.Body)
+			}
+		})
+	}
+}
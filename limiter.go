@@ -0,0 +1,92 @@
+/*
+Copyright 2021 IBM All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package main
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// concurrencyLimiter caps the number of requests handled at once across all clients,
+// holding a bounded number of excess requests until a slot frees up
+type concurrencyLimiter struct {
+	slots chan struct{}
+	queue chan struct{}
+}
+
+// newConcurrencyLimiter creates a limiter allowing maxInFlight concurrent requests with up
+// to maxQueued more waiting. A maxInFlight of zero disables the limit.
+func newConcurrencyLimiter(maxInFlight, maxQueued int) *concurrencyLimiter {
+	if maxInFlight <= 0 {
+		return &concurrencyLimiter{}
+	}
+	return &concurrencyLimiter{
+		slots: make(chan struct{}, maxInFlight),
+		queue: make(chan struct{}, maxQueued),
+	}
+}
+
+// middleware returns a Gin handler that enforces the limit, replying 503 when the queue is full
+func (l *concurrencyLimiter) middleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if l.slots == nil {
+			inFlightRequests.Inc()
+			defer inFlightRequests.Dec()
+			c.Next()
+			return
+		}
+
+		if !l.acquire(c) {
+			return
+		}
+		defer l.release()
+
+		c.Next()
+	}
+}
+
+// acquire takes an in-flight slot, waiting in the queue if necessary. It aborts the
+// request and returns false if the queue is full or the client gives up waiting.
+func (l *concurrencyLimiter) acquire(c *gin.Context) bool {
+	select {
+	case l.slots <- struct{}{}:
+		inFlightRequests.Inc()
+		return true
+	default:
+	}
+
+	select {
+	case l.queue <- struct{}{}:
+	default:
+		rejectedRequests.Inc()
+		c.Header("Retry-After", "1")
+		c.AbortWithStatusJSON(http.StatusServiceUnavailable, gin.H{"error": "Server is busy, try again later"})
+		return false
+	}
+
+	queuedRequests.Inc()
+	defer func() {
+		<-l.queue
+		queuedRequests.Dec()
+	}()
+
+	select {
+	case l.slots <- struct{}{}:
+		inFlightRequests.Inc()
+		return true
+	case <-c.Request.Context().Done():
+		c.Abort()
+		return false
+	}
+}
+
+// release frees the in-flight slot held by a finished request
+func (l *concurrencyLimiter) release() {
+	<-l.slots
+	inFlightRequests.Dec()
+}
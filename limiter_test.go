@@ -0,0 +1,80 @@
+/*
+Copyright 2021 IBM All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package main
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestConcurrencyLimiterRejectsBeyondCapAndQueue(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	limiter := newConcurrencyLimiter(1, 1)
+
+	entered := make(chan struct{}, 2)
+	release := make(chan struct{})
+	router := gin.New()
+	router.GET("/slow", limiter.middleware(), func(c *gin.Context) {
+		entered <- struct{}{}
+		<-release
+		c.Status(http.StatusOK)
+	})
+
+	codes := make(chan int, 2)
+	for i := 0; i < 2; i++ {
+		go func() {
+			codes <- serveRequest(router, http.MethodGet, "/slow", "").Code
+		}()
+	}
+
+	// One request holds the only slot and the other waits in the queue
+	<-entered
+	waitFor(t, func() bool { return len(limiter.queue) == 1 })
+
+	response := serveRequest(router, http.MethodGet, "/slow", "")
+	if response.Code != http.StatusServiceUnavailable {
+		t.Errorf("status beyond the cap and queue = %d, want 503", response.Code)
+	}
+	if response.Header().Get("Retry-After") == "" {
+		t.Error("503 has no Retry-After")
+	}
+
+	close(release)
+	for i := 0; i < 2; i++ {
+		if code := <-codes; code != http.StatusOK {
+			t.Errorf("status of a request within the cap and queue = %d, want 200", code)
+		}
+	}
+}
+
+func TestConcurrencyLimitIsExposedAsMetrics(t *testing.T) {
+	router := newTestRouter(t, &fakeLedger{}, nil)
+
+	response := serveRequest(router, http.MethodGet, "/metrics", "")
+	for _, name := range []string{"rest_api_in_flight_requests", "rest_api_queued_requests"} {
+		if !strings.Contains(response.Body.String(), name) {
+			t.Errorf("/metrics does not report %s", name)
+		}
+	}
+}
+
+// waitFor polls condition until it holds, failing the test if it does not within a second
+func waitFor(t *testing.T, condition func() bool) {
+	t.Helper()
+
+	deadline := time.Now().Add(time.Second)
+	for !condition() {
+		if time.Now().After(deadline) {
+			t.Fatal("timed out waiting for condition")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
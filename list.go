@@ -0,0 +1,254 @@
+/*
+Copyright 2021 IBM All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+// listPageSize is the number of students fetched from the ledger per page while streaming a list
+const listPageSize = 200
+
+// Media types a student list can be sent as, besides JSON
+const (
+	mimeCSV    = "text/csv"
+	mimeNDJSON = "application/x-ndjson"
+)
+
+// studentListFormats are the media types a student list can be sent as, by the Accept header,
+// with JSON first as the default
+var studentListFormats = []string{gin.MIMEJSON, mimeNDJSON, mimeCSV}
+
+// studentListEncoders write a student list in each of the formats, a student at a time
+var studentListEncoders = map[string]func(w io.Writer) studentListEncoder{
+	gin.MIMEJSON: newJSONListEncoder,
+	mimeNDJSON:   newNDJSONListEncoder,
+	mimeCSV:      newCSVListEncoder,
+}
+
+// studentListEncoder writes a list of student records in one format
+type studentListEncoder interface {
+	contentType() string
+
+	// begin writes anything the list starts with, write writes a student, and end finishes the list
+	begin()
+	write(student map[string]interface{})
+	end()
+
+	// flush sends what has been written so far, returning an error if the client stopped reading
+	flush() error
+}
+
+// jsonListEncoder writes students as a JSON array
+type jsonListEncoder struct {
+	writer *bufio.Writer
+	count  int
+	err    error
+}
+
+func newJSONListEncoder(w io.Writer) studentListEncoder {
+	return &jsonListEncoder{writer: bufio.NewWriter(w)}
+}
+
+func (e *jsonListEncoder) contentType() string { return "application/json; charset=utf-8" }
+func (e *jsonListEncoder) begin()              { e.writer.WriteByte('[') }
+func (e *jsonListEncoder) end()                { e.writer.WriteByte(']') }
+
+func (e *jsonListEncoder) write(student map[string]interface{}) {
+	data, err := json.Marshal(student)
+	if err != nil {
+		e.err = err
+		return
+	}
+	if e.count > 0 {
+		e.writer.WriteByte(',')
+	}
+	e.writer.Write(data)
+	e.count++
+}
+
+func (e *jsonListEncoder) flush() error {
+	if e.err != nil {
+		return e.err
+	}
+	return e.writer.Flush()
+}
+
+// ndjsonListEncoder writes students as newline-delimited JSON, one record per line
+type ndjsonListEncoder struct {
+	writer *bufio.Writer
+	err    error
+}
+
+func newNDJSONListEncoder(w io.Writer) studentListEncoder {
+	return &ndjsonListEncoder{writer: bufio.NewWriter(w)}
+}
+
+func (e *ndjsonListEncoder) contentType() string { return mimeNDJSON }
+func (e *ndjsonListEncoder) begin()              {}
+func (e *ndjsonListEncoder) end()                {}
+
+func (e *ndjsonListEncoder) write(student map[string]interface{}) {
+	data, err := json.Marshal(student)
+	if err != nil {
+		e.err = err
+		return
+	}
+	e.writer.Write(data)
+	e.writer.WriteByte('\n')
+}
+
+func (e *ndjsonListEncoder) flush() error {
+	if e.err != nil {
+		return e.err
+	}
+	return e.writer.Flush()
+}
+
+// csvListEncoder writes students as CSV with the export's columns. Fields outside those columns
+// are left out.
+type csvListEncoder struct {
+	writer *csv.Writer
+	row    []string
+}
+
+func newCSVListEncoder(w io.Writer) studentListEncoder {
+	return &csvListEncoder{writer: csv.NewWriter(w), row: make([]string, len(csvColumns))}
+}
+
+func (e *csvListEncoder) contentType() string { return "text/csv; charset=utf-8" }
+func (e *csvListEncoder) begin()              { e.writer.Write(csvColumns) }
+func (e *csvListEncoder) end()                {}
+
+func (e *csvListEncoder) write(student map[string]interface{}) {
+	for i, column := range csvColumns {
+		e.row[i] = ""
+		if value, ok := student[column]; ok && value != nil {
+			e.row[i] = fmt.Sprint(value)
+		}
+	}
+	e.writer.Write(e.row)
+}
+
+func (e *csvListEncoder) flush() error {
+	e.writer.Flush()
+	return e.writer.Error()
+}
+
+// acceptsStudentList reports whether the request's Accept header allows a format a student list
+// can be sent as, writing a 406 response if it does not
+func acceptsStudentList(c *gin.Context) bool {
+	if c.NegotiateFormat(studentListFormats...) == "" {
+		c.JSON(http.StatusNotAcceptable, gin.H{"error": "Student lists can be sent as application/json, application/x-ndjson, or text/csv"})
+		return false
+	}
+	return true
+}
+
+// writeStudentList writes a list of students as JSON or, if the Accept header prefers it, as
+// NDJSON or CSV
+func writeStudentList(c *gin.Context, students []map[string]interface{}) {
+	c.Writer.Header().Add("Vary", "Accept")
+	format := c.NegotiateFormat(studentListFormats...)
+	if format == gin.MIMEJSON {
+		c.JSON(http.StatusOK, students)
+		return
+	}
+
+	encoder := studentListEncoders[format](c.Writer)
+	c.Header("Content-Type", encoder.contentType())
+	c.Status(http.StatusOK)
+
+	encoder.begin()
+	for _, student := range students {
+		encoder.write(student)
+	}
+	encoder.end()
+	if err := encoder.flush(); err != nil {
+		requestLogger(c).Warn("Failed to write student list, client stopped reading", "error", err)
+	}
+}
+
+// streamStudentList writes every student that passes the filter in ID order, in the format the
+// Accept header prefers, fetching them from the ledger a page at a time and sending each page as
+// it arrives, so a large list is never held in memory. A failure after the first page can only
+// truncate the list, which leaves a JSON array unterminated.
+func streamStudentList(c *gin.Context, filter studentFilter) {
+	// Fetch the first page before writing anything, so a failure can still be reported with a status code
+	page, err := fetchStudentRecordsPage(c.Request.Context(), requestLedger(c), "")
+	if err != nil {
+		writeTransactionError(c, "get students", err)
+		return
+	}
+
+	c.Writer.Header().Add("Vary", "Accept")
+	encoder := studentListEncoders[c.NegotiateFormat(studentListFormats...)](c.Writer)
+	c.Header("Content-Type", encoder.contentType())
+	c.Status(http.StatusOK)
+
+	encoder.begin()
+
+	sent := 0
+	for {
+		for _, student := range page.Students {
+			if filter.matches(student) {
+				encoder.write(student)
+				sent++
+			}
+		}
+
+		if err := encoder.flush(); err != nil {
+			requestLogger(c).Warn("Student list aborted, client stopped reading", "sent", sent, "error", err)
+			return
+		}
+		c.Writer.Flush()
+
+		if page.Bookmark == "" {
+			break
+		}
+
+		if page, err = fetchStudentRecordsPage(c.Request.Context(), requestLedger(c), page.Bookmark); err != nil {
+			requestLogger(c).Error("Student list aborted, failed to get students", "sent", sent, "error", err)
+			return
+		}
+	}
+
+	encoder.end()
+	if err := encoder.flush(); err != nil {
+		requestLogger(c).Warn("Student list aborted, client stopped reading", "sent", sent, "error", err)
+	}
+}
+
+// studentRecordsPage is one page of complete student records returned by the GetStudentsPage
+// chaincode function
+type studentRecordsPage struct {
+	Students []map[string]interface{} `json:"students"`
+	Bookmark string                   `json:"bookmark"`
+}
+
+// fetchStudentRecordsPage evaluates one page of complete student records starting from the given bookmark
+func fetchStudentRecordsPage(ctx context.Context, ledger ledgerContract, bookmark string) (studentRecordsPage, error) {
+	var page studentRecordsPage
+
+	result, err := ledger.EvaluateTransaction(ctx, "GetStudentsPage", strconv.Itoa(listPageSize), bookmark)
+	if err != nil {
+		return page, err
+	}
+	if err := json.Unmarshal(result, &page); err != nil {
+		return page, fmt.Errorf("failed to parse student data: %w", err)
+	}
+	return page, nil
+}
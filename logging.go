@@ -0,0 +1,210 @@
+/*
+Copyright 2021 IBM All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"log/slog"
+	"mime"
+	"net/http"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// requestIDHeader carries the correlation ID of a request in both directions
+const requestIDHeader = "X-Request-ID"
+
+// validRequestID matches client-supplied request IDs that are safe to echo and log
+var validRequestID = regexp.MustCompile(`^[A-Za-z0-9._-]{1,64}$`)
+
+// requestIDTransientKey is the transient data key under which transaction proposals carry the
+// request ID, so the chaincode can tell which API call a proposal came from. Transient data is
+// not recorded in the transaction.
+const requestIDTransientKey = "requestId"
+
+// loggerKey is the context key under which the request-scoped logger is stored
+type loggerKey struct{}
+
+// requestIDKey is the context key under which the request ID is stored
+type requestIDKey struct{}
+
+// failureKey is the Gin context key under which a handler records why a request failed, for the access log
+const failureKey = "failure"
+
+// logLevel is the lowest level logged, set from LOG_LEVEL once the configuration is loaded
+var logLevel = new(slog.LevelVar)
+
+// quietRoutes are the operational routes polled by probes and scrapers, whose access logs are
+// written at debug level so they do not drown out the API's
+var quietRoutes = map[string]bool{"/metrics": true, "/health": true, "/ready": true, "/healthz": true, "/readyz": true}
+
+// initLogging makes JSON the output format for all logging, including the standard log package
+func initLogging() {
+	slog.SetDefault(slog.New(slog.NewJSONHandler(os.Stdout, &slog.HandlerOptions{Level: logLevel})))
+}
+
+// requestLoggingMiddleware propagates the caller's X-Request-ID, or generates one, echoes it
+// in the response, and attaches a logger carrying it to the request context. Once the request
+// has been handled it writes an access log line with the route, the student and transaction
+// it concerned, its latency, and its outcome.
+func requestLoggingMiddleware(c *gin.Context) {
+	start := time.Now()
+
+	requestID := c.GetHeader(requestIDHeader)
+	if !validRequestID.MatchString(requestID) {
+		var err error
+		if requestID, err = randomHex(16); err != nil {
+			slog.Error("Failed to generate request ID", "error", err)
+		}
+	}
+
+	c.Header(requestIDHeader, requestID)
+	c.Writer = &requestIDWriter{ResponseWriter: c.Writer, c: c, requestID: requestID}
+
+	logger := slog.Default().With("requestId", requestID)
+	if span := trace.SpanContextFromContext(c.Request.Context()); span.IsValid() {
+		logger = logger.With("traceId", span.TraceID().String())
+	}
+	ctx := context.WithValue(c.Request.Context(), requestIDKey{}, requestID)
+	c.Request = c.Request.WithContext(context.WithValue(ctx, loggerKey{}, logger))
+
+	c.Next()
+
+	status := c.Writer.Status()
+	attrs := []any{
+		"method", c.Request.Method,
+		"path", c.Request.URL.Path,
+		"endpoint", routePattern(c),
+		"status", status,
+		"outcome", requestOutcome(c, status),
+		"latencyMs", time.Since(start).Milliseconds(),
+		"clientIp", c.ClientIP(),
+	}
+	if id := c.Param("id"); id != "" && strings.Contains(c.FullPath(), "/students/:id") {
+		attrs = append(attrs, "studentId", id)
+	}
+	if info := transactionInfoFrom(c.Request.Context()); info != nil && info.TransactionID != "" {
+		attrs = append(attrs, "transactionId", info.TransactionID)
+	}
+
+	level := slog.LevelInfo
+	switch {
+	case status >= http.StatusInternalServerError:
+		level = slog.LevelError
+	case quietRoutes[c.FullPath()]:
+		level = slog.LevelDebug
+	}
+	logger.Log(c.Request.Context(), level, "Request handled", attrs...)
+}
+
+// requestOutcome sums up how a request went: "success", the failure a handler recorded, such
+// as "mvcc_conflict", or else "client_error" or "server_error" by the status code
+func requestOutcome(c *gin.Context, status int) string {
+	switch {
+	case status < http.StatusBadRequest:
+		return "success"
+	case c.GetString(failureKey) != "":
+		return c.GetString(failureKey)
+	case status < http.StatusInternalServerError:
+		return "client_error"
+	default:
+		return "server_error"
+	}
+}
+
+// loggerFrom returns the logger attached to the context, or the default logger if there is none
+func loggerFrom(ctx context.Context) *slog.Logger {
+	if logger, ok := ctx.Value(loggerKey{}).(*slog.Logger); ok {
+		return logger
+	}
+	return slog.Default()
+}
+
+// requestIDFrom returns the ID of the request ctx belongs to, or "" outside a request
+func requestIDFrom(ctx context.Context) string {
+	requestID, _ := ctx.Value(requestIDKey{}).(string)
+	return requestID
+}
+
+// transientWithRequestID returns a copy of the transient data of a proposal with the ID of the
+// request ctx belongs to added, unless the data already has a value under that key
+func transientWithRequestID(ctx context.Context, transient map[string][]byte) map[string][]byte {
+	requestID := requestIDFrom(ctx)
+	if _, ok := transient[requestIDTransientKey]; requestID == "" || ok {
+		return transient
+	}
+
+	withID := make(map[string][]byte, len(transient)+1)
+	for key, value := range transient {
+		withID[key] = value
+	}
+	withID[requestIDTransientKey] = []byte(requestID)
+	return withID
+}
+
+// requestIDWriter adds the request ID to JSON error bodies, so a client reporting a failure can
+// quote it. Under an API root that wraps responses in envelopes, the envelope's meta carries it.
+type requestIDWriter struct {
+	gin.ResponseWriter
+	c         *gin.Context
+	requestID string
+}
+
+// Write adds the request ID to the body if it is a JSON error object. Error bodies are
+// rendered by a single call.
+func (w *requestIDWriter) Write(data []byte) (int, error) {
+	if w.Status() < http.StatusBadRequest || w.Written() || usesEnvelope(w.c) {
+		return w.ResponseWriter.Write(data)
+	}
+	mediaType, _, _ := mime.ParseMediaType(w.Header().Get("Content-Type"))
+	if mediaType != "application/json" {
+		return w.ResponseWriter.Write(data)
+	}
+
+	var body map[string]json.RawMessage
+	if err := json.Unmarshal(data, &body); err != nil {
+		return w.ResponseWriter.Write(data)
+	}
+	if _, ok := body["requestId"]; !ok {
+		body["requestId"], _ = json.Marshal(w.requestID)
+	}
+	withID, err := json.Marshal(body)
+	if err != nil {
+		return w.ResponseWriter.Write(data)
+	}
+	if _, err := w.ResponseWriter.Write(withID); err != nil {
+		return 0, err
+	}
+	return len(data), nil
+}
+
+// WriteString adds the request ID to the body like Write
+func (w *requestIDWriter) WriteString(s string) (int, error) {
+	return w.Write([]byte(s))
+}
+
+// requestLogger returns the logger for the request being handled
+func requestLogger(c *gin.Context) *slog.Logger {
+	return loggerFrom(c.Request.Context())
+}
+
+// randomHex returns n random bytes encoded as hex
+func randomHex(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
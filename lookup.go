@@ -0,0 +1,139 @@
+/*
+Copyright 2021 IBM All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	// maxLookupIDs bounds the students a single lookup may ask for
+	maxLookupIDs = 1000
+
+	// lookupConcurrency is how many reads of a lookup are evaluated at once
+	lookupConcurrency = 16
+)
+
+// lookupRequest is the body of a request to read several students by ID
+type lookupRequest struct {
+	IDs []string `json:"ids" binding:"required,min=1,max=1000,dive,required"`
+}
+
+// lookupFailure is the first read of a lookup that failed for a reason other than the student
+// not existing
+type lookupFailure struct {
+	id  string
+	err error
+}
+
+// lookupStudents reads the students named in the request, evaluating the reads concurrently,
+// and returns the records found together with the IDs that do not exist. Any other failure
+// to read a student fails the whole lookup.
+func lookupStudents(c *gin.Context) {
+	var request lookupRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		writeBindError(c, err)
+		return
+	}
+
+	ids := uniqueIDs(request.IDs)
+	requestLogger(c).Info("Looking up students", "count", len(ids))
+
+	students, failure := readStudents(c.Request.Context(), requestLedger(c), ids)
+	if failure != nil {
+		writeTransactionError(c, "read student "+failure.id, failure.err)
+		return
+	}
+
+	found := make([]json.RawMessage, 0, len(ids))
+	missing := []string{}
+	for i, student := range students {
+		if student == nil {
+			missing = append(missing, ids[i])
+			continue
+		}
+		found = append(found, student)
+	}
+
+	c.JSON(http.StatusOK, gin.H{"found": found, "missing": missing})
+}
+
+// readStudents evaluates ReadStudent for each ID, at most lookupConcurrency at a time, and
+// returns the records in the order of the IDs, with nil for a student that does not exist.
+// Once a read fails for any other reason, the reads not yet started are abandoned and that
+// failure is returned.
+func readStudents(ctx context.Context, contract ledgerContract, ids []string) ([]json.RawMessage, *lookupFailure) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	students := make([]json.RawMessage, len(ids))
+	slots := make(chan struct{}, lookupConcurrency)
+	var wg sync.WaitGroup
+
+	var failed sync.Once
+	var failure *lookupFailure
+	fail := func(id string, err error) {
+		failed.Do(func() {
+			failure = &lookupFailure{id: id, err: err}
+			cancel()
+		})
+	}
+
+	for i, id := range ids {
+		select {
+		case slots <- struct{}{}:
+		case <-ctx.Done():
+		}
+		if ctx.Err() != nil {
+			// The request was cancelled, or a read failed, before this read could start
+			fail(id, ctx.Err())
+			break
+		}
+
+		wg.Add(1)
+		go func(i int, id string) {
+			defer wg.Done()
+			defer func() { <-slots }()
+
+			result, err := contract.EvaluateTransaction(ctx, "ReadStudent", id)
+			if err != nil {
+				if !strings.Contains(gatewayErrorText(err), "does not exist") {
+					fail(id, err)
+				}
+				return
+			}
+			if !json.Valid(result) {
+				fail(id, fmt.Errorf("failed to parse student data"))
+				return
+			}
+			students[i] = result
+		}(i, id)
+	}
+
+	wg.Wait()
+	return students, failure
+}
+
+// uniqueIDs returns the IDs without repeats, keeping the order in which they first appear
+func uniqueIDs(ids []string) []string {
+	seen := make(map[string]bool, len(ids))
+	unique := make([]string, 0, len(ids))
+	for _, id := range ids {
+		if !seen[id] {
+			seen[id] = true
+			unique = append(unique, id)
+		}
+	}
+	return unique
+}
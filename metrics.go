@@ -0,0 +1,154 @@
+/*
+Copyright 2021 IBM All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package main
+
+import (
+	"errors"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/hyperledger/fabric-gateway/pkg/client"
+	"github.com/hyperledger/fabric-protos-go-apiv2/peer"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"google.golang.org/grpc/status"
+)
+
+// metricsRegistry holds the metrics exposed on /metrics. A dedicated registry keeps
+// them separate from anything registered globally by dependencies, and since the
+// metrics are registered once at package initialization they can never be registered twice.
+var metricsRegistry = prometheus.NewRegistry()
+
+var (
+	inFlightRequests = promauto.With(metricsRegistry).NewGauge(prometheus.GaugeOpts{
+		Name: "rest_api_in_flight_requests",
+		Help: "Number of API requests currently being handled.",
+	})
+	queuedRequests = promauto.With(metricsRegistry).NewGauge(prometheus.GaugeOpts{
+		Name: "rest_api_queued_requests",
+		Help: "Number of API requests waiting for an in-flight slot.",
+	})
+	rejectedRequests = promauto.With(metricsRegistry).NewCounter(prometheus.CounterOpts{
+		Name: "rest_api_rejected_requests_total",
+		Help: "Number of API requests rejected because the queue was full.",
+	})
+	rateLimitedRequests = promauto.With(metricsRegistry).NewCounter(prometheus.CounterOpts{
+		Name: "rest_api_rate_limited_requests_total",
+		Help: "Number of API requests rejected because the client exceeded its rate limit.",
+	})
+	backpressureRejectedRequests = promauto.With(metricsRegistry).NewCounter(prometheus.CounterOpts{
+		Name: "rest_api_backpressure_rejected_requests_total",
+		Help: "Number of write requests rejected because recent submit latency exceeded the backpressure threshold.",
+	})
+	eventStreamClients = promauto.With(metricsRegistry).NewGaugeVec(prometheus.GaugeOpts{
+		Name: "rest_api_event_stream_clients",
+		Help: "Number of clients connected to an event stream, by stream.",
+	}, []string{"stream"})
+	webhookDeliveries = promauto.With(metricsRegistry).NewCounterVec(prometheus.CounterOpts{
+		Name: "rest_api_webhook_deliveries_total",
+		Help: "Webhook delivery attempts by outcome: delivered, retried, or dead_lettered.",
+	}, []string{"outcome"})
+	requestDuration = promauto.With(metricsRegistry).NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "rest_api_request_duration_seconds",
+		Help:    "End-to-end latency of API requests by route.",
+		Buckets: []float64{0.01, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60},
+	}, []string{"method", "route", "status"})
+	fabricTransactions = promauto.With(metricsRegistry).NewCounterVec(prometheus.CounterOpts{
+		Name: "fabric_transactions_total",
+		Help: "Number of transactions submitted or evaluated by chaincode function and outcome.",
+	}, []string{"type", "function", "outcome"})
+	fabricTransactionDuration = promauto.With(metricsRegistry).NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "fabric_transaction_duration_seconds",
+		Help:    "Latency of submitted transactions, from endorsement to commit status, and of evaluated transactions, by chaincode function and outcome.",
+		Buckets: []float64{0.01, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60},
+	}, []string{"type", "function", "outcome"})
+	endorsementFailures = promauto.With(metricsRegistry).NewCounterVec(prometheus.CounterOpts{
+		Name: "fabric_endorsement_failures_total",
+		Help: "Number of transactions that failed endorsement, by chaincode function and reason.",
+	}, []string{"function", "reason"})
+	connectionState = promauto.With(metricsRegistry).NewGaugeVec(prometheus.GaugeOpts{
+		Name: "fabric_connection_state",
+		Help: "gRPC connectivity state of each organization's connection to each gateway peer: 0 idle, 1 connecting, 2 ready, 3 transient failure, 4 shutdown.",
+	}, []string{"org", "endpoint"})
+	connectionRebuilds = promauto.With(metricsRegistry).NewCounterVec(prometheus.CounterOpts{
+		Name: "fabric_connection_rebuilds_total",
+		Help: "Number of times each organization's connection to each gateway peer was rebuilt after losing the peer.",
+	}, []string{"org", "endpoint"})
+	identityReloads = promauto.With(metricsRegistry).NewCounterVec(prometheus.CounterOpts{
+		Name: "fabric_identity_reloads_total",
+		Help: "Number of times the identity of a connection was reloaded, by organization and outcome.",
+	}, []string{"org", "outcome"})
+	circuitBreakerState = promauto.With(metricsRegistry).NewGaugeVec(prometheus.GaugeOpts{
+		Name: "fabric_circuit_breaker_state",
+		Help: "State of the circuit breaker of each peer endpoint: 0 closed, 1 open, 2 half-open.",
+	}, []string{"endpoint"})
+	commitStatuses = promauto.With(metricsRegistry).NewCounterVec(prometheus.CounterOpts{
+		Name: "fabric_commit_status_total",
+		Help: "Number of committed transactions by chaincode function and validation code, such as VALID or MVCC_READ_CONFLICT.",
+	}, []string{"function", "code"})
+	mirrorBlockNumber = promauto.With(metricsRegistry).NewGauge(prometheus.GaugeOpts{
+		Name: "state_mirror_block_number",
+		Help: "Number of the last block applied to the PostgreSQL state mirror.",
+	})
+	snapshotRuns = promauto.With(metricsRegistry).NewCounterVec(prometheus.CounterOpts{
+		Name: "ledger_snapshots_total",
+		Help: "Number of scheduled ledger snapshots by outcome, success or failure.",
+	}, []string{"outcome"})
+	lastSnapshotTime = promauto.With(metricsRegistry).NewGauge(prometheus.GaugeOpts{
+		Name: "ledger_snapshot_last_success_timestamp_seconds",
+		Help: "Unix time at which the last successful ledger snapshot finished.",
+	})
+)
+
+// metricsMiddleware records the latency of each API request against its route pattern
+func metricsMiddleware(c *gin.Context) {
+	start := time.Now()
+	c.Next()
+
+	// Use the route pattern rather than the raw path so IDs don't explode the label set
+	route := c.FullPath()
+	if route == "" {
+		route = "unmatched"
+	}
+	requestDuration.WithLabelValues(c.Request.Method, route, strconv.Itoa(c.Writer.Status())).Observe(time.Since(start).Seconds())
+}
+
+// recordTransaction counts a submitted or evaluated transaction by its outcome and records its
+// latency since start, along with why endorsement failed or the code it committed with
+func recordTransaction(txType string, function string, start time.Time, err error) {
+	outcome := transactionOutcome(err)
+	fabricTransactions.WithLabelValues(txType, function, outcome).Inc()
+	fabricTransactionDuration.WithLabelValues(txType, function, outcome).Observe(time.Since(start).Seconds())
+
+	var endorseErr *client.EndorseError
+	var commitFailedErr *commitFailedError
+	switch {
+	case errors.As(err, &endorseErr):
+		endorsementFailures.WithLabelValues(function, endorsementFailureReason(err)).Inc()
+	case errors.As(err, &commitFailedErr):
+		commitStatuses.WithLabelValues(function, commitFailedErr.Code.String()).Inc()
+	case err == nil && txType == "submit":
+		// Submits are only recorded without an error once they have committed
+		commitStatuses.WithLabelValues(function, peer.TxValidationCode_VALID.String()).Inc()
+	}
+}
+
+// endorsementFailureReason labels an endorsement failure with the gateway failure it
+// represents, or else its gRPC status code
+func endorsementFailureReason(err error) string {
+	if failure := classifyGatewayFailure(err); failure != "" {
+		return failure
+	}
+	return status.Code(err).String()
+}
+
+// metricsHandler serves the registered metrics in the Prometheus exposition format
+func metricsHandler() gin.HandlerFunc {
+	return gin.WrapH(promhttp.HandlerFor(metricsRegistry, promhttp.HandlerOpts{}))
+}
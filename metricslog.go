@@ -0,0 +1,131 @@
+/*
+Copyright 2021 IBM All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package main
+
+import (
+	"log/slog"
+	"strings"
+	"time"
+
+	dto "github.com/prometheus/client_model/go"
+)
+
+// metricsSnapshot holds cumulative totals read from the metrics registry
+type metricsSnapshot struct {
+	requests     uint64
+	serverErrors uint64
+	latencySum   float64
+	transactions map[string]float64
+}
+
+// metricsSummary describes the activity over one logging interval
+type metricsSummary struct {
+	IntervalSeconds float64            `json:"intervalSeconds"`
+	Requests        uint64             `json:"requests"`
+	ServerErrors    uint64             `json:"serverErrors"`
+	ErrorRate       float64            `json:"errorRate"`
+	MeanLatencyMs   float64            `json:"meanLatencyMs"`
+	Transactions    map[string]float64 `json:"transactions"`
+}
+
+// logMetricsPeriodically logs a summary of the metrics recorded during each interval.
+// It runs until the process exits, and does nothing if the interval is not positive.
+func logMetricsPeriodically(interval time.Duration) {
+	if interval <= 0 {
+		return
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	logMetricsSummaries(ticker.C, interval)
+}
+
+// logMetricsSummaries logs a summary of the metrics recorded since the previous tick on each
+// tick, until ticks is closed
+func logMetricsSummaries(ticks <-chan time.Time, interval time.Duration) {
+	previous, err := takeMetricsSnapshot()
+	if err != nil {
+		slog.Error("Failed to read metrics", "error", err)
+	}
+
+	for range ticks {
+		current, err := takeMetricsSnapshot()
+		if err != nil {
+			slog.Error("Failed to read metrics", "error", err)
+			continue
+		}
+
+		slog.Info("Metrics summary", "summary", summarizeMetrics(previous, current, interval))
+
+		previous = current
+	}
+}
+
+// takeMetricsSnapshot reads the current request and transaction totals from the registry
+func takeMetricsSnapshot() (metricsSnapshot, error) {
+	snapshot := metricsSnapshot{transactions: make(map[string]float64)}
+
+	families, err := metricsRegistry.Gather()
+	if err != nil {
+		return snapshot, err
+	}
+
+	for _, family := range families {
+		switch family.GetName() {
+		case "rest_api_request_duration_seconds":
+			for _, metric := range family.GetMetric() {
+				histogram := metric.GetHistogram()
+				snapshot.requests += histogram.GetSampleCount()
+				snapshot.latencySum += histogram.GetSampleSum()
+				if strings.HasPrefix(labelValue(metric, "status"), "5") {
+					snapshot.serverErrors += histogram.GetSampleCount()
+				}
+			}
+		case "fabric_transactions_total":
+			for _, metric := range family.GetMetric() {
+				key := labelValue(metric, "type") + "_" + labelValue(metric, "outcome")
+				snapshot.transactions[key] += metric.GetCounter().GetValue()
+			}
+		}
+	}
+
+	return snapshot, nil
+}
+
+// summarizeMetrics describes the activity between two snapshots taken interval apart
+func summarizeMetrics(previous, current metricsSnapshot, interval time.Duration) metricsSummary {
+	summary := metricsSummary{
+		IntervalSeconds: interval.Seconds(),
+		Requests:        current.requests - previous.requests,
+		ServerErrors:    current.serverErrors - previous.serverErrors,
+		Transactions:    make(map[string]float64),
+	}
+
+	if summary.Requests > 0 {
+		summary.ErrorRate = float64(summary.ServerErrors) / float64(summary.Requests)
+		summary.MeanLatencyMs = (current.latencySum - previous.latencySum) / float64(summary.Requests) * 1000
+	}
+
+	for key, count := range current.transactions {
+		if delta := count - previous.transactions[key]; delta > 0 {
+			summary.Transactions[key] = delta
+		}
+	}
+
+	return summary
+}
+
+// labelValue returns the value of the named label on a metric, or "" if it has none
+func labelValue(metric *dto.Metric, name string) string {
+	for _, label := range metric.GetLabel() {
+		if label.GetName() == name {
+			return label.GetValue()
+		}
+	}
+	return ""
+}
@@ -0,0 +1,89 @@
+/*
+Copyright 2021 IBM All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"log"
+	"log/slog"
+	"testing"
+	"time"
+)
+
+// summaryLines is a log output that sends each metrics summary written to it on the channel,
+// discarding the lines logged by other tests' background work
+type summaryLines chan []byte
+
+func (l summaryLines) Write(line []byte) (int, error) {
+	if bytes.Contains(line, []byte(`"msg":"Metrics summary"`)) {
+		l <- append([]byte(nil), line...)
+	}
+	return len(line), nil
+}
+
+func TestMetricsSummaryLoggedEachInterval(t *testing.T) {
+	// Setting the default logger also sends the log package's output to it, which restoring
+	// the previous default does not undo
+	lines := make(summaryLines, 1)
+	previous, previousOutput, previousFlags := slog.Default(), log.Writer(), log.Flags()
+	slog.SetDefault(slog.New(slog.NewJSONHandler(lines, nil)))
+	t.Cleanup(func() {
+		slog.SetDefault(previous)
+		log.SetOutput(previousOutput)
+		log.SetFlags(previousFlags)
+	})
+
+	ticks := make(chan time.Time)
+	go logMetricsSummaries(ticks, time.Minute)
+	defer close(ticks)
+
+	// The first interval ends before anything is recorded
+	ticks <- time.Now()
+	<-lines
+
+	requestDuration.WithLabelValues("GET", "/api/students", "200").Observe(0.1)
+	requestDuration.WithLabelValues("GET", "/api/students", "200").Observe(0.2)
+	requestDuration.WithLabelValues("POST", "/api/students", "500").Observe(0.3)
+	fabricTransactions.WithLabelValues("submit", "CreateStudent", "success").Inc()
+	fabricTransactions.WithLabelValues("submit", "CreateStudent", "failure").Inc()
+	fabricTransactions.WithLabelValues("evaluate", "ReadStudent", "success").Add(2)
+
+	ticks <- time.Now()
+	logged := <-lines
+
+	var line struct {
+		Msg     string         `json:"msg"`
+		Summary metricsSummary `json:"summary"`
+	}
+	if err := json.Unmarshal(logged, &line); err != nil {
+		t.Fatalf("parsing log line %s: %v", logged, err)
+	}
+	if line.Msg != "Metrics summary" {
+		t.Errorf("msg = %q, want Metrics summary", line.Msg)
+	}
+
+	summary := line.Summary
+	if summary.IntervalSeconds != 60 || summary.Requests != 3 || summary.ServerErrors != 1 {
+		t.Errorf("summary = %+v, want 3 requests with 1 server error over 60 seconds", summary)
+	}
+	if summary.ErrorRate < 0.333 || summary.ErrorRate > 0.334 {
+		t.Errorf("errorRate = %v, want 1/3", summary.ErrorRate)
+	}
+	if summary.MeanLatencyMs < 199.9 || summary.MeanLatencyMs > 200.1 {
+		t.Errorf("meanLatencyMs = %v, want 200", summary.MeanLatencyMs)
+	}
+	want := map[string]float64{"submit_success": 1, "submit_failure": 1, "evaluate_success": 2}
+	if len(summary.Transactions) != len(want) {
+		t.Errorf("transactions = %v, want %v", summary.Transactions, want)
+	}
+	for key, count := range want {
+		if summary.Transactions[key] != count {
+			t.Errorf("transactions[%s] = %v, want %v", key, summary.Transactions[key], count)
+		}
+	}
+}
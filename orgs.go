@@ -0,0 +1,343 @@
+/*
+Copyright 2021 IBM All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"sort"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+)
+
+// orgHeader is the request header used to choose the organization a request transacts as
+const orgHeader = "X-Org"
+
+// OrgConfig describes how to connect to the Fabric network as a member of one organization
+type OrgConfig struct {
+	MSPID        string `json:"mspId" yaml:"mspId"`
+	CertPath     string `json:"certPath" yaml:"certPath"` // directory containing the signing certificate
+	KeyPath      string `json:"keyPath" yaml:"keyPath"`   // directory containing the private key
+	TLSCertPath  string `json:"tlsCertPath" yaml:"tlsCertPath"`
+	PeerEndpoint string `json:"peerEndpoint" yaml:"peerEndpoint"`
+	GatewayPeer  string `json:"gatewayPeer" yaml:"gatewayPeer"`
+
+	// Label of a wallet identity to transact as, used instead of CertPath and KeyPath
+	Identity string `json:"identity" yaml:"identity"`
+
+	// PKCS#11 token holding the private key, used instead of KeyPath; the certificate is still read from CertPath
+	HSM *HSMConfig `json:"hsm,omitempty" yaml:"hsm"`
+
+	// Where in Vault the certificate, private key, and TLS CA certificate are kept, used instead
+	// of CertPath, KeyPath, and TLSCertPath
+	Vault *VaultSource `json:"vault,omitempty" yaml:"vault"`
+
+	// Fabric connection profile supplying the MSP ID, peer, and TLS settings above
+	ConnectionProfile string `json:"connectionProfile" yaml:"connectionProfile"`
+
+	// PEM-encoded TLS CA certificate given inline by a connection profile, used instead of TLSCertPath
+	TLSCertPEM []byte `json:"-" yaml:"-"`
+
+	// Further gateway peers of the organization. Evaluations are spread across every healthy
+	// peer, and submits fail over from the peer above to these, in order.
+	Peers []PeerConfig `json:"peers" yaml:"peers"`
+}
+
+// PeerConfig is an additional gateway peer of an organization
+type PeerConfig struct {
+	PeerEndpoint string `json:"peerEndpoint" yaml:"peerEndpoint"`
+	GatewayPeer  string `json:"gatewayPeer" yaml:"gatewayPeer"`
+	TLSCertPath  string `json:"tlsCertPath" yaml:"tlsCertPath"` // defaults to the organization's
+}
+
+// peerOrgs returns the organization's settings once for each of its gateway peers, starting
+// with the preferred one, with the peer's endpoint and TLS settings filled in
+func (org OrgConfig) peerOrgs() []OrgConfig {
+	peerOrgs := []OrgConfig{org}
+	for _, peer := range org.Peers {
+		peerOrg := org
+		peerOrg.PeerEndpoint = peer.PeerEndpoint
+		peerOrg.GatewayPeer = peer.GatewayPeer
+		if peer.TLSCertPath != "" {
+			peerOrg.TLSCertPath = peer.TLSCertPath
+			peerOrg.TLSCertPEM = nil
+		}
+		peerOrgs = append(peerOrgs, peerOrg)
+	}
+	return peerOrgs
+}
+
+// defaultOrgConfig returns the organization used when a request does not choose one
+func defaultOrgConfig() OrgConfig {
+	return cfg.Org
+}
+
+// loadOrgConfigs reads the additional organizations from a JSON file holding an array of OrgConfig
+func loadOrgConfigs(path string) ([]OrgConfig, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read organizations file: %w", err)
+	}
+
+	var orgs []OrgConfig
+	if err := json.Unmarshal(data, &orgs); err != nil {
+		return nil, fmt.Errorf("failed to parse organizations file %s: %w", path, err)
+	}
+
+	return orgs, nil
+}
+
+// resolveOrgConfig applies the organization's connection profile, if it has one, and checks
+// that every connection setting is present
+func resolveOrgConfig(org OrgConfig, channel string) (OrgConfig, error) {
+	if org.ConnectionProfile != "" {
+		var err error
+		if org, err = applyConnectionProfile(org, channel); err != nil {
+			return org, err
+		}
+	}
+
+	hasCredentials := org.Identity != "" || (org.CertPath != "" && (org.KeyPath != "" || org.HSM != nil)) || org.Vault != nil
+	hasTLSCert := org.TLSCertPath != "" || len(org.TLSCertPEM) > 0 || (org.Vault != nil && org.Vault.KVPath != "")
+	if org.MSPID == "" || !hasCredentials || !hasTLSCert || org.PeerEndpoint == "" || org.GatewayPeer == "" {
+		return org, errors.New("must set mspId, tlsCertPath, peerEndpoint, and gatewayPeer, or a connectionProfile, and either an identity, certPath and keyPath or hsm, or vault")
+	}
+	if org.Vault != nil {
+		if org.Identity != "" || org.HSM != nil {
+			return org, errors.New("vault cannot be used with an identity or hsm")
+		}
+		if err := org.Vault.validate(); err != nil {
+			return org, err
+		}
+	}
+	if org.HSM != nil {
+		if org.Identity != "" {
+			return org, errors.New("hsm signs for the certificate at certPath, and cannot be used with an identity")
+		}
+		if err := org.HSM.validate(); err != nil {
+			return org, err
+		}
+	}
+	for i, peer := range org.Peers {
+		if peer.PeerEndpoint == "" || peer.GatewayPeer == "" {
+			return org, fmt.Errorf("peer %d must set peerEndpoint and gatewayPeer", i)
+		}
+	}
+	return org, nil
+}
+
+// orgRegistry holds the configured organizations and caches a gateway connection for
+// each one, and for each API user with their own identity, opened the first time it is used
+type orgRegistry struct {
+	defaultOrg string
+	configs    map[string]OrgConfig
+
+	// Connections are keyed by MSP ID for an organization's shared identity, and by MSP ID and user name for a user's
+	mu          sync.Mutex
+	connections map[string]*fabricConnection
+
+	// pool counts the connections borrowed by requests, so that close can wait for them
+	pool *connectionPool
+
+	// reloadMu lets one identity reload run at a time
+	reloadMu sync.Mutex
+}
+
+// orgs is the registry of organizations the server can transact as, set up by initFabricClient
+var orgs *orgRegistry
+
+// newOrgRegistry creates a registry of the given organizations, the first of which is the default.
+// A later entry with the same MSP ID replaces an earlier one.
+func newOrgRegistry(configs ...OrgConfig) *orgRegistry {
+	r := &orgRegistry{
+		defaultOrg:  configs[0].MSPID,
+		configs:     make(map[string]OrgConfig),
+		connections: make(map[string]*fabricConnection),
+		pool:        newConnectionPool(),
+	}
+	for _, org := range configs {
+		r.configs[org.MSPID] = org
+	}
+	return r
+}
+
+// names returns the MSP IDs of the configured organizations in sorted order
+func (r *orgRegistry) names() []string {
+	names := make([]string, 0, len(r.configs))
+	for name := range r.configs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// connection returns the cached connection for an organization's shared identity, connecting it if this is its first use
+func (r *orgRegistry) connection(mspID string) (*fabricConnection, error) {
+	org, ok := r.configs[mspID]
+	if !ok {
+		return nil, fmt.Errorf("unknown organization %q", mspID)
+	}
+	return r.cachedConnection(mspID, org)
+}
+
+// userConnection returns the cached connection for an API user with their own identity in an
+// organization, connecting it if this is its first use
+func (r *orgRegistry) userConnection(mspID string, user UserConfig) (*fabricConnection, error) {
+	org, ok := r.configs[mspID]
+	if !ok {
+		return nil, fmt.Errorf("unknown organization %q", mspID)
+	}
+	org.Identity, org.CertPath, org.KeyPath, org.HSM, org.Vault = user.Identity, user.CertPath, user.KeyPath, nil, nil
+	return r.cachedConnection(mspID+"/"+user.Username, org)
+}
+
+// cachedConnection returns the connection cached under key, connecting to org if there is none
+func (r *orgRegistry) cachedConnection(key string, org OrgConfig) (*fabricConnection, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.pool.isClosed() {
+		return nil, errPoolClosed
+	}
+	if fc, ok := r.connections[key]; ok {
+		return fc, nil
+	}
+
+	fc, err := newFabricConnection(org)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect as %s: %w", key, err)
+	}
+	r.connections[key] = fc
+	return fc, nil
+}
+
+// defaultConnection returns the connection of the default organization, or nil if it is not connected
+func (r *orgRegistry) defaultConnection() *fabricConnection {
+	if r == nil {
+		return nil
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.connections[r.defaultOrg]
+}
+
+// close closes every cached connection at shutdown, once the requests that borrowed them have
+// returned them or connectionDrainTimeout has passed, logging any still borrowed
+func (r *orgRegistry) close() {
+	ctx, cancel := context.WithTimeout(context.Background(), connectionDrainTimeout)
+	defer cancel()
+
+	for key, count := range r.pool.drain(ctx) {
+		slog.Warn("Closing gateway connection while still borrowed", "connection", key, "requests", count)
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for key, fc := range r.connections {
+		fc.close()
+		delete(r.connections, key)
+	}
+}
+
+// connectionKey is the context key under which the connection chosen for a request is stored
+type connectionKey struct{}
+
+// contextWithConnection returns a copy of ctx that transacts using the given connection
+func contextWithConnection(ctx context.Context, fc *fabricConnection) context.Context {
+	return context.WithValue(ctx, connectionKey{}, fc)
+}
+
+// connectionFrom returns the connection chosen for a request, falling back to the default organization
+func connectionFrom(ctx context.Context) *fabricConnection {
+	if fc, ok := ctx.Value(connectionKey{}).(*fabricConnection); ok {
+		return fc
+	}
+	return orgs.defaultConnection()
+}
+
+// requireConnection returns the connection of the organization chosen for the request, or
+// writes 503 and returns nil if there is no Fabric client
+func requireConnection(c *gin.Context) *fabricConnection {
+	fc := connectionFrom(c.Request.Context())
+	if fc == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Fabric client is not initialized"})
+	}
+	return fc
+}
+
+// selectOrg resolves the organization named by the X-Org header, defaulting to the
+// default organization, and lends its connection to the handlers. Users with their own
+// Fabric identity transact as themselves, in their own organization. Once the connections
+// are closed at shutdown, requests are refused with 503.
+func selectOrg(c *gin.Context) {
+	// Without a Fabric client, as when the router is given another ledger, there are no organizations to choose from
+	if orgs == nil {
+		c.Next()
+		return
+	}
+
+	user, hasIdentity := requestUserIdentity(c)
+
+	mspID := c.GetHeader(orgHeader)
+	if mspID == "" {
+		mspID = orgs.defaultOrg
+		if hasIdentity {
+			mspID = user.MSPID
+		}
+	}
+
+	if _, ok := orgs.configs[mspID]; !ok {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Unknown organization %q", mspID), "organizations": orgs.names()})
+		return
+	}
+
+	// A user's own identity only belongs to one organization, and falling back to another's shared identity would misattribute the transaction
+	if hasIdentity && mspID != user.MSPID {
+		c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": fmt.Sprintf("User %s transacts as %s and cannot select %s", user.Username, user.MSPID, mspID)})
+		return
+	}
+
+	var fc *fabricConnection
+	var err error
+	key := mspID
+	if hasIdentity {
+		fc, err = orgs.userConnection(mspID, user)
+		key = mspID + "/" + user.Username
+	} else {
+		fc, err = orgs.connection(mspID)
+	}
+	if errors.Is(err, errPoolClosed) {
+		c.AbortWithStatusJSON(http.StatusServiceUnavailable, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("Failed to connect to the gateway: %v", err)})
+		return
+	}
+
+	// The connection is borrowed until the request has been handled
+	release, err := orgs.pool.borrow(key)
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusServiceUnavailable, gin.H{"error": err.Error()})
+		return
+	}
+	defer release()
+
+	ctx := contextWithConnection(c.Request.Context(), fc)
+	ctx = context.WithValue(ctx, loggerKey{}, requestLogger(c).With("org", mspID))
+	c.Request = c.Request.WithContext(ctx)
+
+	c.Next()
+}
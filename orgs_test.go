@@ -0,0 +1,172 @@
+/*
+Copyright 2021 IBM All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package main
+
+import (
+	"errors"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// newTestOrg returns an organization whose signing credentials and TLS CA certificate are
+// written to a temporary directory, with a peer that is never dialled
+func newTestOrg(t *testing.T, mspID, user string) OrgConfig {
+	t.Helper()
+
+	dir := t.TempDir()
+	_, certificatePEM, keyPEM := newTestCertificate(t, user)
+	_, tlsCertificatePEM, _ := newTestCertificate(t, "peer0.example.com")
+	files := map[string][]byte{"signcerts/cert.pem": certificatePEM, "keystore/key.pem": keyPEM, "tlsca.pem": tlsCertificatePEM}
+	for name, data := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, data, 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	return OrgConfig{
+		MSPID:        mspID,
+		CertPath:     filepath.Join(dir, "signcerts"),
+		KeyPath:      filepath.Join(dir, "keystore"),
+		TLSCertPath:  filepath.Join(dir, "tlsca.pem"),
+		PeerEndpoint: "127.0.0.1:1",
+		GatewayPeer:  "peer0.example.com",
+	}
+}
+
+func TestOrgHeaderSelectsIdentity(t *testing.T) {
+	previous := orgs
+	orgs = newOrgRegistry(newTestOrg(t, "Org1MSP", "User1@org1.example.com"), newTestOrg(t, "Org2MSP", "User1@org2.example.com"))
+	t.Cleanup(func() {
+		orgs.close()
+		orgs = previous
+	})
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.Use(selectOrg)
+	var selected *fabricConnection
+	router.GET("/", func(c *gin.Context) {
+		selected = connectionFrom(c.Request.Context())
+	})
+
+	connections := map[string]*fabricConnection{}
+	for _, mspID := range []string{"Org1MSP", "Org2MSP"} {
+		selected = nil
+		response := serveRequest(router, http.MethodGet, "/", "", orgHeader, mspID)
+		if response.Code != http.StatusOK || selected == nil {
+			t.Fatalf("%s: status = %d, body %s", mspID, response.Code, response.Body)
+		}
+		if selected.id.MspID() != mspID {
+			t.Errorf("%s: transacting as %s", mspID, selected.id.MspID())
+		}
+		connections[mspID] = selected
+	}
+
+	org1, org2 := connections["Org1MSP"], connections["Org2MSP"]
+	if org1 == org2 || org1.peers[0].gateway == org2.peers[0].gateway {
+		t.Error("both organizations share a gateway connection")
+	}
+	if string(org1.id.Credentials()) == string(org2.id.Credentials()) {
+		t.Error("both organizations transact with the same certificate")
+	}
+
+	// The connection is cached, and used when no organization is chosen as the default's
+	serveRequest(router, http.MethodGet, "/", "", orgHeader, "Org2MSP")
+	if selected != org2 {
+		t.Error("Org2MSP was connected again rather than reusing its connection")
+	}
+	serveRequest(router, http.MethodGet, "/", "")
+	if selected != org1 {
+		t.Error("a request without X-Org did not transact as the default organization")
+	}
+
+	response := serveRequest(router, http.MethodGet, "/", "", orgHeader, "Org3MSP")
+	if response.Code != http.StatusBadRequest {
+		t.Errorf("status for an unknown organization = %d, want 400", response.Code)
+	}
+}
+
+func TestOrgRegistryCloseClosesConnections(t *testing.T) {
+	registry := newOrgRegistry(OrgConfig{MSPID: "Org1MSP"}, OrgConfig{MSPID: "Org2MSP"})
+
+	released := map[string]bool{}
+	for _, key := range []string{"Org1MSP", "Org2MSP", "Org1MSP/alice"} {
+		registry.connections[key] = &fabricConnection{closeSign: func() error {
+			released[key] = true
+			return nil
+		}}
+	}
+
+	registry.close()
+
+	if len(released) != 3 {
+		t.Errorf("closed %v, want every connection closed", released)
+	}
+	if len(registry.connections) != 0 {
+		t.Errorf("%d connections are still cached", len(registry.connections))
+	}
+	if fc := registry.defaultConnection(); fc != nil {
+		t.Error("default connection is still available after close")
+	}
+
+	if _, err := registry.connection("Org1MSP"); !errors.Is(err, errPoolClosed) {
+		t.Errorf("connection after close returned %v, want errPoolClosed", err)
+	}
+	if _, err := registry.userConnection("Org2MSP", UserConfig{Username: "bob"}); !errors.Is(err, errPoolClosed) {
+		t.Errorf("user connection after close returned %v, want errPoolClosed", err)
+	}
+}
+
+func TestOrgRegistryCloseWaitsForBorrowedConnections(t *testing.T) {
+	registry := newOrgRegistry(OrgConfig{MSPID: "Org1MSP"})
+	connectionClosed := make(chan struct{})
+	registry.connections["Org1MSP"] = &fabricConnection{closeSign: func() error {
+		close(connectionClosed)
+		return nil
+	}}
+
+	release, err := registry.pool.borrow("Org1MSP")
+	if err != nil {
+		t.Fatalf("borrowing: %v", err)
+	}
+	closed := make(chan struct{})
+	go func() {
+		registry.close()
+		close(closed)
+	}()
+
+	// The connection stays open while a request still holds it
+	for !registry.pool.isClosed() {
+		time.Sleep(time.Millisecond)
+	}
+	select {
+	case <-connectionClosed:
+		t.Fatal("connection was closed while still borrowed")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	release()
+	select {
+	case <-closed:
+	case <-time.After(time.Second):
+		t.Fatal("close did not return once the connection was given back")
+	}
+	select {
+	case <-connectionClosed:
+	default:
+		t.Error("connection was not closed")
+	}
+}
@@ -0,0 +1,139 @@
+/*
+Copyright 2021 IBM All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+)
+
+// studentPatch holds the fields of a partial update. Fields left out of the request are nil
+// and keep their current values.
+type studentPatch struct {
+	ID         *string `json:"id"`
+	Name       *string `json:"name"`
+	Department *string `json:"department"`
+	Year       *string `json:"year"`
+	CGPA       *string `json:"cgpa"`
+}
+
+// patchStudent updates only the fields given in the request body, keeping the rest of the
+// student as it is. The If-Match header must give the ETag the client last saw, and the update
+// only goes ahead if the student is still at that version; otherwise it gets 412 with the
+// current ETag. An If-Unmodified-Since header is honoured as for PUT. The patched record is
+// submitted with the version it was read at, so the chaincode refuses it if another update
+// commits in between, even with If-Match: *.
+func patchStudent(c *gin.Context) {
+	id := c.Param("id")
+
+	var patch studentPatch
+	decoder := json.NewDecoder(c.Request.Body)
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&patch); err != nil {
+		writeBindError(c, err)
+		return
+	}
+	if patch.ID != nil && *patch.ID != id {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "The id of a student cannot be changed"})
+		return
+	}
+	etag, ok := requireIfMatch(c)
+	if !ok {
+		return
+	}
+
+	requestLogger(c).Info("Patching student", "studentId", id)
+
+	if !checkUnmodifiedSince(c, id) {
+		return
+	}
+
+	result, err := requestLedger(c).EvaluateTransaction(c.Request.Context(), "ReadStudent", id)
+	if err != nil {
+		if !writeStudentError(c, id, err) {
+			writeTransactionError(c, "read student", err)
+		}
+		return
+	}
+
+	// The chaincode keeps the department as the student's branch
+	var stored struct {
+		Student
+		Branch string `json:"branch"`
+	}
+	if err := json.NewDecoder(bytes.NewReader(result)).Decode(&stored); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("Failed to parse student data: %v", err)})
+		return
+	}
+	student := stored.Student
+	student.Department = stored.Branch
+	if student.Status == statusInactive {
+		c.JSON(http.StatusGone, gin.H{"error": "gone", "id": id, "message": errStudentGone.Error()})
+		return
+	}
+	if _, ok := matchIfMatch(c, id, etag, student.Version); !ok {
+		return
+	}
+	version := strconv.FormatUint(student.Version, 10)
+	student.ID = id
+	patch.apply(&student)
+
+	if err := binding.Validator.ValidateStruct(student); err != nil {
+		writeBindError(c, err)
+		return
+	}
+
+	strategy, err := requestCommitStrategy(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if !applyCommitTimeout(c) {
+		return
+	}
+
+	if strategy != waitForCommit {
+		if withStudentDetails(c, student) {
+			submitWithoutCommitWait(c, strategy, "UpdateStudent", updateStudentArgs(student, version)...)
+		}
+		return
+	}
+
+	if err := updateStudentRecord(c.Request.Context(), requestLedger(c), student, version); err != nil {
+		if writeStudentError(c, id, err) {
+			return
+		}
+		writeTransactionError(c, "update student", err)
+		return
+	}
+
+	student.Version++
+	c.Header("ETag", studentETag(id, student.Version))
+	writeWithTransaction(c, http.StatusOK, student)
+}
+
+// apply sets the fields of the student that the patch gives
+func (p studentPatch) apply(student *Student) {
+	if p.Name != nil {
+		student.Name = *p.Name
+	}
+	if p.Department != nil {
+		student.Department = *p.Department
+	}
+	if p.Year != nil {
+		student.Year = *p.Year
+	}
+	if p.CGPA != nil {
+		student.CGPA = *p.CGPA
+	}
+}
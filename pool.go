@@ -0,0 +1,96 @@
+/*
+Copyright 2021 IBM All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package main
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+// connectionDrainTimeout bounds how long shutdown waits for borrowed gateway connections to be
+// returned before closing them anyway
+const connectionDrainTimeout = 30 * time.Second
+
+// errPoolClosed is returned for a connection borrowed after the pool was closed at shutdown
+var errPoolClosed = errors.New("gateway connections are closed, the server is shutting down")
+
+// connectionPool keeps count of the gateway connections lent out, by key, so that shutdown can
+// wait for every borrowed connection to be returned before the connections are closed
+type connectionPool struct {
+	mu       sync.Mutex
+	borrowed map[string]int
+	// returned is closed, and replaced, each time a connection is returned
+	returned chan struct{}
+	closed   bool
+}
+
+// newConnectionPool creates a pool with no connections borrowed
+func newConnectionPool() *connectionPool {
+	return &connectionPool{borrowed: make(map[string]int), returned: make(chan struct{})}
+}
+
+// borrow lends out the connection with the given key, returning the function that gives it
+// back. Once the pool is closed, nothing more can be borrowed.
+func (p *connectionPool) borrow(key string) (func(), error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.closed {
+		return nil, errPoolClosed
+	}
+	p.borrowed[key]++
+
+	var once sync.Once
+	return func() { once.Do(func() { p.giveBack(key) }) }, nil
+}
+
+// giveBack returns a borrowed connection, waking a close waiting for it
+func (p *connectionPool) giveBack(key string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.borrowed[key]--; p.borrowed[key] <= 0 {
+		delete(p.borrowed, key)
+	}
+	close(p.returned)
+	p.returned = make(chan struct{})
+}
+
+// isClosed reports whether the pool has been closed
+func (p *connectionPool) isClosed() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.closed
+}
+
+// drain closes the pool to further borrowing and waits until every borrowed connection is
+// returned or ctx is done. It returns how many times each connection is still borrowed, which
+// is empty if they were all returned in time.
+func (p *connectionPool) drain(ctx context.Context) map[string]int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.closed = true
+	for len(p.borrowed) > 0 {
+		returned := p.returned
+		p.mu.Unlock()
+		select {
+		case <-returned:
+			p.mu.Lock()
+		case <-ctx.Done():
+			p.mu.Lock()
+			stillBorrowed := make(map[string]int, len(p.borrowed))
+			for key, count := range p.borrowed {
+				stillBorrowed[key] = count
+			}
+			return stillBorrowed
+		}
+	}
+	return map[string]int{}
+}
@@ -0,0 +1,73 @@
+/*
+Copyright 2021 IBM All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package main
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestConnectionPoolDrainWaitsForBorrowedConnections(t *testing.T) {
+	pool := newConnectionPool()
+	release, err := pool.borrow("Org1MSP")
+	if err != nil {
+		t.Fatalf("borrowing: %v", err)
+	}
+
+	drained := make(chan map[string]int)
+	go func() { drained <- pool.drain(context.Background()) }()
+
+	// Closing stops further borrowing straight away, but waits for the borrowed connection
+	for !pool.isClosed() {
+		time.Sleep(time.Millisecond)
+	}
+	if _, err := pool.borrow("Org1MSP"); !errors.Is(err, errPoolClosed) {
+		t.Errorf("borrowing after close returned %v, want errPoolClosed", err)
+	}
+	select {
+	case <-drained:
+		t.Fatal("drain returned while a connection was still borrowed")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	release()
+	release()
+	select {
+	case stillBorrowed := <-drained:
+		if len(stillBorrowed) != 0 {
+			t.Errorf("still borrowed %v, want none", stillBorrowed)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("drain did not return once the connection was given back")
+	}
+}
+
+func TestConnectionPoolDrainDeadline(t *testing.T) {
+	pool := newConnectionPool()
+	for _, key := range []string{"Org1MSP", "Org1MSP", "Org2MSP"} {
+		if _, err := pool.borrow(key); err != nil {
+			t.Fatalf("borrowing %s: %v", key, err)
+		}
+	}
+	release, _ := pool.borrow("Org2MSP")
+	release()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	stillBorrowed := pool.drain(ctx)
+
+	if elapsed := time.Since(start); elapsed < 50*time.Millisecond {
+		t.Errorf("drain returned after %s, before its deadline", elapsed)
+	}
+	if want := map[string]int{"Org1MSP": 2, "Org2MSP": 1}; !reflect.DeepEqual(stillBorrowed, want) {
+		t.Errorf("still borrowed %v, want %v", stillBorrowed, want)
+	}
+}
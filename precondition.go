@@ -0,0 +1,163 @@
+/*
+Copyright 2021 IBM All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// recordVersion returns the version of a student record as returned by ReadStudent. Records
+// written before the chaincode kept versions are at version 0.
+func recordVersion(record []byte) (uint64, error) {
+	var student struct {
+		Version uint64 `json:"version"`
+	}
+	err := json.Unmarshal(record, &student)
+	return student.Version, err
+}
+
+// studentCacheControl lets clients keep a student they read but has them revalidate it with
+// If-None-Match before each use; private keeps shared caches from serving it to other users
+const studentCacheControl = "private, no-cache"
+
+// ifNoneMatch reports whether the If-None-Match header names etag, or is "*", so a GET can be
+// answered with 304 Not Modified. Tags are compared weakly, ignoring a W/ prefix, as HTTP
+// asks for If-None-Match.
+func ifNoneMatch(c *gin.Context, etag string) bool {
+	header := strings.TrimSpace(c.GetHeader("If-None-Match"))
+	if header == "" {
+		return false
+	}
+	if header == "*" {
+		return true
+	}
+	for _, tag := range strings.Split(header, ",") {
+		if strings.TrimPrefix(strings.TrimSpace(tag), "W/") == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// requireIfMatch reads the If-Match header that a PUT or PATCH must send with the ETag of the
+// student it last read, and returns the ETag, or "" for "*", which matches any version. Without
+// it, a client could overwrite changes it has not seen, so the write is refused with 428. It
+// writes an error response and returns false if the write must not go ahead.
+func requireIfMatch(c *gin.Context) (string, bool) {
+	header := strings.TrimSpace(c.GetHeader("If-Match"))
+	if header == "" {
+		c.JSON(http.StatusPreconditionRequired, gin.H{"error": "If-Match must give the ETag of the student, from GET /api/students/:id, so that changes made since it was read are not overwritten"})
+		return "", false
+	}
+	if header == "*" {
+		return "", true
+	}
+
+	// The chaincode checks a single version, so a list of tags cannot be honoured
+	if len(header) < 2 || !strings.HasPrefix(header, `"`) || !strings.HasSuffix(header, `"`) || strings.Contains(header, ",") {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("If-Match must be a single student ETag, from GET /api/students/:id, or *, got %s", header)})
+		return "", false
+	}
+	return header, true
+}
+
+// matchIfMatch checks the ETag from requireIfMatch against the student's current version, and
+// returns the version the write must be submitted with so that the chaincode refuses it if
+// another write commits first, or "" for "*". If the student has changed, it writes 412 with
+// the current ETag and returns false.
+func matchIfMatch(c *gin.Context, id, etag string, version uint64) (string, bool) {
+	if etag == "" {
+		return "", true
+	}
+	if current := studentETag(id, version); etag != current {
+		c.Header("ETag", current)
+		c.JSON(http.StatusPreconditionFailed, gin.H{"error": fmt.Sprintf("Student %s has changed since the version in If-Match", id)})
+		return "", false
+	}
+	return strconv.FormatUint(version, 10), true
+}
+
+// ifMatchVersion reads the student and checks the ETag from requireIfMatch against it, as
+// matchIfMatch does, for a write that does not otherwise read the student
+func ifMatchVersion(c *gin.Context, id, etag string) (string, bool) {
+	if etag == "" {
+		return "", true
+	}
+
+	result, err := requestLedger(c).EvaluateTransaction(c.Request.Context(), "ReadStudent", id)
+	if err != nil {
+		if !writeStudentError(c, id, err) {
+			writeTransactionError(c, "read student "+id, err)
+		}
+		return "", false
+	}
+	version, err := recordVersion(result)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("Failed to parse student data: %v", err)})
+		return "", false
+	}
+	return matchIfMatch(c, id, etag, version)
+}
+
+// checkUnmodifiedSince enforces an If-Unmodified-Since precondition on a write to the given
+// student, comparing it with the record's updatedAt timestamp. It writes an error response
+// and returns false if the write must not go ahead.
+func checkUnmodifiedSince(c *gin.Context, id string) bool {
+	header := c.GetHeader("If-Unmodified-Since")
+	if header == "" {
+		return true
+	}
+
+	since, err := http.ParseTime(header)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Invalid If-Unmodified-Since header: %v", err)})
+		return false
+	}
+
+	result, err := requestLedger(c).EvaluateTransaction(c.Request.Context(), "ReadStudent", id)
+	if err != nil {
+		if !writeStudentError(c, id, err) {
+			writeTransactionError(c, "read student "+id, err)
+		}
+		return false
+	}
+
+	var record struct {
+		UpdatedAt string `json:"updatedAt"`
+	}
+	if err := json.Unmarshal(result, &record); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("Failed to parse student data: %v", err)})
+		return false
+	}
+
+	// Records written before modification times were tracked have no date to compare against
+	if record.UpdatedAt == "" {
+		return true
+	}
+
+	updatedAt, err := time.Parse(time.RFC3339Nano, record.UpdatedAt)
+	if err != nil {
+		writeTransactionError(c, "parse student modification time", err)
+		return false
+	}
+
+	// HTTP dates only have one-second precision
+	if updatedAt.Truncate(time.Second).After(since) {
+		c.Header("Last-Modified", updatedAt.UTC().Format(http.TimeFormat))
+		c.JSON(http.StatusPreconditionFailed, gin.H{"error": fmt.Sprintf("Student %s was modified at %s", id, record.UpdatedAt)})
+		return false
+	}
+
+	return true
+}
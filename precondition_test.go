@@ -0,0 +1,64 @@
+/*
+Copyright 2021 IBM All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package main
+
+import (
+	"net/http"
+	"testing"
+)
+
+// modifiedStudent is a stored student last modified at 10:30:00 on 2 January 2024
+const modifiedStudent = `{"id":"S1","name":"Alice","branch":"CSE","cgpa":"9.1","version":3,"updatedAt":"2024-01-02T10:30:00.25Z"}`
+
+func TestIfUnmodifiedSince(t *testing.T) {
+	tests := []struct {
+		name   string
+		method string
+		body   string
+		since  string
+		want   int
+	}{
+		{name: "put unmodified", method: http.MethodPut, body: `{"id":"S1","name":"Alice B","department":"CSE","cgpa":"9.1"}`, since: "Tue, 02 Jan 2024 10:30:00 GMT", want: http.StatusOK},
+		{name: "patch unmodified", method: http.MethodPatch, body: `{"cgpa":"9.5"}`, since: "Tue, 02 Jan 2024 11:00:00 GMT", want: http.StatusOK},
+		{name: "put modified since", method: http.MethodPut, body: `{"id":"S1","name":"Alice B","department":"CSE","cgpa":"9.1"}`, since: "Tue, 02 Jan 2024 10:29:59 GMT", want: http.StatusPreconditionFailed},
+		{name: "patch modified since", method: http.MethodPatch, body: `{"cgpa":"9.5"}`, since: "Mon, 01 Jan 2024 00:00:00 GMT", want: http.StatusPreconditionFailed},
+		{name: "invalid date", method: http.MethodPut, body: `{"id":"S1","name":"Alice B","department":"CSE","cgpa":"9.1"}`, since: "yesterday", want: http.StatusBadRequest},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ledger := &fakeLedger{respond: func(name string, _ []string) ([]byte, error) {
+				if name == "ReadStudent" {
+					return []byte(modifiedStudent), nil
+				}
+				return nil, nil
+			}}
+			router := newTestRouter(t, ledger, nil)
+
+			response := serveRequest(router, test.method, "/api/students/S1", test.body, "If-Match", studentETag("S1", 3), "If-Unmodified-Since", test.since)
+			if response.Code != test.want {
+				t.Fatalf("status = %d, want %d, body %s", response.Code, test.want, response.Body)
+			}
+
+			submitted := ledger.submitted()
+			if test.want == http.StatusOK {
+				if len(submitted) != 1 || submitted[0].name != "UpdateStudent" {
+					t.Errorf("submitted %+v, want the update", submitted)
+				}
+				return
+			}
+			if len(submitted) != 0 {
+				t.Errorf("submitted %+v, want no update", submitted)
+			}
+			if test.want == http.StatusPreconditionFailed {
+				if lastModified := response.Header().Get("Last-Modified"); lastModified != "Tue, 02 Jan 2024 10:30:00 GMT" {
+					t.Errorf("Last-Modified = %q, want the student's modification time", lastModified)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,190 @@
+/*
+Copyright 2021 IBM All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// detailsTransientKey is the transient data key under which the chaincode takes the private
+// details of a student
+const detailsTransientKey = "details"
+
+// contactDetails are the contact details of a student, kept in the private data collection
+type contactDetails struct {
+	Email   string `json:"email,omitempty" binding:"omitempty,email,max=254"`
+	Phone   string `json:"phone,omitempty" binding:"omitempty,max=32"`
+	Address string `json:"address,omitempty" binding:"omitempty,max=500"`
+}
+
+// privateStudentRequest is the body of a request to create a student with private details
+type privateStudentRequest struct {
+	Student
+	contactDetails
+}
+
+// studentPrivateDetails is the body of a request to set the private details of a student
+type studentPrivateDetails struct {
+	CGPA string `json:"cgpa,omitempty" binding:"omitempty,cgpa"`
+	contactDetails
+}
+
+// privateStudentInput is the student passed to CreateStudentPrivate in the transient data.
+// The chaincode calls the department the student's branch.
+type privateStudentInput struct {
+	ID     string `json:"id"`
+	Name   string `json:"name"`
+	Branch string `json:"branch"`
+	CGPA   string `json:"cgpa"`
+	contactDetails
+}
+
+// createStudentPrivate adds a student whose CGPA and contact details are stored in the private
+// data collection rather than the public state. The student travels as transient data, so the
+// private fields are not recorded in the transaction either.
+func createStudentPrivate(c *gin.Context) {
+	var student privateStudentRequest
+
+	// Parse request body
+	if err := c.ShouldBindJSON(&student); err != nil {
+		writeBindError(c, err)
+		return
+	}
+
+	requestLogger(c).Info("Creating student with private details", "studentId", student.ID)
+
+	input := privateStudentInput{ID: student.ID, Name: student.Name, Branch: student.Department, CGPA: student.CGPA, contactDetails: student.contactDetails}
+	studentJSON, err := json.Marshal(input)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("Failed to encode student: %v", err)})
+		return
+	}
+	transient := map[string][]byte{"student": studentJSON}
+
+	ctx := c.Request.Context()
+	err = retryTransient(ctx, "CreateStudentPrivate", func() error {
+		_, err := requestLedger(c).SubmitTransient(ctx, "CreateStudentPrivate", transient)
+		return err
+	})
+	if err != nil {
+		if writeStudentError(c, student.ID, err) {
+			return
+		}
+		writeTransactionError(c, "create student", err)
+		return
+	}
+
+	writeWithTransaction(c, http.StatusCreated, student)
+}
+
+// setStudentPrivate sets the private details of an existing student, replacing any it had. The
+// details travel as transient data, so they never reach the public ledger; a CGPA the student
+// still has in the public state is moved into the private details by the chaincode.
+func setStudentPrivate(c *gin.Context) {
+	id := c.Param("id")
+	var details studentPrivateDetails
+
+	// Parse request body
+	if err := c.ShouldBindJSON(&details); err != nil {
+		writeBindError(c, err)
+		return
+	}
+
+	requestLogger(c).Info("Setting student private details", "studentId", id)
+
+	detailsJSON, err := json.Marshal(details)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("Failed to encode private details: %v", err)})
+		return
+	}
+	transient := map[string][]byte{detailsTransientKey: detailsJSON}
+
+	ctx := c.Request.Context()
+	err = retryTransient(ctx, "CreateStudentPrivateDetails", func() error {
+		_, err := requestLedger(c).SubmitTransient(ctx, "CreateStudentPrivateDetails", transient, id)
+		return err
+	})
+	if err != nil {
+		if writeStudentError(c, id, err) {
+			return
+		}
+		writeTransactionError(c, "set student private details", err)
+		return
+	}
+
+	writeWithTransaction(c, http.StatusOK, details)
+}
+
+// getStudentPrivate retrieves the private details of a student. Only organizations that are
+// members of the private data collection can read them.
+func getStudentPrivate(c *gin.Context) {
+	id := c.Param("id")
+	requestLogger(c).Info("Retrieving student private details", "studentId", id)
+
+	result, err := requestLedger(c).EvaluateTransaction(c.Request.Context(), "ReadStudentPrivateDetails", id)
+	if err != nil {
+		if !writeStudentError(c, id, err) {
+			writeTransactionError(c, "read student private details", err)
+		}
+		return
+	}
+
+	var details map[string]interface{}
+	if err := json.Unmarshal(result, &details); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("Failed to parse student data: %v", err)})
+		return
+	}
+
+	c.JSON(http.StatusOK, details)
+}
+
+// contextWithStudentDetails returns a copy of ctx whose transaction proposals carry the
+// student's CGPA as private details in the transient data, where CreateStudent and
+// UpdateStudent take it, so that the chaincode keeps it in the private data collection. A
+// student without a CGPA leaves ctx as it is.
+func contextWithStudentDetails(ctx context.Context, student Student) (context.Context, error) {
+	if student.CGPA == "" {
+		return ctx, nil
+	}
+	return contextWithPrivateDetails(ctx, studentPrivateDetails{CGPA: student.CGPA})
+}
+
+// contextWithPrivateDetails returns a copy of ctx whose transaction proposals carry details as
+// JSON in the transient data, under the key the chaincode takes private details from, along
+// with any transient data sent with the request
+func contextWithPrivateDetails(ctx context.Context, details interface{}) (context.Context, error) {
+	detailsJSON, err := json.Marshal(details)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode private details: %w", err)
+	}
+
+	transient := map[string][]byte{detailsTransientKey: detailsJSON}
+	for key, value := range transientFrom(ctx) {
+		if _, ok := transient[key]; !ok {
+			transient[key] = value
+		}
+	}
+	return contextWithTransient(ctx, transient), nil
+}
+
+// withStudentDetails passes the student's CGPA to the transactions made for the request as
+// contextWithStudentDetails does, for writes submitted without createStudentRecord or
+// updateStudentRecord. It writes an error response and returns false if it cannot.
+func withStudentDetails(c *gin.Context, student Student) bool {
+	ctx, err := contextWithStudentDetails(c.Request.Context(), student)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return false
+	}
+	c.Request = c.Request.WithContext(ctx)
+	return true
+}
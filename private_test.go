@@ -0,0 +1,52 @@
+/*
+Copyright 2021 IBM All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestCreateStudentPrivateSendsDetailsAsTransient(t *testing.T) {
+	ledger := &fakeLedger{}
+	router := newTestRouter(t, ledger, nil)
+
+	response := serveRequest(router, http.MethodPost, "/api/students/private", `{"id":"S1","name":"Alice","department":"CSE","cgpa":"9.1","email":"alice@example.com"}`)
+	if response.Code != http.StatusCreated {
+		t.Fatalf("status = %d, body %s", response.Code, response.Body)
+	}
+
+	// The private fields travel only in the transient data, which is not recorded on the ledger
+	assertSubmitted(t, ledger, "CreateStudentPrivate")
+	call := ledger.submitted()[0]
+	var student privateStudentInput
+	if err := json.Unmarshal(call.transient["student"], &student); err != nil {
+		t.Fatalf("parsing transient student: %v", err)
+	}
+	want := privateStudentInput{ID: "S1", Name: "Alice", Branch: "CSE", CGPA: "9.1", contactDetails: contactDetails{Email: "alice@example.com"}}
+	if student != want {
+		t.Errorf("transient student = %+v, want %+v", student, want)
+	}
+}
+
+func TestSetStudentPrivateSendsDetailsAsTransient(t *testing.T) {
+	ledger := &fakeLedger{}
+	router := newTestRouter(t, ledger, nil)
+
+	response := serveRequest(router, http.MethodPut, "/api/students/S1/private", `{"cgpa":"9.3","phone":"555-0100"}`)
+	if response.Code != http.StatusOK {
+		t.Fatalf("status = %d, body %s", response.Code, response.Body)
+	}
+
+	assertSubmitted(t, ledger, "CreateStudentPrivateDetails", "S1")
+	details := string(ledger.submitted()[0].transient["details"])
+	if !strings.Contains(details, `"cgpa":"9.3"`) || !strings.Contains(details, `"phone":"555-0100"`) {
+		t.Errorf("transient details = %s, want the CGPA and phone", details)
+	}
+}
@@ -0,0 +1,150 @@
+/*
+Copyright 2021 IBM All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// connectionProfile is the part of a Fabric common connection profile, such as the
+// connection-org1.json generated by the test network, used to find an organization's
+// gateway peer. Profiles may be written in JSON or YAML.
+type connectionProfile struct {
+	Client struct {
+		Organization string `yaml:"organization"`
+	} `yaml:"client"`
+	Channels      map[string]profileChannel      `yaml:"channels"`
+	Organizations map[string]profileOrganization `yaml:"organizations"`
+	Peers         map[string]profilePeer         `yaml:"peers"`
+}
+
+// profileChannel lists the peers that join a channel, keyed by peer name
+type profileChannel struct {
+	Peers map[string]interface{} `yaml:"peers"`
+}
+
+// profileOrganization names an organization's MSP and its peers
+type profileOrganization struct {
+	MSPID string   `yaml:"mspid"`
+	Peers []string `yaml:"peers"`
+}
+
+// profilePeer describes how to reach a peer over TLS
+type profilePeer struct {
+	URL        string `yaml:"url"`
+	TLSCACerts struct {
+		PEM  string `yaml:"pem"`
+		Path string `yaml:"path"`
+	} `yaml:"tlsCACerts"`
+	GRPCOptions map[string]interface{} `yaml:"grpcOptions"`
+}
+
+// applyConnectionProfile fills in the organization's MSP ID, gateway peer, and TLS CA
+// certificate from its connection profile. The organization is the one in the profile with
+// the same MSP ID, or else the profile's client organization. Its first peer that joins
+// the given channel is preferred as the gateway.
+func applyConnectionProfile(org OrgConfig, channel string) (OrgConfig, error) {
+	data, err := os.ReadFile(org.ConnectionProfile)
+	if err != nil {
+		return org, fmt.Errorf("failed to read connection profile: %w", err)
+	}
+
+	var profile connectionProfile
+	if err := yaml.Unmarshal(data, &profile); err != nil {
+		return org, fmt.Errorf("failed to parse connection profile %s: %w", org.ConnectionProfile, err)
+	}
+
+	profileOrg, ok := profile.organization(org.MSPID)
+	if !ok {
+		return org, fmt.Errorf("connection profile %s describes neither organization %s nor a client organization", org.ConnectionProfile, org.MSPID)
+	}
+
+	peerName, ok := profile.gatewayPeer(profileOrg, channel)
+	if !ok {
+		return org, fmt.Errorf("connection profile %s lists no peers for organization %s", org.ConnectionProfile, profileOrg.MSPID)
+	}
+	peer := profile.Peers[peerName]
+
+	// The gateway connection always uses TLS
+	address, ok := strings.CutPrefix(peer.URL, "grpcs://")
+	if !ok {
+		return org, fmt.Errorf("peer %s in connection profile %s must have a grpcs:// URL, got %q", peerName, org.ConnectionProfile, peer.URL)
+	}
+
+	org.MSPID = profileOrg.MSPID
+	org.PeerEndpoint = "dns:///" + address
+	org.GatewayPeer = peerName
+	for _, option := range []string{"ssl-target-name-override", "hostnameOverride"} {
+		if name, ok := peer.GRPCOptions[option].(string); ok && name != "" {
+			org.GatewayPeer = name
+			break
+		}
+	}
+
+	switch {
+	case peer.TLSCACerts.PEM != "":
+		org.TLSCertPEM = []byte(peer.TLSCACerts.PEM)
+		org.TLSCertPath = ""
+	case peer.TLSCACerts.Path != "":
+		// Relative paths are resolved against the directory holding the profile
+		org.TLSCertPath = peer.TLSCACerts.Path
+		if !filepath.IsAbs(org.TLSCertPath) {
+			org.TLSCertPath = filepath.Join(filepath.Dir(org.ConnectionProfile), org.TLSCertPath)
+		}
+	default:
+		return org, fmt.Errorf("peer %s in connection profile %s has no tlsCACerts", peerName, org.ConnectionProfile)
+	}
+
+	return org, nil
+}
+
+// organization returns the profile's organization with the given MSP ID, or else its client organization
+func (p connectionProfile) organization(mspID string) (profileOrganization, bool) {
+	names := make([]string, 0, len(p.Organizations))
+	for name := range p.Organizations {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		if p.Organizations[name].MSPID == mspID {
+			return p.Organizations[name], true
+		}
+	}
+
+	org, ok := p.Organizations[p.Client.Organization]
+	return org, ok && org.MSPID != ""
+}
+
+// gatewayPeer returns the organization's first peer that joins the channel, or else its first
+// peer, skipping peers the profile does not describe
+func (p connectionProfile) gatewayPeer(org profileOrganization, channel string) (string, bool) {
+	var known []string
+	for _, name := range org.Peers {
+		if _, ok := p.Peers[name]; ok {
+			known = append(known, name)
+		}
+	}
+	if len(known) == 0 {
+		return "", false
+	}
+
+	if ch, ok := p.Channels[channel]; ok {
+		for _, name := range known {
+			if _, ok := ch.Peers[name]; ok {
+				return name, true
+			}
+		}
+	}
+	return known[0], true
+}
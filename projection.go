@@ -0,0 +1,108 @@
+/*
+Copyright 2021 IBM All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// fieldSelection is a tree of selected field names parsed from dotted paths such as
+// "courses.code". A nil selection selects the whole value.
+type fieldSelection map[string]fieldSelection
+
+// parseFieldSelection parses a comma-separated list of dotted field paths
+func parseFieldSelection(fields string) (fieldSelection, error) {
+	selection := fieldSelection{}
+
+	for _, path := range strings.Split(fields, ",") {
+		path = strings.TrimSpace(path)
+		if path == "" {
+			continue
+		}
+
+		node := selection
+		segments := strings.Split(path, ".")
+		for i, segment := range segments {
+			if segment == "" {
+				return nil, fmt.Errorf("invalid field path %q", path)
+			}
+
+			if i == len(segments)-1 {
+				// Selecting a field outright overrides any nested selection within it
+				node[segment] = nil
+				break
+			}
+
+			child, exists := node[segment]
+			if exists && child == nil {
+				// The whole field is already selected
+				break
+			}
+			if !exists {
+				child = fieldSelection{}
+				node[segment] = child
+			}
+			node = child
+		}
+	}
+
+	if len(selection) == 0 {
+		return nil, fmt.Errorf("no fields selected")
+	}
+	return selection, nil
+}
+
+// project returns the parts of value picked out by the selection. Selections within
+// an array apply to each of its elements. Paths that do not exist in value are an error.
+func (s fieldSelection) project(value interface{}) (interface{}, error) {
+	return s.projectAt(value, "")
+}
+
+// projectAt projects value, which is found at the given dotted path
+func (s fieldSelection) projectAt(value interface{}, path string) (interface{}, error) {
+	if s == nil {
+		return value, nil
+	}
+
+	switch v := value.(type) {
+	case map[string]interface{}:
+		projected := make(map[string]interface{}, len(s))
+		for name, selection := range s {
+			fieldPath := name
+			if path != "" {
+				fieldPath = path + "." + name
+			}
+
+			field, ok := v[name]
+			if !ok {
+				return nil, fmt.Errorf("unknown field %q", fieldPath)
+			}
+
+			p, err := selection.projectAt(field, fieldPath)
+			if err != nil {
+				return nil, err
+			}
+			projected[name] = p
+		}
+		return projected, nil
+
+	case []interface{}:
+		projected := make([]interface{}, len(v))
+		for i, element := range v {
+			p, err := s.projectAt(element, path)
+			if err != nil {
+				return nil, err
+			}
+			projected[i] = p
+		}
+		return projected, nil
+
+	default:
+		return nil, fmt.Errorf("field %q has no nested fields", path)
+	}
+}
@@ -0,0 +1,92 @@
+/*
+Copyright 2021 IBM All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"testing"
+)
+
+// nestedStudent is a student record with nested objects and arrays of objects
+const nestedStudent = `{"id":"S1","name":"Alice","branch":"CSE","version":2,` +
+	`"labels":{"cohort":"2024","advisor":"Dr. Rao"},` +
+	`"courses":[{"code":"CS101","grade":"A"},{"code":"CS102","grade":"B"}]}`
+
+func projectNestedStudent(t *testing.T, fields string) (interface{}, error) {
+	t.Helper()
+
+	var student map[string]interface{}
+	if err := json.Unmarshal([]byte(nestedStudent), &student); err != nil {
+		t.Fatal(err)
+	}
+	selection, err := parseFieldSelection(fields)
+	if err != nil {
+		return nil, err
+	}
+	return selection.project(student)
+}
+
+func TestProjection(t *testing.T) {
+	tests := []struct {
+		fields string
+		want   string
+	}{
+		{fields: "id,name", want: `{"id":"S1","name":"Alice"}`},
+		{fields: "id, labels.cohort", want: `{"id":"S1","labels":{"cohort":"2024"}}`},
+		{fields: "courses.code", want: `{"courses":[{"code":"CS101"},{"code":"CS102"}]}`},
+		{fields: "labels,labels.cohort", want: `{"labels":{"cohort":"2024","advisor":"Dr. Rao"}}`},
+		{fields: "labels.cohort,labels", want: `{"labels":{"cohort":"2024","advisor":"Dr. Rao"}}`},
+	}
+
+	for _, test := range tests {
+		t.Run(test.fields, func(t *testing.T) {
+			projected, err := projectNestedStudent(t, test.fields)
+			if err != nil {
+				t.Fatalf("project: %v", err)
+			}
+			var want interface{}
+			if err := json.Unmarshal([]byte(test.want), &want); err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(projected, want) {
+				t.Errorf("projected %v, want %v", projected, want)
+			}
+		})
+	}
+}
+
+func TestProjectionRejectsInvalidPaths(t *testing.T) {
+	for _, fields := range []string{"", " , ", "labels..cohort", "grade", "labels.missing", "courses.room", "name.first"} {
+		t.Run(fields, func(t *testing.T) {
+			if projected, err := projectNestedStudent(t, fields); err == nil {
+				t.Errorf("projected %v, want an error", projected)
+			}
+		})
+	}
+}
+
+func TestGetStudentWithFields(t *testing.T) {
+	ledger := &fakeLedger{respond: func(name string, _ []string) ([]byte, error) {
+		return []byte(nestedStudent), nil
+	}}
+	router := newTestRouter(t, ledger, nil)
+
+	response := serveRequest(router, http.MethodGet, "/api/students/S1?fields=id,courses.code", "")
+	if response.Code != http.StatusOK {
+		t.Fatalf("status = %d, body %s", response.Code, response.Body)
+	}
+	if got, want := response.Body.String(), `{"courses":[{"code":"CS101"},{"code":"CS102"}],"id":"S1"}`; got != want {
+		t.Errorf("body = %s, want %s", got, want)
+	}
+
+	response = serveRequest(router, http.MethodGet, "/api/students/S1?fields=courses.room", "")
+	if response.Code != http.StatusBadRequest {
+		t.Errorf("status for an unknown nested field = %d, want 400", response.Code)
+	}
+}
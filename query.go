@@ -0,0 +1,177 @@
+/*
+Copyright 2021 IBM All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Bounds on rich queries, so a single request cannot make the peer do unbounded work
+const (
+	maxQueryLimit         = 1000
+	maxQuerySelectorDepth = 10
+)
+
+// richQueryFields are the top-level fields of a CouchDB query that may be forwarded to the peer
+var richQueryFields = map[string]bool{"selector": true, "fields": true, "sort": true, "limit": true, "skip": true, "use_index": true}
+
+// mangoOperators are the CouchDB selector operators a query may use, and whether each takes
+// an array, an object, or any value as its argument
+var mangoOperators = map[string]string{
+	"$and": "array", "$or": "array", "$nor": "array", "$not": "object",
+	"$eq": "any", "$ne": "any", "$lt": "any", "$lte": "any", "$gt": "any", "$gte": "any",
+	"$exists": "any", "$type": "any",
+	"$in": "array", "$nin": "array", "$all": "array", "$size": "any", "$mod": "array", "$regex": "any",
+	"$elemMatch": "object", "$allMatch": "object", "$keyMapMatch": "object",
+}
+
+// queryStudents returns the students matching a CouchDB query, such as
+// {"selector": {"branch": {"$in": ["CSE", "ECE"]}, "labels.cohort": "2024"}, "limit": 50}
+func queryStudents(c *gin.Context) {
+	body, err := c.GetRawData()
+	if err != nil {
+		writeBindError(c, err)
+		return
+	}
+
+	query, err := validateRichQuery(body)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Invalid query: %v", err)})
+		return
+	}
+
+	requestLogger(c).Info("Querying students", "query", query)
+
+	result, err := requestLedger(c).EvaluateTransaction(c.Request.Context(), "QueryStudents", query)
+	if err != nil {
+		text := gatewayErrorText(err)
+		switch {
+		case isRichQueryUnsupported(err):
+			c.JSON(http.StatusNotImplemented, gin.H{"error": "Rich queries need CouchDB as the peer's state database"})
+		case strings.Contains(text, "no_usable_index"):
+			c.JSON(http.StatusBadRequest, gin.H{"error": "No index can serve the query's sort; sort on indexed fields only", "detail": text})
+		default:
+			writeTransactionError(c, "query students", err)
+		}
+		return
+	}
+
+	var students []map[string]interface{}
+	if err := json.Unmarshal(result, &students); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("Failed to parse student data: %v", err)})
+		return
+	}
+
+	c.JSON(http.StatusOK, students)
+}
+
+// validateRichQuery checks that a request body is a CouchDB query with a selector built from
+// known operators, and returns it compacted for the chaincode
+func validateRichQuery(body []byte) (string, error) {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(body, &fields); err != nil {
+		return "", errors.New("body must be a JSON object")
+	}
+	for name := range fields {
+		if !richQueryFields[name] {
+			return "", fmt.Errorf("unknown field %q, expected selector, fields, sort, limit, skip, or use_index", name)
+		}
+	}
+
+	var selector map[string]interface{}
+	if raw, ok := fields["selector"]; !ok || json.Unmarshal(raw, &selector) != nil || len(selector) == 0 {
+		return "", errors.New("selector must be a non-empty object")
+	}
+	if err := validateSelector(selector, 1); err != nil {
+		return "", err
+	}
+
+	if raw, ok := fields["limit"]; ok {
+		var limit int
+		if err := json.Unmarshal(raw, &limit); err != nil || limit < 1 || limit > maxQueryLimit {
+			return "", fmt.Errorf("limit must be an integer from 1 to %d", maxQueryLimit)
+		}
+	}
+	if raw, ok := fields["skip"]; ok {
+		var skip int
+		if err := json.Unmarshal(raw, &skip); err != nil || skip < 0 {
+			return "", errors.New("skip must be a non-negative integer")
+		}
+	}
+	if raw, ok := fields["fields"]; ok {
+		var names []string
+		if err := json.Unmarshal(raw, &names); err != nil {
+			return "", errors.New("fields must be an array of field names")
+		}
+	}
+	if raw, ok := fields["sort"]; ok {
+		var sort []interface{}
+		if err := json.Unmarshal(raw, &sort); err != nil {
+			return "", errors.New("sort must be an array")
+		}
+	}
+
+	var compacted bytes.Buffer
+	if err := json.Compact(&compacted, body); err != nil {
+		return "", err
+	}
+	return compacted.String(), nil
+}
+
+// validateSelector checks every operator within a selector, and that it is nested no deeper than the limit
+func validateSelector(value interface{}, depth int) error {
+	if depth > maxQuerySelectorDepth {
+		return fmt.Errorf("selector is nested more than %d levels deep", maxQuerySelectorDepth)
+	}
+
+	switch value := value.(type) {
+	case map[string]interface{}:
+		for key, argument := range value {
+			if strings.HasPrefix(key, "$") {
+				kind, ok := mangoOperators[key]
+				if !ok {
+					return fmt.Errorf("unknown operator %q", key)
+				}
+				if err := checkOperatorArgument(key, kind, argument); err != nil {
+					return err
+				}
+			}
+			if err := validateSelector(argument, depth+1); err != nil {
+				return err
+			}
+		}
+	case []interface{}:
+		for _, element := range value {
+			if err := validateSelector(element, depth+1); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// checkOperatorArgument checks that an operator's argument is an array or object if it needs one
+func checkOperatorArgument(operator, kind string, argument interface{}) error {
+	switch kind {
+	case "array":
+		if _, ok := argument.([]interface{}); !ok {
+			return fmt.Errorf("operator %s takes an array", operator)
+		}
+	case "object":
+		if _, ok := argument.(map[string]interface{}); !ok {
+			return fmt.Errorf("operator %s takes an object", operator)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,128 @@
+/*
+Copyright 2021 IBM All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// rateLimitSweepInterval is how often buckets of clients that have gone quiet are dropped
+const rateLimitSweepInterval = time.Minute
+
+// tokenBucket holds the tokens left to one client and when they were last topped up
+type tokenBucket struct {
+	tokens  float64
+	updated time.Time
+}
+
+// rateLimiter is a token-bucket limiter with one bucket per client. Each bucket holds up to
+// burst tokens and refills at rate tokens per second; a request spends one token.
+type rateLimiter struct {
+	rate  float64
+	burst float64
+
+	mu        sync.Mutex
+	buckets   map[string]*tokenBucket
+	lastSweep time.Time
+}
+
+// newRateLimiter creates a limiter allowing each client rate requests per second with bursts
+// of up to burst requests. A rate of zero disables the limit.
+func newRateLimiter(rate float64, burst int) *rateLimiter {
+	if burst < 1 {
+		burst = 1
+	}
+	return &rateLimiter{rate: rate, burst: float64(burst), buckets: make(map[string]*tokenBucket)}
+}
+
+// allow spends a token from the client's bucket at the given time. If none is left it
+// returns false and how long until one will be.
+func (l *rateLimiter) allow(client string, now time.Time) (bool, time.Duration) {
+	if l.rate <= 0 {
+		return true, 0
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.sweep(now)
+
+	bucket, ok := l.buckets[client]
+	if !ok {
+		bucket = &tokenBucket{tokens: l.burst, updated: now}
+		l.buckets[client] = bucket
+	}
+
+	bucket.tokens = math.Min(l.burst, bucket.tokens+now.Sub(bucket.updated).Seconds()*l.rate)
+	bucket.updated = now
+
+	if bucket.tokens < 1 {
+		wait := time.Duration((1 - bucket.tokens) / l.rate * float64(time.Second))
+		return false, wait
+	}
+
+	bucket.tokens--
+	return true, 0
+}
+
+// sweep drops the buckets that would have refilled completely by now, since a new full bucket
+// is equivalent. The caller must hold l.mu.
+func (l *rateLimiter) sweep(now time.Time) {
+	if now.Sub(l.lastSweep) < rateLimitSweepInterval {
+		return
+	}
+	l.lastSweep = now
+
+	for client, bucket := range l.buckets {
+		if bucket.tokens+now.Sub(bucket.updated).Seconds()*l.rate >= l.burst {
+			delete(l.buckets, client)
+		}
+	}
+}
+
+// rateLimitMiddleware limits each client's requests, using the write limiter for requests that
+// may change records and the read limiter for the rest. Limited requests receive 429.
+func rateLimitMiddleware(reads, writes *rateLimiter) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		limiter := reads
+		if isWriteRequest(c) {
+			limiter = writes
+		}
+
+		ok, wait := limiter.allow(rateLimitKey(c), time.Now())
+		if !ok {
+			rateLimitedRequests.Inc()
+			c.Header("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+			c.AbortWithStatusJSON(http.StatusTooManyRequests, gin.H{"error": "Rate limit exceeded, try again later"})
+			return
+		}
+
+		c.Next()
+	}
+}
+
+// rateLimitKey identifies the client a request is counted against: the authenticated user if
+// there is one, then the TLS client certificate the connection was made with, so clients behind
+// a shared address don't share a limit, or else the client address
+func rateLimitKey(c *gin.Context) string {
+	if subject, ok := requestSubject(c); ok {
+		return "user:" + subject
+	}
+	if c.Request.TLS != nil && len(c.Request.TLS.PeerCertificates) > 0 {
+		fingerprint := sha256.Sum256(c.Request.TLS.PeerCertificates[0].Raw)
+		return "cert:" + hex.EncodeToString(fingerprint[:])
+	}
+	return c.ClientIP()
+}
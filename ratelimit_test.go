@@ -0,0 +1,99 @@
+/*
+Copyright 2021 IBM All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRateLimiterRefills(t *testing.T) {
+	limiter := newRateLimiter(2, 3)
+	now := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	for i := 0; i < 3; i++ {
+		if ok, _ := limiter.allow("client", now); !ok {
+			t.Fatalf("request %d of the burst was limited", i+1)
+		}
+	}
+	ok, wait := limiter.allow("client", now)
+	if ok || wait != 500*time.Millisecond {
+		t.Fatalf("request past the burst allowed %t, wait %s, want limited for 500ms", ok, wait)
+	}
+	if ok, _ := limiter.allow("other", now); !ok {
+		t.Error("another client was limited")
+	}
+
+	// One token is back after half a second, and no more
+	now = now.Add(wait)
+	if ok, _ := limiter.allow("client", now); !ok {
+		t.Error("request was limited after the bucket refilled")
+	}
+	if ok, _ := limiter.allow("client", now); ok {
+		t.Error("refilled bucket held more than one token")
+	}
+
+	// The bucket never holds more than the burst
+	now = now.Add(time.Hour)
+	for i := 0; i < 3; i++ {
+		if ok, _ := limiter.allow("client", now); !ok {
+			t.Fatalf("request %d of the refilled burst was limited", i+1)
+		}
+	}
+	if ok, _ := limiter.allow("client", now); ok {
+		t.Error("bucket refilled past the burst")
+	}
+}
+
+func TestRateLimitMiddleware(t *testing.T) {
+	router := newTestRouter(t, &fakeLedger{respond: readStoredStudent}, func(config *Config) {
+		config.ReadRateLimit, config.ReadRateBurst = 10, 3
+		config.WriteRateLimit, config.WriteRateBurst = 10, 1
+	})
+
+	serveFrom := func(client, method, target, body string) *httptest.ResponseRecorder {
+		request := httptest.NewRequest(method, target, strings.NewReader(body))
+		request.Header.Set("Content-Type", "application/json")
+		request.RemoteAddr = client + ":1234"
+		response := httptest.NewRecorder()
+		router.ServeHTTP(response, request)
+		return response
+	}
+
+	for i := 0; i < 3; i++ {
+		if response := serveFrom("192.0.2.1", http.MethodGet, "/api/students/S1", ""); response.Code != http.StatusOK {
+			t.Fatalf("read %d: status = %d, body %s", i+1, response.Code, response.Body)
+		}
+	}
+	response := serveFrom("192.0.2.1", http.MethodGet, "/api/students/S1", "")
+	if response.Code != http.StatusTooManyRequests {
+		t.Fatalf("read past the burst: status = %d, want 429", response.Code)
+	}
+	if retryAfter := response.Header().Get("Retry-After"); retryAfter != "1" {
+		t.Errorf("Retry-After = %q, want 1", retryAfter)
+	}
+
+	// Other clients, and the same client's writes, have buckets of their own
+	if response := serveFrom("192.0.2.2", http.MethodGet, "/api/students/S1", ""); response.Code != http.StatusOK {
+		t.Errorf("another client's read: status = %d, want 200", response.Code)
+	}
+	if response := serveFrom("192.0.2.1", http.MethodPost, "/api/students", `{"id":"S2","name":"Bob"}`); response.Code != http.StatusCreated {
+		t.Errorf("write: status = %d, want 201, body %s", response.Code, response.Body)
+	}
+	if response := serveFrom("192.0.2.1", http.MethodPost, "/api/students", `{"id":"S3","name":"Carol"}`); response.Code != http.StatusTooManyRequests {
+		t.Errorf("write past the stricter burst: status = %d, want 429", response.Code)
+	}
+
+	// A token is back a tenth of a second later
+	time.Sleep(150 * time.Millisecond)
+	if response := serveFrom("192.0.2.1", http.MethodGet, "/api/students/S1", ""); response.Code != http.StatusOK {
+		t.Errorf("read after the bucket refilled: status = %d, want 200", response.Code)
+	}
+}
@@ -0,0 +1,106 @@
+/*
+Copyright 2021 IBM All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package main
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Roles a user can be granted. Each role may also do everything the roles below it can.
+const (
+	roleViewer    = "viewer"
+	roleRegistrar = "registrar"
+	roleAdmin     = "admin"
+)
+
+// roleRanks orders the roles from least to most privileged
+var roleRanks = map[string]int{
+	roleViewer:    1,
+	roleRegistrar: 2,
+	roleAdmin:     3,
+}
+
+// routeRoles names the least privileged role allowed to call a route, by method and route
+// pattern. Routes not listed need the viewer role to read and the registrar role to write.
+var routeRoles = map[string]string{
+	"DELETE /api/students/:id/purge": roleAdmin,
+	"POST /api/init":                 roleAdmin,
+	"POST /api/selftest":             roleAdmin,
+	"POST /api/audit/validate":       roleAdmin,
+
+	"POST /api/admin/identity/reload": roleAdmin,
+
+	"GET /api/admin/chaincode/installed": roleAdmin,
+	"POST /api/admin/chaincode/install":  roleAdmin,
+	"POST /api/admin/chaincode/approve":  roleAdmin,
+	"GET /api/admin/chaincode/readiness": roleAdmin,
+	"POST /api/admin/chaincode/commit":   roleAdmin,
+
+	"GET /api/admin/debug/vars":            roleAdmin,
+	"GET /api/admin/debug/pprof/*profile":  roleAdmin,
+	"POST /api/admin/debug/pprof/*profile": roleAdmin,
+
+	"GET /api/identities":           roleAdmin,
+	"POST /api/identities":          roleAdmin,
+	"DELETE /api/identities/:label": roleAdmin,
+	"POST /api/identities/register": roleAdmin,
+	"POST /api/identities/enroll":   roleAdmin,
+
+	"GET /api/webhooks":              roleAdmin,
+	"POST /api/webhooks":             roleAdmin,
+	"DELETE /api/webhooks/:id":       roleAdmin,
+	"GET /api/webhooks/dead-letters": roleAdmin,
+
+	"GET /api/audit": roleAdmin,
+}
+
+// requiredRole returns the least privileged role allowed to call the route a request matched
+func requiredRole(method, route string) string {
+	if role, ok := routeRoles[method+" "+route]; ok {
+		return role
+	}
+	if method == http.MethodGet || method == http.MethodHead || readOnlyRoutes[method+" "+route] {
+		return roleViewer
+	}
+	return roleRegistrar
+}
+
+// authorize rejects requests from users whose roles don't permit the route. It runs after
+// requireAuth, and lets every request through when authentication is disabled.
+func authorize(c *gin.Context) {
+	if !authEnabled() {
+		c.Next()
+		return
+	}
+
+	role := requiredRole(c.Request.Method, routePattern(c))
+	if !hasRole(c, role) {
+		subject, _ := requestSubject(c)
+		requestLogger(c).Warn("Request forbidden", "requiredRole", role, "roles", c.GetStringSlice(rolesKey))
+		c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": fmt.Sprintf("User %s needs the %s role for this request", subject, role)})
+		return
+	}
+	c.Next()
+}
+
+// hasRole reports whether the authenticated user holds the given role or a more privileged one
+func hasRole(c *gin.Context, role string) bool {
+	return rolesInclude(c.GetStringSlice(rolesKey), role)
+}
+
+// rolesInclude reports whether the granted roles include the given role or a more privileged one
+func rolesInclude(granted []string, role string) bool {
+	for _, g := range granted {
+		if roleRanks[g] >= roleRanks[role] {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,150 @@
+/*
+Copyright 2021 IBM All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package main
+
+import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	// redisCachePrefix starts the key of every cached response, so the cache can share a Redis database
+	redisCachePrefix = "studentrecords:cache:"
+
+	// redisCacheTimeout bounds each call to Redis, so a slow Redis costs a cache miss rather than the request
+	redisCacheTimeout = 500 * time.Millisecond
+
+	// redisScanCount is how many keys each SCAN asks Redis to look at while invalidating
+	redisScanCount = 500
+)
+
+// redisCache keeps cached responses in Redis, so they are shared by every replica of the API and
+// a write through one replica invalidates them for all
+type redisCache struct {
+	client *redis.Client
+}
+
+// redisCacheEntry is a cached response as stored in Redis
+type redisCacheEntry struct {
+	ContentType string            `json:"contentType"`
+	Headers     map[string]string `json:"headers,omitempty"`
+	Body        []byte            `json:"body"`
+}
+
+// newRedisCache connects to the Redis server at a URL such as redis://localhost:6379/0
+func newRedisCache(url string) (*redisCache, error) {
+	options, err := redis.ParseURL(url)
+	if err != nil {
+		return nil, fmt.Errorf("invalid CACHE_REDIS_URL: %w", err)
+	}
+	return &redisCache{client: redis.NewClient(options)}, nil
+}
+
+// redisKey returns the Redis key of a cached response, which names the student the response
+// shows so the responses about a student can be found by pattern
+func redisKey(key, studentID string) string {
+	if studentID == "" {
+		return redisCachePrefix + "list:" + key
+	}
+	return studentPrefix(studentID) + key
+}
+
+// studentPrefix starts the keys of the responses showing a student. The ID is hex-encoded so it
+// cannot contain pattern characters or the separator.
+func studentPrefix(id string) string {
+	return redisCachePrefix + "student:" + hex.EncodeToString([]byte(id)) + ":"
+}
+
+// get returns the cached entry for key if Redis still holds it
+func (rc *redisCache) get(ctx context.Context, key, studentID string) (cacheEntry, bool) {
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), redisCacheTimeout)
+	defer cancel()
+
+	data, err := rc.client.Get(ctx, redisKey(key, studentID)).Bytes()
+	if err != nil {
+		if err != redis.Nil {
+			slog.Warn("Failed to read cached response from Redis", "error", err)
+		}
+		return cacheEntry{}, false
+	}
+
+	var stored redisCacheEntry
+	if err := json.Unmarshal(data, &stored); err != nil {
+		slog.Warn("Discarding unreadable cached response from Redis", "error", err)
+		return cacheEntry{}, false
+	}
+	return cacheEntry{contentType: stored.ContentType, headers: stored.Headers, body: stored.Body, studentID: studentID}, true
+}
+
+// put stores an entry in Redis until it expires
+func (rc *redisCache) put(ctx context.Context, key string, entry cacheEntry) {
+	ttl := time.Until(entry.expires)
+	if ttl <= 0 {
+		return
+	}
+
+	data, err := json.Marshal(redisCacheEntry{ContentType: entry.contentType, Headers: entry.headers, Body: entry.body})
+	if err != nil {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), redisCacheTimeout)
+	defer cancel()
+
+	if err := rc.client.Set(ctx, redisKey(key, entry.studentID), data, ttl).Err(); err != nil {
+		slog.Warn("Failed to cache response in Redis", "error", err)
+	}
+}
+
+// invalidate deletes the entries showing the given students and every entry not about a single student
+func (rc *redisCache) invalidate(ctx context.Context, ids []string) {
+	patterns := []string{redisCachePrefix + "list:*"}
+	for _, id := range ids {
+		patterns = append(patterns, studentPrefix(id)+"*")
+	}
+	rc.deleteMatching(ctx, patterns...)
+}
+
+// clear deletes every cached entry
+func (rc *redisCache) clear(ctx context.Context) {
+	rc.deleteMatching(ctx, redisCachePrefix+"*")
+}
+
+// deleteMatching deletes the keys matching each pattern
+func (rc *redisCache) deleteMatching(ctx context.Context, patterns ...string) {
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), 10*redisCacheTimeout)
+	defer cancel()
+
+	for _, pattern := range patterns {
+		iter := rc.client.Scan(ctx, 0, pattern, redisScanCount).Iterator()
+		var keys []string
+		for iter.Next(ctx) {
+			keys = append(keys, iter.Val())
+		}
+		if err := iter.Err(); err != nil {
+			slog.Error("Failed to find cached responses to invalidate in Redis", "pattern", pattern, "error", err)
+			continue
+		}
+		if len(keys) == 0 {
+			continue
+		}
+		if err := rc.client.Unlink(ctx, keys...).Err(); err != nil {
+			slog.Error("Failed to invalidate cached responses in Redis", "pattern", pattern, "error", err)
+		}
+	}
+}
+
+// close closes the connections to Redis
+func (rc *redisCache) close() {
+	rc.client.Close()
+}
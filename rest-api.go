@@ -8,144 +8,428 @@ package main
 
 import (
 	"bytes"
+	"context"
 	"crypto/x509"
 	"encoding/json"
 	"fmt"
 	"log"
+	"log/slog"
 	"net/http"
 	"os"
 	"path"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/gin-gonic/gin"
-	"github.com/hyperledger/fabric-gateway/pkg/client"
-	"github.com/hyperledger/fabric-gateway/pkg/hash"
+	"github.com/gin-gonic/gin/binding"
 	"github.com/hyperledger/fabric-gateway/pkg/identity"
+	"go.opentelemetry.io/contrib/instrumentation/google.golang.org/grpc/otelgrpc"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/keepalive"
 )
 
 const (
-	mspID        = "Org1MSP"
-	cryptoPath   = "../../test-network/organizations/peerOrganizations/org1.example.com"
-	certPath     = cryptoPath + "/users/User1@org1.example.com/msp/signcerts"
-	keyPath      = cryptoPath + "/users/User1@org1.example.com/msp/keystore"
-	tlsCertPath  = cryptoPath + "/peers/peer0.org1.example.com/tls/ca.crt"
-	peerEndpoint = "dns:///localhost:7051"
-	gatewayPeer  = "peer0.org1.example.com"
-	listenAddr   = ":3000" // REST API server port
+	// Timeouts for the different gRPC calls made to the gateway
+	evaluateTimeout     = 5 * time.Second
+	endorseTimeout      = 15 * time.Second
+	submitTimeout       = 5 * time.Second
+	commitStatusTimeout = 1 * time.Minute
 )
 
-// Global variables to store Fabric client connections
+// Global variables naming the chaincode and channel used by every organization's connection
 var (
-	contract *client.Contract
-	network  *client.Network
-	gw       *client.Gateway
+	chaincodeName string
+	channelName   string
 )
 
 // Student represents a student record
 type Student struct {
-	ID         string `json:"id"`
-	Name       string `json:"name"`
+	ID         string `json:"id" binding:"required,max=64"`
+	Name       string `json:"name" binding:"required,max=100"`
 	Department string `json:"department"`
-	Year       string `json:"year"`
-	CGPA       string `json:"cgpa"`
+	Year       string `json:"year" binding:"omitempty,oneof=1 2 3 4 5"`
+	CGPA       string `json:"cgpa" binding:"omitempty,cgpa"`
+	// Status is set by the ledger, to inactive once the student is soft deleted; it is ignored in request bodies
+	Status string `json:"status,omitempty"`
+	// Version is set by the ledger, counting the writes to the student; it is ignored in request bodies
+	Version uint64 `json:"version,omitempty"`
 }
 
 func main() {
+	initLogging()
+
+	var err error
+	if cfg, err = loadConfig(); err != nil {
+		log.Fatalf("Failed to load configuration: %v", err)
+	}
+	logLevel.Set(cfg.LogLevel)
+
+	// Export traces of requests and the Fabric transactions they make
+	if cfg.TracingEndpoint != "" {
+		shutdownTracing, err := initTracing(cfg.TracingEndpoint)
+		if err != nil {
+			log.Fatalf("Failed to configure tracing: %v", err)
+		}
+		defer shutdownTracing(context.Background())
+	}
+	identities = newWallet(cfg.WalletPath)
+
+	if !authEnabled() {
+		slog.Warn("JWT_SECRET is not set, API requests are not authenticated")
+	}
+
+	// Read crypto material kept in Vault, keeping the server's token alive
+	if cfg.Vault.Address != "" {
+		if vault, err = newVaultClient(cfg.Vault); err != nil {
+			log.Fatalf("Failed to configure Vault: %v", err)
+		}
+		go vault.renewToken()
+	}
+
 	// Initialize Fabric connection
 	initFabricClient()
-	defer gw.Close()
+	defer closeConnection()
+
+	// Resume the chaincode event stream from where it stopped before the restart
+	if cfg.EventsCheckpointFile != "" {
+		if err := chaincodeEvents.useCheckpointFile(cfg.EventsCheckpointFile, cfg.EventsStartBlock); err != nil {
+			log.Fatalf("Failed to configure event checkpointing: %v", err)
+		}
+	}
+
+	// Deliver events to the webhooks registered before the restart
+	webhooks = newWebhookDispatcher(cfg.WebhooksFile, cfg.WebhookDeadLetterFile)
+	if err := webhooks.load(); err != nil {
+		log.Fatalf("Failed to load webhooks: %v", err)
+	}
+
+	// Run bulk imports and exports in the background, resuming those the restart interrupted
+	if cfg.JobsDir != "" {
+		jobs = newJobManager(cfg.JobsDir, cfg.JobWorkers, cfg.JobRetention, gatewayContract{})
+		if err := jobs.load(); err != nil {
+			log.Fatalf("Failed to load jobs: %v", err)
+		}
+	}
+
+	if cfg.DebugEndpoints {
+		publishDebugVars()
+	}
+
+	// Take snapshots of the student state on schedule
+	if cfg.Snapshot.Schedule != "" {
+		if snapshots, err = newSnapshotScheduler(cfg.Snapshot, gatewayContract{}); err != nil {
+			log.Fatalf("Failed to schedule ledger snapshots: %v", err)
+		}
+		snapshots.start()
+	}
+
+	// Keep an audit trail of write requests
+	if cfg.AuditLogFile != "" {
+		auditTrail = newAuditLog(cfg.AuditLogFile)
+	}
+
+	// Cache responses, dropping those that chaincode events show to be out of date
+	if readCache, err = newResponseCache(cfg.CacheTTLs, cfg.CacheRedisURL); err != nil {
+		log.Fatalf("Failed to configure response cache: %v", err)
+	}
+	defer readCache.close()
+	if readCache.enabled() {
+		if err := readCache.invalidateOnEvents(); err != nil {
+			log.Fatalf("Failed to subscribe response cache to events: %v", err)
+		}
+	}
+
+	// Mirror the student state into PostgreSQL for analytic queries
+	if cfg.MirrorDatabaseURL != "" {
+		if mirror, err = newStateMirror(context.Background(), cfg.MirrorDatabaseURL); err != nil {
+			log.Fatalf("Failed to configure state mirror: %v", err)
+		}
+		mirror.start()
+		defer mirror.close()
+	}
+
+	// Periodically log metrics for environments without a Prometheus scraper
+	go logMetricsPeriodically(cfg.MetricsLogInterval)
+
+	// Pick up rotated certificates without waiting for POST /api/admin/identity/reload
+	go watchIdentities(cfg.IdentityWatchInterval)
 
 	// Initialize and start the REST API server
-	router := setupRouter()
-	log.Printf("Starting REST API server on %s", listenAddr)
-	if err := router.Run(listenAddr); err != nil {
-		log.Fatalf("Failed to start server: %v", err)
+	server, err := newServer(setupRouter(gatewayContract{}))
+	if err != nil {
+		log.Fatalf("Failed to configure server: %v", err)
+	}
+
+	// Serve the same operations over gRPC for internal services
+	var grpcServer *grpc.Server
+	if cfg.GRPCListenAddr != "" {
+		if grpcServer, err = newGRPCServer(gatewayContract{}); err != nil {
+			log.Fatalf("Failed to configure gRPC server: %v", err)
+		}
+	}
+	if err := run(server, grpcServer); err != nil {
+		slog.Error("Server stopped", "error", err)
 	}
 }
 
 // initFabricClient initializes the connection to the Fabric network
 func initFabricClient() {
-	// The gRPC client connection is shared by all Gateway connections to this endpoint
-	clientConnection := newGrpcConnection()
+	chaincodeName = cfg.ChaincodeName
+	channelName = cfg.ChannelName
 
-	id := newIdentity()
-	sign := newSign()
-
-	// Establish a Gateway connection using identity, sign function, and gRPC connection
-	var err error
-	gw, err = client.Connect(
-		id,
-		client.WithSign(sign),
-		client.WithHash(hash.SHA256),
-		client.WithClientConnection(clientConnection),
-		// Set timeouts for different gRPC calls
-		client.WithEvaluateTimeout(5*time.Second),
-		client.WithEndorseTimeout(15*time.Second),
-		client.WithSubmitTimeout(5*time.Second),
-		client.WithCommitStatusTimeout(1*time.Minute),
-	)
-	if err != nil {
+	// The default organization is connected up front; others connect when first selected
+	orgs = newOrgRegistry(append([]OrgConfig{defaultOrgConfig()}, cfg.Orgs...)...)
+	if _, err := orgs.connection(orgs.defaultOrg); err != nil {
 		panic(err)
 	}
 
-	// Override default chaincode and channel names through environment variables if present
-	chaincodeName := "studentrecords"
-	if ccname := os.Getenv("CHAINCODE_NAME"); ccname != "" {
-		chaincodeName = ccname
-	}
+	slog.Info("Fabric client initialized successfully", "channel", channelName, "chaincode", chaincodeName, "organizations", orgs.names())
+}
 
-	channelName := "mychannel"
-	if cname := os.Getenv("CHANNEL_NAME"); cname != "" {
-		channelName = cname
-	}
+// setupRouter configures the Gin router with endpoints whose handlers use the given ledger
+func setupRouter(ledger ledgerContract) *gin.Engine {
+	// Gin's default text logger is left out in favour of JSON access logs
+	router := gin.New()
 
-	// Get the network and contract instances
-	network = gw.GetNetwork(channelName)
-	contract = network.GetContract(chaincodeName)
+	// Report invalid request fields by their JSON names, and check student fields before they reach the chaincode
+	registerValidators()
 
-	log.Println("Fabric client initialized successfully")
-}
+	// Reject fields a request does not take in the JSON bodies handlers bind, so typos and
+	// smuggled fields never reach the chaincode
+	binding.EnableDecoderDisallowUnknownFields = cfg.StrictJSON
+
+	// Only take the client address from forwarding headers set by trusted proxies, so clients cannot spoof it
+	if err := router.SetTrustedProxies(cfg.TrustedProxies); err != nil {
+		panic(fmt.Errorf("invalid TRUSTED_PROXIES: %w", err))
+	}
 
-// setupRouter configures the Gin router with endpoints
-func setupRouter() *gin.Engine {
-	router := gin.Default()
+	// Reply 405 rather than 404 to a request for an existing path with an unsupported method
+	router.HandleMethodNotAllowed = true
+	router.NoMethod(methodNotAllowed)
 
 	// Middleware for handling errors
 	router.Use(gin.Recovery())
 
-	// Define API routes
-	router.GET("/api/students", getAllStudents)
-	router.GET("/api/students/:id", getStudentByID)
-	router.POST("/api/students", createStudent)
-	router.PUT("/api/students/:id", updateStudent)
-	router.DELETE("/api/students/:id", deleteStudent)
-	router.POST("/api/init", initLedger)
+	// Middleware for compressing responses, first so it compresses the body the others settle on
+	router.Use(gzipMiddleware(cfg.GzipMinSize))
+
+	// Middleware for tracing, before the access log so its lines carry the trace ID
+	router.Use(tracingMiddleware())
+
+	// Middleware for request correlation IDs and access logs, early so every later log line carries the ID
+	router.Use(requestLoggingMiddleware)
+
+	// Middleware for recording request latency
+	router.Use(metricsMiddleware)
+
+	// Middleware for cross-origin browser requests
+	router.Use(corsMiddleware(cfg.CORSOrigins, cfg.CORSMethods, cfg.CORSHeaders, cfg.CORSExposeHeaders))
+
+	// Middleware capping the size of request bodies
+	router.Use(bodyLimitMiddleware(int64(cfg.MaxBodyBytes)))
+
+	// Operational routes are served outside the concurrency limit so they stay responsive under load
+	router.GET("/metrics", metricsHandler())
+	router.GET("/health", health)
+	router.GET("/ready", ready)
+	router.GET("/healthz", health)
+	router.GET("/readyz", readyDeep)
+
+	// Handlers reach the ledger through the request rather than a global
+	router.Use(provideLedger(ledger))
+
+	// The API is served under each of its roots, sharing the rate limit buckets and the
+	// concurrency limit between them
+	limits := apiLimits{
+		rateLimit:        rateLimitMiddleware(newRateLimiter(cfg.ReadRateLimit, cfg.ReadRateBurst), newRateLimiter(cfg.WriteRateLimit, cfg.WriteRateBurst)),
+		concurrencyLimit: newConcurrencyLimiter(cfg.MaxInFlight, cfg.MaxQueued).middleware(),
+	}
+	for _, root := range apiRoots {
+		registerAPIRoutes(router.Group(root.prefix, root.handlers()...), limits)
+	}
 
 	return router
 }
 
+// apiLimits are the middleware limiting requests, shared by every root the API is served under
+type apiLimits struct {
+	rateLimit        gin.HandlerFunc
+	concurrencyLimit gin.HandlerFunc
+}
+
+// registerAPIRoutes adds the API's routes to the group of one of its roots
+func registerAPIRoutes(root *gin.RouterGroup, limits apiLimits) {
+	// API documentation is public so consumers can explore the API before logging in
+	root.GET("/docs", swaggerUI)
+	root.GET("/docs/openapi.json", openAPISpec)
+	root.GET("/docs/openapi.yaml", openAPISpecYAMLFile)
+
+	// Logins are rate limited by client address, sharing the buckets of the other API routes
+	root.POST("/auth/login", limits.rateLimit, login)
+
+	// Define API routes. Writes are recorded in the audit trail, even if refused. Requests must
+	// be authenticated and permitted by the user's roles, are rate limited per client, and writes
+	// shed while the peer is slow, before they take an in-flight slot. A transient object in a
+	// JSON body is passed to the chaincode as transient data.
+	api := root.Group("",
+		auditMiddleware,
+		requireAuth,
+		authorize,
+		limits.rateLimit,
+		backpressureMiddleware,
+		limits.concurrencyLimit,
+		selectOrg,
+		selectChannel,
+		transactionTimeoutMiddleware,
+		endorsingOrgsMiddleware,
+		transientMiddleware,
+		readCache.middleware(),
+		recordTransactionInfo,
+	)
+	// Event streams hold their connection open for as long as the client listens, so they are
+	// left out of the concurrency limit and the response cache
+	streams := root.Group("", requireAuth, authorize, limits.rateLimit)
+	streams.GET("/events/ws", streamChaincodeEvents)
+	streams.GET("/blocks/stream", streamBlocks)
+
+	// Profiles and runtime state for diagnosing latency and leaks, for admins only. A CPU profile
+	// or trace runs for as long as it is asked to, so these are left out of the concurrency limit.
+	if cfg.DebugEndpoints {
+		debug := root.Group("/admin/debug", requireAuth, authorize, requireAdmin)
+		debug.GET("/vars", debugVars)
+		debug.GET("/pprof/*profile", debugProfile)
+		debug.POST("/pprof/*profile", debugProfile)
+	}
+
+	// Ledger routes transact on the channel chosen with X-Channel, or named in the URL under /api/channels/:channel
+	registerLedgerRoutes(api)
+	registerLedgerRoutes(api.Group(strings.TrimPrefix(channelRoutePrefix, "/api")))
+	api.GET("/channels", listChannels)
+	api.GET("/transactions/:txid", getTransactionStatus)
+
+	// Analytic reads served from the PostgreSQL mirror of the configured channel
+	if mirror != nil {
+		api.GET("/mirror/students", listMirroredStudents)
+		api.GET("/mirror/students/stats", getMirroredStudentStats)
+		api.GET("/mirror/students/:id/history", getMirroredStudentHistory)
+	}
+
+	// Admin routes
+	api.POST("/selftest", requireAdmin, selfTest)
+	api.POST("/audit/validate", requireAdmin, validateLedger)
+	api.GET("/identities", requireAdmin, listIdentities)
+	api.POST("/identities", requireAdmin, importIdentity)
+	api.DELETE("/identities/:label", requireAdmin, removeIdentity)
+	api.POST("/identities/register", requireAdmin, registerIdentity)
+	api.POST("/identities/enroll", requireAdmin, enrollIdentity)
+	api.POST("/admin/identity/reload", requireAdmin, reloadIdentity)
+	api.GET("/admin/chaincode/installed", requireAdmin, listInstalledChaincodes)
+	api.POST("/admin/chaincode/install", requireAdmin, installChaincode)
+	api.POST("/admin/chaincode/approve", requireAdmin, approveChaincode)
+	api.GET("/admin/chaincode/readiness", requireAdmin, checkCommitReadiness)
+	api.POST("/admin/chaincode/commit", requireAdmin, commitChaincode)
+	api.GET("/webhooks", requireAdmin, listWebhooks)
+	api.POST("/webhooks", requireAdmin, registerWebhook)
+	api.DELETE("/webhooks/:id", requireAdmin, removeWebhook)
+	api.GET("/webhooks/dead-letters", requireAdmin, listDeadLetters)
+	if auditTrail != nil {
+		api.GET("/audit", requireAdmin, listAuditRecords)
+	}
+
+	// Background imports and exports, each seen only by the client that created it
+	if jobs != nil {
+		api.GET("/jobs", listJobs)
+		api.GET("/jobs/:id", getJob)
+		api.GET("/jobs/:id/result", getJobResult)
+		api.DELETE("/jobs/:id", cancelJob)
+	}
+}
+
+// registerLedgerRoutes adds the routes that read and write the channel's ledger to the group
+func registerLedgerRoutes(ledger *gin.RouterGroup) {
+	ledger.GET("/students", getAllStudents)
+	ledger.GET("/students/export", exportStudents)
+	ledger.GET("/students/search", searchStudents)
+	ledger.GET("/students/digest", getStateDigest)
+	ledger.GET("/students/stats", getStudentStats)
+	ledger.GET("/students/diff", diffStudents)
+	ledger.GET("/students/:id", getStudentByID)
+	ledger.HEAD("/students/:id", studentExists)
+	ledger.GET("/students/:id/private", getStudentPrivate)
+	ledger.PUT("/students/:id/private", setStudentPrivate)
+	ledger.GET("/students/:id/history", getStudentHistory)
+	ledger.POST("/students", idempotent, createStudent)
+	ledger.POST("/students/batch", createStudents)
+	ledger.POST("/students/import", importStudents)
+	ledger.GET("/students/import/reports/:id", getImportReport)
+	if jobs != nil {
+		ledger.POST("/students/import/jobs", createImportJob)
+		ledger.POST("/students/export/jobs", createExportJob)
+	}
+	ledger.POST("/students/tag", tagStudents)
+	ledger.POST("/students/query", queryStudents)
+	ledger.POST("/students/lookup", lookupStudents)
+	ledger.POST("/students/private", createStudentPrivate)
+	ledger.PUT("/students/:id", updateStudent)
+	ledger.PATCH("/students/:id", patchStudent)
+	ledger.DELETE("/students/:id", deleteStudent)
+	ledger.POST("/students/:id/restore", restoreStudent)
+	ledger.DELETE("/students/:id/purge", purgeStudent)
+	ledger.POST("/init", initLedger)
+	ledger.GET("/contract/version", getContractVersion)
+	ledger.GET("/chaincodes", listChaincodes)
+	ledger.POST("/tx/evaluate", evaluateProxyTransaction)
+	ledger.POST("/tx/submit", submitProxyTransaction)
+}
+
+// methodNotAllowed reports a request for an existing path with an unsupported method.
+// Gin has already set the Allow header to the supported methods.
+func methodNotAllowed(c *gin.Context) {
+	c.JSON(http.StatusMethodNotAllowed, gin.H{
+		"error":   fmt.Sprintf("Method %s is not allowed on %s", c.Request.Method, c.Request.URL.Path),
+		"allowed": strings.Split(c.Writer.Header().Get("Allow"), ", "),
+	})
+}
+
 // initLedger initializes the ledger with sample data
 func initLedger(c *gin.Context) {
-	log.Println("Initializing ledger...")
+	requestLogger(c).Info("Initializing ledger")
 
-	_, err := contract.SubmitTransaction("InitLedger")
+	_, err := submitWithRetry(c.Request.Context(), requestLedger(c), "InitLedger")
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("Failed to initialize ledger: %v", err)})
+		writeTransactionError(c, "initialize ledger", err)
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{"message": "Ledger initialized successfully"})
+	writeWithTransaction(c, http.StatusOK, gin.H{"message": "Ledger initialized successfully"})
 }
 
-// getAllStudents retrieves all student records
+// getAllStudents retrieves all student records, or those selected by the filter and sort
+// query parameters, such as ?branch=CSE&min_cgpa=8.0&sort=cgpa:desc
 func getAllStudents(c *gin.Context) {
-	log.Println("Retrieving all students...")
+	if !acceptsStudentList(c) {
+		return
+	}
 
-	result, err := contract.EvaluateTransaction("GetAllStudents")
+	filter, err := parseStudentFilter(c)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("Failed to get students: %v", err)})
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Invalid query: %v", err)})
+		return
+	}
+
+	requestLogger(c).Info("Retrieving all students")
+
+	// Without filters every student is listed, which may be too many to hold in memory at once
+	if !filter.active() {
+		streamStudentList(c, filter)
+		return
+	}
+
+	result, err := evaluateStudentList(c, filter)
+	if err != nil {
+		writeTransactionError(c, "get students", err)
 		return
 	}
 
@@ -154,18 +438,32 @@ func getAllStudents(c *gin.Context) {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("Failed to parse student data: %v", err)})
 		return
 	}
+	writeStudentList(c, filter.apply(students))
+}
+
+// getStateDigest returns a digest of all student records, for comparing the ledger against a backup
+func getStateDigest(c *gin.Context) {
+	requestLogger(c).Info("Computing state digest")
+
+	result, err := requestLedger(c).EvaluateTransaction(c.Request.Context(), "StateDigest")
+	if err != nil {
+		writeTransactionError(c, "compute state digest", err)
+		return
+	}
 
-	c.JSON(http.StatusOK, students)
+	c.JSON(http.StatusOK, gin.H{"digest": string(result)})
 }
 
 // getStudentByID retrieves a specific student by ID
 func getStudentByID(c *gin.Context) {
 	id := c.Param("id")
-	log.Printf("Retrieving student with ID: %s", id)
+	requestLogger(c).Info("Retrieving student", "studentId", id)
 
-	result, err := contract.EvaluateTransaction("ReadStudent", id)
+	result, err := readStudentRecord(c.Request.Context(), requestLedger(c), id)
 	if err != nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": fmt.Sprintf("Student not found: %v", err)})
+		if !writeStudentError(c, id, err) {
+			writeTransactionError(c, "read student", err)
+		}
 		return
 	}
 
@@ -175,37 +473,115 @@ func getStudentByID(c *gin.Context) {
 		return
 	}
 
+	// The ETag changes with the student's version, and is what a PUT or PATCH must name in
+	// If-Match and a client polling the student sends back in If-None-Match to be told it has
+	// not changed
+	version, err := recordVersion(result)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("Failed to parse student data: %v", err)})
+		return
+	}
+	etag := studentETag(id, version)
+	c.Header("ETag", etag)
+	c.Header("Cache-Control", studentCacheControl)
+	if ifNoneMatch(c, etag) {
+		c.Status(http.StatusNotModified)
+		return
+	}
+
+	// Return only the requested fields, e.g. ?fields=id,name,courses.code
+	if fields := c.Query("fields"); fields != "" {
+		selection, err := parseFieldSelection(fields)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Invalid fields: %v", err)})
+			return
+		}
+
+		projected, err := selection.project(student)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Invalid fields: %v", err)})
+			return
+		}
+
+		c.JSON(http.StatusOK, projected)
+		return
+	}
+
 	c.JSON(http.StatusOK, student)
 }
 
+// studentExists answers 200 or 404, without a body, depending on whether a student exists, so
+// clients can check an ID without fetching the record. A soft deleted student is answered 404
+// like the lists leave it out, unless include_deleted=true.
+func studentExists(c *gin.Context) {
+	id := c.Param("id")
+	includeDeleted, err := parseIncludeDeleted(c)
+	if err != nil {
+		c.Status(http.StatusBadRequest)
+		return
+	}
+	requestLogger(c).Info("Checking student exists", "studentId", id)
+
+	result, err := readStudentRecord(c.Request.Context(), requestLedger(c), id)
+	if err != nil {
+		if studentError(err) == errStudentNotFound {
+			c.Status(http.StatusNotFound)
+			return
+		}
+		writeTransactionError(c, "check student exists", err)
+		return
+	}
+
+	var student Student
+	if err := json.Unmarshal(result, &student); err != nil {
+		c.Status(http.StatusInternalServerError)
+		return
+	}
+	if !includeDeleted && student.Status == statusInactive {
+		c.Status(http.StatusNotFound)
+		return
+	}
+	c.Status(http.StatusOK)
+}
+
 // createStudent adds a new student record
 func createStudent(c *gin.Context) {
 	var student Student
 
 	// Parse request body
 	if err := c.ShouldBindJSON(&student); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Invalid request body: %v", err)})
+		writeBindError(c, err)
 		return
 	}
 
-	log.Printf("Creating student with ID: %s", student.ID)
+	requestLogger(c).Info("Creating student", "studentId", student.ID)
 
-	// Submit transaction to create student
-	_, err := contract.SubmitTransaction(
-		"CreateStudent", 
-		student.ID, 
-		student.Name, 
-		student.Department, 
-		student.Year, 
-		student.CGPA,
-	)
-	
+	strategy, err := requestCommitStrategy(c)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("Failed to create student: %v", err)})
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if !applyCommitTimeout(c) {
+		return
+	}
+
+	if strategy != waitForCommit {
+		if withStudentDetails(c, student) {
+			submitWithoutCommitWait(c, strategy, "CreateStudent", studentArgs(student)...)
+		}
+		return
+	}
+
+	// Submit transaction to create student
+	if err := createStudentRecord(c.Request.Context(), requestLedger(c), student); err != nil {
+		if writeStudentError(c, student.ID, err) {
+			return
+		}
+		writeTransactionError(c, "create student", err)
 		return
 	}
 
-	c.JSON(http.StatusCreated, student)
+	writeWithTransaction(c, http.StatusCreated, student)
 }
 
 // updateStudent updates an existing student record
@@ -213,59 +589,165 @@ func updateStudent(c *gin.Context) {
 	id := c.Param("id")
 	var student Student
 
-	// Parse request body
-	if err := c.ShouldBindJSON(&student); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Invalid request body: %v", err)})
+	// Parse request body, taking the ID from the URL path rather than requiring it in the body
+	if err := decodeJSONBody(c, &student); err != nil {
+		writeBindError(c, err)
+		return
+	}
+	student.ID = id
+	if err := binding.Validator.ValidateStruct(student); err != nil {
+		writeBindError(c, err)
 		return
 	}
 
-	log.Printf("Updating student with ID: %s", id)
+	etag, ok := requireIfMatch(c)
+	if !ok {
+		return
+	}
 
-	// Use the ID from the URL path rather than from the JSON body
-	_, err := contract.SubmitTransaction(
-		"UpdateStudent", 
-		id, 
-		student.Name, 
-		student.Department, 
-		student.Year, 
-		student.CGPA,
-	)
-	
+	requestLogger(c).Info("Updating student", "studentId", id)
+
+	version, ok := ifMatchVersion(c, id, etag)
+	if !ok {
+		return
+	}
+
+	if !checkUnmodifiedSince(c, id) {
+		return
+	}
+
+	strategy, err := requestCommitStrategy(c)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("Failed to update student: %v", err)})
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if !applyCommitTimeout(c) {
 		return
 	}
 
-	// Set the ID to be consistent with the URL parameter
-	student.ID = id
-	c.JSON(http.StatusOK, student)
+	if strategy != waitForCommit {
+		if withStudentDetails(c, student) {
+			submitWithoutCommitWait(c, strategy, "UpdateStudent", updateStudentArgs(student, version)...)
+		}
+		return
+	}
+
+	if err := updateStudentRecord(c.Request.Context(), requestLedger(c), student, version); err != nil {
+		if writeStudentError(c, id, err) {
+			return
+		}
+		writeTransactionError(c, "update student", err)
+		return
+	}
+
+	// Only a versioned update knows the version it wrote; If-Match: * could have written any
+	if version != "" {
+		written, _ := strconv.ParseUint(version, 10, 64)
+		student.Version = written + 1
+		c.Header("ETag", studentETag(id, student.Version))
+	}
+	writeWithTransaction(c, http.StatusOK, student)
 }
 
-// deleteStudent removes a student record
+// deleteStudent soft deletes a student record, marking it inactive so it is left out of student
+// lists but can be restored
 func deleteStudent(c *gin.Context) {
 	id := c.Param("id")
-	log.Printf("Deleting student with ID: %s", id)
+	requestLogger(c).Info("Deleting student", "studentId", id)
 
-	_, err := contract.SubmitTransaction("DeleteStudent", id)
+	strategy, err := requestCommitStrategy(c)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("Failed to delete student: %v", err)})
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if strategy != waitForCommit {
+		submitWithoutCommitWait(c, strategy, "DeactivateStudent", id)
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{"message": fmt.Sprintf("Student %s deleted successfully", id)})
+	if err := deactivateStudentRecord(c.Request.Context(), requestLedger(c), id); err != nil {
+		if writeStudentError(c, id, err) {
+			return
+		}
+		writeTransactionError(c, "delete student", err)
+		return
+	}
+
+	writeWithTransaction(c, http.StatusOK, gin.H{"message": fmt.Sprintf("Student %s deleted successfully", id)})
 }
 
-// newGrpcConnection creates a secure gRPC connection to the Fabric gateway (peer)
-func newGrpcConnection() *grpc.ClientConn {
-	certificatePEM, err := os.ReadFile(tlsCertPath)
+// restoreStudent makes a soft deleted student record active again
+func restoreStudent(c *gin.Context) {
+	id := c.Param("id")
+	requestLogger(c).Info("Restoring student", "studentId", id)
+
+	strategy, err := requestCommitStrategy(c)
 	if err != nil {
-		panic(fmt.Errorf("failed to read TLS certificate file: %w", err))
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if strategy != waitForCommit {
+		submitWithoutCommitWait(c, strategy, "RestoreStudent", id)
+		return
+	}
+
+	if err := restoreStudentRecord(c.Request.Context(), requestLedger(c), id); err != nil {
+		if writeStudentError(c, id, err) {
+			return
+		}
+		writeTransactionError(c, "restore student", err)
+		return
+	}
+
+	writeWithTransaction(c, http.StatusOK, gin.H{"message": fmt.Sprintf("Student %s restored successfully", id)})
+}
+
+// purgeStudent removes a student record from the world state for good
+func purgeStudent(c *gin.Context) {
+	id := c.Param("id")
+	requestLogger(c).Info("Purging student", "studentId", id)
+
+	strategy, err := requestCommitStrategy(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if strategy != waitForCommit {
+		submitWithoutCommitWait(c, strategy, "DeleteStudent", id)
+		return
+	}
+
+	if err := deleteStudentRecord(c.Request.Context(), requestLedger(c), id); err != nil {
+		if writeStudentError(c, id, err) {
+			return
+		}
+		writeTransactionError(c, "purge student", err)
+		return
+	}
+
+	writeWithTransaction(c, http.StatusOK, gin.H{"message": fmt.Sprintf("Student %s permanently deleted", id)})
+}
+
+// newGrpcConnection creates a secure gRPC connection to the organization's Fabric gateway (peer)
+func newGrpcConnection(org OrgConfig) (*grpc.ClientConn, error) {
+	certificatePEM := org.TLSCertPEM
+	if len(certificatePEM) == 0 && org.Vault != nil && vault != nil {
+		var err error
+		if certificatePEM, err = vault.tlsCACertificate(*org.Vault); err != nil {
+			return nil, err
+		}
+	}
+	if len(certificatePEM) == 0 {
+		var err error
+		if certificatePEM, err = os.ReadFile(org.TLSCertPath); err != nil {
+			return nil, fmt.Errorf("failed to read TLS certificate file: %w", err)
+		}
 	}
 
 	// Parse the TLS certificate from PEM
 	certificate, err := identity.CertificateFromPEM(certificatePEM)
 	if err != nil {
-		panic(err)
+		return nil, err
 	}
 
 	// Create a certificate pool and add our peer's TLS certificate
@@ -273,59 +755,95 @@ func newGrpcConnection() *grpc.ClientConn {
 	certPool.AddCert(certificate)
 
 	// Create transport credentials that enforce TLS and check the server's name
-	transportCredentials := credentials.NewClientTLSFromCert(certPool, gatewayPeer)
+	transportCredentials := credentials.NewClientTLSFromCert(certPool, org.GatewayPeer)
 
 	// Create the gRPC client connection using the peer endpoint and transport credentials
-	connection, err := grpc.Dial(peerEndpoint, grpc.WithTransportCredentials(transportCredentials))
+	// Keepalive pings let a connection to a peer that has gone away fail, rather than look ready
+	// until the next call. Peers reject pings more often than once a minute by default.
+	connection, err := grpc.Dial(org.PeerEndpoint,
+		grpc.WithTransportCredentials(transportCredentials),
+		grpc.WithKeepaliveParams(keepalive.ClientParameters{Time: 2 * time.Minute, Timeout: 20 * time.Second, PermitWithoutStream: true}),
+		// Trace each gRPC call to the peer under the span of the transaction that made it
+		grpc.WithStatsHandler(otelgrpc.NewClientHandler()),
+	)
 	if err != nil {
-		panic(fmt.Errorf("failed to create gRPC connection: %w", err))
+		return nil, fmt.Errorf("failed to create gRPC connection: %w", err)
 	}
 
-	return connection
+	return connection, nil
 }
 
-// newIdentity creates a client identity using an X.509 certificate
-func newIdentity() *identity.X509Identity {
-	certificatePEM, err := readFirstFile(certPath)
-	if err != nil {
-		panic(fmt.Errorf("failed to read certificate file: %w", err))
+// newIdentity creates a client identity for the organization using an X.509 certificate,
+// taken from the wallet if the organization names an identity, or from Vault
+func newIdentity(org OrgConfig) (*identity.X509Identity, error) {
+	var certificatePEM []byte
+	if org.Vault != nil {
+		certificate, _, err := orgVaultCredentials(org)
+		if err != nil {
+			return nil, err
+		}
+		certificatePEM = []byte(certificate)
+	} else if org.Identity != "" {
+		id, err := walletCredentials(org)
+		if err != nil {
+			return nil, err
+		}
+		certificatePEM = []byte(id.Credentials.Certificate)
+	} else {
+		var err error
+		if certificatePEM, err = readFirstFile(org.CertPath); err != nil {
+			return nil, fmt.Errorf("failed to read certificate file: %w", err)
+		}
 	}
 
 	// Parse the certificate
 	certificate, err := identity.CertificateFromPEM(certificatePEM)
 	if err != nil {
-		panic(err)
+		return nil, err
 	}
 
 	// Create a new X509 identity using the MSP ID and the parsed certificate
-	id, err := identity.NewX509Identity(mspID, certificate)
-	if err != nil {
-		panic(err)
-	}
-
-	return id
+	return identity.NewX509Identity(org.MSPID, certificate)
 }
 
-// newSign creates a signing function using the user's private key
-func newSign() identity.Sign {
-	privateKeyPEM, err := readFirstFile(keyPath)
-	if err != nil {
-		panic(fmt.Errorf("failed to read private key file: %w", err))
+// newSign creates a signing function for the identity using the organization user's private
+// key, taken from the wallet if the organization names an identity or from Vault, or kept in its
+// HSM if it has one. It also returns a function releasing the key once the signing function is no
+// longer used.
+func newSign(org OrgConfig, id *identity.X509Identity) (identity.Sign, func() error, error) {
+	if org.HSM != nil {
+		return newHSMSign(org, id)
+	}
+
+	var privateKeyPEM []byte
+	if org.Vault != nil {
+		_, privateKey, err := orgVaultCredentials(org)
+		if err != nil {
+			return nil, nil, err
+		}
+		privateKeyPEM = []byte(privateKey)
+	} else if org.Identity != "" {
+		id, err := walletCredentials(org)
+		if err != nil {
+			return nil, nil, err
+		}
+		privateKeyPEM = []byte(id.Credentials.PrivateKey)
+	} else {
+		var err error
+		if privateKeyPEM, err = readFirstFile(org.KeyPath); err != nil {
+			return nil, nil, fmt.Errorf("failed to read private key file: %w", err)
+		}
 	}
 
 	// Parse the PEM-encoded private key
 	privateKey, err := identity.PrivateKeyFromPEM(privateKeyPEM)
 	if err != nil {
-		panic(err)
+		return nil, nil, err
 	}
 
 	// Create a signing function from the private key
 	sign, err := identity.NewPrivateKeySign(privateKey)
-	if err != nil {
-		panic(err)
-	}
-
-	return sign
+	return sign, func() error { return nil }, err
 }
 
 // readFirstFile reads the first file found within the given directory
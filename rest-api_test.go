@@ -0,0 +1,261 @@
+/*
+Copyright 2021 IBM All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"reflect"
+	"testing"
+)
+
+func TestGetStateDigest(t *testing.T) {
+	ledger := &fakeLedger{respond: func(name string, _ []string) ([]byte, error) {
+		if name == "StateDigest" {
+			return []byte("5f0d8c"), nil
+		}
+		return nil, nil
+	}}
+	router := newTestRouter(t, ledger, nil)
+
+	response := serveRequest(router, http.MethodGet, "/api/students/digest", "")
+	if response.Code != http.StatusOK {
+		t.Fatalf("status = %d, body %s", response.Code, response.Body)
+	}
+	if got, want := response.Body.String(), `{"digest":"5f0d8c"}`; got != want {
+		t.Errorf("body = %s, want %s", got, want)
+	}
+	if len(ledger.submitted()) != 0 {
+		t.Error("the digest was submitted rather than evaluated")
+	}
+
+	ledger.respond = func(string, []string) ([]byte, error) {
+		return nil, errors.New("chaincode response 500, failed to read the world state")
+	}
+	if response := serveRequest(router, http.MethodGet, "/api/students/digest", ""); response.Code != http.StatusInternalServerError {
+		t.Errorf("status for a failed evaluation = %d, want 500, body %s", response.Code, response.Body)
+	}
+}
+
+func TestMethodNotAllowed(t *testing.T) {
+	tests := []struct {
+		method string
+		target string
+		allow  string
+	}{
+		{method: http.MethodPatch, target: "/api/students/S1/private", allow: "GET, PUT"},
+		{method: http.MethodDelete, target: "/api/students", allow: "GET, POST"},
+		{method: http.MethodPost, target: "/api/students/S1", allow: "GET, HEAD, PUT, PATCH, DELETE"},
+		{method: http.MethodPut, target: "/health", allow: "GET"},
+	}
+
+	ledger := &fakeLedger{}
+	router := newTestRouter(t, ledger, nil)
+	for _, test := range tests {
+		t.Run(test.method+" "+test.target, func(t *testing.T) {
+			response := serveRequest(router, test.method, test.target, `{"id":"S1"}`)
+			if response.Code != http.StatusMethodNotAllowed {
+				t.Fatalf("status = %d, want 405, body %s", response.Code, response.Body)
+			}
+			if allow := response.Header().Get("Allow"); allow != test.allow {
+				t.Errorf("Allow = %q, want %q", allow, test.allow)
+			}
+
+			var body struct {
+				Error   string   `json:"error"`
+				Allowed []string `json:"allowed"`
+			}
+			if err := json.Unmarshal(response.Body.Bytes(), &body); err != nil {
+				t.Fatalf("parsing response: %v", err)
+			}
+			if want := "Method " + test.method + " is not allowed on " + test.target; body.Error != want {
+				t.Errorf("error = %q, want %q", body.Error, want)
+			}
+			if want := splitList(test.allow); !reflect.DeepEqual(body.Allowed, want) {
+				t.Errorf("allowed = %q, want %q", body.Allowed, want)
+			}
+		})
+	}
+
+	if calls := ledger.calls; len(calls) != 0 {
+		t.Errorf("ledger was called %+v, want no calls", calls)
+	}
+}
+
+// pagedLedger answers GetStudentsPage with pages of students chained by bookmarks, and
+// ReadStudent with storedStudent
+func pagedLedger(pages map[string]string) *fakeLedger {
+	return &fakeLedger{respond: func(name string, args []string) ([]byte, error) {
+		switch name {
+		case "GetStudentsPage":
+			return []byte(pages[args[1]]), nil
+		case "ReadStudent":
+			if args[0] == "S1" {
+				return []byte(storedStudent), nil
+			}
+			return nil, fmt.Errorf("chaincode response 500, the student %s does not exist", args[0])
+		}
+		return nil, nil
+	}}
+}
+
+func TestGetAllStudents(t *testing.T) {
+	ledger := pagedLedger(map[string]string{
+		"":   `{"students":[{"id":"S1","name":"Alice"},{"id":"S2","name":"Bob"}],"bookmark":"S3"}`,
+		"S3": `{"students":[{"id":"S3","name":"Carol"}],"bookmark":""}`,
+	})
+	router := newTestRouter(t, ledger, nil)
+
+	response := serveRequest(router, http.MethodGet, "/api/students", "")
+	if response.Code != http.StatusOK {
+		t.Fatalf("status = %d, body %s", response.Code, response.Body)
+	}
+	var students []Student
+	if err := json.Unmarshal(response.Body.Bytes(), &students); err != nil {
+		t.Fatalf("parsing response %s: %v", response.Body, err)
+	}
+	var ids []string
+	for _, student := range students {
+		ids = append(ids, student.ID)
+	}
+	if want := []string{"S1", "S2", "S3"}; !reflect.DeepEqual(ids, want) {
+		t.Errorf("listed %q, want every page %q", ids, want)
+	}
+}
+
+func TestGetAllStudentsFailure(t *testing.T) {
+	ledger := &fakeLedger{respond: func(string, []string) ([]byte, error) {
+		return nil, errors.New("failed to evaluate transaction: peer crashed")
+	}}
+	router := newTestRouter(t, ledger, nil)
+
+	response := serveRequest(router, http.MethodGet, "/api/students", "")
+	if response.Code != http.StatusInternalServerError {
+		t.Fatalf("status = %d, want 500, body %s", response.Code, response.Body)
+	}
+	var body struct {
+		Error  string `json:"error"`
+		Detail string `json:"detail"`
+	}
+	if err := json.Unmarshal(response.Body.Bytes(), &body); err != nil {
+		t.Fatalf("parsing response %s: %v", response.Body, err)
+	}
+	if body.Error != failureTransaction || body.Detail != "failed to evaluate transaction: peer crashed" {
+		t.Errorf("body = %s, want the failure and its detail", response.Body)
+	}
+}
+
+func TestGetStudentByID(t *testing.T) {
+	router := newTestRouter(t, pagedLedger(nil), nil)
+
+	response := serveRequest(router, http.MethodGet, "/api/students/S1", "")
+	if response.Code != http.StatusOK {
+		t.Fatalf("status = %d, body %s", response.Code, response.Body)
+	}
+	if etag := response.Header().Get("ETag"); etag != studentETag("S1", 3) {
+		t.Errorf("ETag = %s, want the ETag of the student's version", etag)
+	}
+	var student map[string]interface{}
+	if err := json.Unmarshal(response.Body.Bytes(), &student); err != nil {
+		t.Fatalf("parsing response %s: %v", response.Body, err)
+	}
+	if student["id"] != "S1" || student["name"] != "Alice" || student["branch"] != "CSE" {
+		t.Errorf("body = %s, want the stored student", response.Body)
+	}
+
+	response = serveRequest(router, http.MethodGet, "/api/students/S9", "")
+	if response.Code != http.StatusNotFound {
+		t.Errorf("status for a missing student = %d, want 404, body %s", response.Code, response.Body)
+	}
+}
+
+func TestCreateStudent(t *testing.T) {
+	ledger := &fakeLedger{}
+	router := newTestRouter(t, ledger, nil)
+
+	response := serveRequest(router, http.MethodPost, "/api/students", `{"id":"S1","name":"Alice","department":"CSE","cgpa":"9.1"}`)
+	if response.Code != http.StatusCreated {
+		t.Fatalf("status = %d, body %s", response.Code, response.Body)
+	}
+	var body struct {
+		Data Student `json:"data"`
+	}
+	if err := json.Unmarshal(response.Body.Bytes(), &body); err != nil {
+		t.Fatalf("parsing response %s: %v", response.Body, err)
+	}
+	if want := (Student{ID: "S1", Name: "Alice", Department: "CSE", CGPA: "9.1"}); body.Data != want {
+		t.Errorf("created %+v, want %+v", body.Data, want)
+	}
+	assertSubmitted(t, ledger, "CreateStudent", "S1", "Alice", "CSE")
+	assertSubmittedDetails(t, ledger, `{"cgpa":"9.1"}`)
+}
+
+func TestCreateStudentFailures(t *testing.T) {
+	tests := []struct {
+		name string
+		body string
+		err  error
+		want int
+	}{
+		{name: "malformed body", body: `{"id":"S1",`, want: http.StatusBadRequest},
+		{name: "existing student", body: `{"id":"S1","name":"Alice"}`, err: endorseFailure("the student S1 already exists"), want: http.StatusConflict},
+		{name: "ledger failure", body: `{"id":"S1","name":"Alice"}`, err: errors.New("failed to submit transaction: orderer unreachable"), want: http.StatusInternalServerError},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ledger := &fakeLedger{respond: func(string, []string) ([]byte, error) {
+				return nil, test.err
+			}}
+			router := newTestRouter(t, ledger, nil)
+
+			if response := serveRequest(router, http.MethodPost, "/api/students", test.body); response.Code != test.want {
+				t.Errorf("status = %d, want %d, body %s", response.Code, test.want, response.Body)
+			}
+		})
+	}
+}
+
+func TestDeleteStudent(t *testing.T) {
+	ledger := &fakeLedger{}
+	router := newTestRouter(t, ledger, nil)
+
+	response := serveRequest(router, http.MethodDelete, "/api/students/S1", "")
+	if response.Code != http.StatusOK {
+		t.Fatalf("status = %d, body %s", response.Code, response.Body)
+	}
+	if got, want := response.Body.String(), `{"data":{"message":"Student S1 deleted successfully"}}`; got != want {
+		t.Errorf("body = %s, want %s", got, want)
+	}
+	assertSubmitted(t, ledger, "DeactivateStudent", "S1")
+}
+
+func TestDeleteStudentFailures(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want int
+	}{
+		{name: "missing student", err: endorseFailure("the student S9 does not exist"), want: http.StatusNotFound},
+		{name: "ledger failure", err: errors.New("failed to submit transaction: orderer unreachable"), want: http.StatusInternalServerError},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ledger := &fakeLedger{respond: func(string, []string) ([]byte, error) {
+				return nil, test.err
+			}}
+			router := newTestRouter(t, ledger, nil)
+
+			if response := serveRequest(router, http.MethodDelete, "/api/students/S9", ""); response.Code != test.want {
+				t.Errorf("status = %d, want %d, body %s", response.Code, test.want, response.Body)
+			}
+		})
+	}
+}
@@ -0,0 +1,165 @@
+/*
+Copyright 2021 IBM All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package main
+
+import (
+	"context"
+	"errors"
+	"math/rand/v2"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/hyperledger/fabric-gateway/pkg/client"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// transactionSubmitter is the part of *client.Contract needed to submit transactions
+type transactionSubmitter interface {
+	SubmitTransaction(ctx context.Context, name string, args ...string) ([]byte, error)
+}
+
+// ledgerContract is the part of the gateway needed to submit and evaluate transactions
+type ledgerContract interface {
+	transactionSubmitter
+	EvaluateTransaction(ctx context.Context, name string, args ...string) ([]byte, error)
+	SubmitTransient(ctx context.Context, name string, transient map[string][]byte, args ...string) ([]byte, error)
+	EvaluateTransient(ctx context.Context, name string, transient map[string][]byte, args ...string) ([]byte, error)
+
+	// ProposeTransaction creates a proposal for a transaction that is submitted without
+	// waiting for its commit, as the commit strategies other than wait-for-commit do
+	ProposeTransaction(ctx context.Context, name string, args ...string) (proposedTransaction, error)
+}
+
+// proposedTransaction is a transaction that has been proposed but not yet submitted
+type proposedTransaction interface {
+	TransactionID() string
+
+	// Submit endorses the transaction and sends it to the orderer
+	Submit(ctx context.Context) error
+
+	// AwaitCommit waits for the submitted transaction to commit, failing if it was invalidated
+	AwaitCommit(ctx context.Context) (*client.Status, error)
+}
+
+// ledgerKey is the Gin context key under which the ledger given to the router is stored
+const ledgerKey = "ledger"
+
+// provideLedger returns a Gin handler that makes the ledger available to the handlers, so they
+// can be served by a different implementation than the gateway
+func provideLedger(ledger ledgerContract) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Set(ledgerKey, ledger)
+		c.Next()
+	}
+}
+
+// requestLedger returns the ledger the handlers submit and evaluate transactions with
+func requestLedger(c *gin.Context) ledgerContract {
+	return c.MustGet(ledgerKey).(ledgerContract)
+}
+
+// submitWithRetry submits a transaction, retrying with exponential backoff while the
+// failure is transient. Deterministic failures, such as a chaincode error returned during
+// endorsement, are returned to the caller straight away.
+func submitWithRetry(ctx context.Context, contract transactionSubmitter, fn string, args ...string) ([]byte, error) {
+	var result []byte
+	err := retryTransient(ctx, fn, func() error {
+		var err error
+		result, err = contract.SubmitTransaction(ctx, fn, args...)
+		return err
+	})
+	return result, err
+}
+
+// retryPolicy is how often and how patiently a kind of call is retried
+type retryPolicy struct {
+	MaxAttempts    int
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+}
+
+// submitRetryPolicy is the retry policy for submits, set with the RETRY_* settings
+func submitRetryPolicy() retryPolicy {
+	return retryPolicy{MaxAttempts: cfg.RetryMaxAttempts, InitialBackoff: cfg.RetryInitialBackoff, MaxBackoff: cfg.RetryMaxBackoff}
+}
+
+// evaluateRetryPolicy is the retry policy for evaluations, set with the EVALUATE_RETRY_* settings
+func evaluateRetryPolicy() retryPolicy {
+	return retryPolicy{MaxAttempts: cfg.EvaluateRetryMaxAttempts, InitialBackoff: cfg.EvaluateRetryInitialBackoff, MaxBackoff: cfg.EvaluateRetryMaxBackoff}
+}
+
+// retryTransient makes a submit call for the named transaction until it succeeds, fails with
+// an error that is not transient, or runs out of attempts, returning the last error
+func retryTransient(ctx context.Context, fn string, call func() error) error {
+	return retryWithPolicy(ctx, submitRetryPolicy(), fn, call)
+}
+
+// retryWithPolicy makes a call for the named transaction until it succeeds, fails with an error
+// that is not transient, or runs out of the policy's attempts, returning the last error. The
+// backoff doubles after each attempt and is varied by RETRY_JITTER, so clients that failed
+// together don't all retry at the same moment.
+func retryWithPolicy(ctx context.Context, policy retryPolicy, fn string, call func() error) error {
+	backoff := policy.InitialBackoff
+
+	for attempt := 1; ; attempt++ {
+		err := call()
+		if err == nil || attempt >= policy.MaxAttempts || !isTransient(err) {
+			return err
+		}
+
+		delay := jitter(backoff, cfg.RetryJitter)
+		loggerFrom(ctx).Warn("Transient transaction failure, retrying",
+			"function", fn, "attempt", attempt, "maxAttempts", policy.MaxAttempts, "backoff", delay.String(), "error", err)
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return err
+		}
+
+		backoff *= 2
+		if backoff > policy.MaxBackoff {
+			backoff = policy.MaxBackoff
+		}
+	}
+}
+
+// jitter varies d randomly by up to the given fraction of it in either direction
+func jitter(d time.Duration, fraction float64) time.Duration {
+	if fraction <= 0 || d <= 0 {
+		return d
+	}
+	return d + time.Duration((rand.Float64()*2-1)*fraction*float64(d))
+}
+
+// isTransient reports whether a failed call is safe and worthwhile to retry
+func isTransient(err error) bool {
+	// A transaction invalidated by an MVCC read conflict was not applied, and a new transaction
+	// reads the updated state, so it is safe to submit again
+	if isMVCCConflict(err) {
+		return true
+	}
+
+	// Otherwise, once the transaction reaches the orderer it may still commit, so resubmitting
+	// after a commit status or commit failure could apply it twice
+	var commitStatusErr *client.CommitStatusError
+	var commitErr *client.CommitError
+	var commitFailedErr *commitFailedError
+	if errors.As(err, &commitStatusErr) || errors.As(err, &commitErr) || errors.As(err, &commitFailedErr) {
+		return false
+	}
+
+	switch status.Code(err) {
+	case codes.Unavailable, codes.DeadlineExceeded:
+		return true
+	default:
+		return false
+	}
+}
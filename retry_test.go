@@ -0,0 +1,117 @@
+/*
+Copyright 2021 IBM All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package main
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// flakySubmitter fails its first failures submits with err, then succeeds
+type flakySubmitter struct {
+	failures int
+	err      error
+	calls    int
+}
+
+func (s *flakySubmitter) SubmitTransaction(_ context.Context, _ string, _ ...string) ([]byte, error) {
+	s.calls++
+	if s.calls <= s.failures {
+		return nil, s.err
+	}
+	return []byte("ok"), nil
+}
+
+// useFastRetries sets a retry policy of attempts submits with a millisecond backoff
+func useFastRetries(t *testing.T, attempts int) {
+	useConfig(t, func(config *Config) {
+		config.RetryMaxAttempts = attempts
+		config.RetryInitialBackoff = time.Millisecond
+		config.RetryMaxBackoff = time.Millisecond
+	})
+}
+
+func TestSubmitWithRetryRetriesTransientFailures(t *testing.T) {
+	useFastRetries(t, 3)
+	contract := &flakySubmitter{failures: 2, err: status.Error(codes.Unavailable, "peer unavailable")}
+
+	result, err := submitWithRetry(context.Background(), contract, "CreateStudent", "S1")
+	if err != nil {
+		t.Fatalf("submitWithRetry: %v", err)
+	}
+	if string(result) != "ok" {
+		t.Errorf("result = %q, want ok", result)
+	}
+	if contract.calls != 3 {
+		t.Errorf("submitted %d times, want 3", contract.calls)
+	}
+}
+
+func TestSubmitWithRetryGivesUpAfterMaxAttempts(t *testing.T) {
+	useFastRetries(t, 2)
+	contract := &flakySubmitter{failures: 5, err: status.Error(codes.DeadlineExceeded, "timed out")}
+
+	_, err := submitWithRetry(context.Background(), contract, "CreateStudent", "S1")
+	if status.Code(err) != codes.DeadlineExceeded {
+		t.Errorf("error = %v, want the last DeadlineExceeded", err)
+	}
+	if contract.calls != 2 {
+		t.Errorf("submitted %d times, want 2", contract.calls)
+	}
+}
+
+func TestSubmitWithRetryDoesNotRetryDeterministicFailures(t *testing.T) {
+	useFastRetries(t, 3)
+	contract := &flakySubmitter{failures: 1, err: status.Error(codes.Aborted, "chaincode response 500, the student S1 already exists")}
+
+	if _, err := submitWithRetry(context.Background(), contract, "CreateStudent", "S1"); err == nil {
+		t.Fatal("submitWithRetry succeeded, want the chaincode error")
+	}
+	if contract.calls != 1 {
+		t.Errorf("submitted %d times, want 1", contract.calls)
+	}
+}
+
+func TestCreateStudentRetriesTransientFailures(t *testing.T) {
+	failures := 2
+	ledger := &fakeLedger{respond: func(string, []string) ([]byte, error) {
+		if failures > 0 {
+			failures--
+			return nil, status.Error(codes.Unavailable, "peer unavailable")
+		}
+		return nil, nil
+	}}
+	router := newTestRouter(t, ledger, func(config *Config) {
+		config.RetryInitialBackoff = time.Millisecond
+		config.RetryMaxBackoff = time.Millisecond
+	})
+
+	response := serveRequest(router, http.MethodPost, "/api/students", `{"id":"S1","name":"Alice"}`)
+	if response.Code != http.StatusCreated {
+		t.Fatalf("status = %d, body %s", response.Code, response.Body)
+	}
+	if calls := ledger.submitted(); len(calls) != 3 {
+		t.Errorf("submitted %d times, want 3", len(calls))
+	}
+}
+
+func TestJitterStaysWithinFraction(t *testing.T) {
+	for i := 0; i < 100; i++ {
+		d := jitter(100*time.Millisecond, 0.2)
+		if d < 80*time.Millisecond || d > 120*time.Millisecond {
+			t.Fatalf("jitter gave %s, want within 20%% of 100ms", d)
+		}
+	}
+	if d := jitter(100*time.Millisecond, 0); d != 100*time.Millisecond {
+		t.Errorf("jitter with no fraction gave %s, want 100ms", d)
+	}
+}
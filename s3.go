@@ -0,0 +1,166 @@
+/*
+Copyright 2021 IBM All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+// s3UploadTimeout bounds each upload to S3
+const s3UploadTimeout = 30 * time.Minute
+
+// S3Config names an S3 bucket, or a bucket of an S3-compatible store such as MinIO, that files
+// are uploaded to
+type S3Config struct {
+	Bucket string `yaml:"bucket"`
+	Region string `yaml:"region"`
+
+	// Prefix of the keys of uploaded files, such as snapshots/
+	Prefix string `yaml:"prefix"`
+
+	// URL of an S3-compatible store, such as http://localhost:9000, used instead of AWS. Such
+	// stores usually need PathStyle, which puts the bucket in the path rather than the host name.
+	Endpoint  string `yaml:"endpoint"`
+	PathStyle bool   `yaml:"pathStyle"`
+
+	// Credentials to sign requests with; the session token is only needed for temporary credentials
+	AccessKeyID     string `yaml:"accessKeyId"`
+	SecretAccessKey string `yaml:"secretAccessKey"`
+	SessionToken    string `yaml:"sessionToken"`
+}
+
+// validate checks that a bucket to upload to has a region and credentials
+func (c S3Config) validate() error {
+	if c.Bucket == "" {
+		return nil
+	}
+	if c.Region == "" {
+		return errors.New("SNAPSHOT_S3_BUCKET needs SNAPSHOT_S3_REGION")
+	}
+	if c.AccessKeyID == "" || c.SecretAccessKey == "" {
+		return errors.New("SNAPSHOT_S3_BUCKET needs AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY")
+	}
+	if c.Endpoint != "" {
+		if endpoint, err := url.Parse(c.Endpoint); err != nil || endpoint.Host == "" {
+			return fmt.Errorf("invalid SNAPSHOT_S3_ENDPOINT %q", c.Endpoint)
+		}
+	}
+	return nil
+}
+
+// objectURL returns the URL of the object with the key
+func (c S3Config) objectURL(key string) *url.URL {
+	u := &url.URL{Scheme: "https", Host: "s3." + c.Region + ".amazonaws.com"}
+	if c.Endpoint != "" {
+		endpoint, _ := url.Parse(c.Endpoint)
+		u.Scheme, u.Host = endpoint.Scheme, endpoint.Host
+	}
+
+	if c.PathStyle {
+		u.Path = "/" + c.Bucket + "/" + key
+	} else {
+		u.Host = c.Bucket + "." + u.Host
+		u.Path = "/" + key
+	}
+	return u
+}
+
+// upload puts the file at path into the bucket under the configured prefix and the given name
+func (c S3Config) upload(ctx context.Context, path, name string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	hash := sha256.New()
+	size, err := io.Copy(hash, file)
+	if err != nil {
+		return err
+	}
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, s3UploadTimeout)
+	defer cancel()
+
+	request, err := http.NewRequestWithContext(ctx, http.MethodPut, c.objectURL(c.Prefix+name).String(), file)
+	if err != nil {
+		return err
+	}
+	request.ContentLength = size
+	c.sign(request, hex.EncodeToString(hash.Sum(nil)), time.Now().UTC())
+
+	response, err := http.DefaultClient.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(response.Body, 1024))
+		return fmt.Errorf("S3 responded %d: %s", response.StatusCode, strings.TrimSpace(string(body)))
+	}
+	return nil
+}
+
+// sign adds the headers of AWS Signature Version 4 to a request whose body has the given SHA-256 hash
+func (c S3Config) sign(request *http.Request, payloadHash string, now time.Time) {
+	amzDate := now.Format("20060102T150405Z")
+	date := now.Format("20060102")
+	scope := date + "/" + c.Region + "/s3/aws4_request"
+
+	request.Header.Set("X-Amz-Content-Sha256", payloadHash)
+	request.Header.Set("X-Amz-Date", amzDate)
+	signedHeaders := "host;x-amz-content-sha256;x-amz-date"
+	canonicalHeaders := "host:" + request.URL.Host + "\n" +
+		"x-amz-content-sha256:" + payloadHash + "\n" +
+		"x-amz-date:" + amzDate + "\n"
+	if c.SessionToken != "" {
+		request.Header.Set("X-Amz-Security-Token", c.SessionToken)
+		signedHeaders += ";x-amz-security-token"
+		canonicalHeaders += "x-amz-security-token:" + c.SessionToken + "\n"
+	}
+
+	canonicalRequest := strings.Join([]string{
+		request.Method,
+		request.URL.EscapedPath(),
+		request.URL.RawQuery,
+		canonicalHeaders,
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+	requestHash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(requestHash[:])
+
+	key := hmacSHA256([]byte("AWS4"+c.SecretAccessKey), date)
+	for _, part := range []string{c.Region, "s3", "aws4_request"} {
+		key = hmacSHA256(key, part)
+	}
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	request.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s", c.AccessKeyID, scope, signedHeaders, signature))
+}
+
+// hmacSHA256 returns the HMAC-SHA256 of data keyed with key
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
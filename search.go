@@ -0,0 +1,104 @@
+/*
+Copyright 2021 IBM All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+// defaultSearchPageSize and maxSearchPageSize bound the students returned by one search page
+const (
+	defaultSearchPageSize = 50
+	maxSearchPageSize     = 1000
+)
+
+// searchMatches are the ways a search can match names, by the match query parameter, and
+// whether each only matches the start of the name
+var searchMatches = map[string]bool{"substring": false, "prefix": true}
+
+// searchPage is one page of search results returned by the SearchStudentsByName chaincode function
+type searchPage struct {
+	Students []map[string]interface{} `json:"students"`
+	Bookmark string                   `json:"bookmark"`
+}
+
+// searchStudents finds the students whose name contains the name query parameter, ignoring
+// case, or with match=prefix whose name starts with it. Results are in name order, a page at a
+// time; the bookmark of a page fetches the next one, and is empty after the last page. Soft
+// deleted students are left out of each page unless include_deleted=true, so a page may hold
+// fewer than limit students.
+func searchStudents(c *gin.Context) {
+	name := c.Query("name")
+	if name == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Query parameter name must give the text to search for"})
+		return
+	}
+
+	match := c.DefaultQuery("match", "substring")
+	prefix, ok := searchMatches[match]
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("match must be substring or prefix, got %q", match)})
+		return
+	}
+
+	limit := defaultSearchPageSize
+	if value := c.Query("limit"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 1 || n > maxSearchPageSize {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("limit must be a number from 1 to %d, got %q", maxSearchPageSize, value)})
+			return
+		}
+		limit = n
+	}
+
+	includeDeleted, err := parseIncludeDeleted(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	requestLogger(c).Info("Searching students by name", "name", name, "match", match)
+
+	result, err := requestLedger(c).EvaluateTransaction(c.Request.Context(), "SearchStudentsByName",
+		name, strconv.FormatBool(prefix), strconv.Itoa(limit), c.Query("bookmark"))
+	if err != nil {
+		if isRichQueryUnsupported(err) {
+			c.JSON(http.StatusNotImplemented, gin.H{"error": "Searching needs CouchDB as the peer's state database"})
+			return
+		}
+		writeTransactionError(c, "search students", err)
+		return
+	}
+
+	var page searchPage
+	if err := json.Unmarshal(result, &page); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("Failed to parse student data: %v", err)})
+		return
+	}
+	if !includeDeleted {
+		active := make([]map[string]interface{}, 0, len(page.Students))
+		for _, student := range page.Students {
+			if !isDeleted(student) {
+				active = append(active, student)
+			}
+		}
+		page.Students = active
+	}
+
+	// Under envelopes, the bookmark goes in the meta and the data is the students
+	if usesEnvelope(c) {
+		c.Set(bookmarkKey, page.Bookmark)
+		c.JSON(http.StatusOK, page.Students)
+		return
+	}
+	c.JSON(http.StatusOK, page)
+}
@@ -0,0 +1,136 @@
+/*
+Copyright 2021 IBM All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"reflect"
+	"testing"
+)
+
+// selfTestLedger keeps the students created on it the way the chaincode does, as its branch
+// and at version 1
+func selfTestLedger() *fakeLedger {
+	stored := map[string][]byte{}
+	return &fakeLedger{respond: func(name string, args []string) ([]byte, error) {
+		switch name {
+		case "CreateStudent":
+			stored[args[0]], _ = json.Marshal(map[string]any{"id": args[0], "name": args[1], "branch": args[2], "version": 1})
+		case "ReadStudent":
+			if record, ok := stored[args[0]]; ok {
+				return record, nil
+			}
+			return nil, errors.New("chaincode response 500, the student " + args[0] + " does not exist")
+		case "DeleteStudent":
+			delete(stored, args[0])
+		}
+		return nil, nil
+	}}
+}
+
+func TestSelfTestPasses(t *testing.T) {
+	ledger := selfTestLedger()
+
+	report := runSelfTest(context.Background(), ledger, "selftest-1")
+	if !report.Passed {
+		t.Fatalf("self-test failed: %+v", report.Steps)
+	}
+
+	var names []string
+	for _, call := range ledger.submitted() {
+		names = append(names, call.name)
+	}
+	if len(names) != 2 || names[0] != "CreateStudent" || names[1] != "DeleteStudent" {
+		t.Errorf("submitted %v, want CreateStudent then DeleteStudent", names)
+	}
+}
+
+func TestSelfTestReportsMismatch(t *testing.T) {
+	ledger := selfTestLedger()
+	respond := ledger.respond
+	ledger.respond = func(name string, args []string) ([]byte, error) {
+		if name == "ReadStudent" {
+			return []byte(`{"id":"` + args[0] + `","name":"Someone Else","cgpa":"0.0","version":1}`), nil
+		}
+		return respond(name, args)
+	}
+
+	report := runSelfTest(context.Background(), ledger, "selftest-2")
+	if report.Passed {
+		t.Fatal("self-test passed, want the verify step to fail")
+	}
+	for _, step := range report.Steps {
+		if step.Name == "delete" && !step.Passed {
+			t.Errorf("delete step failed: %s", step.Error)
+		}
+	}
+}
+
+func TestSelfTestReportsEachStep(t *testing.T) {
+	failure := errors.New("chaincode response 500, something went wrong")
+	tests := []struct {
+		name  string
+		fail  string
+		steps map[string]bool
+	}{
+		{name: "create fails", fail: "CreateStudent", steps: map[string]bool{"create": false}},
+		{name: "read fails", fail: "ReadStudent", steps: map[string]bool{"create": true, "read": false, "delete": true}},
+		{name: "delete fails", fail: "DeleteStudent", steps: map[string]bool{"create": true, "read": true, "verify": true, "delete": false}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ledger := selfTestLedger()
+			respond := ledger.respond
+			ledger.respond = func(name string, args []string) ([]byte, error) {
+				if name == test.fail {
+					return nil, failure
+				}
+				return respond(name, args)
+			}
+
+			report := runSelfTest(context.Background(), ledger, "selftest-3")
+			if report.Passed {
+				t.Error("self-test passed, want it to fail")
+			}
+
+			steps := map[string]bool{}
+			for _, step := range report.Steps {
+				steps[step.Name] = step.Passed
+				if !step.Passed && step.Error == "" {
+					t.Errorf("failed step %s has no error", step.Name)
+				}
+			}
+			if !reflect.DeepEqual(steps, test.steps) {
+				t.Errorf("steps = %v, want %v", steps, test.steps)
+			}
+		})
+	}
+}
+
+func TestSelfTestEndpointRequiresAdmin(t *testing.T) {
+	router := newTestRouter(t, selfTestLedger(), func(config *Config) { config.AdminToken = "secret" })
+
+	if response := serveRequest(router, http.MethodPost, "/api/selftest", ""); response.Code != http.StatusForbidden {
+		t.Errorf("without a token, status = %d, want 403", response.Code)
+	}
+
+	response := serveRequest(router, http.MethodPost, "/api/selftest", "", "X-Admin-Token", "secret")
+	if response.Code != http.StatusOK {
+		t.Fatalf("status = %d, body %s", response.Code, response.Body)
+	}
+	var report selfTestReport
+	if err := json.Unmarshal(response.Body.Bytes(), &report); err != nil {
+		t.Fatalf("parsing report: %v", err)
+	}
+	if !report.Passed || len(report.Steps) != 4 {
+		t.Errorf("report = %+v, want four passed steps", report)
+	}
+}
@@ -0,0 +1,234 @@
+/*
+Copyright 2021 IBM All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+
+	"google.golang.org/grpc"
+)
+
+// readHeaderTimeout bounds how long a client may take to send its request headers
+const readHeaderTimeout = 10 * time.Second
+
+// TLSConfig enables HTTPS for the REST server
+type TLSConfig struct {
+	// PEM files of the server's certificate chain and private key; TLS is enabled when both are set
+	CertFile string `yaml:"certFile"`
+	KeyFile  string `yaml:"keyFile"`
+
+	// PEM file of the CAs that sign client certificates. When set, clients must present a
+	// certificate signed by one of them.
+	ClientCAFile string `yaml:"clientCAFile"`
+
+	// Address of an optional plaintext listener that redirects every request to HTTPS.
+	// Without it, plaintext requests are refused.
+	RedirectAddr string `yaml:"redirectAddr"`
+}
+
+// enabled reports whether the server listens over HTTPS
+func (t TLSConfig) enabled() bool {
+	return t.CertFile != "" && t.KeyFile != ""
+}
+
+// validate checks that the TLS settings are complete
+func (t TLSConfig) validate() error {
+	if (t.CertFile == "") != (t.KeyFile == "") {
+		return errors.New("TLS_CERT_FILE and TLS_KEY_FILE must be set together")
+	}
+	if !t.enabled() && (t.ClientCAFile != "" || t.RedirectAddr != "") {
+		return errors.New("TLS_CLIENT_CA_FILE and TLS_REDIRECT_ADDR need TLS_CERT_FILE and TLS_KEY_FILE")
+	}
+	return nil
+}
+
+// newServer creates the HTTP server for the given handler, configured for TLS, and mutual TLS
+// if a client CA is set, when TLS is enabled
+func newServer(handler http.Handler) (*http.Server, error) {
+	server := &http.Server{
+		Addr:              cfg.ListenAddr,
+		Handler:           handler,
+		ReadHeaderTimeout: readHeaderTimeout,
+	}
+
+	// Shutdown does not wait for hijacked connections, and would wait forever for streaming
+	// responses, so event streams are closed explicitly
+	server.RegisterOnShutdown(chaincodeEvents.shutdown)
+	server.RegisterOnShutdown(closeBlockStreams)
+	server.RegisterOnShutdown(webhooks.shutdown)
+	if jobs != nil {
+		server.RegisterOnShutdown(jobs.shutdown)
+	}
+	if snapshots != nil {
+		server.RegisterOnShutdown(snapshots.shutdown)
+	}
+
+	if !cfg.TLS.enabled() {
+		return server, nil
+	}
+
+	tlsConfig, err := serverTLSConfig()
+	if err != nil {
+		return nil, err
+	}
+	server.TLSConfig = tlsConfig
+	return server, nil
+}
+
+// serverTLSConfig returns the TLS settings shared by the REST and gRPC servers, requiring
+// client certificates if a client CA is set
+func serverTLSConfig() (*tls.Config, error) {
+	config := &tls.Config{MinVersion: tls.VersionTLS12}
+	if cfg.TLS.ClientCAFile != "" {
+		caPEM, err := os.ReadFile(cfg.TLS.ClientCAFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read client CA file: %w", err)
+		}
+		clientCAs := x509.NewCertPool()
+		if !clientCAs.AppendCertsFromPEM(caPEM) {
+			return nil, fmt.Errorf("no certificates found in %s", cfg.TLS.ClientCAFile)
+		}
+		config.ClientCAs = clientCAs
+		config.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	return config, nil
+}
+
+// backgroundWork tracks transactions that carry on after their request has been answered,
+// so shutdown can wait for them
+var backgroundWork sync.WaitGroup
+
+// goBackground runs fn in a goroutine that shutdown waits for
+func goBackground(fn func()) {
+	backgroundWork.Add(1)
+	go func() {
+		defer backgroundWork.Done()
+		fn()
+	}()
+}
+
+// run serves requests, and gRPC calls if grpcServer is not nil, until SIGINT or SIGTERM arrives,
+// then stops accepting connections and waits until the shutdown deadline for in-flight requests,
+// calls, and background transactions to finish
+func run(server *http.Server, grpcServer *grpc.Server) error {
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	var redirect *http.Server
+	if cfg.TLS.enabled() && cfg.TLS.RedirectAddr != "" {
+		redirect = newHTTPSRedirectServer(cfg.TLS.RedirectAddr, server.Addr)
+		go func() {
+			slog.Info("Redirecting plaintext requests to HTTPS", "address", redirect.Addr)
+			if err := redirect.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+				slog.Error("HTTPS redirect listener stopped", "error", err)
+			}
+		}()
+	}
+
+	errs := make(chan error, 2)
+	go func() { errs <- serve(server) }()
+	if grpcServer != nil {
+		go func() { errs <- serveGRPC(grpcServer) }()
+	}
+
+	select {
+	case err := <-errs:
+		return err
+	case <-ctx.Done():
+	}
+
+	slog.Info("Shutting down, draining in-flight requests", "timeout", cfg.ShutdownTimeout.String())
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), cfg.ShutdownTimeout)
+	defer cancel()
+
+	if redirect != nil {
+		redirect.Shutdown(shutdownCtx)
+	}
+
+	// Calls are drained alongside requests, since the event streams of both are closed by the
+	// REST server's shutdown
+	grpcStopped := make(chan struct{})
+	if grpcServer != nil {
+		go func() {
+			grpcServer.GracefulStop()
+			close(grpcStopped)
+		}()
+	} else {
+		close(grpcStopped)
+	}
+
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		return fmt.Errorf("failed to drain in-flight requests: %w", err)
+	}
+	select {
+	case <-grpcStopped:
+	case <-shutdownCtx.Done():
+		grpcServer.Stop()
+		return fmt.Errorf("failed to drain in-flight gRPC calls: %w", shutdownCtx.Err())
+	}
+
+	drained := make(chan struct{})
+	go func() {
+		backgroundWork.Wait()
+		close(drained)
+	}()
+	select {
+	case <-drained:
+		slog.Info("Shutdown complete")
+	case <-shutdownCtx.Done():
+		slog.Warn("Shutdown deadline passed with background transactions still running")
+	}
+	return nil
+}
+
+// serve listens for requests until the server is shut down, over HTTPS when TLS is enabled
+func serve(server *http.Server) error {
+	var err error
+	if cfg.TLS.enabled() {
+		slog.Info("Starting REST API server with TLS", "address", server.Addr, "mutualTls", cfg.TLS.ClientCAFile != "")
+		err = server.ListenAndServeTLS(cfg.TLS.CertFile, cfg.TLS.KeyFile)
+	} else {
+		slog.Info("Starting REST API server", "address", server.Addr)
+		err = server.ListenAndServe()
+	}
+
+	if errors.Is(err, http.ErrServerClosed) {
+		return nil
+	}
+	return err
+}
+
+// newHTTPSRedirectServer creates a plaintext server on addr that permanently redirects every
+// request to the same host and path on the HTTPS listener
+func newHTTPSRedirectServer(addr, httpsAddr string) *http.Server {
+	_, httpsPort, _ := net.SplitHostPort(httpsAddr)
+
+	redirect := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host := r.Host
+		if h, _, err := net.SplitHostPort(r.Host); err == nil {
+			host = h
+		}
+		if httpsPort != "" && httpsPort != "443" {
+			host = net.JoinHostPort(host, httpsPort)
+		}
+		http.Redirect(w, r, "https://"+host+r.URL.RequestURI(), http.StatusPermanentRedirect)
+	})
+
+	return &http.Server{Addr: addr, Handler: redirect, ReadHeaderTimeout: readHeaderTimeout}
+}
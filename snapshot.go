@@ -0,0 +1,342 @@
+/*
+Copyright 2021 IBM All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// snapshotTimeFormat stamps the names of snapshot files with the time they were scheduled for
+const snapshotTimeFormat = "20060102T150405Z"
+
+// SnapshotConfig schedules exports of the full student state to timestamped files, kept in a
+// directory, uploaded to S3, or both, for backups and reporting
+type SnapshotConfig struct {
+	// Cron schedule of the snapshots, in UTC, such as "0 2 * * *" for 02:00 every day;
+	// snapshots are off when it is empty
+	Schedule string `yaml:"schedule"`
+
+	// Format of the student file, csv or json, and whether it includes soft deleted students
+	Format         string `yaml:"format"`
+	IncludeDeleted bool   `yaml:"includeDeleted"`
+
+	// History also writes the history of each student to a JSON file alongside the students. It
+	// reads each student's history separately, so it makes snapshots of large ledgers much slower.
+	History bool `yaml:"history"`
+
+	// Directory snapshots are kept in, and how many of them are kept there; 0 keeps them all
+	Dir  string `yaml:"dir"`
+	Keep int    `yaml:"keep"`
+
+	// Bucket snapshots are uploaded to
+	S3 S3Config `yaml:"s3"`
+}
+
+// validate checks the schedule and format, and that snapshots have somewhere to go
+func (c SnapshotConfig) validate() error {
+	if c.Schedule == "" {
+		return nil
+	}
+	if _, err := parseCronSchedule(c.Schedule); err != nil {
+		return fmt.Errorf("invalid SNAPSHOT_SCHEDULE: %w", err)
+	}
+	if _, ok := exportFormats[c.Format]; !ok {
+		return fmt.Errorf("invalid SNAPSHOT_FORMAT %q, expected csv or json", c.Format)
+	}
+	if c.Dir == "" && c.S3.Bucket == "" {
+		return errors.New("SNAPSHOT_SCHEDULE needs SNAPSHOT_DIR or SNAPSHOT_S3_BUCKET")
+	}
+	if c.Keep < 0 {
+		return fmt.Errorf("SNAPSHOT_KEEP must not be negative, got %d", c.Keep)
+	}
+	return c.S3.validate()
+}
+
+// snapshotScheduler takes snapshots of the student state on its schedule until shutdown
+type snapshotScheduler struct {
+	config   SnapshotConfig
+	schedule *cronSchedule
+	ledger   ledgerContract
+
+	// ctx is cancelled at shutdown, abandoning a snapshot in progress
+	ctx  context.Context
+	stop context.CancelFunc
+}
+
+// snapshots takes scheduled snapshots of the student state; it is nil when no schedule is set
+var snapshots *snapshotScheduler
+
+// newSnapshotScheduler creates a scheduler taking snapshots through the ledger
+func newSnapshotScheduler(config SnapshotConfig, ledger ledgerContract) (*snapshotScheduler, error) {
+	schedule, err := parseCronSchedule(config.Schedule)
+	if err != nil {
+		return nil, err
+	}
+	ctx, stop := context.WithCancel(context.Background())
+	return &snapshotScheduler{config: config, schedule: schedule, ledger: ledger, ctx: ctx, stop: stop}, nil
+}
+
+// start takes snapshots in the background
+func (s *snapshotScheduler) start() {
+	goBackground(s.run)
+}
+
+// shutdown stops the scheduler, abandoning a snapshot in progress
+func (s *snapshotScheduler) shutdown() {
+	s.stop()
+}
+
+// run waits for each scheduled time and takes a snapshot. A snapshot that runs past the next
+// scheduled time makes the scheduler skip that time rather than fall behind.
+func (s *snapshotScheduler) run() {
+	for {
+		at := s.schedule.next(time.Now())
+		slog.Debug("Next ledger snapshot scheduled", "at", at)
+
+		timer := time.NewTimer(time.Until(at))
+		select {
+		case <-s.ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+
+		start := time.Now()
+		files, count, err := s.take(at)
+		switch {
+		case s.ctx.Err() != nil:
+			slog.Info("Ledger snapshot abandoned at shutdown", "scheduledAt", at)
+			return
+		case err != nil:
+			snapshotRuns.WithLabelValues("failure").Inc()
+			slog.Error("Ledger snapshot failed", "scheduledAt", at, "error", err)
+		default:
+			snapshotRuns.WithLabelValues("success").Inc()
+			lastSnapshotTime.Set(float64(time.Now().Unix()))
+			slog.Info("Ledger snapshot taken", "scheduledAt", at, "files", files, "students", count, "duration", time.Since(start))
+		}
+	}
+}
+
+// take writes a snapshot of the student state, and of each student's history if configured, to
+// files named for the scheduled time, then uploads them and removes old snapshots. It returns
+// the names of the files and the number of students.
+func (s *snapshotScheduler) take(at time.Time) ([]string, int, error) {
+	ctx := s.ctx
+	if orgs != nil {
+		fc, err := orgs.connection(orgs.defaultOrg)
+		if err != nil {
+			return nil, 0, err
+		}
+		ctx = contextWithConnection(ctx, fc)
+	}
+
+	// Files are written to the snapshot directory, or to a temporary one if they are only uploaded
+	dir := s.config.Dir
+	if dir == "" {
+		tmp, err := os.MkdirTemp("", "snapshot-")
+		if err != nil {
+			return nil, 0, err
+		}
+		defer os.RemoveAll(tmp)
+		dir = tmp
+	} else if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, 0, fmt.Errorf("failed to create snapshot directory: %w", err)
+	}
+
+	stamp := at.UTC().Format(snapshotTimeFormat)
+	studentsFile := newSnapshotFile(dir, "students-"+stamp+"."+s.config.Format)
+	defer studentsFile.discard()
+	var historyFile *snapshotFile
+	if s.config.History {
+		historyFile = newSnapshotFile(dir, "students-history-"+stamp+".json")
+		defer historyFile.discard()
+	}
+
+	count, err := s.write(ctx, studentsFile, historyFile)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	files := []*snapshotFile{studentsFile}
+	if historyFile != nil {
+		files = append(files, historyFile)
+	}
+	names := make([]string, len(files))
+	for i, file := range files {
+		if err := file.commit(); err != nil {
+			return nil, 0, err
+		}
+		names[i] = file.name
+	}
+
+	if s.config.S3.Bucket != "" {
+		for _, file := range files {
+			if err := s.config.S3.upload(ctx, file.path(), file.name); err != nil {
+				return nil, 0, fmt.Errorf("failed to upload %s to S3: %w", file.name, err)
+			}
+		}
+	}
+	if s.config.Dir != "" && s.config.Keep > 0 {
+		pruneSnapshots(s.config.Dir, s.config.Keep)
+	}
+	return names, count, nil
+}
+
+// write writes every student to the students file a page at a time, and each student's history
+// to the history file if there is one, returning the number of students written
+func (s *snapshotScheduler) write(ctx context.Context, studentsFile, historyFile *snapshotFile) (int, error) {
+	if err := studentsFile.create(); err != nil {
+		return 0, err
+	}
+	exporter := exportFormats[s.config.Format](studentsFile.writer)
+	exporter.begin()
+
+	if historyFile != nil {
+		if err := historyFile.create(); err != nil {
+			return 0, err
+		}
+		historyFile.writer.WriteString("[\n")
+	}
+
+	count := 0
+	bookmark := ""
+	for {
+		page, err := fetchStudentsPage(ctx, s.ledger, bookmark)
+		if err != nil {
+			return 0, fmt.Errorf("failed to get students: %s", gatewayErrorText(err))
+		}
+
+		for _, student := range page.Students {
+			if !s.config.IncludeDeleted && student.Status == statusInactive {
+				continue
+			}
+			exporter.write(student)
+
+			if historyFile != nil {
+				result, err := s.ledger.EvaluateTransaction(ctx, "GetStudentHistory", student.ID)
+				if err != nil {
+					return 0, fmt.Errorf("failed to get history of student %s: %s", student.ID, gatewayErrorText(err))
+				}
+				data, err := json.Marshal(studentHistory{ID: student.ID, Versions: result})
+				if err != nil {
+					return 0, fmt.Errorf("failed to parse history of student %s: %w", student.ID, err)
+				}
+				if count > 0 {
+					historyFile.writer.WriteString(",\n")
+				}
+				historyFile.writer.Write(data)
+			}
+			count++
+		}
+		if err := exporter.flush(); err != nil {
+			return 0, fmt.Errorf("failed to write snapshot: %w", err)
+		}
+
+		if page.Bookmark == "" {
+			break
+		}
+		bookmark = page.Bookmark
+	}
+
+	exporter.end()
+	if err := exporter.flush(); err != nil {
+		return 0, fmt.Errorf("failed to write snapshot: %w", err)
+	}
+	if historyFile != nil {
+		historyFile.writer.WriteString("\n]\n")
+	}
+	return count, nil
+}
+
+// studentHistory is the entry of a student in a snapshot's history file, holding the versions
+// returned by the chaincode's GetStudentHistory function as they are
+type studentHistory struct {
+	ID       string          `json:"id"`
+	Versions json.RawMessage `json:"versions"`
+}
+
+// snapshotFile is a file of a snapshot, written to a temporary file renamed into place once the
+// snapshot is complete, so a snapshot that fails leaves no partial files
+type snapshotFile struct {
+	dir, name string
+	tmp       *os.File
+	writer    *bufio.Writer
+}
+
+// newSnapshotFile returns a snapshot file that will be named name in dir
+func newSnapshotFile(dir, name string) *snapshotFile {
+	return &snapshotFile{dir: dir, name: name}
+}
+
+// create opens the temporary file to write to
+func (f *snapshotFile) create() error {
+	tmp, err := os.CreateTemp(f.dir, "."+f.name+"-*")
+	if err != nil {
+		return fmt.Errorf("failed to create snapshot file: %w", err)
+	}
+	f.tmp, f.writer = tmp, bufio.NewWriter(tmp)
+	return nil
+}
+
+// commit flushes the temporary file and renames it into place
+func (f *snapshotFile) commit() error {
+	if err := f.writer.Flush(); err != nil {
+		return fmt.Errorf("failed to write snapshot file: %w", err)
+	}
+	if err := f.tmp.Close(); err != nil {
+		return fmt.Errorf("failed to write snapshot file: %w", err)
+	}
+	if err := os.Rename(f.tmp.Name(), f.path()); err != nil {
+		return err
+	}
+	f.tmp = nil
+	return nil
+}
+
+// discard removes the temporary file if the snapshot was not committed
+func (f *snapshotFile) discard() {
+	if f.tmp != nil {
+		f.tmp.Close()
+		os.Remove(f.tmp.Name())
+	}
+}
+
+// path returns where the file is kept once committed
+func (f *snapshotFile) path() string {
+	return filepath.Join(f.dir, f.name)
+}
+
+// pruneSnapshots removes all but the newest keep snapshots in dir, along with their history files
+func pruneSnapshots(dir string, keep int) {
+	paths, err := filepath.Glob(filepath.Join(dir, "students-[0-9]*"))
+	if err != nil || len(paths) <= keep {
+		return
+	}
+
+	// The timestamps in the names sort in the order the snapshots were taken
+	sort.Strings(paths)
+	for _, path := range paths[:len(paths)-keep] {
+		stamp := strings.TrimPrefix(filepath.Base(path), "students-")
+		stamp = strings.TrimSuffix(stamp, filepath.Ext(stamp))
+		for _, old := range []string{path, filepath.Join(dir, "students-history-"+stamp+".json")} {
+			if err := os.Remove(old); err != nil && !errors.Is(err, os.ErrNotExist) {
+				slog.Warn("Failed to remove old ledger snapshot", "path", old, "error", err)
+			}
+		}
+	}
+}
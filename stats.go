@@ -0,0 +1,118 @@
+/*
+Copyright 2021 IBM All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgx/v5"
+)
+
+// studentStats summarizes the students for dashboards, as returned by the StudentStats chaincode
+// function or computed from the state mirror
+type studentStats struct {
+	Total       int            `json:"total"`
+	AverageCGPA *float64       `json:"averageCgpa"`
+	Branches    []*branchStats `json:"branches"`
+	// Source is "ledger" or "mirror", and BlockNumber is the last block the mirror applied
+	Source      string  `json:"source"`
+	BlockNumber *uint64 `json:"blockNumber,omitempty"`
+}
+
+// branchStats summarizes the students of one branch
+type branchStats struct {
+	Branch      string   `json:"branch"`
+	Count       int      `json:"count"`
+	AverageCGPA *float64 `json:"averageCgpa"`
+}
+
+// getStudentStats counts the students overall and per branch and averages their CGPAs, leaving
+// out soft deleted students unless include_deleted=true. With the state mirror enabled, the
+// stats for its channel and chaincode are computed by PostgreSQL rather than by the chaincode
+// reading every student.
+func getStudentStats(c *gin.Context) {
+	includeDeleted, err := parseIncludeDeleted(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if mirror != nil && channelFrom(c.Request.Context()) == channelName && chaincodeFrom(c.Request.Context()) == chaincodeName {
+		getMirrorStats(c, includeDeleted)
+		return
+	}
+
+	requestLogger(c).Info("Computing student stats")
+
+	result, err := requestLedger(c).EvaluateTransaction(c.Request.Context(), "StudentStats", strconv.FormatBool(includeDeleted))
+	if err != nil {
+		writeTransactionError(c, "compute student stats", err)
+		return
+	}
+
+	stats := studentStats{Source: "ledger"}
+	if err := json.Unmarshal(result, &stats); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("Failed to parse student stats: %v", err)})
+		return
+	}
+
+	c.JSON(http.StatusOK, stats)
+}
+
+// getMirrorStats computes the student stats from the state mirror
+func getMirrorStats(c *gin.Context, includeDeleted bool) {
+	requestLogger(c).Info("Computing student stats from the state mirror")
+
+	height, ok := mirror.mirrorHeight(c)
+	if !ok {
+		return
+	}
+
+	rows, err := mirror.pool.Query(c.Request.Context(), `SELECT branch, count(*), count(cgpa), avg(cgpa)::float8
+		FROM mirror_students WHERE `+mirrorActiveCondition+` GROUP BY branch ORDER BY branch`, includeDeleted)
+	if err != nil {
+		writeMirrorError(c, err)
+		return
+	}
+
+	// The CGPA count of each branch weights its average in the overall one
+	type branchRow struct {
+		stats    branchStats
+		withCGPA int
+	}
+	branches, err := pgx.CollectRows(rows, func(row pgx.CollectableRow) (branchRow, error) {
+		var branch branchRow
+		err := row.Scan(&branch.stats.Branch, &branch.stats.Count, &branch.withCGPA, &branch.stats.AverageCGPA)
+		return branch, err
+	})
+	if err != nil {
+		writeMirrorError(c, err)
+		return
+	}
+
+	stats := studentStats{Branches: []*branchStats{}, Source: "mirror", BlockNumber: height}
+	var cgpaTotal float64
+	var withCGPA int
+	for _, branch := range branches {
+		stats.Branches = append(stats.Branches, &branch.stats)
+		stats.Total += branch.stats.Count
+		if branch.stats.AverageCGPA != nil {
+			cgpaTotal += *branch.stats.AverageCGPA * float64(branch.withCGPA)
+			withCGPA += branch.withCGPA
+		}
+	}
+	if withCGPA > 0 {
+		average := cgpaTotal / float64(withCGPA)
+		stats.AverageCGPA = &average
+	}
+
+	c.JSON(http.StatusOK, stats)
+}
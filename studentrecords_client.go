@@ -4,6 +4,11 @@ Copyright 2021 IBM All Rights Reserved.
 SPDX-License-Identifier: Apache-2.0
 */
 
+//go:build ignore
+
+// This sample client is run on its own with `go run studentrecords_client.go`
+// and is excluded from the REST server build.
+
 package main
 
 import (
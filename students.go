@@ -0,0 +1,114 @@
+/*
+Copyright 2021 IBM All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package main
+
+import (
+	"context"
+	"errors"
+	"strings"
+)
+
+// statusInactive is the status the chaincode gives a soft deleted student
+const statusInactive = "inactive"
+
+// The chaincode errors reporting that a student already exists or does not exist, that it
+// is already deleted or is not deleted when soft deleting or restoring it, that it is deleted
+// when updating it, or that it is no longer at the version an update expected, as told apart by
+// studentError
+var (
+	errStudentExists     = errors.New("student already exists")
+	errStudentNotFound   = errors.New("student does not exist")
+	errStudentDeleted    = errors.New("student is already deleted")
+	errStudentNotDeleted = errors.New("student is not deleted")
+	errStudentGone       = errors.New("student is deleted and must be restored before it is updated")
+	errStudentChanged    = errors.New("student has changed since the expected version")
+)
+
+// studentError returns the error above that a chaincode error reports, or nil for any other
+// error. Submits report chaincode errors as an EndorseError and evaluations as a gRPC status; in
+// both cases the chaincode's message is carried in the peer error details, where
+// chaincodeErrorMessage finds it.
+func studentError(err error) error {
+	text := chaincodeErrorMessage(err)
+	switch {
+	case strings.Contains(text, "already exists"):
+		return errStudentExists
+	case strings.Contains(text, "does not exist"):
+		return errStudentNotFound
+	case strings.Contains(text, "is already deleted"):
+		return errStudentDeleted
+	case strings.Contains(text, "is not deleted"):
+		return errStudentNotDeleted
+	case strings.Contains(text, "is deleted and must be restored"):
+		return errStudentGone
+	case strings.Contains(text, "is at version"):
+		return errStudentChanged
+	default:
+		return nil
+	}
+}
+
+// The operations on students shared by the REST handlers and the gRPC service. Writes wait for
+// their transaction to commit, recording it in ctx's transactionInfo.
+
+// readStudentRecord returns a student's complete record as JSON
+func readStudentRecord(ctx context.Context, ledger ledgerContract, id string) ([]byte, error) {
+	return ledger.EvaluateTransaction(ctx, "ReadStudent", id)
+}
+
+// createStudentRecord adds a student, passing its CGPA to the private data collection
+func createStudentRecord(ctx context.Context, ledger ledgerContract, student Student) error {
+	ctx, err := contextWithStudentDetails(ctx, student)
+	if err != nil {
+		return err
+	}
+	_, err = submitWithRetry(ctx, ledger, "CreateStudent", studentArgs(student)...)
+	return err
+}
+
+// updateStudentRecord replaces the fields of the student with the same ID, passing its CGPA to
+// the private data collection. Unless version is empty, the chaincode refuses the update if the
+// student is no longer at that version.
+func updateStudentRecord(ctx context.Context, ledger ledgerContract, student Student, version string) error {
+	ctx, err := contextWithStudentDetails(ctx, student)
+	if err != nil {
+		return err
+	}
+	_, err = submitWithRetry(ctx, ledger, "UpdateStudent", updateStudentArgs(student, version)...)
+	return err
+}
+
+// deactivateStudentRecord soft deletes a student, marking it inactive
+func deactivateStudentRecord(ctx context.Context, ledger ledgerContract, id string) error {
+	_, err := submitWithRetry(ctx, ledger, "DeactivateStudent", id)
+	return err
+}
+
+// restoreStudentRecord makes a soft deleted student active again
+func restoreStudentRecord(ctx context.Context, ledger ledgerContract, id string) error {
+	_, err := submitWithRetry(ctx, ledger, "RestoreStudent", id)
+	return err
+}
+
+// deleteStudentRecord removes a student for good
+func deleteStudentRecord(ctx context.Context, ledger ledgerContract, id string) error {
+	_, err := submitWithRetry(ctx, ledger, "DeleteStudent", id)
+	return err
+}
+
+// studentArgs returns the arguments CreateStudent takes for a student. The chaincode calls the
+// department the student's branch, and does not keep the year. The CGPA is never an argument,
+// as arguments are recorded on the public ledger; contextWithStudentDetails passes it instead.
+func studentArgs(student Student) []string {
+	return []string{student.ID, student.Name, student.Department}
+}
+
+// updateStudentArgs returns the arguments UpdateStudent takes to replace a student's fields,
+// ending with the version the student is expected to be at
+func updateStudentArgs(student Student, version string) []string {
+	return []string{student.ID, student.Name, student.Department, version}
+}
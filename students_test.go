@@ -0,0 +1,234 @@
+/*
+Copyright 2021 IBM All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package main
+
+import (
+	"context"
+	"net/http"
+	"reflect"
+	"testing"
+
+	"github.com/VishnuKC26/studentrecords/studentpb"
+)
+
+// storedStudent is a student as the chaincode returns it, with the department as its branch
+const storedStudent = `{"id":"S1","name":"Alice","branch":"CSE","cgpa":"9.1","version":3}`
+
+// readStoredStudent answers ReadStudent with storedStudent
+func readStoredStudent(name string, _ []string) ([]byte, error) {
+	if name == "ReadStudent" {
+		return []byte(storedStudent), nil
+	}
+	return nil, nil
+}
+
+func TestCreateStudentSendsChaincodeArgs(t *testing.T) {
+	ledger := &fakeLedger{}
+	router := newTestRouter(t, ledger, nil)
+
+	response := serveRequest(router, http.MethodPost, "/api/students", `{"id":"S1","name":"Alice","department":"CSE","year":"2","cgpa":"9.1"}`)
+	if response.Code != http.StatusCreated {
+		t.Fatalf("status = %d, body %s", response.Code, response.Body)
+	}
+
+	assertSubmitted(t, ledger, "CreateStudent", "S1", "Alice", "CSE")
+	assertSubmittedDetails(t, ledger, `{"cgpa":"9.1"}`)
+}
+
+func TestCreateStudentWithoutCommitWaitSendsCGPAPrivately(t *testing.T) {
+	ledger := &fakeLedger{}
+	router := newTestRouter(t, ledger, nil)
+
+	response := serveRequest(router, http.MethodPost, "/api/students", `{"id":"S1","name":"Alice","department":"CSE","cgpa":"9.1"}`,
+		commitStrategyHeader, string(waitForEndorse))
+	if response.Code != http.StatusAccepted {
+		t.Fatalf("status = %d, body %s", response.Code, response.Body)
+	}
+	backgroundWork.Wait()
+
+	assertSubmitted(t, ledger, "CreateStudent", "S1", "Alice", "CSE")
+	assertSubmittedDetails(t, ledger, `{"cgpa":"9.1"}`)
+}
+
+func TestCreateStudentWithoutCGPASendsNoDetails(t *testing.T) {
+	ledger := &fakeLedger{}
+	router := newTestRouter(t, ledger, nil)
+
+	response := serveRequest(router, http.MethodPost, "/api/students", `{"id":"S1","name":"Alice","department":"CSE"}`)
+	if response.Code != http.StatusCreated {
+		t.Fatalf("status = %d, body %s", response.Code, response.Body)
+	}
+
+	assertSubmitted(t, ledger, "CreateStudent", "S1", "Alice", "CSE")
+	if details, ok := ledger.submitted()[0].transient[detailsTransientKey]; ok {
+		t.Errorf("submitted private details %s for a student without a CGPA", details)
+	}
+}
+
+func TestUpdateStudentSendsChaincodeArgs(t *testing.T) {
+	tests := []struct {
+		name       string
+		method     string
+		body       string
+		anyVersion bool
+		want       []string
+		details    string
+	}{
+		{
+			name:    "put",
+			method:  http.MethodPut,
+			body:    `{"id":"S1","name":"Alice B","department":"ECE","year":"3","cgpa":"8.7"}`,
+			want:    []string{"S1", "Alice B", "ECE", "3"},
+			details: `{"cgpa":"8.7"}`,
+		},
+		{
+			name:       "put any version",
+			method:     http.MethodPut,
+			body:       `{"id":"S1","name":"Alice B","department":"ECE","cgpa":"8.7"}`,
+			anyVersion: true,
+			want:       []string{"S1", "Alice B", "ECE", ""},
+			details:    `{"cgpa":"8.7"}`,
+		},
+		{
+			name:    "patch keeps the stored branch",
+			method:  http.MethodPatch,
+			body:    `{"cgpa":"9.5"}`,
+			want:    []string{"S1", "Alice", "CSE", "3"},
+			details: `{"cgpa":"9.5"}`,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ledger := &fakeLedger{respond: readStoredStudent}
+			router := newTestRouter(t, ledger, nil)
+
+			ifMatch := studentETag("S1", 3)
+			if test.anyVersion {
+				ifMatch = "*"
+			}
+			response := serveRequest(router, test.method, "/api/students/S1", test.body, "If-Match", ifMatch)
+			if response.Code != http.StatusOK {
+				t.Fatalf("status = %d, body %s", response.Code, response.Body)
+			}
+
+			assertSubmitted(t, ledger, "UpdateStudent", test.want...)
+			assertSubmittedDetails(t, ledger, test.details)
+		})
+	}
+}
+
+func TestGRPCUpdateStudentSendsChaincodeArgs(t *testing.T) {
+	useConfig(t, nil)
+	ledger := &fakeLedger{}
+	service := &studentService{ledger: ledger}
+
+	version := uint64(4)
+	request := &studentpb.UpdateStudentRequest{
+		Student: &studentpb.Student{Id: "S1", Name: "Alice", Department: "CSE", Year: "2", Cgpa: "9.1"},
+		Version: &version,
+	}
+	if _, err := service.UpdateStudent(context.Background(), request); err != nil {
+		t.Fatalf("UpdateStudent: %v", err)
+	}
+
+	assertSubmitted(t, ledger, "UpdateStudent", "S1", "Alice", "CSE", "4")
+	assertSubmittedDetails(t, ledger, `{"cgpa":"9.1"}`)
+}
+
+// assertSubmitted checks the ledger was asked to submit exactly one transaction, fn with args
+func assertSubmitted(t *testing.T, ledger *fakeLedger, fn string, args ...string) {
+	t.Helper()
+
+	calls := ledger.submitted()
+	if len(calls) != 1 {
+		t.Fatalf("submitted %d transactions, want 1: %+v", len(calls), calls)
+	}
+	if calls[0].name != fn || !reflect.DeepEqual(calls[0].args, args) {
+		t.Errorf("submitted %s%q, want %s%q", calls[0].name, calls[0].args, fn, args)
+	}
+}
+
+// assertSubmittedDetails checks the single transaction the ledger was asked to submit carried
+// the given private details in its transient data
+func assertSubmittedDetails(t *testing.T, ledger *fakeLedger, details string) {
+	t.Helper()
+
+	calls := ledger.submitted()
+	if len(calls) != 1 {
+		t.Fatalf("submitted %d transactions, want 1: %+v", len(calls), calls)
+	}
+	if got := string(calls[0].transient[detailsTransientKey]); got != details {
+		t.Errorf("submitted private details %s, want %s", got, details)
+	}
+}
+
+// deletedStudent is a soft deleted student as the chaincode returns it
+const deletedStudent = `{"id":"S1","name":"Alice","branch":"CSE","status":"inactive","version":4}`
+
+func TestStudentExistsHidesDeletedStudents(t *testing.T) {
+	tests := []struct {
+		name   string
+		record string
+		target string
+		want   int
+	}{
+		{name: "active", record: storedStudent, target: "/api/students/S1", want: http.StatusOK},
+		{name: "deleted", record: deletedStudent, target: "/api/students/S1", want: http.StatusNotFound},
+		{name: "deleted included", record: deletedStudent, target: "/api/students/S1?include_deleted=true", want: http.StatusOK},
+		{name: "invalid include_deleted", record: storedStudent, target: "/api/students/S1?include_deleted=maybe", want: http.StatusBadRequest},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ledger := &fakeLedger{respond: func(string, []string) ([]byte, error) {
+				return []byte(test.record), nil
+			}}
+			router := newTestRouter(t, ledger, nil)
+
+			response := serveRequest(router, http.MethodHead, test.target, "")
+			if response.Code != test.want {
+				t.Errorf("status = %d, want %d", response.Code, test.want)
+			}
+		})
+	}
+}
+
+func TestStudentStatsPassesIncludeDeleted(t *testing.T) {
+	for target, want := range map[string]string{
+		"/api/students/stats":                      "false",
+		"/api/students/stats?include_deleted=true": "true",
+	} {
+		ledger := &fakeLedger{respond: func(string, []string) ([]byte, error) {
+			return []byte(`{"total":0,"branches":[]}`), nil
+		}}
+		router := newTestRouter(t, ledger, nil)
+
+		response := serveRequest(router, http.MethodGet, target, "")
+		if response.Code != http.StatusOK {
+			t.Fatalf("GET %s: status = %d, body %s", target, response.Code, response.Body)
+		}
+		if calls := ledger.calls; len(calls) != 1 || calls[0].name != "StudentStats" || !reflect.DeepEqual(calls[0].args, []string{want}) {
+			t.Errorf("GET %s evaluated %+v, want StudentStats(%s)", target, calls, want)
+		}
+	}
+}
+
+func TestPatchDeletedStudentIsGone(t *testing.T) {
+	ledger := &fakeLedger{respond: func(string, []string) ([]byte, error) {
+		return []byte(deletedStudent), nil
+	}}
+	router := newTestRouter(t, ledger, nil)
+
+	response := serveRequest(router, http.MethodPatch, "/api/students/S1", `{"name":"Alice B"}`, "If-Match", "*")
+	if response.Code != http.StatusGone {
+		t.Fatalf("status = %d, want 410, body %s", response.Code, response.Body)
+	}
+	if calls := ledger.submitted(); len(calls) != 0 {
+		t.Errorf("submitted %+v for a deleted student", calls)
+	}
+}
@@ -0,0 +1,51 @@
+/*
+Copyright 2021 IBM All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+// tagRequest is the body of a request to label the students matching a query
+type tagRequest struct {
+	// Query is a CouchDB rich query such as {"selector": {"branch": "CSE"}}
+	Query json.RawMessage `json:"query" binding:"required"`
+	Key   string          `json:"key" binding:"required"`
+	Value string          `json:"value"`
+}
+
+// tagStudents sets a label on every student matching a rich query in a single transaction
+func tagStudents(c *gin.Context) {
+	var request tagRequest
+
+	// Parse request body
+	if err := c.ShouldBindJSON(&request); err != nil {
+		writeBindError(c, err)
+		return
+	}
+
+	requestLogger(c).Info("Tagging students by query", "key", request.Key, "value", request.Value)
+
+	result, err := submitWithRetry(c.Request.Context(), requestLedger(c), "TagStudentsByQuery", string(request.Query), request.Key, request.Value)
+	if err != nil {
+		writeTransactionError(c, "tag students", err)
+		return
+	}
+
+	tagged, err := strconv.Atoi(string(result))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("Failed to parse tagged count: %v", err)})
+		return
+	}
+
+	writeWithTransaction(c, http.StatusOK, gin.H{"tagged": tagged, "key": request.Key, "value": request.Value})
+}
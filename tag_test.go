@@ -0,0 +1,54 @@
+/*
+Copyright 2021 IBM All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+)
+
+func TestTagStudents(t *testing.T) {
+	ledger := &fakeLedger{respond: func(string, []string) ([]byte, error) {
+		return []byte("2"), nil
+	}}
+	router := newTestRouter(t, ledger, nil)
+
+	response := serveRequest(router, http.MethodPost, "/api/students/tag", `{"query":{"selector":{"branch":"CSE"}},"key":"cohort","value":"2024"}`)
+	if response.Code != http.StatusOK {
+		t.Fatalf("status = %d, body %s", response.Code, response.Body)
+	}
+	assertSubmitted(t, ledger, "TagStudentsByQuery", `{"selector":{"branch":"CSE"}}`, "cohort", "2024")
+
+	var body struct {
+		Data struct {
+			Tagged int    `json:"tagged"`
+			Key    string `json:"key"`
+			Value  string `json:"value"`
+		} `json:"data"`
+	}
+	if err := json.Unmarshal(response.Body.Bytes(), &body); err != nil {
+		t.Fatalf("parsing response: %v", err)
+	}
+	if body.Data.Tagged != 2 || body.Data.Key != "cohort" || body.Data.Value != "2024" {
+		t.Errorf("body = %s, want 2 students tagged cohort=2024", response.Body)
+	}
+}
+
+func TestTagStudentsInvalid(t *testing.T) {
+	for _, body := range []string{`{"key":"cohort","value":"2024"}`, `{"query":{"selector":{}},"value":"2024"}`} {
+		ledger := &fakeLedger{}
+		router := newTestRouter(t, ledger, nil)
+
+		if response := serveRequest(router, http.MethodPost, "/api/students/tag", body); response.Code != http.StatusBadRequest {
+			t.Errorf("%s: status = %d, want 400", body, response.Code)
+		}
+		if calls := ledger.submitted(); len(calls) != 0 {
+			t.Errorf("%s: submitted %+v, want nothing", body, calls)
+		}
+	}
+}
@@ -0,0 +1,63 @@
+/*
+Copyright 2021 IBM All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// transactionTimeoutHeader is the request header used to override how long each evaluation or
+// endorsement a request makes may take
+const transactionTimeoutHeader = "X-Transaction-Timeout"
+
+// callTimeoutKey is the context key under which a per-request evaluate and endorse timeout is stored
+type callTimeoutKey struct{}
+
+// contextWithCallTimeout returns a copy of ctx in which evaluations and endorsements may take up to timeout
+func contextWithCallTimeout(ctx context.Context, timeout time.Duration) context.Context {
+	return context.WithValue(ctx, callTimeoutKey{}, timeout)
+}
+
+// callTimeoutFrom returns how long an evaluation or endorsement made with ctx may take, or
+// fallback if the request did not set a timeout
+func callTimeoutFrom(ctx context.Context, fallback time.Duration) time.Duration {
+	if timeout, ok := ctx.Value(callTimeoutKey{}).(time.Duration); ok {
+		return timeout
+	}
+	return fallback
+}
+
+// transactionTimeoutMiddleware sets the evaluate and endorse timeout of a request: the one
+// requested in the X-Transaction-Timeout header, such as "30s", clamped to the configured maximum,
+// or else the one configured for its route. Other requests keep the default timeouts. An invalid
+// header is rejected with 400.
+func transactionTimeoutMiddleware(c *gin.Context) {
+	timeout, ok := cfg.RouteTimeouts[routePattern(c)]
+
+	if value := c.GetHeader(transactionTimeoutHeader); value != "" {
+		requested, err := time.ParseDuration(value)
+		if err != nil || requested <= 0 {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Invalid %s %q, expected a positive duration such as 30s", transactionTimeoutHeader, value)})
+			return
+		}
+		if requested > cfg.MaxTransactionTimeout {
+			requestLogger(c).Info("Clamping requested transaction timeout", "requested", requested.String(), "max", cfg.MaxTransactionTimeout.String())
+			requested = cfg.MaxTransactionTimeout
+		}
+		timeout, ok = requested, true
+	}
+
+	if ok {
+		c.Request = c.Request.WithContext(contextWithCallTimeout(c.Request.Context(), timeout))
+	}
+	c.Next()
+}
@@ -0,0 +1,81 @@
+/*
+Copyright 2021 IBM All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+
+	"go.opentelemetry.io/contrib/instrumentation/github.com/gin-gonic/gin/otelgin"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	otelcodes "go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/gin-gonic/gin"
+)
+
+// tracingServiceName names the API in the traces it exports
+const tracingServiceName = "studentrecords-rest-api"
+
+// tracer starts the spans of the Fabric transactions a request makes. Until tracing is
+// configured it is a no-op.
+var tracer = otel.Tracer("github.com/VishnuKC26/studentrecords")
+
+// initTracing exports spans to the OTLP collector at a URL such as http://localhost:4317, and
+// takes the trace context of incoming requests from their traceparent headers. It returns a
+// function that flushes the spans not yet exported.
+func initTracing(endpoint string) (func(context.Context) error, error) {
+	exporter, err := otlptracegrpc.New(context.Background(), otlptracegrpc.WithEndpointURL(endpoint))
+	if err != nil {
+		return nil, fmt.Errorf("invalid OTEL_EXPORTER_OTLP_ENDPOINT: %w", err)
+	}
+
+	res, err := resource.Merge(resource.Default(), resource.NewWithAttributes(semconv.SchemaURL, semconv.ServiceName(tracingServiceName)))
+	if err != nil {
+		return nil, err
+	}
+
+	provider := sdktrace.NewTracerProvider(sdktrace.WithBatcher(exporter), sdktrace.WithResource(res))
+	otel.SetTracerProvider(provider)
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+	return provider.Shutdown, nil
+}
+
+// tracingMiddleware starts a span for each request, named by its route. Probes and scrapes are
+// not traced.
+func tracingMiddleware() gin.HandlerFunc {
+	return otelgin.Middleware(tracingServiceName, otelgin.WithFilter(func(r *http.Request) bool {
+		return !quietRoutes[r.URL.Path]
+	}))
+}
+
+// startTransactionSpan starts the span of a Fabric transaction, such as "submit CreateStudent",
+// under the span of the request ctx belongs to
+func startTransactionSpan(ctx context.Context, operation, function string, peer *peerConnection) (context.Context, trace.Span) {
+	return tracer.Start(ctx, operation+" "+function, trace.WithAttributes(
+		attribute.String("fabric.function", function),
+		attribute.String("fabric.channel", channelFrom(ctx)),
+		attribute.String("fabric.chaincode", chaincodeFrom(ctx)),
+		attribute.String("fabric.peer", peer.org.PeerEndpoint),
+	))
+}
+
+// endSpan ends a span, marking it failed if err is not nil
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(otelcodes.Error, err.Error())
+	}
+	span.End()
+}
@@ -0,0 +1,131 @@
+/*
+Copyright 2021 IBM All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/hyperledger/fabric-gateway/pkg/client"
+	"github.com/hyperledger/fabric-protos-go-apiv2/peer"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// commitFailedError reports a transaction that was ordered but failed validation when committed
+type commitFailedError struct {
+	TransactionID string
+	Code          peer.TxValidationCode
+}
+
+func (e *commitFailedError) Error() string {
+	return fmt.Sprintf("transaction %s failed to commit with status code %d (%s)", e.TransactionID, int32(e.Code), e.Code)
+}
+
+// commitTimeoutKey is the context key under which a per-request commit status timeout is stored
+type commitTimeoutKey struct{}
+
+// contextWithCommitTimeout returns a copy of ctx in which submits wait up to timeout for the commit status
+func contextWithCommitTimeout(ctx context.Context, timeout time.Duration) context.Context {
+	return context.WithValue(ctx, commitTimeoutKey{}, timeout)
+}
+
+// commitTimeoutFrom returns how long submits made with ctx wait for the commit status
+func commitTimeoutFrom(ctx context.Context) time.Duration {
+	if timeout, ok := ctx.Value(commitTimeoutKey{}).(time.Duration); ok {
+		return timeout
+	}
+	return commitStatusTimeout
+}
+
+// transactionInfo describes the transaction a write request submitted, returned alongside the
+// response data so callers can correlate the request with the ledger
+type transactionInfo struct {
+	TransactionID string  `json:"transactionId"`
+	BlockNumber   *uint64 `json:"blockNumber,omitempty"`
+	CommitStatus  string  `json:"commitStatus,omitempty"`
+}
+
+// transactionInfoKey is the context key under which the request's transactionInfo is stored
+type transactionInfoKey struct{}
+
+// recordTransactionInfo gives each request somewhere for its submits to record the transaction
+// they made. With retries or several submits, the last attempt is recorded.
+func recordTransactionInfo(c *gin.Context) {
+	c.Request = c.Request.WithContext(context.WithValue(c.Request.Context(), transactionInfoKey{}, &transactionInfo{}))
+	c.Next()
+}
+
+// transactionInfoFrom returns the transaction recorded for the request ctx belongs to, or nil
+// if the request does not record one
+func transactionInfoFrom(ctx context.Context) *transactionInfo {
+	info, _ := ctx.Value(transactionInfoKey{}).(*transactionInfo)
+	return info
+}
+
+// writeWithTransaction writes the response to a write request as an envelope carrying the data
+// and the transaction that was submitted for it
+func writeWithTransaction(c *gin.Context, code int, data any) {
+	c.JSON(code, transactionEnvelope(c, data))
+}
+
+// transactionEnvelope returns the body writeWithTransaction writes for the response data
+func transactionEnvelope(c *gin.Context, data any) gin.H {
+	body := dataBody(c, data)
+	if info := transactionInfoFrom(c.Request.Context()); info != nil && info.TransactionID != "" {
+		body["transaction"] = info
+	}
+	return body
+}
+
+// endorseAndSubmit endorses a proposal and sends the endorsed transaction to the orderer,
+// returning the transaction result and the pending commit. Each step is traced as a span.
+func endorseAndSubmit(ctx context.Context, proposal *client.Proposal) ([]byte, *client.Commit, error) {
+	endorseCtx, span := tracer.Start(ctx, "endorse")
+	endorseCtx, cancel := context.WithTimeout(endorseCtx, callTimeoutFrom(ctx, endorseTimeout))
+	defer cancel()
+
+	transaction, err := proposal.EndorseWithContext(endorseCtx)
+	endSpan(span, err)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	submitCtx, span := tracer.Start(ctx, "submit")
+	submitCtx, cancel = context.WithTimeout(submitCtx, submitTimeout)
+	defer cancel()
+
+	commit, err := transaction.SubmitWithContext(submitCtx)
+	endSpan(span, err)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return transaction.Result(), commit, nil
+}
+
+// awaitCommitStatus waits for a submitted transaction to commit, returning an error if it failed
+// validation. The wait is traced as a span.
+func awaitCommitStatus(ctx context.Context, commit *client.Commit) (status *client.Status, err error) {
+	ctx, span := tracer.Start(ctx, "commit_wait")
+	defer func() { endSpan(span, err) }()
+
+	ctx, cancel := context.WithTimeout(ctx, commitTimeoutFrom(ctx))
+	defer cancel()
+
+	status, err = commit.StatusWithContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if !status.Successful {
+		return status, &commitFailedError{TransactionID: status.TransactionID, Code: status.Code}
+	}
+	span.SetAttributes(attribute.Int64("fabric.block_number", int64(status.BlockNumber)))
+
+	return status, nil
+}
@@ -0,0 +1,142 @@
+/*
+Copyright 2021 IBM All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+
+	"github.com/gin-gonic/gin"
+)
+
+// transientBodyField is the field of a JSON request body holding transient data to pass to
+// the chaincode alongside the transaction
+const transientBodyField = "transient"
+
+// transientKey is the context key under which the transient data sent with a request is stored
+type transientKey struct{}
+
+// contextWithTransient returns a copy of ctx whose transaction proposals carry the transient data
+func contextWithTransient(ctx context.Context, transient map[string][]byte) context.Context {
+	return context.WithValue(ctx, transientKey{}, transient)
+}
+
+// transientFrom returns the transient data sent with a request, or nil if there is none
+func transientFrom(ctx context.Context) map[string][]byte {
+	transient, _ := ctx.Value(transientKey{}).(map[string][]byte)
+	return transient
+}
+
+// proposalTransient returns the transient data of a proposal: the data sent with the request
+// ctx belongs to, overridden by the data given for the transaction, with the request ID added
+func proposalTransient(ctx context.Context, transient map[string][]byte) map[string][]byte {
+	if requestTransient := transientFrom(ctx); len(requestTransient) > 0 {
+		merged := make(map[string][]byte, len(requestTransient)+len(transient))
+		for key, value := range requestTransient {
+			merged[key] = value
+		}
+		for key, value := range transient {
+			merged[key] = value
+		}
+		transient = merged
+	}
+	return transientWithRequestID(ctx, transient)
+}
+
+// transientValues converts the transient object of a request body to the bytes passed to the
+// chaincode. Strings are passed as their contents, and any other value as its JSON encoding.
+func transientValues(raw json.RawMessage) (map[string][]byte, error) {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(raw, &fields); err != nil || fields == nil {
+		return nil, fmt.Errorf("%s must be an object", transientBodyField)
+	}
+
+	values := make(map[string][]byte, len(fields))
+	for key, value := range fields {
+		if key == "" {
+			return nil, fmt.Errorf("%s keys must not be empty", transientBodyField)
+		}
+		var text string
+		if err := json.Unmarshal(value, &text); err == nil {
+			values[key] = []byte(text)
+			continue
+		}
+		var compact bytes.Buffer
+		if err := json.Compact(&compact, value); err != nil {
+			return nil, err
+		}
+		values[key] = compact.Bytes()
+	}
+	return values, nil
+}
+
+// transientFingerprint writes the transient data sent with a request to w in order of its keys,
+// so requests differing only in their transient data can be told apart
+func transientFingerprint(ctx context.Context, w io.Writer) {
+	transient := transientFrom(ctx)
+	keys := make([]string, 0, len(transient))
+	for key := range transient {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		fmt.Fprintf(w, "%s\x00%s\x00", key, transient[key])
+	}
+}
+
+// transientMiddleware takes the transient object out of a JSON request body and passes it to
+// every transaction proposal made for the request, so chaincode private data and secret
+// parameters reach the chaincode without being recorded in the transaction. Handlers see the
+// body without it. Transient values are never logged.
+func transientMiddleware(c *gin.Context) {
+	if !isWriteMethod(c.Request.Method) || c.ContentType() != gin.MIMEJSON || c.Request.Body == nil {
+		c.Next()
+		return
+	}
+
+	// A body that cannot be read or parsed as an object is left for the handler to reject
+	body, err := io.ReadAll(c.Request.Body)
+	if err != nil {
+		c.Request.Body = io.NopCloser(io.MultiReader(bytes.NewReader(body), c.Request.Body))
+		c.Next()
+		return
+	}
+	c.Request.Body = io.NopCloser(bytes.NewReader(body))
+
+	var fields map[string]json.RawMessage
+	if json.Unmarshal(body, &fields) != nil {
+		c.Next()
+		return
+	}
+	raw, ok := fields[transientBodyField]
+	if !ok {
+		c.Next()
+		return
+	}
+
+	transient, err := transientValues(raw)
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Invalid request body: %v", err)})
+		return
+	}
+	delete(fields, transientBodyField)
+	if body, err = json.Marshal(fields); err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Invalid request body: %v", err)})
+		return
+	}
+	c.Request.Body = io.NopCloser(bytes.NewReader(body))
+	c.Request.ContentLength = int64(len(body))
+
+	requestLogger(c).Debug("Request carries transient data", "transientKeys", len(transient))
+	c.Request = c.Request.WithContext(contextWithTransient(c.Request.Context(), transient))
+	c.Next()
+}
@@ -0,0 +1,129 @@
+/*
+Copyright 2021 IBM All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// proxyTransactionRequest is a transaction to forward to the gateway as-is
+type proxyTransactionRequest struct {
+	Chaincode string   `json:"chaincode"`
+	Function  string   `json:"function" binding:"required"`
+	Args      []string `json:"args"`
+}
+
+// chaincodeKey is the context key under which the chaincode chosen for a request is stored
+type chaincodeKey struct{}
+
+// contextWithChaincode returns a copy of ctx that transacts with the given chaincode
+func contextWithChaincode(ctx context.Context, chaincode string) context.Context {
+	return context.WithValue(ctx, chaincodeKey{}, chaincode)
+}
+
+// chaincodeFrom returns the chaincode chosen for a request, falling back to the configured chaincode
+func chaincodeFrom(ctx context.Context) string {
+	if chaincode, ok := ctx.Value(chaincodeKey{}).(string); ok {
+		return chaincode
+	}
+	return chaincodeName
+}
+
+// isProxyAllowed reports whether TX_PROXY_ALLOW lets the function of the chaincode be proxied,
+// either by name or with a chaincode:* entry
+func isProxyAllowed(chaincode, function string) bool {
+	for _, entry := range cfg.TxProxyAllow {
+		allowedChaincode, allowedFunction, _ := strings.Cut(entry, ":")
+		if allowedChaincode == chaincode && (allowedFunction == "*" || allowedFunction == function) {
+			return true
+		}
+	}
+	return false
+}
+
+// validateProxyAllow checks that each TX_PROXY_ALLOW entry names a chaincode and a function
+func validateProxyAllow(entries []string) error {
+	for _, entry := range entries {
+		chaincode, function, ok := strings.Cut(entry, ":")
+		if !ok || chaincode == "" || function == "" {
+			return fmt.Errorf("invalid TX_PROXY_ALLOW entry %q, expected chaincode:function or chaincode:*", entry)
+		}
+	}
+	return nil
+}
+
+// bindProxyTransaction parses a proxied transaction and checks that it may be forwarded,
+// returning the request context to transact with. It writes the error response and returns
+// false if the transaction is rejected.
+func bindProxyTransaction(c *gin.Context) (proxyTransactionRequest, context.Context, bool) {
+	var req proxyTransactionRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		writeBindError(c, err)
+		return req, nil, false
+	}
+	if req.Chaincode == "" {
+		req.Chaincode = chaincodeName
+	}
+
+	if !isProxyAllowed(req.Chaincode, req.Function) {
+		c.JSON(http.StatusForbidden, gin.H{"error": fmt.Sprintf("Function %s of chaincode %s may not be proxied", req.Function, req.Chaincode)})
+		return req, nil, false
+	}
+
+	requestLogger(c).Info("Proxying transaction", "chaincode", req.Chaincode, "function", req.Function, "args", len(req.Args), "transientKeys", len(transientFrom(c.Request.Context())))
+	return req, contextWithChaincode(c.Request.Context(), req.Chaincode), true
+}
+
+// evaluateProxyTransaction evaluates any allowed chaincode function and returns its result,
+// as JSON if the chaincode returned JSON and as a string otherwise. Transient data in the body
+// is passed on by transientMiddleware.
+func evaluateProxyTransaction(c *gin.Context) {
+	req, ctx, ok := bindProxyTransaction(c)
+	if !ok {
+		return
+	}
+
+	result, err := requestLedger(c).EvaluateTransaction(ctx, req.Function, req.Args...)
+	if err != nil {
+		writeTransactionError(c, "evaluate "+req.Function, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"result": jsonOrString(result)})
+}
+
+// submitProxyTransaction submits any allowed chaincode function, retrying transient failures,
+// and returns its result with the transaction that recorded it. Like creates and updates, it
+// waits for the commit for as long as X-Commit-Timeout asks, up to the configured maximum.
+func submitProxyTransaction(c *gin.Context) {
+	if !applyCommitTimeout(c) {
+		return
+	}
+	req, ctx, ok := bindProxyTransaction(c)
+	if !ok {
+		return
+	}
+
+	ledger := requestLedger(c)
+	var result []byte
+	err := retryTransient(ctx, req.Function, func() error {
+		var err error
+		result, err = ledger.SubmitTransaction(ctx, req.Function, req.Args...)
+		return err
+	})
+	if err != nil {
+		writeTransactionError(c, "submit "+req.Function, err)
+		return
+	}
+
+	writeWithTransaction(c, http.StatusOK, gin.H{"result": jsonOrString(result)})
+}
@@ -0,0 +1,173 @@
+/*
+Copyright 2021 IBM All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/hyperledger/fabric-gateway/pkg/client"
+)
+
+const (
+	// transactionStatusRetention is how long the status of a transaction is kept after it was submitted
+	transactionStatusRetention = time.Hour
+
+	// maxTrackedTransactions bounds the number of statuses kept, dropping the oldest first
+	maxTrackedTransactions = 10000
+)
+
+// transactionState is the stage a tracked transaction has reached
+type transactionState string
+
+const (
+	// txEndorsing means the proposal is being endorsed and sent to the orderer
+	txEndorsing transactionState = "endorsing"
+	// txSubmitted means the transaction was ordered and its commit status is awaited
+	txSubmitted transactionState = "submitted"
+	// txCommitted means the transaction committed as valid
+	txCommitted transactionState = "committed"
+	// txFailed means endorsement or submission failed, or the transaction was committed as invalid
+	txFailed transactionState = "failed"
+	// txUnknown means the commit status was not received in time; the transaction may still commit
+	txUnknown transactionState = "unknown"
+)
+
+// transactionStatus is the progress of a transaction submitted without waiting for its commit
+type transactionStatus struct {
+	TransactionID  string           `json:"transactionId"`
+	Function       string           `json:"function"`
+	Status         transactionState `json:"status"`
+	BlockNumber    *uint64          `json:"blockNumber,omitempty"`
+	ValidationCode string           `json:"validationCode,omitempty"`
+	Error          string           `json:"error,omitempty"`
+	SubmittedAt    time.Time        `json:"submittedAt"`
+	UpdatedAt      time.Time        `json:"updatedAt"`
+}
+
+// transactionTracker keeps the status of recent transactions in memory, so clients that did
+// not wait for a commit can look up how their transaction went
+type transactionTracker struct {
+	mu       sync.Mutex
+	statuses map[string]*transactionStatus
+	order    []string // transaction IDs, oldest first
+}
+
+// transactions tracks the transactions submitted without waiting for their commit
+var transactions = newTransactionTracker()
+
+// newTransactionTracker creates an empty tracker
+func newTransactionTracker() *transactionTracker {
+	return &transactionTracker{statuses: make(map[string]*transactionStatus)}
+}
+
+// start begins tracking a transaction that is about to be endorsed
+func (t *transactionTracker) start(txID, fn string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	now := time.Now().UTC()
+	t.evictLocked(now)
+
+	t.statuses[txID] = &transactionStatus{TransactionID: txID, Function: fn, Status: txEndorsing, SubmittedAt: now, UpdatedAt: now}
+	t.order = append(t.order, txID)
+}
+
+// evictLocked drops statuses past their retention, and the oldest beyond the limit. The caller must hold t.mu.
+func (t *transactionTracker) evictLocked(now time.Time) {
+	drop := 0
+	for drop < len(t.order) {
+		status := t.statuses[t.order[drop]]
+		if len(t.order)-drop < maxTrackedTransactions && now.Sub(status.SubmittedAt) < transactionStatusRetention {
+			break
+		}
+		delete(t.statuses, t.order[drop])
+		drop++
+	}
+	t.order = t.order[drop:]
+}
+
+// update changes a tracked transaction's status. Transactions no longer tracked are ignored.
+func (t *transactionTracker) update(txID string, change func(status *transactionStatus)) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if status, ok := t.statuses[txID]; ok {
+		change(status)
+		status.UpdatedAt = time.Now().UTC()
+	}
+}
+
+// submitted records that a transaction was endorsed and ordered, or why it was not
+func (t *transactionTracker) submitted(txID string, err error) {
+	t.update(txID, func(status *transactionStatus) {
+		if err != nil {
+			status.Status = txFailed
+			status.Error = err.Error()
+			return
+		}
+		status.Status = txSubmitted
+	})
+}
+
+// committed records a transaction's commit status, or the error received while waiting for it
+func (t *transactionTracker) committed(txID string, commit *client.Status, err error) {
+	t.update(txID, func(status *transactionStatus) {
+		if commit != nil {
+			blockNumber := commit.BlockNumber
+			status.BlockNumber = &blockNumber
+			status.ValidationCode = commit.Code.String()
+		}
+
+		var commitErr *commitFailedError
+		switch {
+		case err == nil:
+			status.Status = txCommitted
+		case errors.As(err, &commitErr):
+			status.Status = txFailed
+			status.Error = err.Error()
+		default:
+			status.Status = txUnknown
+			status.Error = err.Error()
+		}
+	})
+}
+
+// get returns a copy of a tracked transaction's status
+func (t *transactionTracker) get(txID string) (transactionStatus, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	status, ok := t.statuses[txID]
+	if !ok {
+		return transactionStatus{}, false
+	}
+	return *status, true
+}
+
+// transactionStatusPath is the URL at which a transaction's status can be polled, under the
+// version of the API the request used
+func transactionStatusPath(c *gin.Context, txID string) string {
+	return apiPath(c, "/transactions/"+txID)
+}
+
+// getTransactionStatus reports the progress of a transaction submitted without waiting for its
+// commit, for up to an hour after it was submitted
+func getTransactionStatus(c *gin.Context) {
+	txID := c.Param("txid")
+
+	status, ok := transactions.get(txID)
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": fmt.Sprintf("Transaction %s is not tracked; only transactions submitted without waiting for their commit are, for an hour", txID)})
+		return
+	}
+	c.JSON(http.StatusOK, status)
+}
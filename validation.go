@@ -0,0 +1,122 @@
+/*
+Copyright 2021 IBM All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+	"github.com/go-playground/validator/v10"
+)
+
+// fieldError is a problem with one field of a request body
+type fieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+// registerValidators names fields by their JSON names in validation errors and adds the
+// validations used by the Student binding tags
+func registerValidators() {
+	engine, ok := binding.Validator.Engine().(*validator.Validate)
+	if !ok {
+		return
+	}
+
+	engine.RegisterTagNameFunc(func(field reflect.StructField) string {
+		name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+		if name == "-" {
+			return ""
+		}
+		return name
+	})
+	_ = engine.RegisterValidation("cgpa", func(fl validator.FieldLevel) bool {
+		cgpa, err := strconv.ParseFloat(fl.Field().String(), 64)
+		return err == nil && cgpa >= minCGPA && cgpa <= maxCGPA
+	})
+}
+
+// fieldErrors lists the invalid fields of a request body, or returns nil if err is not a validation error
+func fieldErrors(err error) []fieldError {
+	var validationErrs validator.ValidationErrors
+	if !errors.As(err, &validationErrs) {
+		return nil
+	}
+
+	fields := make([]fieldError, 0, len(validationErrs))
+	for _, fe := range validationErrs {
+		fields = append(fields, fieldError{Field: fe.Field(), Message: fieldErrorMessage(fe)})
+	}
+	return fields
+}
+
+// fieldErrorMessage describes why a field failed validation
+func fieldErrorMessage(fe validator.FieldError) string {
+	switch fe.Tag() {
+	case "required":
+		return "is required"
+	case "max":
+		return fmt.Sprintf("must be at most %s characters", fe.Param())
+	case "cgpa":
+		return fmt.Sprintf("must be a number from %g to %g", minCGPA, maxCGPA)
+	case "email":
+		return "must be an email address"
+	case "oneof":
+		return "must be one of " + strings.Join(strings.Fields(fe.Param()), ", ")
+	default:
+		return fmt.Sprintf("failed the %s check", fe.Tag())
+	}
+}
+
+// fieldErrorsText joins field errors into a single message such as "name is required; cgpa must be a number from 0 to 10"
+func fieldErrorsText(fields []fieldError) string {
+	messages := make([]string, len(fields))
+	for i, field := range fields {
+		messages[i] = field.Field + " " + field.Message
+	}
+	return strings.Join(messages, "; ")
+}
+
+// unknownFieldError returns the field error for a JSON body rejected for having a field its
+// request does not take, or false if err is not one
+func unknownFieldError(err error) (fieldError, bool) {
+	// encoding/json reports unknown fields only by message, as json: unknown field "name"
+	quoted, ok := strings.CutPrefix(err.Error(), "json: unknown field ")
+	if !ok {
+		return fieldError{}, false
+	}
+	name, unquoteErr := strconv.Unquote(quoted)
+	if unquoteErr != nil {
+		return fieldError{}, false
+	}
+	return fieldError{Field: name, Message: "is not a known field"}, true
+}
+
+// writeBindError writes the response for a request body that could not be read or bound: 413
+// if it was over the size limit, or 400 listing each invalid field when the body was
+// well-formed but failed validation or had fields the request does not take
+func writeBindError(c *gin.Context, err error) {
+	if writeBodyTooLarge(c, err) {
+		return
+	}
+	if field, ok := unknownFieldError(err); ok {
+		fields := []fieldError{field}
+		c.JSON(http.StatusBadRequest, gin.H{"error": "validation_failed", "message": "Invalid request body: " + fieldErrorsText(fields), "fields": fields})
+		return
+	}
+	if fields := fieldErrors(err); fields != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "validation_failed", "message": "Invalid request body: " + fieldErrorsText(fields), "fields": fields})
+		return
+	}
+	c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Invalid request body: %v", err)})
+}
@@ -0,0 +1,79 @@
+/*
+Copyright 2021 IBM All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"testing"
+)
+
+func TestInvalidStudentRejected(t *testing.T) {
+	tests := []struct {
+		name   string
+		method string
+		target string
+		body   string
+		want   []fieldError
+	}{
+		{
+			name:   "missing id",
+			method: http.MethodPost,
+			target: "/api/students",
+			body:   `{"name":"Alice","cgpa":"9.1"}`,
+			want:   []fieldError{{Field: "id", Message: "is required"}},
+		},
+		{
+			name:   "missing name and invalid cgpa",
+			method: http.MethodPost,
+			target: "/api/students",
+			body:   `{"id":"S1","cgpa":"nine"}`,
+			want:   []fieldError{{Field: "name", Message: "is required"}, {Field: "cgpa", Message: "must be a number from 0 to 10"}},
+		},
+		{
+			name:   "cgpa out of range",
+			method: http.MethodPut,
+			target: "/api/students/S1",
+			body:   `{"id":"S1","name":"Alice","cgpa":"10.5"}`,
+			want:   []fieldError{{Field: "cgpa", Message: "must be a number from 0 to 10"}},
+		},
+		{
+			name:   "unknown field",
+			method: http.MethodPost,
+			target: "/api/students",
+			body:   `{"id":"S1","name":"Alice","gpa":"9.1"}`,
+			want:   []fieldError{{Field: "gpa", Message: "is not a known field"}},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ledger := &fakeLedger{respond: readStoredStudent}
+			router := newTestRouter(t, ledger, nil)
+
+			response := serveRequest(router, test.method, test.target, test.body, "If-Match", "*")
+			if response.Code != http.StatusBadRequest {
+				t.Fatalf("status = %d, want 400, body %s", response.Code, response.Body)
+			}
+
+			var body struct {
+				Error  string       `json:"error"`
+				Fields []fieldError `json:"fields"`
+			}
+			if err := json.Unmarshal(response.Body.Bytes(), &body); err != nil {
+				t.Fatalf("parsing response: %v", err)
+			}
+			if body.Error != "validation_failed" || !reflect.DeepEqual(body.Fields, test.want) {
+				t.Errorf("body = %s, want fields %+v", response.Body, test.want)
+			}
+			if calls := ledger.submitted(); len(calls) != 0 {
+				t.Errorf("submitted %+v, want nothing", calls)
+			}
+		})
+	}
+}
@@ -0,0 +1,389 @@
+/*
+Copyright 2021 IBM All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package main
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hyperledger/fabric-gateway/pkg/identity"
+)
+
+// vaultRequestTimeout bounds each call to Vault
+const vaultRequestTimeout = 30 * time.Second
+
+// vaultMinRenewInterval is the shortest wait between renewals of the Vault token, so a token
+// close to its maximum TTL is not renewed in a tight loop
+const vaultMinRenewInterval = 10 * time.Second
+
+// Fields of a KV secret holding an organization's crypto material
+const (
+	vaultCertificateField = "certificate"
+	vaultPrivateKeyField  = "privateKey"
+	vaultTLSCACertField   = "tlsCACertificate"
+)
+
+// VaultConfig describes the HashiCorp Vault server that organizations' crypto material is read from
+type VaultConfig struct {
+	// Address of the Vault server, such as https://vault.example.com:8200
+	Address string `yaml:"address"`
+
+	// Token to authenticate with, or a file holding it, such as a Vault Agent sink. The file is
+	// read again for every request, so the agent can renew and replace the token.
+	Token     string `yaml:"token"`
+	TokenFile string `yaml:"tokenFile"`
+
+	// Enterprise namespace to use, if any
+	Namespace string `yaml:"namespace"`
+
+	// PEM file of the CA certificates trusted for Vault's TLS connection
+	CACert string `yaml:"caCert"`
+
+	// How long KV secrets are reused before being read again, so rotated secrets are picked up
+	CacheTTL time.Duration `yaml:"cacheTTL"`
+}
+
+// validate checks that a Vault server is configured if any of the organizations reads from it
+func (c VaultConfig) validate(orgs []OrgConfig) error {
+	if c.CacheTTL < 0 {
+		return fmt.Errorf("VAULT_CACHE_TTL must not be negative, got %s", c.CacheTTL)
+	}
+	for _, org := range orgs {
+		if org.Vault != nil && c.Address == "" {
+			return fmt.Errorf("organization %s reads its credentials from Vault, but VAULT_ADDR is not set", org.MSPID)
+		}
+	}
+	if c.Address != "" && (c.Token == "") == (c.TokenFile == "") {
+		return errors.New("VAULT_ADDR needs one of VAULT_TOKEN and VAULT_TOKEN_FILE")
+	}
+	return nil
+}
+
+// VaultSource names where in Vault an organization's certificate, private key, and peer TLS CA
+// certificate are kept
+type VaultSource struct {
+	// Path of a KV secret, as in the Vault API, such as secret/data/fabric/org1 for a KV version 2
+	// engine mounted at secret. Its certificate, privateKey, and tlsCACertificate fields hold PEM data.
+	KVPath string `json:"kvPath" yaml:"kvPath"`
+
+	// Issue endpoint of a PKI engine role, such as pki/issue/org1-client, used instead of the KV
+	// secret's certificate and private key, with the common name and TTL to request
+	PKIPath    string `json:"pkiPath" yaml:"pkiPath"`
+	CommonName string `json:"commonName" yaml:"commonName"`
+	TTL        string `json:"ttl" yaml:"ttl"`
+}
+
+// validate checks that the source names a KV secret or a PKI role to issue from
+func (s VaultSource) validate() error {
+	if s.KVPath == "" && s.PKIPath == "" {
+		return errors.New("vault must set kvPath or pkiPath")
+	}
+	if s.PKIPath != "" && s.CommonName == "" {
+		return errors.New("vault pkiPath needs a commonName")
+	}
+	return nil
+}
+
+// vaultError is an error reported by Vault, with the HTTP status it responded with
+type vaultError struct {
+	StatusCode int
+	Messages   []string
+}
+
+func (e *vaultError) Error() string {
+	if len(e.Messages) == 0 {
+		return fmt.Sprintf("Vault responded %d: %s", e.StatusCode, http.StatusText(e.StatusCode))
+	}
+	return fmt.Sprintf("Vault responded %d: %s", e.StatusCode, strings.Join(e.Messages, "; "))
+}
+
+// vaultSecret is the envelope of Vault responses
+type vaultSecret struct {
+	Data json.RawMessage `json:"data"`
+	Auth *struct {
+		LeaseDuration int  `json:"lease_duration"`
+		Renewable     bool `json:"renewable"`
+	} `json:"auth"`
+	Errors []string `json:"errors"`
+}
+
+// vaultCredentials is a certificate and private key read from Vault, and when to read them again
+type vaultCredentials struct {
+	certificatePEM string
+	privateKeyPEM  string
+	refreshAt      time.Time
+}
+
+// vaultClient calls the Vault HTTP API, caching the secrets it reads
+type vaultClient struct {
+	config VaultConfig
+	http   *http.Client
+
+	mu     sync.Mutex
+	kv     map[string]vaultKVEntry
+	issued map[string]vaultCredentials
+}
+
+// vaultKVEntry is a cached KV secret
+type vaultKVEntry struct {
+	fields    map[string]string
+	refreshAt time.Time
+}
+
+// vault is the client for the configured Vault server, or nil if none is configured
+var vault *vaultClient
+
+// newVaultClient creates a client for the configured Vault server, trusting its TLS certificates if given
+func newVaultClient(config VaultConfig) (*vaultClient, error) {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if config.CACert != "" {
+		certificatePEM, err := os.ReadFile(config.CACert)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read Vault CA certificate file: %w", err)
+		}
+		certPool := x509.NewCertPool()
+		if !certPool.AppendCertsFromPEM(certificatePEM) {
+			return nil, fmt.Errorf("no certificates found in %s", config.CACert)
+		}
+		transport.TLSClientConfig = &tls.Config{RootCAs: certPool, MinVersion: tls.VersionTLS12}
+	}
+
+	return &vaultClient{
+		config: config,
+		http:   &http.Client{Transport: transport, Timeout: vaultRequestTimeout},
+		kv:     make(map[string]vaultKVEntry),
+		issued: make(map[string]vaultCredentials),
+	}, nil
+}
+
+// token returns the token to authenticate with, reading its file if it has one
+func (v *vaultClient) token() (string, error) {
+	if v.config.TokenFile == "" {
+		return v.config.Token, nil
+	}
+	token, err := os.ReadFile(v.config.TokenFile)
+	if err != nil {
+		return "", fmt.Errorf("failed to read Vault token file: %w", err)
+	}
+	return strings.TrimSpace(string(token)), nil
+}
+
+// do calls a Vault API path and returns the decoded response
+func (v *vaultClient) do(method, path string, body interface{}) (*vaultSecret, error) {
+	var reader *bytes.Reader
+	if body != nil {
+		encoded, err := json.Marshal(body)
+		if err != nil {
+			return nil, err
+		}
+		reader = bytes.NewReader(encoded)
+	} else {
+		reader = bytes.NewReader(nil)
+	}
+
+	request, err := http.NewRequest(method, strings.TrimSuffix(v.config.Address, "/")+"/v1/"+strings.TrimPrefix(path, "/"), reader)
+	if err != nil {
+		return nil, fmt.Errorf("invalid Vault address: %w", err)
+	}
+	token, err := v.token()
+	if err != nil {
+		return nil, err
+	}
+	request.Header.Set("X-Vault-Token", token)
+	if v.config.Namespace != "" {
+		request.Header.Set("X-Vault-Namespace", v.config.Namespace)
+	}
+	if body != nil {
+		request.Header.Set("Content-Type", "application/json")
+	}
+
+	response, err := v.http.Do(request)
+	if err != nil {
+		return nil, fmt.Errorf("failed to reach Vault: %w", err)
+	}
+	defer response.Body.Close()
+
+	var secret vaultSecret
+	if err := json.NewDecoder(response.Body).Decode(&secret); err != nil {
+		return nil, &vaultError{StatusCode: response.StatusCode, Messages: []string{fmt.Sprintf("unreadable response: %v", err)}}
+	}
+	if response.StatusCode >= http.StatusBadRequest {
+		return nil, &vaultError{StatusCode: response.StatusCode, Messages: secret.Errors}
+	}
+	return &secret, nil
+}
+
+// kvSecret returns the string fields of a KV secret, read from Vault once the cached copy is
+// older than the cache TTL. Secrets of KV version 2 engines are unwrapped from their metadata.
+func (v *vaultClient) kvSecret(path string) (map[string]string, error) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	if entry, ok := v.kv[path]; ok && time.Now().Before(entry.refreshAt) {
+		return entry.fields, nil
+	}
+
+	secret, err := v.do(http.MethodGet, path, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read Vault secret %s: %w", path, err)
+	}
+	var data map[string]json.RawMessage
+	if err := json.Unmarshal(secret.Data, &data); err != nil {
+		return nil, fmt.Errorf("failed to parse Vault secret %s: %w", path, err)
+	}
+	if nested, ok := data["data"]; ok {
+		if _, versioned := data["metadata"]; versioned {
+			data = nil
+			if err := json.Unmarshal(nested, &data); err != nil {
+				return nil, fmt.Errorf("failed to parse Vault secret %s: %w", path, err)
+			}
+		}
+	}
+
+	fields := make(map[string]string, len(data))
+	for name, value := range data {
+		var text string
+		if json.Unmarshal(value, &text) == nil {
+			fields[name] = text
+		}
+	}
+	v.kv[path] = vaultKVEntry{fields: fields, refreshAt: time.Now().Add(v.config.CacheTTL)}
+	return fields, nil
+}
+
+// issue returns a certificate and private key issued by a PKI role. The same pair is reused
+// until two thirds of the certificate's lifetime has passed, after which a new one is issued,
+// so connections reloading their identity move to it well before the old one expires.
+func (v *vaultClient) issue(source VaultSource) (vaultCredentials, error) {
+	key := source.PKIPath + " " + source.CommonName
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	if credentials, ok := v.issued[key]; ok && time.Now().Before(credentials.refreshAt) {
+		return credentials, nil
+	}
+
+	request := map[string]string{"common_name": source.CommonName, "format": "pem", "private_key_format": "pkcs8"}
+	if source.TTL != "" {
+		request["ttl"] = source.TTL
+	}
+	secret, err := v.do(http.MethodPost, source.PKIPath, request)
+	if err != nil {
+		return vaultCredentials{}, fmt.Errorf("failed to issue certificate from %s: %w", source.PKIPath, err)
+	}
+	var result struct {
+		Certificate string `json:"certificate"`
+		PrivateKey  string `json:"private_key"`
+	}
+	if err := json.Unmarshal(secret.Data, &result); err != nil {
+		return vaultCredentials{}, fmt.Errorf("failed to parse certificate issued from %s: %w", source.PKIPath, err)
+	}
+	certificate, err := identity.CertificateFromPEM([]byte(result.Certificate))
+	if err != nil {
+		return vaultCredentials{}, fmt.Errorf("invalid certificate issued from %s: %w", source.PKIPath, err)
+	}
+
+	lifetime := certificate.NotAfter.Sub(certificate.NotBefore)
+	credentials := vaultCredentials{
+		certificatePEM: result.Certificate,
+		privateKeyPEM:  result.PrivateKey,
+		refreshAt:      certificate.NotBefore.Add(lifetime * 2 / 3),
+	}
+	v.issued[key] = credentials
+	slog.Info("Issued certificate from Vault", "path", source.PKIPath, "commonName", source.CommonName,
+		"serial", certificate.SerialNumber.Text(16), "notAfter", certificate.NotAfter)
+	return credentials, nil
+}
+
+// credentials returns the PEM-encoded certificate and private key kept for an organization,
+// issuing them from its PKI role if it has one
+func (v *vaultClient) credentials(source VaultSource) (string, string, error) {
+	if source.PKIPath != "" {
+		credentials, err := v.issue(source)
+		return credentials.certificatePEM, credentials.privateKeyPEM, err
+	}
+
+	fields, err := v.kvSecret(source.KVPath)
+	if err != nil {
+		return "", "", err
+	}
+	certificatePEM, privateKeyPEM := fields[vaultCertificateField], fields[vaultPrivateKeyField]
+	if certificatePEM == "" || privateKeyPEM == "" {
+		return "", "", fmt.Errorf("Vault secret %s must have %s and %s fields", source.KVPath, vaultCertificateField, vaultPrivateKeyField)
+	}
+	return certificatePEM, privateKeyPEM, nil
+}
+
+// tlsCACertificate returns the peer TLS CA certificate kept in an organization's KV secret,
+// or nil if it has none
+func (v *vaultClient) tlsCACertificate(source VaultSource) ([]byte, error) {
+	if source.KVPath == "" {
+		return nil, nil
+	}
+	fields, err := v.kvSecret(source.KVPath)
+	if err != nil {
+		return nil, err
+	}
+	if certificatePEM := fields[vaultTLSCACertField]; certificatePEM != "" {
+		return []byte(certificatePEM), nil
+	}
+	return nil, nil
+}
+
+// orgVaultCredentials returns the certificate and private key of an organization that keeps them in Vault
+func orgVaultCredentials(org OrgConfig) (string, string, error) {
+	if vault == nil {
+		return "", "", errors.New("organization reads its credentials from Vault, but VAULT_ADDR is not set")
+	}
+	return vault.credentials(*org.Vault)
+}
+
+// renewToken keeps a renewable Vault token alive, renewing it each time half its TTL has
+// passed. It runs until the process exits. Tokens read from a file are left to whatever writes
+// the file, such as Vault Agent, and tokens that cannot be renewed are left to expire.
+func (v *vaultClient) renewToken() {
+	if v.config.TokenFile != "" {
+		return
+	}
+	secret, err := v.do(http.MethodGet, "auth/token/lookup-self", nil)
+	if err != nil {
+		slog.Warn("Failed to look up Vault token", "error", err)
+		return
+	}
+	var lookup struct {
+		TTL       int  `json:"ttl"`
+		Renewable bool `json:"renewable"`
+	}
+	if err := json.Unmarshal(secret.Data, &lookup); err != nil || !lookup.Renewable || lookup.TTL <= 0 {
+		return
+	}
+
+	ttl := time.Duration(lookup.TTL) * time.Second
+	for {
+		time.Sleep(max(ttl/2, vaultMinRenewInterval))
+
+		secret, err := v.do(http.MethodPost, "auth/token/renew-self", nil)
+		if err != nil {
+			slog.Warn("Failed to renew Vault token", "error", err)
+			continue
+		}
+		if secret.Auth != nil && secret.Auth.LeaseDuration > 0 {
+			ttl = time.Duration(secret.Auth.LeaseDuration) * time.Second
+		}
+		slog.Debug("Renewed Vault token", "ttl", ttl.String())
+	}
+}
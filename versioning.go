@@ -0,0 +1,99 @@
+/*
+Copyright 2021 IBM All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package main
+
+import (
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// apiVersionHeader names the version of the API that served a response
+const apiVersionHeader = "API-Version"
+
+// apiVersionKey is the Gin context key under which the API root a request used is stored
+const apiVersionKey = "apiVersion"
+
+// apiRoot is a URL prefix the API is served under, and the version of the API served there
+type apiRoot struct {
+	prefix  string
+	version string
+	// unversioned marks the paths from before versioning, kept so existing clients do not break
+	unversioned bool
+	// envelope wraps every JSON response in a responseEnvelope
+	envelope bool
+}
+
+// apiRoots are the prefixes the API is served under. Each version keeps its response shapes,
+// so a breaking change, such as a pagination envelope or a new error format, is made in a new
+// version added here. The unversioned paths stay an alias of v1.
+var apiRoots = []apiRoot{
+	{prefix: "/api", version: "v1", unversioned: true},
+	{prefix: "/api/v1", version: "v1"},
+	{prefix: "/api/v2", version: "v2", envelope: true},
+}
+
+// handlers returns the middleware of the routes under the root
+func (r apiRoot) handlers() []gin.HandlerFunc {
+	handlers := []gin.HandlerFunc{r.middleware}
+	if r.envelope {
+		handlers = append(handlers, envelopeMiddleware)
+	}
+	return handlers
+}
+
+// middleware records the API root a request used and names its version in the response. Responses
+// to unversioned paths are marked deprecated, with a link to the same path under the version
+// they are an alias of.
+func (r apiRoot) middleware(c *gin.Context) {
+	c.Set(apiVersionKey, r)
+	c.Header(apiVersionHeader, r.version)
+	if r.unversioned {
+		successor := r.versioned().prefix + strings.TrimPrefix(c.Request.URL.Path, r.prefix)
+		c.Header("Deprecation", "true")
+		c.Header("Link", "<"+successor+`>; rel="successor-version"`)
+	}
+	c.Next()
+}
+
+// versioned returns the versioned root serving the same version as the root
+func (r apiRoot) versioned() apiRoot {
+	for _, root := range apiRoots {
+		if root.version == r.version && !root.unversioned {
+			return root
+		}
+	}
+	return r
+}
+
+// apiRootFrom returns the API root a request used, defaulting to the unversioned one
+func apiRootFrom(c *gin.Context) apiRoot {
+	if root, ok := c.Get(apiVersionKey); ok {
+		return root.(apiRoot)
+	}
+	return apiRoots[0]
+}
+
+// apiPath returns the URL of a path under the API root the request used, such as
+// /api/v1/transactions/... for a request to /api/v1, so links keep clients on their version
+func apiPath(c *gin.Context, path string) string {
+	return apiRootFrom(c).prefix + path
+}
+
+// unversionedRoute returns a route pattern with the version prefix replaced by /api, so every
+// version of a route shares its settings
+func unversionedRoute(route string) string {
+	for _, root := range apiRoots {
+		if root.unversioned {
+			continue
+		}
+		if rest, ok := strings.CutPrefix(route, root.prefix+"/"); ok {
+			return "/api/" + rest
+		}
+	}
+	return route
+}
@@ -0,0 +1,310 @@
+/*
+Copyright 2021 IBM All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package main
+
+import (
+	"crypto"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/hyperledger/fabric-gateway/pkg/identity"
+)
+
+// walletIdentityType is the only kind of identity the wallet stores
+const walletIdentityType = "X.509"
+
+// walletFileSuffix is the extension of identity files, as used by the Fabric SDK filesystem wallets
+const walletFileSuffix = ".id"
+
+// validWalletLabel matches labels that are safe to use as file names
+var validWalletLabel = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._@-]{0,127}$`)
+
+// Errors returned by the wallet when an identity is missing or already stored
+var (
+	errIdentityNotFound = errors.New("identity not found in wallet")
+	errIdentityExists   = errors.New("identity already exists in wallet")
+)
+
+// walletIdentity is an enrolled identity as stored on disk, in the same JSON format as the
+// filesystem wallets of the Fabric SDKs so wallets can be shared with other applications
+type walletIdentity struct {
+	Credentials struct {
+		Certificate string `json:"certificate"`
+		PrivateKey  string `json:"privateKey"`
+	} `json:"credentials"`
+	MSPID   string `json:"mspId"`
+	Type    string `json:"type"`
+	Version int    `json:"version"`
+}
+
+// walletEntry describes a stored identity without its private key
+type walletEntry struct {
+	Label string `json:"label"`
+	MSPID string `json:"mspId"`
+	Type  string `json:"type"`
+}
+
+// wallet stores identities as one file per label in a directory
+type wallet struct {
+	dir string
+}
+
+// identities is the wallet that organizations and users load identities from by label
+var identities = newWallet(defaultConfig().WalletPath)
+
+// newWallet returns a wallet kept in dir, which is created when the first identity is stored
+func newWallet(dir string) *wallet {
+	return &wallet{dir: dir}
+}
+
+// path returns the file holding the identity with the given label
+func (w *wallet) path(label string) (string, error) {
+	if !validWalletLabel.MatchString(label) {
+		return "", fmt.Errorf("invalid identity label %q", label)
+	}
+	return filepath.Join(w.dir, label+walletFileSuffix), nil
+}
+
+// get reads the identity with the given label
+func (w *wallet) get(label string) (walletIdentity, error) {
+	var id walletIdentity
+
+	path, err := w.path(label)
+	if err != nil {
+		return id, err
+	}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return id, fmt.Errorf("%w: %s", errIdentityNotFound, label)
+	}
+	if err != nil {
+		return id, fmt.Errorf("failed to read identity %s: %w", label, err)
+	}
+
+	if err := json.Unmarshal(data, &id); err != nil {
+		return id, fmt.Errorf("failed to parse identity %s: %w", label, err)
+	}
+	return id, nil
+}
+
+// put stores a new identity under the given label, failing if the label is already taken
+func (w *wallet) put(label string, id walletIdentity) error {
+	path, err := w.path(label)
+	if err != nil {
+		return err
+	}
+
+	data, err := json.Marshal(id)
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(w.dir, 0o700); err != nil {
+		return fmt.Errorf("failed to create wallet directory: %w", err)
+	}
+
+	// Write to a temporary file and link it into place, so a reader never sees a partly written identity
+	// and two imports of the same label cannot both succeed
+	tmp, err := os.CreateTemp(w.dir, "."+label+"-*")
+	if err != nil {
+		return fmt.Errorf("failed to store identity %s: %w", label, err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to store identity %s: %w", label, err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to store identity %s: %w", label, err)
+	}
+
+	if err := os.Link(tmp.Name(), path); err != nil {
+		if errors.Is(err, fs.ErrExist) {
+			return fmt.Errorf("%w: %s", errIdentityExists, label)
+		}
+		return fmt.Errorf("failed to store identity %s: %w", label, err)
+	}
+	return nil
+}
+
+// remove deletes the identity with the given label
+func (w *wallet) remove(label string) error {
+	path, err := w.path(label)
+	if err != nil {
+		return err
+	}
+
+	if err := os.Remove(path); err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return fmt.Errorf("%w: %s", errIdentityNotFound, label)
+		}
+		return fmt.Errorf("failed to remove identity %s: %w", label, err)
+	}
+	return nil
+}
+
+// list describes every stored identity, sorted by label. A wallet directory that doesn't exist yet is empty.
+func (w *wallet) list() ([]walletEntry, error) {
+	entries := []walletEntry{}
+
+	files, err := os.ReadDir(w.dir)
+	if errors.Is(err, fs.ErrNotExist) {
+		return entries, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read wallet directory: %w", err)
+	}
+
+	for _, file := range files {
+		label, ok := strings.CutSuffix(file.Name(), walletFileSuffix)
+		if !ok || file.IsDir() || !validWalletLabel.MatchString(label) {
+			continue
+		}
+
+		id, err := w.get(label)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, walletEntry{Label: label, MSPID: id.MSPID, Type: id.Type})
+	}
+
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Label < entries[j].Label })
+	return entries, nil
+}
+
+// newX509WalletIdentity checks that the PEM-encoded certificate and private key belong together
+// and returns them as a wallet identity
+func newX509WalletIdentity(mspID, certificatePEM, privateKeyPEM string) (walletIdentity, error) {
+	var id walletIdentity
+
+	certificate, err := identity.CertificateFromPEM([]byte(certificatePEM))
+	if err != nil {
+		return id, fmt.Errorf("invalid certificate: %w", err)
+	}
+	privateKey, err := identity.PrivateKeyFromPEM([]byte(privateKeyPEM))
+	if err != nil {
+		return id, fmt.Errorf("invalid private key: %w", err)
+	}
+
+	signer, ok := privateKey.(crypto.Signer)
+	if !ok {
+		return id, errors.New("unsupported private key type")
+	}
+	if public, ok := signer.Public().(interface{ Equal(crypto.PublicKey) bool }); !ok || !public.Equal(certificate.PublicKey) {
+		return id, errors.New("private key does not match the certificate")
+	}
+
+	id.Credentials.Certificate = certificatePEM
+	id.Credentials.PrivateKey = privateKeyPEM
+	id.MSPID = mspID
+	id.Type = walletIdentityType
+	id.Version = 1
+	return id, nil
+}
+
+// walletCredentials loads the identity an organization or user is configured to use from the wallet
+func walletCredentials(org OrgConfig) (walletIdentity, error) {
+	id, err := identities.get(org.Identity)
+	if err != nil {
+		return id, err
+	}
+	if id.Type != walletIdentityType {
+		return id, fmt.Errorf("identity %s has unsupported type %q", org.Identity, id.Type)
+	}
+	if id.MSPID != org.MSPID {
+		return id, fmt.Errorf("identity %s belongs to %s, not %s", org.Identity, id.MSPID, org.MSPID)
+	}
+	return id, nil
+}
+
+// importIdentityRequest is the body of a request to store an identity in the wallet
+type importIdentityRequest struct {
+	Label       string `json:"label" binding:"required"`
+	MSPID       string `json:"mspId" binding:"required"`
+	Certificate string `json:"certificate" binding:"required"`
+	PrivateKey  string `json:"privateKey" binding:"required"`
+}
+
+// listIdentities describes the identities in the wallet, without their private keys
+func listIdentities(c *gin.Context) {
+	entries, err := identities.list()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("Failed to list identities: %v", err)})
+		return
+	}
+	c.JSON(http.StatusOK, entries)
+}
+
+// importIdentity stores a PEM-encoded certificate and private key in the wallet under a new label
+func importIdentity(c *gin.Context) {
+	var request importIdentityRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		writeBindError(c, err)
+		return
+	}
+
+	if !validWalletLabel.MatchString(request.Label) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Invalid identity label %q", request.Label)})
+		return
+	}
+
+	id, err := newX509WalletIdentity(request.MSPID, request.Certificate, request.PrivateKey)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Invalid identity: %v", err)})
+		return
+	}
+
+	storeIdentity(c, request.Label, id)
+}
+
+// storeIdentity puts an identity in the wallet and responds with its description
+func storeIdentity(c *gin.Context, label string, id walletIdentity) {
+	if err := identities.put(label, id); err != nil {
+		if errors.Is(err, errIdentityExists) {
+			c.JSON(http.StatusConflict, gin.H{"error": fmt.Sprintf("Identity %s already exists", label)})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("Failed to import identity: %v", err)})
+		return
+	}
+
+	requestLogger(c).Info("Stored identity in wallet", "label", label, "mspId", id.MSPID)
+	c.JSON(http.StatusCreated, walletEntry{Label: label, MSPID: id.MSPID, Type: id.Type})
+}
+
+// removeIdentity deletes an identity from the wallet. Gateway connections already opened
+// with it keep working until the server restarts.
+func removeIdentity(c *gin.Context) {
+	label := c.Param("label")
+	if !validWalletLabel.MatchString(label) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Invalid identity label %q", label)})
+		return
+	}
+
+	if err := identities.remove(label); err != nil {
+		if errors.Is(err, errIdentityNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": fmt.Sprintf("Identity %s not found", label)})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("Failed to remove identity: %v", err)})
+		return
+	}
+
+	requestLogger(c).Info("Removed identity from wallet", "label", label)
+	c.JSON(http.StatusOK, gin.H{"message": fmt.Sprintf("Identity %s removed", label)})
+}
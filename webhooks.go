@@ -0,0 +1,600 @@
+/*
+Copyright 2021 IBM All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+)
+
+const (
+	// webhookQueueSize is the number of deliveries queued for a webhook before further events
+	// are dead-lettered without being attempted
+	webhookQueueSize = 256
+
+	// Delivery policy: each attempt must be answered within the timeout, and failed attempts are
+	// retried with exponential backoff until the attempts run out
+	webhookTimeout        = 10 * time.Second
+	webhookMaxAttempts    = 5
+	webhookInitialBackoff = time.Second
+	webhookMaxBackoff     = time.Minute
+
+	// webhookSignatureHeader carries the hex HMAC-SHA256 of the request body, keyed with the webhook's secret
+	webhookSignatureHeader = "X-Webhook-Signature"
+)
+
+// errWebhookNotFound is returned when removing a webhook that is not registered
+var errWebhookNotFound = errors.New("webhook not found")
+
+// webhook is a registered endpoint that is sent the chaincode events it asks for
+type webhook struct {
+	ID        string    `json:"id"`
+	URL       string    `json:"url"`
+	Events    []string  `json:"events,omitempty"`
+	Secret    string    `json:"secret,omitempty"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// wants reports whether the webhook asked for events with the given name
+func (w webhook) wants(name string) bool {
+	if len(w.Events) == 0 {
+		return true
+	}
+	for _, event := range w.Events {
+		if event == name {
+			return true
+		}
+	}
+	return false
+}
+
+// public returns the webhook without its secret
+func (w webhook) public() webhook {
+	w.Secret = ""
+	return w
+}
+
+// webhookDelivery is the JSON body POSTed to a webhook for each event
+type webhookDelivery struct {
+	ID        string                `json:"id"`
+	WebhookID string                `json:"webhookId"`
+	Timestamp time.Time             `json:"timestamp"`
+	Event     chaincodeEventMessage `json:"event"`
+}
+
+// deadLetter records a delivery that could not be made
+type deadLetter struct {
+	Delivery webhookDelivery `json:"delivery"`
+	URL      string          `json:"url"`
+	Attempts int             `json:"attempts"`
+	Error    string          `json:"error"`
+	FailedAt time.Time       `json:"failedAt"`
+}
+
+// webhookTarget is a registered webhook with its queue of pending deliveries
+type webhookTarget struct {
+	webhook
+	queue   chan webhookDelivery
+	removed chan struct{} // closed when the webhook is unregistered
+}
+
+// webhookDispatcher keeps the registered webhooks in a file and POSTs matching chaincode events
+// to them, subscribing to the event hub while any webhook is registered. Each webhook has its
+// own queue and worker, so a slow endpoint doesn't hold up the others.
+type webhookDispatcher struct {
+	path           string
+	deadLetterPath string
+	client         *http.Client
+
+	// ctx is cancelled at shutdown, abandoning pending deliveries to the dead-letter file
+	ctx  context.Context
+	stop context.CancelFunc
+
+	mu         sync.Mutex
+	targets    map[string]*webhookTarget
+	sub        *eventSubscriber
+	stopListen chan struct{} // closed to stop reading from sub
+
+	deadLetterMu sync.Mutex
+}
+
+// webhooks dispatches chaincode events to the registered webhooks
+var webhooks = newWebhookDispatcher(defaultConfig().WebhooksFile, defaultConfig().WebhookDeadLetterFile)
+
+// newWebhookDispatcher creates a dispatcher keeping webhooks in path and failed deliveries in deadLetterPath
+func newWebhookDispatcher(path, deadLetterPath string) *webhookDispatcher {
+	ctx, stop := context.WithCancel(context.Background())
+	return &webhookDispatcher{
+		path:           path,
+		deadLetterPath: deadLetterPath,
+		client:         &http.Client{Timeout: webhookTimeout},
+		ctx:            ctx,
+		stop:           stop,
+		targets:        make(map[string]*webhookTarget),
+	}
+}
+
+// load starts delivering to the webhooks registered before the server restarted. A webhooks
+// file that doesn't exist yet holds no webhooks.
+func (d *webhookDispatcher) load() error {
+	data, err := os.ReadFile(d.path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read webhooks file: %w", err)
+	}
+
+	var hooks []webhook
+	if err := json.Unmarshal(data, &hooks); err != nil {
+		return fmt.Errorf("failed to parse webhooks file %s: %w", d.path, err)
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	for _, hook := range hooks {
+		d.startLocked(hook)
+	}
+	if err := d.listenLocked(); err != nil {
+		return err
+	}
+
+	if len(hooks) > 0 {
+		slog.Info("Loaded webhooks", "count", len(hooks))
+	}
+	return nil
+}
+
+// list returns the registered webhooks, without their secrets, oldest first
+func (d *webhookDispatcher) list() []webhook {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	hooks := make([]webhook, 0, len(d.targets))
+	for _, target := range d.targets {
+		hooks = append(hooks, target.public())
+	}
+	sort.Slice(hooks, func(i, j int) bool { return hooks[i].CreatedAt.Before(hooks[j].CreatedAt) })
+	return hooks
+}
+
+// register saves a new webhook and starts delivering events to it
+func (d *webhookDispatcher) register(hook webhook) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	target := d.startLocked(hook)
+	if err := d.saveLocked(); err != nil {
+		d.stopLocked(target)
+		return err
+	}
+	if err := d.listenLocked(); err != nil {
+		d.stopLocked(target)
+		d.saveLocked()
+		return err
+	}
+	return nil
+}
+
+// remove unregisters a webhook, discarding deliveries still queued for it
+func (d *webhookDispatcher) remove(id string) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	target, ok := d.targets[id]
+	if !ok {
+		return fmt.Errorf("%w: %s", errWebhookNotFound, id)
+	}
+
+	d.stopLocked(target)
+	if err := d.saveLocked(); err != nil {
+		d.startLocked(target.webhook)
+		return err
+	}
+	if len(d.targets) == 0 {
+		d.unlistenLocked()
+	}
+	return nil
+}
+
+// startLocked adds a webhook and starts its delivery worker. The caller must hold d.mu.
+func (d *webhookDispatcher) startLocked(hook webhook) *webhookTarget {
+	target := &webhookTarget{
+		webhook: hook,
+		queue:   make(chan webhookDelivery, webhookQueueSize),
+		removed: make(chan struct{}),
+	}
+	d.targets[hook.ID] = target
+	goBackground(func() { d.work(target) })
+	return target
+}
+
+// stopLocked removes a webhook and stops its delivery worker. The caller must hold d.mu.
+func (d *webhookDispatcher) stopLocked(target *webhookTarget) {
+	delete(d.targets, target.ID)
+	close(target.removed)
+}
+
+// saveLocked writes the registered webhooks to the file. The caller must hold d.mu.
+func (d *webhookDispatcher) saveLocked() error {
+	hooks := make([]webhook, 0, len(d.targets))
+	for _, target := range d.targets {
+		hooks = append(hooks, target.webhook)
+	}
+	sort.Slice(hooks, func(i, j int) bool { return hooks[i].CreatedAt.Before(hooks[j].CreatedAt) })
+
+	data, err := json.MarshalIndent(hooks, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	// Write to a temporary file and rename it into place, so a crash never leaves a partly written file.
+	// The file holds the webhooks' secrets, so only the server may read it.
+	tmp, err := os.CreateTemp(filepath.Dir(d.path), "."+filepath.Base(d.path)+"-*")
+	if err != nil {
+		return fmt.Errorf("failed to save webhooks: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to save webhooks: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to save webhooks: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), d.path); err != nil {
+		return fmt.Errorf("failed to save webhooks: %w", err)
+	}
+	return nil
+}
+
+// listenLocked subscribes to the event hub if there are webhooks and no subscription yet.
+// The caller must hold d.mu.
+func (d *webhookDispatcher) listenLocked() error {
+	if d.sub != nil || len(d.targets) == 0 {
+		return nil
+	}
+
+	sub := newEventSubscriber(nil)
+	if err := chaincodeEvents.add(sub); err != nil {
+		return err
+	}
+	d.sub, d.stopListen = sub, make(chan struct{})
+	go d.listen(sub, d.stopListen)
+	return nil
+}
+
+// unlistenLocked unsubscribes from the event hub. The caller must hold d.mu.
+func (d *webhookDispatcher) unlistenLocked() {
+	if d.sub == nil {
+		return
+	}
+	chaincodeEvents.remove(d.sub)
+	close(d.stopListen)
+	d.sub, d.stopListen = nil, nil
+}
+
+// listen queues each event from the hub for the webhooks that want it, until told to stop or
+// dropped by the hub. If the hub drops the dispatcher for falling behind, it subscribes again.
+func (d *webhookDispatcher) listen(sub *eventSubscriber, stop chan struct{}) {
+	for {
+		select {
+		case message := <-sub.events:
+			d.dispatch(message)
+		case <-stop:
+			return
+		case <-sub.done:
+			if sub.closeCode == websocket.CloseGoingAway {
+				return
+			}
+			slog.Warn("Webhook dispatcher dropped by the event hub, subscribing again", "reason", sub.closeText)
+
+			d.mu.Lock()
+			if d.sub == sub {
+				d.sub, d.stopListen = nil, nil
+				if err := d.listenLocked(); err != nil {
+					slog.Error("Failed to subscribe webhook dispatcher to events", "error", err)
+				}
+			}
+			d.mu.Unlock()
+			return
+		}
+	}
+}
+
+// dispatch queues an event for every webhook that wants it. Events for a webhook whose queue
+// is full are dead-lettered straight away rather than holding up the others.
+func (d *webhookDispatcher) dispatch(message chaincodeEventMessage) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	for _, target := range d.targets {
+		if !target.wants(message.EventName) {
+			continue
+		}
+
+		id, err := randomHex(16)
+		if err != nil {
+			slog.Error("Failed to generate webhook delivery ID", "error", err)
+		}
+		delivery := webhookDelivery{ID: id, WebhookID: target.ID, Timestamp: time.Now().UTC(), Event: message}
+
+		// Workers stop taking deliveries at shutdown, so later events would be lost in the queue
+		if d.ctx.Err() != nil {
+			d.deadLetter(target, delivery, 0, errors.New("server shut down before delivery"))
+			continue
+		}
+
+		select {
+		case target.queue <- delivery:
+		default:
+			d.deadLetter(target, delivery, 0, errors.New("delivery queue is full"))
+		}
+	}
+}
+
+// work makes the deliveries queued for a webhook one at a time, in order, until the webhook is
+// removed or the server shuts down. Deliveries still queued at shutdown are dead-lettered.
+func (d *webhookDispatcher) work(target *webhookTarget) {
+	for {
+		select {
+		case delivery := <-target.queue:
+			d.deliver(target, delivery)
+		case <-target.removed:
+			return
+		case <-d.ctx.Done():
+			for {
+				select {
+				case delivery := <-target.queue:
+					d.deadLetter(target, delivery, 0, errors.New("server shut down before delivery"))
+				default:
+					return
+				}
+			}
+		}
+	}
+}
+
+// deliver POSTs a delivery to its webhook, retrying with exponential backoff, and dead-letters
+// it if every attempt fails
+func (d *webhookDispatcher) deliver(target *webhookTarget, delivery webhookDelivery) {
+	logger := slog.With("webhookId", target.ID, "deliveryId", delivery.ID, "eventName", delivery.Event.EventName)
+	backoff := webhookInitialBackoff
+
+	for attempt := 1; ; attempt++ {
+		err := d.post(target.webhook, delivery)
+		if err == nil {
+			webhookDeliveries.WithLabelValues("delivered").Inc()
+			return
+		}
+
+		if attempt == webhookMaxAttempts {
+			d.deadLetter(target, delivery, attempt, err)
+			return
+		}
+
+		webhookDeliveries.WithLabelValues("retried").Inc()
+		logger.Warn("Webhook delivery failed, retrying", "attempt", attempt, "backoff", backoff.String(), "error", err)
+
+		select {
+		case <-time.After(backoff):
+		case <-target.removed:
+			return
+		case <-d.ctx.Done():
+			d.deadLetter(target, delivery, attempt, fmt.Errorf("server shut down before delivery: %w", err))
+			return
+		}
+		backoff = min(backoff*2, webhookMaxBackoff)
+	}
+}
+
+// post sends one delivery attempt, signed with the webhook's secret. Any response other than 2xx is a failure.
+func (d *webhookDispatcher) post(hook webhook, delivery webhookDelivery) error {
+	body, err := json.Marshal(delivery)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(d.ctx, webhookTimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, hook.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Webhook-ID", hook.ID)
+	req.Header.Set("X-Webhook-Delivery", delivery.ID)
+	req.Header.Set("X-Webhook-Event", delivery.Event.EventName)
+	req.Header.Set(webhookSignatureHeader, "sha256="+signWebhookBody(hook.Secret, body))
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("webhook responded with status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// signWebhookBody returns the hex HMAC-SHA256 of a delivery body keyed with the webhook's secret
+func signWebhookBody(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// deadLetter appends a failed delivery to the dead-letter file, one JSON object per line
+func (d *webhookDispatcher) deadLetter(target *webhookTarget, delivery webhookDelivery, attempts int, cause error) {
+	webhookDeliveries.WithLabelValues("dead_lettered").Inc()
+	slog.Error("Webhook delivery failed, dead-lettering", "webhookId", target.ID, "deliveryId", delivery.ID, "attempts", attempts, "error", cause)
+
+	line, err := json.Marshal(deadLetter{
+		Delivery: delivery,
+		URL:      target.URL,
+		Attempts: attempts,
+		Error:    cause.Error(),
+		FailedAt: time.Now().UTC(),
+	})
+	if err != nil {
+		slog.Error("Failed to encode dead letter", "deliveryId", delivery.ID, "error", err)
+		return
+	}
+
+	d.deadLetterMu.Lock()
+	defer d.deadLetterMu.Unlock()
+
+	file, err := os.OpenFile(d.deadLetterPath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+	if err != nil {
+		slog.Error("Failed to open dead-letter file", "error", err)
+		return
+	}
+	defer file.Close()
+
+	if _, err := file.Write(append(line, '\n')); err != nil {
+		slog.Error("Failed to write dead letter", "deliveryId", delivery.ID, "error", err)
+	}
+}
+
+// deadLetters reads every dead-lettered delivery, oldest first
+func (d *webhookDispatcher) deadLetters() ([]deadLetter, error) {
+	d.deadLetterMu.Lock()
+	defer d.deadLetterMu.Unlock()
+
+	letters := []deadLetter{}
+
+	file, err := os.Open(d.deadLetterPath)
+	if errors.Is(err, fs.ErrNotExist) {
+		return letters, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read dead-letter file: %w", err)
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(nil, 1<<20)
+	for scanner.Scan() {
+		var letter deadLetter
+		if err := json.Unmarshal(scanner.Bytes(), &letter); err != nil {
+			return nil, fmt.Errorf("failed to parse dead-letter file: %w", err)
+		}
+		letters = append(letters, letter)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read dead-letter file: %w", err)
+	}
+	return letters, nil
+}
+
+// shutdown stops delivering, dead-lettering the deliveries that were still pending
+func (d *webhookDispatcher) shutdown() {
+	d.stop()
+}
+
+// registerWebhookRequest is the body of a request to register a webhook
+type registerWebhookRequest struct {
+	URL    string   `json:"url" binding:"required"`
+	Events []string `json:"events"`
+	Secret string   `json:"secret"`
+}
+
+// registerWebhook registers a URL to be POSTed the chaincode events named in events, or every
+// event if none are named. Without a secret one is generated; either way it is only returned here.
+func registerWebhook(c *gin.Context) {
+	var request registerWebhookRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		writeBindError(c, err)
+		return
+	}
+
+	if u, err := url.Parse(request.URL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Invalid webhook URL %q: must be an absolute http or https URL", request.URL)})
+		return
+	}
+	for _, event := range request.Events {
+		if event == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Event names must not be empty"})
+			return
+		}
+	}
+
+	id, err := randomHex(8)
+	if err == nil && request.Secret == "" {
+		request.Secret, err = randomHex(32)
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("Failed to generate webhook ID: %v", err)})
+		return
+	}
+
+	hook := webhook{ID: id, URL: request.URL, Events: request.Events, Secret: request.Secret, CreatedAt: time.Now().UTC()}
+	if err := webhooks.register(hook); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("Failed to register webhook: %v", err)})
+		return
+	}
+
+	requestLogger(c).Info("Registered webhook", "webhookId", hook.ID, "url", hook.URL, "events", hook.Events)
+	c.JSON(http.StatusCreated, hook)
+}
+
+// listWebhooks describes the registered webhooks, without their secrets
+func listWebhooks(c *gin.Context) {
+	c.JSON(http.StatusOK, webhooks.list())
+}
+
+// removeWebhook unregisters a webhook
+func removeWebhook(c *gin.Context) {
+	id := c.Param("id")
+	if err := webhooks.remove(id); err != nil {
+		if errors.Is(err, errWebhookNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": fmt.Sprintf("Webhook %s not found", id)})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("Failed to remove webhook: %v", err)})
+		return
+	}
+
+	requestLogger(c).Info("Removed webhook", "webhookId", id)
+	c.JSON(http.StatusOK, gin.H{"message": fmt.Sprintf("Webhook %s removed", id)})
+}
+
+// listDeadLetters returns the deliveries that could not be made
+func listDeadLetters(c *gin.Context) {
+	letters, err := webhooks.deadLetters()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("Failed to read dead letters: %v", err)})
+		return
+	}
+	c.JSON(http.StatusOK, letters)
+}